@@ -0,0 +1,279 @@
+package analytics
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/btm-stats/consensus"
+	"github.com/btm-stats/database"
+	"github.com/btm-stats/errors"
+	"github.com/btm-stats/protocol/bc"
+	"github.com/btm-stats/protocol/state"
+	"github.com/btm-stats/types"
+)
+
+// Anomaly kinds
+const (
+	FutureTimestamp     = "future_timestamp"
+	TimestampRegression = "timestamp_regression"
+	LongGap             = "long_gap"
+	ArrivalBurst        = "arrival_burst"
+)
+
+// syncHorizon is how far behind its arrival a block timestamp can be before
+// the block counts as caught up with rather than mined live, the arrival
+// bursts of a syncing node are expected
+const syncHorizon = int64(consensus.MaxTimeOffsetSeconds)
+
+var anomalyPrefix = []byte("AE:")
+
+// Anomaly is a block whose timestamp or arrival looks off, the blocks of the
+// side chains included
+type Anomaly struct {
+	Kind      string  `json:"kind"`
+	Height    uint64  `json:"height"`
+	Hash      bc.Hash `json:"hash"`
+	Timestamp uint64  `json:"timestamp"`
+	Arrival   uint64  `json:"arrival"`
+	// ParentTimestamp and PastMedian are the rules the timestamp is held to
+	ParentTimestamp uint64 `json:"parent_timestamp,omitempty"`
+	PastMedian      uint64 `json:"past_median,omitempty"`
+	Detail          string `json:"detail"`
+}
+
+func calcAnomalyKey(height uint64, hash *bc.Hash, kind string) []byte {
+	buf := [8]byte{}
+	binary.BigEndian.PutUint64(buf[:], height)
+	key := append(append(append([]byte{}, anomalyPrefix...), buf[:]...), hash.Bytes()...)
+	return append(key, kind...)
+}
+
+func calcAnomalyHeightKey(height uint64) []byte {
+	buf := [8]byte{}
+	binary.BigEndian.PutUint64(buf[:], height)
+	return append(append([]byte{}, anomalyPrefix...), buf[:]...)
+}
+
+// AnomalyConfig sets when a block is flagged
+type AnomalyConfig struct {
+	// FutureSeconds is how far a timestamp may be ahead of the arrival
+	FutureSeconds uint64
+	// GapFactor times the target block time is the longest normal interval
+	GapFactor uint64
+	// BurstBlocks blocks extending each other and arriving within
+	// BurstSeconds is a burst, like a withheld branch being released
+	BurstBlocks  int
+	BurstSeconds uint64
+}
+
+// anomalyQueueSize is how many blocks of anomalies wait for the worker, the
+// ones past it are dropped with a warning
+const anomalyQueueSize = 64
+
+// AnomalyDetector checks the blocks as they reach the chain, the flagged ones
+// are stored, logged and fired on the event switch by a worker so the block
+// processing doesn't wait on them. It implements protocol.BlockObserver.
+type AnomalyDetector struct {
+	db     database.DB
+	config AnomalyConfig
+	evsw   types.Fireable
+
+	mtx sync.Mutex
+	// arrivals of the recent blocks, kept for BurstSeconds
+	arrivals map[bc.Hash]time.Time
+
+	anomalyCh chan []*Anomaly
+	quit      chan struct{}
+	done      chan struct{}
+}
+
+// NewAnomalyDetector creates the detector, evsw may be nil
+func NewAnomalyDetector(db database.DB, config AnomalyConfig, evsw types.Fireable) *AnomalyDetector {
+	return &AnomalyDetector{
+		db:        db,
+		config:    config,
+		evsw:      evsw,
+		arrivals:  map[bc.Hash]time.Time{},
+		anomalyCh: make(chan []*Anomaly, anomalyQueueSize),
+		quit:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Start records the anomalies queued by ObserveBlock until Stop
+func (d *AnomalyDetector) Start() {
+	go d.recordLoop()
+}
+
+// Stop ends the worker once the anomalies still queued are recorded
+func (d *AnomalyDetector) Stop() {
+	close(d.quit)
+	<-d.done
+}
+
+// ObserveBlock checks a block added to the block index and queues its
+// anomalies to the worker
+func (d *AnomalyDetector) ObserveBlock(node *state.BlockNode, arrival time.Time) {
+	anomalies := d.check(node, arrival)
+	if len(anomalies) == 0 {
+		return
+	}
+
+	select {
+	case d.anomalyCh <- anomalies:
+	default:
+		log.WithFields(log.Fields{"height": node.Height, "hash": node.Hash.String()}).Warning("the anomaly queue is full, drop the block anomalies")
+	}
+}
+
+func (d *AnomalyDetector) recordLoop() {
+	defer close(d.done)
+	for {
+		select {
+		case anomalies := <-d.anomalyCh:
+			d.record(anomalies)
+		case <-d.quit:
+			for {
+				select {
+				case anomalies := <-d.anomalyCh:
+					d.record(anomalies)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// record stores, logs and fires the anomalies of a block
+func (d *AnomalyDetector) record(anomalies []*Anomaly) {
+	batch := d.db.NewBatch()
+	for _, anomaly := range anomalies {
+		data, err := json.Marshal(anomaly)
+		if err != nil {
+			log.WithField("err", err).Error("fail on marshal the block anomaly")
+			return
+		}
+		batch.Set(calcAnomalyKey(anomaly.Height, &anomaly.Hash, anomaly.Kind), data)
+	}
+	if err := batch.Write(); err != nil {
+		log.WithField("err", err).Error("fail on save the block anomalies")
+	}
+
+	for _, anomaly := range anomalies {
+		log.WithFields(log.Fields{
+			"kind":      anomaly.Kind,
+			"height":    anomaly.Height,
+			"hash":      anomaly.Hash.String(),
+			"timestamp": anomaly.Timestamp,
+			"arrival":   anomaly.Arrival,
+		}).Warning(anomaly.Detail)
+
+		types.FireEventBlockAnomaly(d.evsw, types.EventDataBlockAnomaly{
+			Kind:      anomaly.Kind,
+			Height:    anomaly.Height,
+			Hash:      anomaly.Hash.String(),
+			Timestamp: anomaly.Timestamp,
+			Arrival:   anomaly.Arrival,
+			Detail:    anomaly.Detail,
+		})
+	}
+}
+
+// check returns the anomalies of the block
+func (d *AnomalyDetector) check(node *state.BlockNode, arrival time.Time) []*Anomaly {
+	anomalies := []*Anomaly{}
+	add := func(kind, detail string) *Anomaly {
+		anomaly := &Anomaly{
+			Kind:      kind,
+			Height:    node.Height,
+			Hash:      node.Hash,
+			Timestamp: node.Timestamp,
+			Arrival:   uint64(arrival.Unix()),
+			Detail:    detail,
+		}
+		anomalies = append(anomalies, anomaly)
+		return anomaly
+	}
+
+	ahead := int64(node.Timestamp) - arrival.Unix()
+	if ahead > int64(d.config.FutureSeconds) {
+		add(FutureTimestamp, fmt.Sprintf("block timestamp is %ds ahead of its arrival", ahead))
+	}
+
+	if parent := node.Parent; parent != nil {
+		interval := int64(node.Timestamp) - int64(parent.Timestamp)
+		if interval < 0 {
+			// the block still passed the median time rule
+			median := parent.CalcPastMedianTime()
+			anomaly := add(TimestampRegression, fmt.Sprintf("block timestamp is %ds behind its parent, %ds above the median of the %d blocks before it", -interval, node.Timestamp-median, consensus.MedianTimeBlocks))
+			anomaly.ParentTimestamp, anomaly.PastMedian = parent.Timestamp, median
+		}
+
+		if maxGap := d.config.GapFactor * consensus.TargetSecondsPerBlock; d.config.GapFactor > 0 && interval > int64(maxGap) {
+			anomaly := add(LongGap, fmt.Sprintf("block comes %ds after its parent, over %d times the target block time", interval, d.config.GapFactor))
+			anomaly.ParentTimestamp = parent.Timestamp
+		}
+	}
+
+	if burst, span := d.burst(node, arrival); burst {
+		add(ArrivalBurst, fmt.Sprintf("%d blocks extending each other arrived within %s", d.config.BurstBlocks, span))
+	}
+	return anomalies
+}
+
+// burst records the arrival of the block and tells whether it completes a run
+// of BurstBlocks blocks arriving within BurstSeconds. A run is only flagged
+// once, the blocks extending it beyond BurstBlocks are not.
+func (d *AnomalyDetector) burst(node *state.BlockNode, arrival time.Time) (bool, time.Duration) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	window := time.Duration(d.config.BurstSeconds) * time.Second
+	for hash, at := range d.arrivals {
+		if arrival.Sub(at) > window {
+			delete(d.arrivals, hash)
+		}
+	}
+	if d.config.BurstBlocks < 2 || int64(node.Timestamp) < arrival.Unix()-syncHorizon {
+		return false, 0
+	}
+	d.arrivals[node.Hash] = arrival
+
+	run, first := 0, arrival
+	for iterNode := node; iterNode != nil; iterNode = iterNode.Parent {
+		at, ok := d.arrivals[iterNode.Hash]
+		if !ok {
+			break
+		}
+		run, first = run+1, at
+	}
+	return run == d.config.BurstBlocks, arrival.Sub(first)
+}
+
+// ListAnomalies returns the anomalies of the blocks from height to height
+// included
+func ListAnomalies(db database.Reader, from, to uint64) ([]*Anomaly, error) {
+	iter := db.IteratorRange(calcAnomalyHeightKey(from), calcAnomalyHeightKey(to+1))
+	defer iter.Release()
+
+	anomalies := []*Anomaly{}
+	for iter.Next() {
+		anomaly := &Anomaly{}
+		if err := json.Unmarshal(iter.Value(), anomaly); err != nil {
+			return nil, errors.Wrap(err, "unmarshaling block anomaly")
+		}
+		anomalies = append(anomalies, anomaly)
+	}
+	return anomalies, iter.Error()
+}
+
+// Anomalies returns the anomalies of the blocks from height to height included
+func (d *AnomalyDetector) Anomalies(from, to uint64) ([]*Anomaly, error) {
+	return ListAnomalies(d.db, from, to)
+}
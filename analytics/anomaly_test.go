@@ -0,0 +1,176 @@
+package analytics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/btm-stats/database"
+	"github.com/btm-stats/protocol/bc"
+	"github.com/btm-stats/protocol/state"
+	"github.com/btm-stats/types"
+)
+
+func newTestNode(parent *state.BlockNode, timestamp uint64) *state.BlockNode {
+	node := &state.BlockNode{Parent: parent, Timestamp: timestamp}
+	if parent != nil {
+		node.Height = parent.Height + 1
+	}
+	node.Hash = bc.NewHash([32]byte{byte(node.Height), byte(timestamp), byte(timestamp >> 8)})
+	return node
+}
+
+func kinds(anomalies []*Anomaly) []string {
+	result := []string{}
+	for _, anomaly := range anomalies {
+		result = append(result, anomaly.Kind)
+	}
+	return result
+}
+
+func TestAnomalyCheck(t *testing.T) {
+	config := AnomalyConfig{FutureSeconds: 900, GapFactor: 10, BurstBlocks: 3, BurstSeconds: 60}
+	now := time.Unix(1527814550, 0)
+
+	// the chain is stamped a block per 150s up to 150s ago
+	var parent *state.BlockNode
+	for i := 0; i < 12; i++ {
+		parent = newTestNode(parent, uint64(now.Unix())-1800+uint64(i)*150)
+	}
+
+	cases := []struct {
+		desc      string
+		timestamp uint64
+		arrival   time.Time
+		want      []string
+	}{
+		{
+			desc:      "normal block",
+			timestamp: parent.Timestamp + 150,
+			arrival:   time.Unix(int64(parent.Timestamp)+151, 0),
+			want:      []string{},
+		},
+		{
+			desc:      "timestamp ahead of the arrival",
+			timestamp: uint64(now.Unix()) + 1000,
+			arrival:   now,
+			want:      []string{FutureTimestamp},
+		},
+		{
+			desc:      "timestamp behind the parent",
+			timestamp: parent.Timestamp - 300,
+			arrival:   now,
+			want:      []string{TimestampRegression},
+		},
+		{
+			desc:      "interval over the gap factor",
+			timestamp: parent.Timestamp + 1501,
+			arrival:   time.Unix(int64(parent.Timestamp)+1502, 0),
+			want:      []string{LongGap},
+		},
+	}
+
+	for _, c := range cases {
+		detector := NewAnomalyDetector(database.NewMemDB(), config, nil)
+		got := kinds(detector.check(newTestNode(parent, c.timestamp), c.arrival))
+		if len(got) != len(c.want) {
+			t.Errorf("%s: got anomalies %v, want %v", c.desc, got, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("%s: got anomalies %v, want %v", c.desc, got, c.want)
+			}
+		}
+	}
+
+	regression := NewAnomalyDetector(database.NewMemDB(), config, nil).check(newTestNode(parent, parent.Timestamp-300), now)
+	if median := parent.CalcPastMedianTime(); regression[0].PastMedian != median || regression[0].ParentTimestamp != parent.Timestamp {
+		t.Errorf("got regression rules %d %d, want %d %d", regression[0].ParentTimestamp, regression[0].PastMedian, parent.Timestamp, median)
+	}
+}
+
+func TestAnomalyBurst(t *testing.T) {
+	config := AnomalyConfig{FutureSeconds: 900, GapFactor: 10, BurstBlocks: 3, BurstSeconds: 60}
+	db := database.NewMemDB()
+	detector := NewAnomalyDetector(db, config, nil)
+	detector.Start()
+
+	now := time.Unix(1527814550, 0)
+	parent := newTestNode(nil, uint64(now.Unix())-600)
+	bursts := []uint64{}
+	for i := 0; i < 4; i++ {
+		// withheld blocks stamped 150s apart released 5s apart
+		node := newTestNode(parent, parent.Timestamp+150)
+		detector.ObserveBlock(node, now.Add(time.Duration(i)*5*time.Second))
+		parent = node
+	}
+	detector.Stop()
+
+	anomalies, err := ListAnomalies(db, 0, parent.Height)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, anomaly := range anomalies {
+		if anomaly.Kind == ArrivalBurst {
+			bursts = append(bursts, anomaly.Height)
+		}
+	}
+	if len(bursts) != 1 || bursts[0] != 3 {
+		t.Errorf("got bursts at heights %v, want [3]", bursts)
+	}
+
+	// the blocks a syncing node catches up with arrive in bursts
+	syncing := NewAnomalyDetector(database.NewMemDB(), config, nil)
+	parent = newTestNode(nil, uint64(now.Unix())-86400)
+	for i := 0; i < 4; i++ {
+		node := newTestNode(parent, parent.Timestamp+150)
+		if got := kinds(syncing.check(node, now)); len(got) != 0 {
+			t.Errorf("got anomalies %v for a synced block, want none", got)
+		}
+		parent = node
+	}
+}
+
+func TestAnomalyEvent(t *testing.T) {
+	evsw := types.NewEventSwitch()
+	if _, err := evsw.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer evsw.Stop()
+
+	eventCh := make(chan types.EventDataBlockAnomaly, 1)
+	types.AddListenerForEvent(evsw, "test", types.EventStringBlockAnomaly(), func(data types.TMEventData) {
+		eventCh <- data.Unwrap().(types.EventDataBlockAnomaly)
+	})
+
+	db := database.NewMemDB()
+	detector := NewAnomalyDetector(db, AnomalyConfig{FutureSeconds: 900}, evsw)
+	detector.Start()
+	defer detector.Stop()
+
+	now := time.Unix(1527814550, 0)
+	node := newTestNode(newTestNode(nil, uint64(now.Unix())-150), uint64(now.Unix())+1000)
+	detector.ObserveBlock(node, now)
+
+	select {
+	case event := <-eventCh:
+		want := types.EventDataBlockAnomaly{
+			Kind:      FutureTimestamp,
+			Height:    node.Height,
+			Hash:      node.Hash.String(),
+			Timestamp: node.Timestamp,
+			Arrival:   uint64(now.Unix()),
+			Detail:    "block timestamp is 1000s ahead of its arrival",
+		}
+		if event != want {
+			t.Errorf("got event %+v, want %+v", event, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the listener didn't receive the block anomaly")
+	}
+
+	// the anomaly is stored before it's fired
+	if anomalies, err := ListAnomalies(db, node.Height, node.Height); err != nil || len(anomalies) != 1 {
+		t.Errorf("got %d stored anomalies err %v, want 1", len(anomalies), err)
+	}
+}
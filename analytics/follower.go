@@ -0,0 +1,242 @@
+// Package analytics derives statistics from the main chain blocks, each
+// processor keeps its records in the core store next to the chain data
+package analytics
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/btm-stats/database"
+	"github.com/btm-stats/errors"
+	"github.com/btm-stats/protocol"
+	"github.com/btm-stats/protocol/bc"
+	"github.com/btm-stats/protocol/bc/types"
+)
+
+// followCycle is the period the followers catch up with the main chain
+const followCycle = 3 * time.Second
+
+var followerPrefix = []byte("AF:")
+
+// Block is a block attached to or detached from the main chain, with the
+// status of its transactions and the header of its parent
+type Block struct {
+	*types.Block
+	Status *bc.TransactionStatus
+	// Parent is nil for the genesis block
+	Parent *types.BlockHeader
+}
+
+// A Processor derives its records from the blocks, the records of a block are
+// written in the batch moving the follower to the block. DetachBlock reverts
+// AttachBlock of the same block.
+type Processor interface {
+	Name() string
+	AttachBlock(batch database.Batch, block *Block) error
+	DetachBlock(batch database.Batch, block *Block) error
+}
+
+// Follower feeds a processor with the main chain blocks in order, the blocks
+// of the chain it left are detached first. It implements leveldb.Deriver so
+// the pruning keeps the bodies it's yet to process.
+type Follower struct {
+	db        database.DB
+	chain     *protocol.Chain
+	processor Processor
+
+	// height is the next height to attach
+	height uint64
+	quit   chan struct{}
+}
+
+// NewFollower creates a follower resuming where the processor stopped
+func NewFollower(db database.DB, chain *protocol.Chain, processor Processor) *Follower {
+	f := &Follower{
+		db:        db,
+		chain:     chain,
+		processor: processor,
+		quit:      make(chan struct{}),
+	}
+	if height, _, ok := f.tip(); ok {
+		f.height = height + 1
+	}
+	return f
+}
+
+func (f *Follower) tipKey() []byte {
+	return append(append([]byte{}, followerPrefix...), f.processor.Name()...)
+}
+
+// tip returns the last block attached to the processor
+func (f *Follower) tip() (uint64, bc.Hash, bool) {
+	data := f.db.Get(f.tipKey())
+	if len(data) != 40 {
+		return 0, bc.Hash{}, false
+	}
+
+	var hashBytes [32]byte
+	copy(hashBytes[:], data[8:])
+	return binary.BigEndian.Uint64(data), bc.NewHash(hashBytes), true
+}
+
+func (f *Follower) setTip(batch database.Batch, height uint64, hash *bc.Hash) {
+	buf := make([]byte, 8, 40)
+	binary.BigEndian.PutUint64(buf, height)
+	batch.Set(f.tipKey(), append(buf, hash.Bytes()...))
+}
+
+// DerivedHeight returns the height up to which the processor is done with
+// the block bodies
+func (f *Follower) DerivedHeight() uint64 {
+	if height := atomic.LoadUint64(&f.height); height > 0 {
+		return height - 1
+	}
+	return 0
+}
+
+// Start catches up with the main chain periodically until Stop
+func (f *Follower) Start() {
+	go f.follow()
+}
+
+// Stop ends the catch up, the block in process is completed first
+func (f *Follower) Stop() {
+	close(f.quit)
+}
+
+func (f *Follower) follow() {
+	ticker := time.NewTicker(followCycle)
+	defer ticker.Stop()
+
+	for {
+		if err := f.sync(); err != nil {
+			log.WithFields(log.Fields{"processor": f.processor.Name(), "err": err}).Error("fail on follow the main chain")
+		}
+
+		select {
+		case <-ticker.C:
+		case <-f.quit:
+			return
+		}
+	}
+}
+
+// sync detaches the blocks the main chain left, then attaches the main
+// chain blocks up to the tip
+func (f *Follower) sync() error {
+	for {
+		select {
+		case <-f.quit:
+			return nil
+		default:
+		}
+
+		height, hash, ok := f.tip()
+		if ok && !f.inMainChain(height, &hash) {
+			if err := f.detachBlock(height, &hash); err != nil {
+				return errors.Wrapf(err, "detach block %d", height)
+			}
+			continue
+		}
+
+		next := uint64(0)
+		if ok {
+			next = height + 1
+		}
+		if next > f.chain.BestBlockHeight() {
+			return nil
+		}
+
+		attached, err := f.attachBlock(next, ok, &hash)
+		if err != nil {
+			return errors.Wrapf(err, "attach block %d", next)
+		}
+		if !attached {
+			// the main chain moved under the follower, check the tip again
+			continue
+		}
+	}
+}
+
+func (f *Follower) inMainChain(height uint64, hash *bc.Hash) bool {
+	header, err := f.chain.GetHeaderByHeight(height)
+	return err == nil && header.Hash() == *hash
+}
+
+func (f *Follower) loadBlock(block *types.Block) (*Block, error) {
+	hash := block.Hash()
+	status, err := f.chain.GetTransactionStatus(&hash)
+	if err != nil {
+		return nil, err
+	}
+
+	loaded := &Block{Block: block, Status: status}
+	if block.Height > 0 {
+		if loaded.Parent, err = f.chain.GetHeaderByHash(&block.PreviousBlockHash); err != nil {
+			return nil, err
+		}
+	}
+	return loaded, nil
+}
+
+// attachBlock attaches the main chain block at the height, it's skipped when
+// it doesn't extend the tip anymore
+func (f *Follower) attachBlock(height uint64, hasTip bool, tipHash *bc.Hash) (bool, error) {
+	block, err := f.chain.GetBlockByHeight(height)
+	if err != nil {
+		return false, err
+	}
+	if hasTip && block.PreviousBlockHash != *tipHash {
+		return false, nil
+	}
+
+	loaded, err := f.loadBlock(block)
+	if err != nil {
+		return false, err
+	}
+
+	batch := f.db.NewBatch()
+	if err := f.processor.AttachBlock(batch, loaded); err != nil {
+		return false, err
+	}
+	hash := block.Hash()
+	f.setTip(batch, height, &hash)
+	if err := batch.Write(); err != nil {
+		return false, err
+	}
+
+	atomic.StoreUint64(&f.height, height+1)
+	return true, nil
+}
+
+func (f *Follower) detachBlock(height uint64, hash *bc.Hash) error {
+	block, err := f.chain.GetBlockByHash(hash)
+	if err != nil {
+		return err
+	}
+
+	loaded, err := f.loadBlock(block)
+	if err != nil {
+		return err
+	}
+
+	batch := f.db.NewBatch()
+	if err := f.processor.DetachBlock(batch, loaded); err != nil {
+		return err
+	}
+	if height == 0 {
+		batch.Delete(f.tipKey())
+	} else {
+		f.setTip(batch, height-1, &block.PreviousBlockHash)
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+
+	atomic.StoreUint64(&f.height, height)
+	log.WithFields(log.Fields{"processor": f.processor.Name(), "height": height, "hash": hash.String()}).Debug("detach block from the analytics")
+	return nil
+}
@@ -0,0 +1,622 @@
+package analytics
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"sort"
+	"time"
+
+	"github.com/btm-stats/consensus"
+	"github.com/btm-stats/database"
+	"github.com/btm-stats/errors"
+	"github.com/btm-stats/protocol/bc"
+)
+
+// secondsPerDay buckets the block timestamps into the days of the snapshots
+const secondsPerDay = 24 * 60 * 60
+
+var (
+	balancePrefix       = []byte("RB:")
+	assetSnapshotPrefix = []byte("RS:")
+	assetTotalsPrefix   = []byte("RT:")
+)
+
+var errNegativeBalance = errors.New("balance drops below zero")
+
+// Holder is the balance of a control program
+type Holder struct {
+	ControlProgram string `json:"control_program"`
+	Balance        uint64 `json:"balance"`
+}
+
+// AssetSnapshot is the distribution of an asset among the control programs at
+// the end of a day
+type AssetSnapshot struct {
+	AssetID bc.AssetID `json:"asset_id"`
+	Day     uint64     `json:"day"`
+	Date    string     `json:"date"`
+	Height  uint64     `json:"height"`
+
+	Supply     uint64    `json:"supply"`
+	Holders    int       `json:"holders"`
+	Gini       float64   `json:"gini"`
+	TopShare   float64   `json:"top_share"`
+	DustCount  int       `json:"dust_count"`
+	DustAmount uint64    `json:"dust_amount"`
+	TopHolders []*Holder `json:"top_holders,omitempty"`
+}
+
+// DayDate renders a day of the snapshots as its UTC date
+func DayDate(day uint64) string {
+	return time.Unix(int64(day*secondsPerDay), 0).UTC().Format("2006-01-02")
+}
+
+// ParseDate returns the day of an UTC date like 2018-06-01
+func ParseDate(date string) (uint64, error) {
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return 0, err
+	}
+	if t.Unix() < 0 {
+		return 0, fmt.Errorf("date %s is before the epoch", date)
+	}
+	return uint64(t.Unix()) / secondsPerDay, nil
+}
+
+func calcBalanceKey(assetID *bc.AssetID, program []byte) []byte {
+	key := append(append([]byte{}, balancePrefix...), assetID.Bytes()...)
+	return append(key, program...)
+}
+
+func calcAssetTotalsKey(assetID *bc.AssetID) []byte {
+	return append(append([]byte{}, assetTotalsPrefix...), assetID.Bytes()...)
+}
+
+func calcAssetSnapshotKey(assetID *bc.AssetID, day uint64) []byte {
+	buf := [8]byte{}
+	binary.BigEndian.PutUint64(buf[:], day)
+	return append(calcAssetSnapshotPrefix(assetID), buf[:]...)
+}
+
+func calcAssetSnapshotPrefix(assetID *bc.AssetID) []byte {
+	return append(append([]byte{}, assetSnapshotPrefix...), assetID.Bytes()...)
+}
+
+// RichList keeps the balance of every control program per asset along the
+// totals of each asset, and writes the snapshot of each asset distribution
+// from its totals when the chain moves past a day. A day snapshot is the
+// state after the last block stamped with the day.
+type RichList struct {
+	db            database.DB
+	size          int
+	dustThreshold uint64
+}
+
+// NewRichList creates the processor keeping the size top holders in the
+// snapshots, the balances below the dust threshold count as dust
+func NewRichList(db database.DB, size int, dustThreshold uint64) *RichList {
+	return &RichList{db: db, size: size, dustThreshold: dustThreshold}
+}
+
+// Name is the name of the processor
+func (r *RichList) Name() string {
+	return "richlist"
+}
+
+type balanceKey struct {
+	assetID bc.AssetID
+	program string
+}
+
+// balanceChange sums the amounts a block adds to and removes from a balance
+type balanceChange struct {
+	in, out uint64
+}
+
+// blockChanges sums the balance changes of the block, a failed transaction
+// only moves BTM as it's charged its gas
+func blockChanges(block *Block) (map[balanceKey]*balanceChange, error) {
+	changes := map[balanceKey]*balanceChange{}
+	change := func(output *bc.Output) *balanceChange {
+		key := balanceKey{assetID: *output.Source.Value.AssetId, program: string(output.ControlProgram.Code)}
+		if changes[key] == nil {
+			changes[key] = &balanceChange{}
+		}
+		return changes[key]
+	}
+
+	for i, tx := range block.Transactions {
+		statusFail, err := block.Status.GetStatus(i)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, prevout := range tx.SpentOutputIDs {
+			output, ok := tx.Entries[prevout].(*bc.Output)
+			if !ok {
+				return nil, fmt.Errorf("tx %s spends unknown output %s", tx.ID.String(), prevout.String())
+			}
+			if statusFail && *output.Source.Value.AssetId != *consensus.BTMAssetID {
+				continue
+			}
+			change(output).out += output.Source.Value.Amount
+		}
+
+		for _, id := range tx.ResultIds {
+			output, ok := tx.Entries[*id].(*bc.Output)
+			if !ok {
+				// retirements don't credit any program
+				continue
+			}
+			if statusFail && *output.Source.Value.AssetId != *consensus.BTMAssetID {
+				continue
+			}
+			change(output).in += output.Source.Value.Amount
+		}
+	}
+	return changes, nil
+}
+
+func (r *RichList) balance(key []byte) uint64 {
+	data := r.db.Get(key)
+	if len(data) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(data)
+}
+
+// assetTotals sums the balances of an asset along the blocks. The holders
+// whose balance is above every other one are kept in a min-heap of twice the
+// rich list size, the holders left out have at most Bound. The dust sums
+// follow the threshold the balances moved under.
+type assetTotals struct {
+	Holders    int    `json:"holders"`
+	Supply     uint64 `json:"supply"`
+	DustCount  int    `json:"dust_count"`
+	DustAmount uint64 `json:"dust_amount"`
+
+	// Counts and Sums bucket the balances by their bit length, the Gini
+	// coefficient is computed over the buckets
+	Counts [65]int    `json:"counts"`
+	Sums   [65]uint64 `json:"sums"`
+
+	Top   holderHeap `json:"top"`
+	Bound uint64     `json:"bound"`
+}
+
+// holderHeap is a min-heap of the holders by balance
+type holderHeap []*Holder
+
+func (h holderHeap) Len() int            { return len(h) }
+func (h holderHeap) Less(i, j int) bool  { return h[i].Balance < h[j].Balance }
+func (h holderHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *holderHeap) Push(x interface{}) { *h = append(*h, x.(*Holder)) }
+func (h *holderHeap) Pop() interface{} {
+	old := *h
+	holder := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return holder
+}
+
+// update moves the balance of the program from old to new
+func (t *assetTotals) update(program string, old, new uint64, size int, dustThreshold uint64) {
+	if old > 0 {
+		t.Holders--
+		t.Supply -= old
+		t.Counts[bits.Len64(old)]--
+		t.Sums[bits.Len64(old)] -= old
+		if old < dustThreshold {
+			t.DustCount--
+			t.DustAmount -= old
+		}
+	}
+	if new > 0 {
+		t.Holders++
+		t.Supply += new
+		t.Counts[bits.Len64(new)]++
+		t.Sums[bits.Len64(new)] += new
+		if new < dustThreshold {
+			t.DustCount++
+			t.DustAmount += new
+		}
+	}
+	t.updateTop(program, new, 2*size)
+	// no holder is left out of the heap once it tracks them all
+	if len(t.Top) == t.Holders {
+		t.Bound = 0
+	}
+}
+
+// updateTop keeps the heap of the largest balances, a holder left out or
+// dropped off the heap raises the bound of the ones outside
+func (t *assetTotals) updateTop(program string, balance uint64, capacity int) {
+	for i, holder := range t.Top {
+		if holder.ControlProgram != program {
+			continue
+		}
+		if balance == 0 {
+			heap.Remove(&t.Top, i)
+			return
+		}
+		holder.Balance = balance
+		heap.Fix(&t.Top, i)
+		return
+	}
+
+	switch {
+	case balance == 0:
+	case len(t.Top) < capacity:
+		heap.Push(&t.Top, &Holder{ControlProgram: program, Balance: balance})
+	case capacity > 0 && balance > t.Top[0].Balance:
+		dropped := heap.Pop(&t.Top).(*Holder)
+		heap.Push(&t.Top, &Holder{ControlProgram: program, Balance: balance})
+		balance = dropped.Balance
+		fallthrough
+	default:
+		if balance > t.Bound {
+			t.Bound = balance
+		}
+	}
+}
+
+// topHolders returns the size largest holders, it fails when the heap ran
+// short of the holders above the bound
+func (t *assetTotals) topHolders(size int) ([]*Holder, bool) {
+	holders := make([]*Holder, len(t.Top))
+	copy(holders, t.Top)
+	sort.Slice(holders, func(i, j int) bool { return holders[i].Balance > holders[j].Balance })
+	if len(holders) > size {
+		holders = holders[:size]
+	}
+
+	if len(t.Top) == t.Holders || size == 0 {
+		return holders, true
+	}
+	return holders, len(holders) == size && holders[size-1].Balance >= t.Bound
+}
+
+// loadTotals returns the totals of the asset, the totals missing along
+// stored balances are summed from them
+func (r *RichList) loadTotals(totals map[bc.AssetID]*assetTotals, assetID bc.AssetID) (*assetTotals, error) {
+	if t, ok := totals[assetID]; ok {
+		return t, nil
+	}
+
+	t := &assetTotals{}
+	if data := r.db.Get(calcAssetTotalsKey(&assetID)); data != nil {
+		if err := json.Unmarshal(data, t); err != nil {
+			return nil, errors.Wrap(err, "unmarshaling asset totals")
+		}
+	} else {
+		var err error
+		if t, err = r.scanTotals(assetID); err != nil {
+			return nil, err
+		}
+	}
+	totals[assetID] = t
+	return t, nil
+}
+
+// scanTotals sums the stored balances of the asset
+func (r *RichList) scanTotals(assetID bc.AssetID) (*assetTotals, error) {
+	prefix := calcBalanceKey(&assetID, nil)
+	iter := r.db.IteratorPrefix(prefix)
+	defer iter.Release()
+
+	t := &assetTotals{}
+	for iter.Next() {
+		if len(iter.Value()) != 8 {
+			continue
+		}
+		program := hex.EncodeToString(iter.Key()[len(prefix):])
+		t.update(program, 0, binary.BigEndian.Uint64(iter.Value()), r.size, r.dustThreshold)
+	}
+	return t, iter.Error()
+}
+
+// loadStoredTotals sums the totals of every asset from the balances stored
+// before the totals were kept, the stores keeping them are left as they are
+func (r *RichList) loadStoredTotals(totals map[bc.AssetID]*assetTotals) error {
+	if hasPrefix(r.db, assetTotalsPrefix) || !hasPrefix(r.db, balancePrefix) {
+		return nil
+	}
+
+	iter := r.db.IteratorPrefix(balancePrefix)
+	defer iter.Release()
+
+	for iter.Next() {
+		key := iter.Key()[len(balancePrefix):]
+		if len(key) < 32 || len(iter.Value()) != 8 {
+			continue
+		}
+
+		var assetBytes [32]byte
+		copy(assetBytes[:], key[:32])
+		assetID := bc.NewAssetID(assetBytes)
+		if totals[assetID] == nil {
+			totals[assetID] = &assetTotals{}
+		}
+		totals[assetID].update(hex.EncodeToString(key[32:]), 0, binary.BigEndian.Uint64(iter.Value()), r.size, r.dustThreshold)
+	}
+	return iter.Error()
+}
+
+func hasPrefix(db database.DB, prefix []byte) bool {
+	iter := db.IteratorPrefix(prefix)
+	defer iter.Release()
+	return iter.Next()
+}
+
+// saveTotals writes the totals, the assets left without holders are deleted
+func saveTotals(batch database.Batch, totals map[bc.AssetID]*assetTotals) error {
+	for assetID, t := range totals {
+		key := calcAssetTotalsKey(&assetID)
+		if t.Holders == 0 {
+			batch.Delete(key)
+			continue
+		}
+
+		data, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		batch.Set(key, data)
+	}
+	return nil
+}
+
+// applyChanges credits the in amounts and debits the out ones, or the
+// reverse to detach the block, the totals of the assets follow the balances
+func (r *RichList) applyChanges(batch database.Batch, totals map[bc.AssetID]*assetTotals, changes map[balanceKey]*balanceChange, detach bool) error {
+	for key, change := range changes {
+		in, out := change.in, change.out
+		if detach {
+			in, out = out, in
+		}
+
+		dbKey := calcBalanceKey(&key.assetID, []byte(key.program))
+		old := r.balance(dbKey)
+		balance := old + in
+		if balance < out {
+			return errors.WithDetailf(errNegativeBalance, "asset %s program %x", key.assetID.String(), key.program)
+		}
+
+		t, err := r.loadTotals(totals, key.assetID)
+		if err != nil {
+			return err
+		}
+		balance -= out
+		t.update(hex.EncodeToString([]byte(key.program)), old, balance, r.size, r.dustThreshold)
+
+		if balance == 0 {
+			batch.Delete(dbKey)
+			continue
+		}
+		buf := [8]byte{}
+		binary.BigEndian.PutUint64(buf[:], balance)
+		batch.Set(dbKey, buf[:])
+	}
+	return saveTotals(batch, totals)
+}
+
+// AttachBlock credits the outputs of the block and debits the ones it spends,
+// the first block past a day snapshots the balances of every day from its
+// parent's up to the one before it
+func (r *RichList) AttachBlock(batch database.Batch, block *Block) error {
+	totals := map[bc.AssetID]*assetTotals{}
+	if err := r.loadStoredTotals(totals); err != nil {
+		return err
+	}
+	if from, to, ok := snapshotDays(block); ok {
+		if err := r.saveSnapshots(batch, totals, from, to, block.Parent.Height); err != nil {
+			return err
+		}
+	}
+
+	changes, err := blockChanges(block)
+	if err != nil {
+		return err
+	}
+	return r.applyChanges(batch, totals, changes, false)
+}
+
+// DetachBlock reverts the balance changes of the block and deletes the
+// snapshots it triggered, they're written again once the chain moves past
+// the day again
+func (r *RichList) DetachBlock(batch database.Batch, block *Block) error {
+	changes, err := blockChanges(block)
+	if err != nil {
+		return err
+	}
+	totals := map[bc.AssetID]*assetTotals{}
+	if err := r.loadStoredTotals(totals); err != nil {
+		return err
+	}
+
+	if from, to, ok := snapshotDays(block); ok {
+		// the assets snapshotted are the ones held before the block, they
+		// hold the asset now or the block moved it
+		assets := map[bc.AssetID]bool{}
+		for key := range changes {
+			assets[key.assetID] = true
+		}
+		held, err := r.totalsAssets(totals)
+		if err != nil {
+			return err
+		}
+		for _, assetID := range held {
+			assets[assetID] = true
+		}
+
+		for assetID := range assets {
+			for day := from; day <= to; day++ {
+				batch.Delete(calcAssetSnapshotKey(&assetID, day))
+			}
+		}
+	}
+	return r.applyChanges(batch, totals, changes, true)
+}
+
+// snapshotDays returns the days the block moves the chain past, if any. The
+// days without a block keep the balances of the day before them.
+func snapshotDays(block *Block) (uint64, uint64, bool) {
+	if block.Parent == nil {
+		return 0, 0, false
+	}
+	from, day := block.Parent.Timestamp/secondsPerDay, block.Timestamp/secondsPerDay
+	if day <= from {
+		return 0, 0, false
+	}
+	return from, day - 1, true
+}
+
+// saveSnapshots writes the snapshot of each asset for the days from its
+// totals, the totals whose top holders ran short are summed again
+func (r *RichList) saveSnapshots(batch database.Batch, totals map[bc.AssetID]*assetTotals, from, to, height uint64) error {
+	assets, err := r.totalsAssets(totals)
+	if err != nil {
+		return err
+	}
+
+	for _, assetID := range assets {
+		t, err := r.loadTotals(totals, assetID)
+		if err != nil {
+			return err
+		}
+		if _, ok := t.topHolders(r.size); !ok {
+			if t, err = r.scanTotals(assetID); err != nil {
+				return err
+			}
+			totals[assetID] = t
+		}
+
+		snapshot := r.snapshot(assetID, t)
+		for day := from; day <= to; day++ {
+			snapshot.Day, snapshot.Date, snapshot.Height = day, DayDate(day), height
+			data, err := json.Marshal(snapshot)
+			if err != nil {
+				return err
+			}
+			batch.Set(calcAssetSnapshotKey(&assetID, day), data)
+		}
+	}
+	return nil
+}
+
+// totalsAssets lists the assets of the stored totals and of the ones loaded
+func (r *RichList) totalsAssets(totals map[bc.AssetID]*assetTotals) ([]bc.AssetID, error) {
+	assets := []bc.AssetID{}
+	for assetID := range totals {
+		assets = append(assets, assetID)
+	}
+
+	iter := r.db.IteratorPrefix(assetTotalsPrefix)
+	defer iter.Release()
+	for iter.Next() {
+		key := iter.Key()[len(assetTotalsPrefix):]
+		if len(key) != 32 {
+			continue
+		}
+
+		var assetBytes [32]byte
+		copy(assetBytes[:], key)
+		if assetID := bc.NewAssetID(assetBytes); totals[assetID] == nil {
+			assets = append(assets, assetID)
+		}
+	}
+	return assets, iter.Error()
+}
+
+// snapshot sums the distribution of the asset. The Gini coefficient goes
+// from 0 when every holder has the same balance to nearly 1 when a single
+// holder has it all, the holders of a balance bucket count as holding its
+// mean.
+func (r *RichList) snapshot(assetID bc.AssetID, t *assetTotals) *AssetSnapshot {
+	snapshot := &AssetSnapshot{
+		AssetID:    assetID,
+		Supply:     t.Supply,
+		Holders:    t.Holders,
+		DustCount:  t.DustCount,
+		DustAmount: t.DustAmount,
+	}
+	if snapshot.Supply == 0 {
+		return snapshot
+	}
+
+	// the ranks from the smallest balance, starting at 1, sum to the mean
+	// rank of the bucket times its balances
+	var weighted float64
+	ranked := 0
+	for i, count := range t.Counts {
+		weighted += (float64(ranked) + float64(count+1)/2) * float64(t.Sums[i])
+		ranked += count
+	}
+	n, supply := float64(t.Holders), float64(snapshot.Supply)
+	snapshot.Gini = 2*weighted/(n*supply) - (n+1)/n
+
+	holders, _ := t.topHolders(r.size)
+	var topSupply uint64
+	for _, holder := range holders {
+		topSupply += holder.Balance
+	}
+	snapshot.TopShare = float64(topSupply) / supply
+	snapshot.TopHolders = holders
+	return snapshot
+}
+
+// GetAssetSnapshot returns the snapshot of the asset for the day, or the
+// latest one for a zero day
+func GetAssetSnapshot(db database.Reader, assetID *bc.AssetID, day uint64) (*AssetSnapshot, error) {
+	var data []byte
+	if day != 0 {
+		data = db.Get(calcAssetSnapshotKey(assetID, day))
+	} else {
+		iter := db.IteratorPrefix(calcAssetSnapshotPrefix(assetID))
+		for iter.Next() {
+			data = append(data[:0], iter.Value()...)
+		}
+		iter.Release()
+	}
+	if data == nil {
+		return nil, fmt.Errorf("can't find the snapshot of asset %s", assetID.String())
+	}
+
+	snapshot := &AssetSnapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, errors.Wrap(err, "unmarshaling asset snapshot")
+	}
+	return snapshot, nil
+}
+
+// ListAssetSnapshots returns the snapshots of the asset from day to day
+// included, without their top holders
+func ListAssetSnapshots(db database.Reader, assetID *bc.AssetID, from, to uint64) ([]*AssetSnapshot, error) {
+	iter := db.IteratorRange(calcAssetSnapshotKey(assetID, from), calcAssetSnapshotKey(assetID, to+1))
+	defer iter.Release()
+
+	snapshots := []*AssetSnapshot{}
+	for iter.Next() {
+		snapshot := &AssetSnapshot{}
+		if err := json.Unmarshal(iter.Value(), snapshot); err != nil {
+			return nil, errors.Wrap(err, "unmarshaling asset snapshot")
+		}
+		snapshot.TopHolders = nil
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, iter.Error()
+}
+
+// Snapshot returns the snapshot of the asset for the day, or the latest one
+// for a zero day
+func (r *RichList) Snapshot(assetID *bc.AssetID, day uint64) (*AssetSnapshot, error) {
+	return GetAssetSnapshot(r.db, assetID, day)
+}
+
+// Snapshots returns the snapshots of the asset from day to day included
+func (r *RichList) Snapshots(assetID *bc.AssetID, from, to uint64) ([]*AssetSnapshot, error) {
+	return ListAssetSnapshots(r.db, assetID, from, to)
+}
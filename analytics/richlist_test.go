@@ -0,0 +1,300 @@
+package analytics
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/btm-stats/consensus"
+	"github.com/btm-stats/database"
+	"github.com/btm-stats/protocol/bc"
+	"github.com/btm-stats/protocol/bc/types"
+)
+
+var testAsset = bc.AssetID{V0: 1}
+
+func newTestCoinbase(height, amount uint64, program []byte) *types.Tx {
+	return types.NewTx(types.TxData{
+		Version:        1,
+		SerializedSize: 1,
+		Inputs:         []*types.TxInput{types.NewCoinbaseInput([]byte{byte(height)})},
+		Outputs:        []*types.TxOutput{types.NewTxOutput(*consensus.BTMAssetID, amount, program)},
+	})
+}
+
+// newTestTransfer spends the inputs into the outputs, the source of each
+// input is told apart by its position
+func newTestTransfer(inputs []*types.TxInput, outputs []*types.TxOutput) *types.Tx {
+	return types.NewTx(types.TxData{Version: 1, SerializedSize: 100, Inputs: inputs, Outputs: outputs})
+}
+
+func newTestSpend(pos uint64, assetID bc.AssetID, amount uint64, program []byte) *types.TxInput {
+	return types.NewSpendInput(nil, bc.Hash{V0: 1}, assetID, amount, pos, program)
+}
+
+// newTestBlock stamps the block after its parent, the failed transactions are
+// flagged in its status
+func newTestBlock(parent *types.BlockHeader, timestamp uint64, txs []*types.Tx, failed ...int) *Block {
+	block := &Block{
+		Block:  &types.Block{BlockHeader: types.BlockHeader{Timestamp: timestamp}, Transactions: txs},
+		Status: bc.NewTransactionStatus(),
+		Parent: parent,
+	}
+	if parent != nil {
+		block.Height = parent.Height + 1
+	}
+	for i := range txs {
+		block.Status.SetStatus(i, false)
+	}
+	for _, i := range failed {
+		block.Status.SetStatus(i, true)
+	}
+	return block
+}
+
+func newTestTotals(balances []uint64, size int, dustThreshold uint64) *assetTotals {
+	t := &assetTotals{}
+	for i, balance := range balances {
+		t.update(hex.EncodeToString([]byte{byte(i)}), 0, balance, size, dustThreshold)
+	}
+	return t
+}
+
+func TestSnapshot(t *testing.T) {
+	r := NewRichList(nil, 2, 10)
+	cases := []struct {
+		desc     string
+		balances []uint64
+		gini     float64
+		topShare float64
+		dust     int
+	}{
+		{desc: "equal balances", balances: []uint64{100, 100, 100, 100}, gini: 0, topShare: 0.5},
+		{desc: "a single whale", balances: []uint64{1, 1, 1, 400}, gini: 2*1606.0/(4*403) - 1.25, topShare: 401.0 / 403, dust: 3},
+		{desc: "a balance per bucket", balances: []uint64{5, 20, 40, 100}, gini: 2*565.0/(4*165) - 1.25, topShare: 140.0 / 165, dust: 1},
+		{desc: "the balances of a bucket count as its mean", balances: []uint64{20, 25}, gini: 0, topShare: 1},
+	}
+
+	for _, c := range cases {
+		snapshot := r.snapshot(bc.AssetID{}, newTestTotals(c.balances, 2, 10))
+		if math.Abs(snapshot.Gini-c.gini) > 1e-9 || math.Abs(snapshot.TopShare-c.topShare) > 1e-9 {
+			t.Errorf("%s: got gini %v top share %v, want %v %v", c.desc, snapshot.Gini, snapshot.TopShare, c.gini, c.topShare)
+		}
+		if snapshot.DustCount != c.dust || snapshot.Holders != len(c.balances) || len(snapshot.TopHolders) != 2 {
+			t.Errorf("%s: got %d dust %d holders %d top holders", c.desc, snapshot.DustCount, snapshot.Holders, len(snapshot.TopHolders))
+		}
+		if snapshot.TopHolders[0].Balance < snapshot.TopHolders[1].Balance {
+			t.Errorf("%s: top holders aren't sorted by balance", c.desc)
+		}
+	}
+}
+
+func TestTopHoldersRescan(t *testing.T) {
+	db := database.NewMemDB()
+	r := NewRichList(db, 1, 0)
+	balances := map[string]uint64{"a": 30, "b": 20, "c": 10}
+	totals := &assetTotals{}
+	for program, balance := range balances {
+		buf := [8]byte{}
+		binary.BigEndian.PutUint64(buf[:], balance)
+		db.Set(calcBalanceKey(&testAsset, []byte(program)), buf[:])
+		totals.update(hex.EncodeToString([]byte(program)), 0, balance, r.size, r.dustThreshold)
+	}
+	if top, ok := totals.topHolders(r.size); !ok || len(top) != 1 || top[0].Balance != 30 {
+		t.Fatalf("got top holders %v ok %v, want the balance 30", top, ok)
+	}
+
+	// the two holders kept by the heap leave, the one left out is unknown
+	for _, program := range []string{"a", "b"} {
+		totals.update(hex.EncodeToString([]byte(program)), balances[program], 0, r.size, r.dustThreshold)
+		db.Delete(calcBalanceKey(&testAsset, []byte(program)))
+	}
+	if _, ok := totals.topHolders(r.size); ok {
+		t.Fatal("the top holders don't run short")
+	}
+
+	scanned, err := r.scanTotals(testAsset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if top, ok := scanned.topHolders(r.size); !ok || len(top) != 1 || top[0].Balance != 10 {
+		t.Fatalf("got rescanned top holders %v ok %v, want the balance 10", top, ok)
+	}
+	if scanned.Holders != totals.Holders || scanned.Supply != totals.Supply {
+		t.Errorf("got rescanned %d holders supply %d, want %d %d", scanned.Holders, scanned.Supply, totals.Holders, totals.Supply)
+	}
+}
+
+func TestRichListAttachDetach(t *testing.T) {
+	db := database.NewMemDB()
+	r := NewRichList(db, 2, 150)
+	btm := *consensus.BTMAssetID
+	progA, progB, progC := []byte{0x51, 1}, []byte{0x51, 2}, []byte{0x51, 3}
+
+	day := uint64(1527811200)
+	genesis := newTestBlock(nil, day, []*types.Tx{newTestCoinbase(0, 300, progA)})
+	transfer := newTestBlock(&genesis.BlockHeader, day+150, []*types.Tx{
+		newTestCoinbase(1, 0, progA),
+		newTestTransfer(
+			[]*types.TxInput{newTestSpend(0, btm, 300, progA)},
+			[]*types.TxOutput{types.NewTxOutput(btm, 200, progB), types.NewTxOutput(btm, 100, progC)},
+		),
+	})
+	// the block past the day snapshots it, the failed transaction only moves
+	// its BTM
+	nextDay := newTestBlock(&transfer.BlockHeader, day+secondsPerDay, []*types.Tx{
+		newTestCoinbase(2, 50, progA),
+		newTestTransfer(
+			[]*types.TxInput{newTestSpend(1, btm, 200, progB), newTestSpend(2, testAsset, 5, progC)},
+			[]*types.TxOutput{types.NewTxOutput(btm, 190, progB), types.NewTxOutput(testAsset, 5, progA)},
+		),
+	}, 1)
+
+	apply := func(block *Block, detach bool) {
+		batch := db.NewBatch()
+		process := r.AttachBlock
+		if detach {
+			process = r.DetachBlock
+		}
+		if err := process(batch, block); err != nil {
+			t.Fatal(err)
+		}
+		if err := batch.Write(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// state dumps the balances and the snapshot the totals would give
+	state := func() (map[string]string, *AssetSnapshot) {
+		totals, err := r.loadTotals(map[bc.AssetID]*assetTotals{}, btm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dumpPrefix(db, balancePrefix), r.snapshot(btm, totals)
+	}
+
+	apply(genesis, false)
+	apply(transfer, false)
+	wantBalances, wantSnapshot := state()
+	apply(nextDay, false)
+
+	snapshot, err := r.Snapshot(&btm, day/secondsPerDay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &AssetSnapshot{
+		AssetID: btm, Day: day / secondsPerDay, Date: DayDate(day / secondsPerDay), Height: 1,
+		Supply: 300, Holders: 2, Gini: 2*500.0/(2*300) - 1.5, TopShare: 1, DustCount: 1, DustAmount: 100,
+		TopHolders: []*Holder{{ControlProgram: hex.EncodeToString(progB), Balance: 200}, {ControlProgram: hex.EncodeToString(progC), Balance: 100}},
+	}
+	if !reflect.DeepEqual(snapshot, want) {
+		t.Errorf("got snapshot %+v, want %+v", snapshot, want)
+	}
+	if _, err := r.Snapshot(&testAsset, day/secondsPerDay); err == nil {
+		t.Error("the failed transaction moved the asset")
+	}
+	if _, snapshot := state(); snapshot.Supply != 340 || snapshot.Holders != 3 {
+		t.Errorf("got supply %d holders %d after the day, want 340 3", snapshot.Supply, snapshot.Holders)
+	}
+
+	apply(nextDay, true)
+	if _, err := r.Snapshot(&btm, day/secondsPerDay); err == nil {
+		t.Error("the snapshot of the detached block is kept")
+	}
+	if balances, snapshot := state(); !reflect.DeepEqual(balances, wantBalances) || !reflect.DeepEqual(snapshot, wantSnapshot) {
+		t.Errorf("got balances %v snapshot %+v after the detach, want %v %+v", balances, snapshot, wantBalances, wantSnapshot)
+	}
+
+	apply(transfer, true)
+	apply(genesis, true)
+	iter := db.IteratorRange(nil, nil)
+	defer iter.Release()
+	if iter.Next() {
+		t.Fatalf("key %x is left after detaching every block", iter.Key())
+	}
+}
+
+func TestTopHoldersBoundReset(t *testing.T) {
+	totals := newTestTotals([]uint64{30, 20, 10}, 1, 0)
+	if totals.Bound != 10 {
+		t.Fatalf("got bound %d, want the balance left out 10", totals.Bound)
+	}
+
+	// the holder left out leaves, the heap tracks every holder again
+	totals.update(hex.EncodeToString([]byte{2}), 10, 0, 1, 0)
+	if totals.Bound != 0 {
+		t.Errorf("got bound %d once every holder is tracked, want 0", totals.Bound)
+	}
+
+	// the new holders below the heap only raise the bound to their balance
+	totals.update(hex.EncodeToString([]byte{3}), 0, 5, 1, 0)
+	if totals.Bound != 5 {
+		t.Errorf("got bound %d, want the balance left out 5", totals.Bound)
+	}
+	totals.update(hex.EncodeToString([]byte{0}), 30, 0, 1, 0)
+	if top, ok := totals.topHolders(1); !ok || len(top) != 1 || top[0].Balance != 20 || totals.Bound != 5 {
+		t.Errorf("got top holders %v ok %v bound %d, want the balance 20 above the bound 5", top, ok, totals.Bound)
+	}
+}
+
+func TestRichListDayGap(t *testing.T) {
+	db := database.NewMemDB()
+	r := NewRichList(db, 2, 0)
+	btm := *consensus.BTMAssetID
+
+	day := uint64(1527811200)
+	genesis := newTestBlock(nil, day, []*types.Tx{newTestCoinbase(0, 300, []byte{0x51, 1})})
+	// the block three days later snapshots the day of its parent and the two
+	// days without a block
+	gap := newTestBlock(&genesis.BlockHeader, day+3*secondsPerDay+10, []*types.Tx{newTestCoinbase(1, 50, []byte{0x51, 2})})
+
+	for _, block := range []*Block{genesis, gap} {
+		batch := db.NewBatch()
+		if err := r.AttachBlock(batch, block); err != nil {
+			t.Fatal(err)
+		}
+		if err := batch.Write(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first := day / secondsPerDay
+	for d := first; d < first+3; d++ {
+		snapshot, err := r.Snapshot(&btm, d)
+		if err != nil {
+			t.Fatalf("day %d: %v", d, err)
+		}
+		if snapshot.Day != d || snapshot.Date != DayDate(d) || snapshot.Height != 0 || snapshot.Supply != 300 || snapshot.Holders != 1 {
+			t.Errorf("day %d: got day %d date %s height %d supply %d holders %d, want the state of the genesis", d, snapshot.Day, snapshot.Date, snapshot.Height, snapshot.Supply, snapshot.Holders)
+		}
+	}
+	if _, err := r.Snapshot(&btm, first+3); err == nil {
+		t.Error("the day of the block is snapshotted before it ends")
+	}
+
+	batch := db.NewBatch()
+	if err := r.DetachBlock(batch, gap); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Write(); err != nil {
+		t.Fatal(err)
+	}
+	for d := first; d < first+3; d++ {
+		if _, err := r.Snapshot(&btm, d); err == nil {
+			t.Errorf("day %d: the snapshot of the detached block is kept", d)
+		}
+	}
+}
+
+// dumpPrefix returns the records of the db under the prefix
+func dumpPrefix(db database.DB, prefix []byte) map[string]string {
+	iter := db.IteratorPrefix(prefix)
+	defer iter.Release()
+
+	records := map[string]string{}
+	for iter.Next() {
+		records[string(iter.Key())] = string(iter.Value())
+	}
+	return records
+}
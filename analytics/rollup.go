@@ -0,0 +1,349 @@
+package analytics
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/btm-stats/consensus"
+	"github.com/btm-stats/database"
+	"github.com/btm-stats/errors"
+	"github.com/btm-stats/protocol/bc"
+)
+
+// Rollup periods
+const (
+	HourPeriod = "hour"
+	DayPeriod  = "day"
+)
+
+var periodSeconds = map[string]uint64{
+	HourPeriod: 60 * 60,
+	DayPeriod:  secondsPerDay,
+}
+
+var (
+	rollupPrefix        = []byte("RU:")
+	rollupProgramPrefix = []byte("RP:")
+	firstAssetPrefix    = []byte("RN:")
+)
+
+var errUnknownPeriod = errors.New("unknown rollup period")
+
+// BucketStats sums the activity of the blocks stamped within a bucket, the
+// volumes exclude the coinbase whose amount above the subsidy is the fees
+type BucketStats struct {
+	Period string `json:"period"`
+	Start  uint64 `json:"start"`
+
+	Blocks         uint64            `json:"blocks"`
+	Intervals      uint64            `json:"intervals"`
+	IntervalSum    int64             `json:"interval_sum"`
+	Txs            uint64            `json:"txs"`
+	Fees           uint64            `json:"fees"`
+	ActivePrograms uint64            `json:"active_programs"`
+	NewAssets      uint64            `json:"new_assets"`
+	Volumes        map[string]uint64 `json:"volumes"`
+}
+
+// AvgInterval returns the average seconds between the blocks of the bucket
+// and their parents
+func (b *BucketStats) AvgInterval() float64 {
+	if b.Intervals == 0 {
+		return 0
+	}
+	return float64(b.IntervalSum) / float64(b.Intervals)
+}
+
+// ParseTime reads an UTC date like 2018-06-01 or a RFC3339 time
+func ParseTime(s string) (uint64, error) {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		if t, err = time.Parse(time.RFC3339, s); err != nil {
+			return 0, fmt.Errorf("invalid time %q, want a date like 2018-06-01 or a RFC3339 time", s)
+		}
+	}
+	if t.Unix() < 0 {
+		return 0, fmt.Errorf("time %s is before the epoch", s)
+	}
+	return uint64(t.Unix()), nil
+}
+
+func calcRollupPrefix(period string) []byte {
+	return append(append([]byte{}, rollupPrefix...), period[0])
+}
+
+func calcRollupKey(period string, start uint64) []byte {
+	buf := [8]byte{}
+	binary.BigEndian.PutUint64(buf[:], start)
+	return append(calcRollupPrefix(period), buf[:]...)
+}
+
+func calcRollupProgramKey(period string, start uint64, program []byte) []byte {
+	buf := [8]byte{}
+	binary.BigEndian.PutUint64(buf[:], start)
+	key := append(append(append([]byte{}, rollupProgramPrefix...), period[0]), buf[:]...)
+	return append(key, program...)
+}
+
+func calcFirstAssetKey(assetID *bc.AssetID) []byte {
+	return append(append([]byte{}, firstAssetPrefix...), assetID.Bytes()...)
+}
+
+// Rollups sums the per-block activity into hourly and daily buckets by the
+// block timestamps
+type Rollups struct {
+	db database.DB
+}
+
+// NewRollups creates the processor of the rollups
+func NewRollups(db database.DB) *Rollups {
+	return &Rollups{db: db}
+}
+
+// Name is the name of the processor
+func (r *Rollups) Name() string {
+	return "rollups"
+}
+
+// blockActivity is what a block adds to its buckets
+type blockActivity struct {
+	txs      uint64
+	fees     uint64
+	programs map[string]bool
+	volumes  map[bc.AssetID]uint64
+	assets   map[bc.AssetID]bool
+}
+
+// calcActivity sums the activity of the block, a failed transaction only
+// moves BTM as it's charged its gas
+func calcActivity(block *Block) (*blockActivity, error) {
+	activity := &blockActivity{
+		txs:      uint64(len(block.Transactions)),
+		programs: map[string]bool{},
+		volumes:  map[bc.AssetID]uint64{},
+		assets:   map[bc.AssetID]bool{},
+	}
+
+	for i, tx := range block.Transactions {
+		statusFail, err := block.Status.GetStatus(i)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, prevout := range tx.SpentOutputIDs {
+			output, ok := tx.Entries[prevout].(*bc.Output)
+			if !ok {
+				return nil, fmt.Errorf("tx %s spends unknown output %s", tx.ID.String(), prevout.String())
+			}
+			if statusFail && *output.Source.Value.AssetId != *consensus.BTMAssetID {
+				continue
+			}
+			activity.programs[string(output.ControlProgram.Code)] = true
+		}
+
+		for _, id := range tx.ResultIds {
+			output, ok := tx.Entries[*id].(*bc.Output)
+			if !ok {
+				continue
+			}
+			if statusFail && *output.Source.Value.AssetId != *consensus.BTMAssetID {
+				continue
+			}
+
+			assetID := *output.Source.Value.AssetId
+			activity.programs[string(output.ControlProgram.Code)] = true
+			activity.assets[assetID] = true
+			if i == 0 {
+				// the coinbase pays the subsidy and the fees of the block
+				activity.fees += output.Source.Value.Amount
+				continue
+			}
+			activity.volumes[assetID] += output.Source.Value.Amount
+		}
+	}
+
+	if subsidy := consensus.BlockSubsidy(block.Height); activity.fees > subsidy {
+		activity.fees -= subsidy
+	} else {
+		activity.fees = 0
+	}
+	return activity, nil
+}
+
+func (r *Rollups) getBucket(period string, start uint64) (*BucketStats, error) {
+	data := r.db.Get(calcRollupKey(period, start))
+	if data == nil {
+		return &BucketStats{Period: period, Start: start, Volumes: map[string]uint64{}}, nil
+	}
+
+	bucket := &BucketStats{}
+	if err := json.Unmarshal(data, bucket); err != nil {
+		return nil, errors.Wrap(err, "unmarshaling rollup bucket")
+	}
+	if bucket.Volumes == nil {
+		bucket.Volumes = map[string]uint64{}
+	}
+	return bucket, nil
+}
+
+// countProgram counts the blocks of the bucket a program is active in, it
+// returns the change of the active programs of the bucket
+func (r *Rollups) countProgram(batch database.Batch, period string, start uint64, program []byte, detach bool) int {
+	key := calcRollupProgramKey(period, start, program)
+	count := uint64(0)
+	if data := r.db.Get(key); len(data) == 8 {
+		count = binary.BigEndian.Uint64(data)
+	}
+
+	if detach {
+		if count <= 1 {
+			batch.Delete(key)
+			return -1
+		}
+		count--
+	} else {
+		count++
+	}
+
+	buf := [8]byte{}
+	binary.BigEndian.PutUint64(buf[:], count)
+	batch.Set(key, buf[:])
+	if count == 1 {
+		return 1
+	}
+	return 0
+}
+
+// newAssets records the assets the block shows first, or forgets them when
+// the block is detached
+func (r *Rollups) newAssets(batch database.Batch, block *Block, activity *blockActivity, detach bool) uint64 {
+	hash := block.Hash()
+	count := uint64(0)
+	for assetID := range activity.assets {
+		key := calcFirstAssetKey(&assetID)
+		first := r.db.Get(key)
+		switch {
+		case !detach && first == nil:
+			batch.Set(key, hash.Bytes())
+			count++
+		case detach && bytes.Equal(first, hash.Bytes()):
+			batch.Delete(key)
+			count++
+		}
+	}
+	return count
+}
+
+// updateBuckets adds the activity of the block to its hourly and daily
+// buckets, or removes it to detach the block
+func (r *Rollups) updateBuckets(batch database.Batch, block *Block, detach bool) error {
+	activity, err := calcActivity(block)
+	if err != nil {
+		return err
+	}
+	newAssets := r.newAssets(batch, block, activity, detach)
+
+	interval, intervals := int64(0), uint64(0)
+	if block.Parent != nil {
+		interval, intervals = int64(block.Timestamp)-int64(block.Parent.Timestamp), 1
+	}
+
+	for _, period := range []string{HourPeriod, DayPeriod} {
+		start := block.Timestamp - block.Timestamp%periodSeconds[period]
+		bucket, err := r.getBucket(period, start)
+		if err != nil {
+			return err
+		}
+
+		activePrograms := int64(0)
+		for program := range activity.programs {
+			activePrograms += int64(r.countProgram(batch, period, start, []byte(program), detach))
+		}
+
+		if detach {
+			bucket.Blocks--
+			bucket.Intervals -= intervals
+			bucket.IntervalSum -= interval
+			bucket.Txs -= activity.txs
+			bucket.Fees -= activity.fees
+			bucket.NewAssets -= newAssets
+			for assetID, volume := range activity.volumes {
+				bucket.Volumes[hex.EncodeToString(assetID.Bytes())] -= volume
+			}
+		} else {
+			bucket.Blocks++
+			bucket.Intervals += intervals
+			bucket.IntervalSum += interval
+			bucket.Txs += activity.txs
+			bucket.Fees += activity.fees
+			bucket.NewAssets += newAssets
+			for assetID, volume := range activity.volumes {
+				bucket.Volumes[hex.EncodeToString(assetID.Bytes())] += volume
+			}
+		}
+		bucket.ActivePrograms = uint64(int64(bucket.ActivePrograms) + activePrograms)
+
+		for asset, volume := range bucket.Volumes {
+			if volume == 0 {
+				delete(bucket.Volumes, asset)
+			}
+		}
+		if bucket.Blocks == 0 {
+			batch.Delete(calcRollupKey(period, start))
+			continue
+		}
+
+		data, err := json.Marshal(bucket)
+		if err != nil {
+			return err
+		}
+		batch.Set(calcRollupKey(period, start), data)
+	}
+	return nil
+}
+
+// AttachBlock adds the activity of the block to its buckets
+func (r *Rollups) AttachBlock(batch database.Batch, block *Block) error {
+	return r.updateBuckets(batch, block, false)
+}
+
+// DetachBlock removes the activity of the block from its buckets
+func (r *Rollups) DetachBlock(batch database.Batch, block *Block) error {
+	return r.updateBuckets(batch, block, true)
+}
+
+// ListRollups returns the buckets of the period starting from from to to
+// included, the empty buckets are skipped. A to of math.MaxUint64 reaches
+// the last bucket.
+func ListRollups(db database.Reader, period string, from, to uint64) ([]*BucketStats, error) {
+	if _, ok := periodSeconds[period]; !ok {
+		return nil, errors.WithDetailf(errUnknownPeriod, "period %q", period)
+	}
+
+	limit := database.PrefixLimit(calcRollupPrefix(period))
+	if to < math.MaxUint64 {
+		limit = calcRollupKey(period, to+1)
+	}
+	iter := db.IteratorRange(calcRollupKey(period, from), limit)
+	defer iter.Release()
+
+	buckets := []*BucketStats{}
+	for iter.Next() {
+		bucket := &BucketStats{}
+		if err := json.Unmarshal(iter.Value(), bucket); err != nil {
+			return nil, errors.Wrap(err, "unmarshaling rollup bucket")
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, iter.Error()
+}
+
+// Buckets returns the buckets of the period starting from from to to included
+func (r *Rollups) Buckets(period string, from, to uint64) ([]*BucketStats, error) {
+	return ListRollups(r.db, period, from, to)
+}
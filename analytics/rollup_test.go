@@ -0,0 +1,170 @@
+package analytics
+
+import (
+	"encoding/hex"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/btm-stats/consensus"
+	"github.com/btm-stats/database"
+	"github.com/btm-stats/errors"
+	"github.com/btm-stats/protocol/bc/types"
+)
+
+func TestRollupsDetach(t *testing.T) {
+	db := database.NewMemDB()
+	rollups := NewRollups(db)
+	btm := *consensus.BTMAssetID
+	progA, progB, progC, progD, progE := []byte{0x51, 1}, []byte{0x51, 2}, []byte{0x51, 3}, []byte{0x51, 4}, []byte{0x51, 5}
+
+	parent := &types.BlockHeader{Height: 9, Timestamp: 1527814550}
+	coinbase := func(height, fee uint64) *types.Tx {
+		return newTestCoinbase(height, consensus.BlockSubsidy(height)+fee, progA)
+	}
+	txsList := [][]*types.Tx{
+		{
+			coinbase(10, 10),
+			newTestTransfer(
+				[]*types.TxInput{newTestSpend(0, btm, 100, progA)},
+				[]*types.TxOutput{types.NewTxOutput(btm, 90, progB)},
+			),
+		},
+		{
+			coinbase(11, 20),
+			newTestTransfer(
+				[]*types.TxInput{newTestSpend(1, testAsset, 5, progB), newTestSpend(2, btm, 30, progB)},
+				[]*types.TxOutput{types.NewTxOutput(testAsset, 5, progC), types.NewTxOutput(btm, 10, progC)},
+			),
+		},
+		// the failed transaction only moves its BTM
+		{
+			coinbase(12, 0),
+			newTestTransfer(
+				[]*types.TxInput{newTestSpend(3, btm, 50, progC), newTestSpend(4, testAsset, 7, progD)},
+				[]*types.TxOutput{types.NewTxOutput(btm, 40, progE), types.NewTxOutput(testAsset, 7, progD)},
+			),
+		},
+	}
+	blocks := []*Block{}
+	for i, txs := range txsList {
+		failed := []int{}
+		if i == 2 {
+			failed = append(failed, 1)
+		}
+		block := newTestBlock(parent, parent.Timestamp+150, txs, failed...)
+		blocks = append(blocks, block)
+		parent = &block.BlockHeader
+	}
+
+	update := func(block *Block, detach bool) {
+		batch := db.NewBatch()
+		process := rollups.AttachBlock
+		if detach {
+			process = rollups.DetachBlock
+		}
+		if err := process(batch, block); err != nil {
+			t.Fatal(err)
+		}
+		if err := batch.Write(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, block := range blocks {
+		update(block, false)
+	}
+
+	btmKey, assetKey := hex.EncodeToString(btm.Bytes()), hex.EncodeToString(testAsset.Bytes())
+	// the first block is the last of its hour
+	day := blocks[0].Timestamp - blocks[0].Timestamp%secondsPerDay
+	hour := blocks[1].Timestamp - blocks[1].Timestamp%periodSeconds[HourPeriod]
+	cases := []struct {
+		desc     string
+		detached int
+		want     []*BucketStats
+	}{
+		{
+			desc: "every block attached",
+			want: []*BucketStats{
+				{Period: DayPeriod, Start: day, Blocks: 3, Intervals: 3, IntervalSum: 450, Txs: 6, Fees: 30, ActivePrograms: 4, NewAssets: 2, Volumes: map[string]uint64{btmKey: 140, assetKey: 5}},
+				{Period: HourPeriod, Start: day, Blocks: 1, Intervals: 1, IntervalSum: 150, Txs: 2, Fees: 10, ActivePrograms: 2, NewAssets: 1, Volumes: map[string]uint64{btmKey: 90}},
+				{Period: HourPeriod, Start: hour, Blocks: 2, Intervals: 2, IntervalSum: 300, Txs: 4, Fees: 20, ActivePrograms: 4, NewAssets: 1, Volumes: map[string]uint64{btmKey: 50, assetKey: 5}},
+			},
+		},
+		{
+			desc:     "the failed transaction block detached",
+			detached: 1,
+			want: []*BucketStats{
+				{Period: DayPeriod, Start: day, Blocks: 2, Intervals: 2, IntervalSum: 300, Txs: 4, Fees: 30, ActivePrograms: 3, NewAssets: 2, Volumes: map[string]uint64{btmKey: 100, assetKey: 5}},
+				{Period: HourPeriod, Start: day, Blocks: 1, Intervals: 1, IntervalSum: 150, Txs: 2, Fees: 10, ActivePrograms: 2, NewAssets: 1, Volumes: map[string]uint64{btmKey: 90}},
+				{Period: HourPeriod, Start: hour, Blocks: 1, Intervals: 1, IntervalSum: 150, Txs: 2, Fees: 20, ActivePrograms: 3, NewAssets: 1, Volumes: map[string]uint64{btmKey: 10, assetKey: 5}},
+			},
+		},
+		{
+			desc:     "the asset transfer block detached",
+			detached: 2,
+			want: []*BucketStats{
+				{Period: DayPeriod, Start: day, Blocks: 1, Intervals: 1, IntervalSum: 150, Txs: 2, Fees: 10, ActivePrograms: 2, NewAssets: 1, Volumes: map[string]uint64{btmKey: 90}},
+				{Period: HourPeriod, Start: day, Blocks: 1, Intervals: 1, IntervalSum: 150, Txs: 2, Fees: 10, ActivePrograms: 2, NewAssets: 1, Volumes: map[string]uint64{btmKey: 90}},
+			},
+		},
+	}
+
+	detached := 0
+	for _, c := range cases {
+		for ; detached < c.detached; detached++ {
+			update(blocks[len(blocks)-1-detached], true)
+		}
+
+		days, err := ListRollups(db, DayPeriod, day, day)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hours, err := ListRollups(db, HourPeriod, day, day+secondsPerDay)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := append(days, hours...); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got buckets", c.desc)
+			for _, bucket := range got {
+				t.Errorf("%+v", bucket)
+			}
+		}
+	}
+
+	update(blocks[0], true)
+	iter := db.IteratorRange(nil, nil)
+	defer iter.Release()
+	if iter.Next() {
+		t.Fatalf("key %x is left after detaching every block", iter.Key())
+	}
+}
+
+func TestListRollupsRange(t *testing.T) {
+	db := database.NewMemDB()
+	rollups := NewRollups(db)
+	parent := &types.BlockHeader{Height: 9, Timestamp: 1527814550}
+	for _, timestamp := range []uint64{parent.Timestamp + 150, parent.Timestamp + secondsPerDay} {
+		block := newTestBlock(parent, timestamp, []*types.Tx{newTestCoinbase(parent.Height+1, 0, []byte{0x51})})
+		batch := db.NewBatch()
+		if err := rollups.AttachBlock(batch, block); err != nil {
+			t.Fatal(err)
+		}
+		if err := batch.Write(); err != nil {
+			t.Fatal(err)
+		}
+		parent = &block.BlockHeader
+	}
+
+	// the whole range reaches the first and the last bucket
+	buckets, err := ListRollups(db, DayPeriod, 0, math.MaxUint64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 2 || buckets[1].Start != parent.Timestamp-parent.Timestamp%secondsPerDay {
+		t.Errorf("got %d day buckets, want both days", len(buckets))
+	}
+	if _, err := ListRollups(db, "week", 0, math.MaxUint64); errors.Root(err) != errUnknownPeriod {
+		t.Errorf("got err %v on an unknown period, want %v", err, errUnknownPeriod)
+	}
+}
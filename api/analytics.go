@@ -0,0 +1,167 @@
+package api
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/btm-stats/analytics"
+	"github.com/btm-stats/consensus"
+	"github.com/btm-stats/errors"
+	"github.com/btm-stats/protocol/bc"
+	"github.com/btm-stats/types"
+)
+
+// anomalyWaitTimeout is how long a wait for the next anomaly holds the
+// request, under the server write timeout
+const anomalyWaitTimeout = 25 * time.Second
+
+// anomalyWaiters numbers the event listeners of the waits
+var anomalyWaiters uint64
+
+var (
+	errRichListOff  = errors.New("the rich list analytics are disabled")
+	errRollupsOff   = errors.New("the rollup analytics are disabled")
+	errAnomaliesOff = errors.New("the anomaly detector is disabled")
+)
+
+// snapshotQuery selects the snapshots of an asset, BTM when the asset is
+// absent, by UTC dates like 2018-06-01
+type snapshotQuery struct {
+	AssetID bc.AssetID `json:"asset_id"`
+	Date    string     `json:"date"`
+	From    string     `json:"from"`
+	To      string     `json:"to"`
+}
+
+func (in *snapshotQuery) assetID() *bc.AssetID {
+	if in.AssetID.IsZero() {
+		return consensus.BTMAssetID
+	}
+	return &in.AssetID
+}
+
+// getRichList returns the distribution snapshot of the asset for the date,
+// the latest one when the date is absent
+func (a *API) getRichList(in snapshotQuery) Response {
+	if a.richList == nil {
+		return NewErrorResponse(errRichListOff)
+	}
+
+	day := uint64(0)
+	if in.Date != "" {
+		var err error
+		if day, err = analytics.ParseDate(in.Date); err != nil {
+			return NewErrorResponse(err)
+		}
+	}
+
+	snapshot, err := a.richList.Snapshot(in.assetID(), day)
+	if err != nil {
+		return NewErrorResponse(err)
+	}
+	return NewSuccessResponse(snapshot)
+}
+
+// listAssetSnapshots returns the distribution metrics of the asset from date
+// to date included, without the top holders
+func (a *API) listAssetSnapshots(in snapshotQuery) Response {
+	if a.richList == nil {
+		return NewErrorResponse(errRichListOff)
+	}
+
+	from, err := analytics.ParseDate(in.From)
+	if err != nil {
+		return NewErrorResponse(err)
+	}
+	to, err := analytics.ParseDate(in.To)
+	if err != nil {
+		return NewErrorResponse(err)
+	}
+
+	snapshots, err := a.richList.Snapshots(in.assetID(), from, to)
+	if err != nil {
+		return NewErrorResponse(err)
+	}
+	return NewSuccessResponse(snapshots)
+}
+
+// rollupQuery selects the buckets of a period, hour or day, starting between
+// two times, UTC dates like 2018-06-01 or RFC3339 times
+type rollupQuery struct {
+	Period string `json:"period"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+}
+
+// listRollups returns the activity buckets of the period
+func (a *API) listRollups(in rollupQuery) Response {
+	if a.rollups == nil {
+		return NewErrorResponse(errRollupsOff)
+	}
+
+	from, err := analytics.ParseTime(in.From)
+	if err != nil {
+		return NewErrorResponse(err)
+	}
+	to, err := analytics.ParseTime(in.To)
+	if err != nil {
+		return NewErrorResponse(err)
+	}
+
+	buckets, err := a.rollups.Buckets(in.Period, from, to)
+	if err != nil {
+		return NewErrorResponse(err)
+	}
+	return NewSuccessResponse(buckets)
+}
+
+// anomalyQuery selects the anomalies of the blocks between two heights, up to
+// the best block when the end is absent
+type anomalyQuery struct {
+	FromHeight uint64 `json:"from_height"`
+	ToHeight   uint64 `json:"to_height"`
+}
+
+// listAnomalies returns the flagged blocks, the side chain ones included
+func (a *API) listAnomalies(in anomalyQuery) Response {
+	if a.anomalies == nil {
+		return NewErrorResponse(errAnomaliesOff)
+	}
+
+	to := in.ToHeight
+	if to == 0 {
+		to = a.chain.BestBlockHeight()
+	}
+	anomalies, err := a.anomalies.Anomalies(in.FromHeight, to)
+	if err != nil {
+		return NewErrorResponse(err)
+	}
+	return NewSuccessResponse(anomalies)
+}
+
+// waitAnomaly holds the request until the next block anomaly is fired and
+// returns it, or returns no data at the timeout. The anomalies fired between
+// two waits are read back with list-anomalies.
+func (a *API) waitAnomaly() Response {
+	if a.anomalies == nil || a.evsw == nil {
+		return NewErrorResponse(errAnomaliesOff)
+	}
+
+	anomalyCh := make(chan types.EventDataBlockAnomaly, 1)
+	listenerID := fmt.Sprintf("api-wait-anomaly-%d", atomic.AddUint64(&anomalyWaiters, 1))
+	types.AddListenerForEvent(a.evsw, listenerID, types.EventStringBlockAnomaly(), func(data types.TMEventData) {
+		select {
+		case anomalyCh <- data.Unwrap().(types.EventDataBlockAnomaly):
+		default:
+		}
+	})
+	defer a.evsw.RemoveListener(listenerID)
+
+	select {
+	case anomaly := <-anomalyCh:
+		return NewSuccessResponse(anomaly)
+	case <-time.After(anomalyWaitTimeout):
+		return NewSuccessResponse(nil)
+	}
+}
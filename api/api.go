@@ -0,0 +1,122 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	cmn "github.com/tendermint/tmlibs/common"
+
+	"github.com/btm-stats/analytics"
+	cfg "github.com/btm-stats/config"
+	"github.com/btm-stats/database/leveldb"
+	"github.com/btm-stats/errors"
+	"github.com/btm-stats/netsync"
+	"github.com/btm-stats/protocol"
+	"github.com/btm-stats/types"
+)
+
+const (
+	// SUCCESS indicates the rpc calling is successful.
+	SUCCESS = "success"
+	// FAIL indicated the rpc calling is failed.
+	FAIL = "fail"
+
+	// serverReadTimeout is the max duration of reading the request body
+	serverReadTimeout = 30 * time.Second
+	// serverWriteTimeout is the max duration of writing the response
+	serverWriteTimeout = 30 * time.Second
+)
+
+// Response describes the response standard.
+type Response struct {
+	Status string      `json:"status,omitempty"`
+	Msg    string      `json:"msg,omitempty"`
+	Data   interface{} `json:"data,omitempty"`
+}
+
+// NewSuccessResponse success response
+func NewSuccessResponse(data interface{}) Response {
+	return Response{Status: SUCCESS, Data: data}
+}
+
+// NewErrorResponse error response
+func NewErrorResponse(err error) Response {
+	return Response{Status: FAIL, Msg: err.Error()}
+}
+
+// API is the scheduling center for the node's http interface
+type API struct {
+	sync      *netsync.SyncManager
+	chain     *protocol.Chain
+	store     *leveldb.Store
+	richList  *analytics.RichList
+	rollups   *analytics.Rollups
+	anomalies *analytics.AnomalyDetector
+	evsw      types.EventSwitch
+	server    *http.Server
+	handler   http.Handler
+}
+
+// NewAPI create and initialize the API
+func NewAPI(sync *netsync.SyncManager, chain *protocol.Chain, store *leveldb.Store, richList *analytics.RichList, rollups *analytics.Rollups, anomalies *analytics.AnomalyDetector, evsw types.EventSwitch, config *cfg.Config) *API {
+	api := &API{
+		sync:      sync,
+		chain:     chain,
+		store:     store,
+		richList:  richList,
+		rollups:   rollups,
+		anomalies: anomalies,
+		evsw:      evsw,
+	}
+	api.buildHandler()
+	api.server = &http.Server{
+		Handler:      api.handler,
+		ReadTimeout:  serverReadTimeout,
+		WriteTimeout: serverWriteTimeout,
+	}
+	return api
+}
+
+// StartServer start the server
+func (a *API) StartServer(address string) {
+	log.WithField("api address:", address).Info("Rpc listen")
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		cmn.Exit(cmn.Fmt("Failed to register tcp port: %v", err))
+	}
+
+	// The `Serve` call has to happen in its own goroutine because
+	// it's blocking and we need to proceed to the rest of the core setup after
+	// we call it.
+	go func() {
+		if err := a.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.WithField("error", errors.Wrap(err, "Serve")).Error("Rpc server")
+		}
+	}()
+}
+
+// StopServer closes the listener and all the open connections
+func (a *API) StopServer() {
+	if err := a.server.Close(); err != nil {
+		log.WithField("error", err).Error("Rpc server close")
+	}
+}
+
+func (a *API) buildHandler() {
+	m := http.NewServeMux()
+	m.Handle("/get-rate-limits", jsonHandler(a.getRateLimits))
+	m.Handle("/set-rate-limits", jsonHandler(a.setRateLimits))
+	m.Handle("/get-rejected-blocks", jsonHandler(a.getRejectedBlocks))
+	m.Handle("/get-block", jsonHandler(a.getBlock))
+	m.Handle("/get-transaction", jsonHandler(a.getTransaction))
+	m.Handle("/get-spending-transaction", jsonHandler(a.getSpendingTransaction))
+	m.Handle("/get-rich-list", jsonHandler(a.getRichList))
+	m.Handle("/list-asset-snapshots", jsonHandler(a.listAssetSnapshots))
+	m.Handle("/list-rollups", jsonHandler(a.listRollups))
+	m.Handle("/list-anomalies", jsonHandler(a.listAnomalies))
+	m.Handle("/wait-anomaly", jsonHandler(a.waitAnomaly))
+	m.Handle("/get-cache-stats", jsonHandler(a.getCacheStats))
+	a.handler = m
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"github.com/btm-stats/protocol/bc"
+	"github.com/btm-stats/protocol/bc/types"
+	"github.com/btm-stats/query"
+)
+
+// blockQuery selects a block by its hash, or by its main chain height when
+// the hash is absent
+type blockQuery struct {
+	BlockHash   bc.Hash `json:"block_hash"`
+	BlockHeight uint64  `json:"block_height"`
+}
+
+// getBlock returns the annotated block along the status of its transactions
+func (a *API) getBlock(in blockQuery) Response {
+	var block *types.Block
+	var err error
+	if in.BlockHash.IsZero() {
+		block, err = a.chain.GetBlockByHeight(in.BlockHeight)
+	} else {
+		block, err = a.chain.GetBlockByHash(&in.BlockHash)
+	}
+	if err != nil {
+		return NewErrorResponse(err)
+	}
+
+	hash := block.Hash()
+	status, err := a.chain.GetTransactionStatus(&hash)
+	if err != nil {
+		return NewErrorResponse(err)
+	}
+
+	annotated, err := query.AnnotateBlock(block, status)
+	if err != nil {
+		return NewErrorResponse(err)
+	}
+	return NewSuccessResponse(annotated)
+}
+
+// getRejectedBlocks returns the number of blocks received from the peers that
+// failed the validation, keyed by the reject reason
+func (a *API) getRejectedBlocks() Response {
+	return NewSuccessResponse(a.sync.RejectedBlocks())
+}
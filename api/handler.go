@@ -0,0 +1,54 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/btm-stats/errors"
+)
+
+var (
+	errBadHandler = errors.New("handler must be a func returning Response, with zero or one argument")
+	errBadRequest = errors.New("httpjson: bad request")
+
+	responseType = reflect.TypeOf(Response{})
+)
+
+// jsonHandler wraps a handler func into a http.Handler. The func has the
+// form func() Response or func(in T) Response, in the latter case the
+// request body is decoded as JSON into a fresh T.
+func jsonHandler(f interface{}) http.Handler {
+	fv := reflect.ValueOf(f)
+	ft := fv.Type()
+	if ft.Kind() != reflect.Func || ft.NumIn() > 1 || ft.NumOut() != 1 || ft.Out(0) != responseType {
+		panic(errBadHandler)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var args []reflect.Value
+		if ft.NumIn() == 1 {
+			in := reflect.New(ft.In(0))
+			if req.ContentLength != 0 {
+				if err := json.NewDecoder(req.Body).Decode(in.Interface()); err != nil {
+					writeResponse(w, http.StatusBadRequest, NewErrorResponse(errors.Wrap(errBadRequest, err.Error())))
+					return
+				}
+			}
+			args = append(args, in.Elem())
+		}
+
+		resp := fv.Call(args)[0].Interface().(Response)
+		writeResponse(w, http.StatusOK, resp)
+	})
+}
+
+func writeResponse(w http.ResponseWriter, status int, resp Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.WithField("error", err).Error("fail on write api response")
+	}
+}
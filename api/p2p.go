@@ -0,0 +1,57 @@
+package api
+
+import (
+	"github.com/btm-stats/errors"
+	"github.com/btm-stats/p2p"
+)
+
+// rateLimitsUpdate holds the limits to change, the absent ones are kept. A
+// zero rate means unlimited.
+type rateLimitsUpdate struct {
+	SendRate         *int64 `json:"send_rate"`
+	RecvRate         *int64 `json:"recv_rate"`
+	MaxTotalSendRate *int64 `json:"max_total_send_rate"`
+	MaxTotalRecvRate *int64 `json:"max_total_recv_rate"`
+}
+
+// apply merges the update onto the current limits
+func (in *rateLimitsUpdate) apply(limits *p2p.RateLimits) error {
+	for _, field := range []struct {
+		name   string
+		update *int64
+		limit  *int64
+	}{
+		{"send_rate", in.SendRate, &limits.SendRate},
+		{"recv_rate", in.RecvRate, &limits.RecvRate},
+		{"max_total_send_rate", in.MaxTotalSendRate, &limits.MaxTotalSendRate},
+		{"max_total_recv_rate", in.MaxTotalRecvRate, &limits.MaxTotalRecvRate},
+	} {
+		if field.update == nil {
+			continue
+		}
+		if *field.update < 0 {
+			return errors.WithDetailf(p2p.ErrInvalidRateLimit, "%s is %d", field.name, *field.update)
+		}
+		*field.limit = *field.update
+	}
+	return nil
+}
+
+// getRateLimits returns the bandwidth limits applied to the peers
+func (a *API) getRateLimits() Response {
+	return NewSuccessResponse(a.sync.Switch().RateLimits())
+}
+
+// setRateLimits adjusts the bandwidth limits of the running node, the limits
+// absent from the request are left as they are
+func (a *API) setRateLimits(in rateLimitsUpdate) Response {
+	sw := a.sync.Switch()
+	limits := sw.RateLimits()
+	if err := in.apply(limits); err != nil {
+		return NewErrorResponse(err)
+	}
+	if err := sw.SetRateLimits(limits); err != nil {
+		return NewErrorResponse(err)
+	}
+	return NewSuccessResponse(sw.RateLimits())
+}
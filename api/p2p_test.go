@@ -0,0 +1,54 @@
+package api
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/btm-stats/errors"
+	"github.com/btm-stats/p2p"
+)
+
+func TestRateLimitsUpdate(t *testing.T) {
+	current := p2p.RateLimits{SendRate: 100, RecvRate: 200, MaxTotalSendRate: 300, MaxTotalRecvRate: 400}
+	cases := []struct {
+		body string
+		want p2p.RateLimits
+		err  error
+	}{
+		{
+			body: `{}`,
+			want: current,
+		},
+		{
+			body: `{"send_rate": 150}`,
+			want: p2p.RateLimits{SendRate: 150, RecvRate: 200, MaxTotalSendRate: 300, MaxTotalRecvRate: 400},
+		},
+		{
+			// zero lifts a limit
+			body: `{"recv_rate": 0, "max_total_send_rate": 350}`,
+			want: p2p.RateLimits{SendRate: 100, RecvRate: 0, MaxTotalSendRate: 350, MaxTotalRecvRate: 400},
+		},
+		{
+			body: `{"send_rate": 150, "max_total_recv_rate": -1}`,
+			want: current,
+			err:  p2p.ErrInvalidRateLimit,
+		},
+	}
+
+	for i, c := range cases {
+		in := rateLimitsUpdate{}
+		if err := json.Unmarshal([]byte(c.body), &in); err != nil {
+			t.Fatal(err)
+		}
+
+		limits := current
+		err := in.apply(&limits)
+		if errors.Root(err) != c.err {
+			t.Errorf("case %d: got error %v, want %v", i, err, c.err)
+		}
+		if c.err == nil && !reflect.DeepEqual(limits, c.want) {
+			t.Errorf("case %d: got limits %+v, want %+v", i, limits, c.want)
+		}
+	}
+}
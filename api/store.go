@@ -0,0 +1,6 @@
+package api
+
+// getCacheStats returns the hits, misses and evictions of the store caches
+func (a *API) getCacheStats() Response {
+	return NewSuccessResponse(a.store.CacheStats())
+}
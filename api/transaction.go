@@ -0,0 +1,54 @@
+package api
+
+import (
+	"github.com/btm-stats/database/leveldb"
+	"github.com/btm-stats/protocol/bc"
+	"github.com/btm-stats/query"
+)
+
+// txQuery selects a main chain transaction by its id
+type txQuery struct {
+	TxID bc.Hash `json:"tx_id"`
+}
+
+// txResponse is the annotated transaction along the block holding it
+type txResponse struct {
+	*query.AnnotatedTx
+	leveldb.TxLocation
+}
+
+// getTransaction returns the annotated transaction found by the tx index
+func (a *API) getTransaction(in txQuery) Response {
+	tx, loc, err := a.store.GetTransaction(&in.TxID)
+	if err != nil {
+		return NewErrorResponse(err)
+	}
+
+	status, err := a.store.GetTransactionStatus(&loc.BlockHash)
+	if err != nil {
+		return NewErrorResponse(err)
+	}
+
+	annotated := query.AnnotateTx(tx)
+	statusFail, err := status.GetStatus(int(loc.Position))
+	if err != nil {
+		return NewErrorResponse(err)
+	}
+	annotated.StatusFail = &statusFail
+	return NewSuccessResponse(&txResponse{AnnotatedTx: annotated, TxLocation: *loc})
+}
+
+// spendQuery selects an output by its id
+type spendQuery struct {
+	OutputID bc.Hash `json:"output_id"`
+}
+
+// getSpendingTransaction returns the id of the main chain transaction
+// spending the output, found by the spent output index
+func (a *API) getSpendingTransaction(in spendQuery) Response {
+	txID, err := a.store.GetSpendingTx(&in.OutputID)
+	if err != nil {
+		return NewErrorResponse(err)
+	}
+	return NewSuccessResponse(map[string]*bc.Hash{"tx_id": txID})
+}
@@ -0,0 +1,391 @@
+// Package chaingen builds deterministic synthetic chains on top of the genesis
+// block for tests and benchmarks.
+package chaingen
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+
+	"github.com/btm-stats/config"
+	"github.com/btm-stats/consensus"
+	"github.com/btm-stats/consensus/difficulty"
+	"github.com/btm-stats/errors"
+	"github.com/btm-stats/protocol/bc"
+	"github.com/btm-stats/protocol/bc/types"
+)
+
+// OpTrue is the default control and issuance program of the generated
+// outputs, it can be spent without any witness
+var OpTrue = []byte{0x51}
+
+var errForkHeight = errors.New("fork height is beyond the branch tip")
+
+// Config controls the shape of the generated chain
+type Config struct {
+	Seed           int64
+	BlockInterval  uint64 // seconds between two blocks
+	MaxSpendTxs    int    // max spend transactions of a block
+	MaxIssuanceTxs int    // max issuance transactions of a block
+	NumAssets      int    // number of distinct assets to issue
+	Fee            uint64 // fee paid by every non coinbase transaction
+	ControlProgram []byte
+}
+
+// DefaultConfig returns a config generating a few transactions per block
+func DefaultConfig() *Config {
+	return &Config{
+		Seed:           1,
+		BlockInterval:  150,
+		MaxSpendTxs:    4,
+		MaxIssuanceTxs: 1,
+		NumAssets:      4,
+		Fee:            10000000,
+		ControlProgram: OpTrue,
+	}
+}
+
+// Generator owns the branches of a synthetic chain, the same config always
+// produces the same blocks
+type Generator struct {
+	config   *Config
+	main     *Branch
+	branches int
+	blocks   []*types.Block
+}
+
+// New creates a generator whose main branch starts at the genesis block
+func New(cfg *Config) *Generator {
+	g := &Generator{config: cfg}
+	g.main = g.newBranch()
+	g.main.applyBlock(config.GenesisBlock())
+	return g
+}
+
+// Main returns the main branch
+func (g *Generator) Main() *Branch {
+	return g.main
+}
+
+// Blocks returns every generated block except the genesis in generation
+// order, parents always come before their children
+func (g *Generator) Blocks() []*types.Block {
+	return g.blocks
+}
+
+func (g *Generator) newBranch() *Branch {
+	id := g.branches
+	g.branches++
+	return &Branch{
+		gen:   g,
+		id:    id,
+		rand:  rand.New(rand.NewSource(g.config.Seed + int64(id))),
+		utxos: make(map[bc.Hash]*utxo),
+	}
+}
+
+type utxo struct {
+	id             bc.Hash
+	sourceID       bc.Hash
+	sourcePos      uint64
+	assetID        bc.AssetID
+	amount         uint64
+	controlProgram []byte
+	coinbase       bool
+	height         uint64
+}
+
+// Branch is a chain of blocks from the genesis with its own utxo set
+type Branch struct {
+	gen    *Generator
+	id     int
+	rand   *rand.Rand
+	blocks []*types.Block
+
+	utxos     map[bc.Hash]*utxo
+	utxoOrder []bc.Hash // map iteration isn't deterministic
+}
+
+// Blocks returns the blocks of the branch, blocks[0] is the genesis
+func (b *Branch) Blocks() []*types.Block {
+	return b.blocks
+}
+
+// Tip returns the last block of the branch
+func (b *Branch) Tip() *types.Block {
+	return b.blocks[len(b.blocks)-1]
+}
+
+// Fork creates a new branch sharing the blocks up to height
+func (b *Branch) Fork(height uint64) (*Branch, error) {
+	if height > b.Tip().Height {
+		return nil, errors.WithDetailf(errForkHeight, "fork height %d, tip height %d", height, b.Tip().Height)
+	}
+
+	fork := b.gen.newBranch()
+	for _, block := range b.blocks[:height+1] {
+		fork.applyBlock(block)
+	}
+	return fork, nil
+}
+
+// Generate appends n blocks to the branch
+func (b *Branch) Generate(n int) ([]*types.Block, error) {
+	blocks := []*types.Block{}
+	for i := 0; i < n; i++ {
+		block, err := b.nextBlock()
+		if err != nil {
+			return nil, err
+		}
+
+		b.applyBlock(block)
+		b.gen.blocks = append(b.gen.blocks, block)
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+func (b *Branch) nextBlock() (*types.Block, error) {
+	cfg := b.gen.config
+	parent := b.Tip()
+	height := parent.Height + 1
+
+	txs := []*types.Tx{}
+	for i := b.rand.Intn(cfg.MaxIssuanceTxs + 1); i > 0; i-- {
+		tx, err := b.issuanceTx(height)
+		if err != nil {
+			return nil, err
+		}
+		if tx != nil {
+			txs = append(txs, tx)
+		}
+	}
+	for i := b.rand.Intn(cfg.MaxSpendTxs + 1); i > 0; i-- {
+		tx, err := b.spendTx(height)
+		if err != nil {
+			return nil, err
+		}
+		if tx != nil {
+			txs = append(txs, tx)
+		}
+	}
+
+	fee := cfg.Fee * uint64(len(txs))
+	coinbase, err := b.coinbaseTx(height, fee)
+	if err != nil {
+		return nil, err
+	}
+	txs = append([]*types.Tx{coinbase}, txs...)
+
+	txStatus := bc.NewTransactionStatus()
+	bcTxs := make([]*bc.Tx, len(txs))
+	for i, tx := range txs {
+		txStatus.SetStatus(i, false)
+		bcTxs[i] = tx.Tx
+	}
+
+	txStatusHash, err := bc.TxStatusMerkleRoot(txStatus.VerifyStatus)
+	if err != nil {
+		return nil, errors.Wrap(err, "calc tx status merkle root")
+	}
+
+	merkleRoot, err := bc.TxMerkleRoot(bcTxs)
+	if err != nil {
+		return nil, errors.Wrap(err, "calc tx merkle root")
+	}
+
+	return &types.Block{
+		BlockHeader: types.BlockHeader{
+			Version:           1,
+			Height:            height,
+			PreviousBlockHash: parent.Hash(),
+			Timestamp:         parent.Timestamp + cfg.BlockInterval,
+			Bits:              b.nextBits(),
+			BlockCommitment: types.BlockCommitment{
+				TransactionsMerkleRoot: merkleRoot,
+				TransactionStatusHash:  txStatusHash,
+			},
+		},
+		Transactions: txs,
+	}, nil
+}
+
+// nextBits follows the difficulty retarget of the chain, the generated blocks
+// aren't mined so it only keeps the bits field consistent with the headers
+func (b *Branch) nextBits() uint64 {
+	parent := b.Tip()
+	if parent.Height%consensus.BlocksPerRetarget != 0 || parent.Height == 0 {
+		return parent.Bits
+	}
+
+	compare := b.blocks[parent.Height-consensus.BlocksPerRetarget]
+	return difficulty.CalcNextRequiredDifficulty(parent.Height, parent.Bits, parent.Timestamp, compare.Timestamp)
+}
+
+func (b *Branch) coinbaseTx(height, fee uint64) (*types.Tx, error) {
+	// the branch id keeps the coinbase of competing blocks apart
+	arbitrary := []byte(fmt.Sprintf("chaingen:%d:%d", b.id, height))
+	return newTx(types.TxData{
+		Version: 1,
+		Inputs:  []*types.TxInput{types.NewCoinbaseInput(arbitrary)},
+		Outputs: []*types.TxOutput{types.NewTxOutput(*consensus.BTMAssetID, consensus.BlockSubsidy(height)+fee, b.gen.config.ControlProgram)},
+	})
+}
+
+// spendTx moves a random utxo to new outputs, BTM is split in two and the
+// other assets pay the fee with an extra BTM input
+func (b *Branch) spendTx(height uint64) (*types.Tx, error) {
+	cfg := b.gen.config
+	u := b.pickUtxo(height, nil)
+	if u == nil {
+		return nil, nil
+	}
+
+	inputs := []*types.TxInput{spendInput(u)}
+	outputs := []*types.TxOutput{}
+	if u.assetID == *consensus.BTMAssetID {
+		if u.amount < cfg.Fee+2 {
+			return nil, nil
+		}
+		b.removeUtxo(u.id)
+		left := u.amount - cfg.Fee
+		half := left / 2
+		outputs = append(outputs,
+			types.NewTxOutput(u.assetID, half, cfg.ControlProgram),
+			types.NewTxOutput(u.assetID, left-half, cfg.ControlProgram),
+		)
+		return newTx(types.TxData{Version: 1, Inputs: inputs, Outputs: outputs})
+	}
+
+	feeUtxo := b.pickUtxo(height, consensus.BTMAssetID)
+	if feeUtxo == nil || feeUtxo.amount <= cfg.Fee {
+		return nil, nil
+	}
+	b.removeUtxo(u.id)
+	b.removeUtxo(feeUtxo.id)
+	inputs = append(inputs, spendInput(feeUtxo))
+	outputs = append(outputs,
+		types.NewTxOutput(u.assetID, u.amount, cfg.ControlProgram),
+		types.NewTxOutput(feeUtxo.assetID, feeUtxo.amount-cfg.Fee, cfg.ControlProgram),
+	)
+	return newTx(types.TxData{Version: 1, Inputs: inputs, Outputs: outputs})
+}
+
+// issuanceTx issues one of the configured assets, the fee is paid by a BTM
+// input
+func (b *Branch) issuanceTx(height uint64) (*types.Tx, error) {
+	cfg := b.gen.config
+	if cfg.NumAssets <= 0 {
+		return nil, nil
+	}
+
+	feeUtxo := b.pickUtxo(height, consensus.BTMAssetID)
+	if feeUtxo == nil || feeUtxo.amount <= cfg.Fee {
+		return nil, nil
+	}
+	b.removeUtxo(feeUtxo.id)
+
+	nonce := make([]byte, 8)
+	binary.BigEndian.PutUint64(nonce, uint64(b.rand.Int63()))
+	amount := uint64(b.rand.Int63n(100000000)) + 1
+	definition := []byte(fmt.Sprintf(`{"name":"CHAINGEN%d"}`, b.rand.Intn(cfg.NumAssets)))
+
+	issuance := types.NewIssuanceInput(nonce, amount, OpTrue, nil, definition)
+	assetID := issuance.TypedInput.(*types.IssuanceInput).AssetID()
+	return newTx(types.TxData{
+		Version: 1,
+		Inputs:  []*types.TxInput{issuance, spendInput(feeUtxo)},
+		Outputs: []*types.TxOutput{
+			types.NewTxOutput(assetID, amount, cfg.ControlProgram),
+			types.NewTxOutput(feeUtxo.assetID, feeUtxo.amount-cfg.Fee, cfg.ControlProgram),
+		},
+	})
+}
+
+// pickUtxo returns a random spendable utxo, of the given asset if not nil
+func (b *Branch) pickUtxo(height uint64, assetID *bc.AssetID) *utxo {
+	if len(b.utxoOrder) == 0 {
+		return nil
+	}
+
+	start := b.rand.Intn(len(b.utxoOrder))
+	for i := 0; i < len(b.utxoOrder); i++ {
+		u := b.utxos[b.utxoOrder[(start+i)%len(b.utxoOrder)]]
+		if u.coinbase && height < u.height+consensus.CoinbasePendingBlockNumber {
+			continue
+		}
+		if assetID != nil && u.assetID != *assetID {
+			continue
+		}
+		return u
+	}
+	return nil
+}
+
+// applyBlock appends the block and updates the utxo set
+func (b *Branch) applyBlock(block *types.Block) {
+	b.blocks = append(b.blocks, block)
+	for i, tx := range block.Transactions {
+		for _, id := range tx.SpentOutputIDs {
+			b.removeUtxo(id)
+		}
+		for _, id := range tx.ResultIds {
+			output, ok := tx.Entries[*id].(*bc.Output)
+			if !ok {
+				continue
+			}
+			b.addUtxo(&utxo{
+				id:             *id,
+				sourceID:       *output.Source.Ref,
+				sourcePos:      output.Source.Position,
+				assetID:        *output.Source.Value.AssetId,
+				amount:         output.Source.Value.Amount,
+				controlProgram: output.ControlProgram.Code,
+				coinbase:       i == 0,
+				height:         block.Height,
+			})
+		}
+	}
+}
+
+func (b *Branch) addUtxo(u *utxo) {
+	// only track the outputs the generator is able to spend
+	if !bytes.Equal(u.controlProgram, b.gen.config.ControlProgram) {
+		return
+	}
+	if _, ok := b.utxos[u.id]; ok {
+		return
+	}
+	b.utxos[u.id] = u
+	b.utxoOrder = append(b.utxoOrder, u.id)
+}
+
+func (b *Branch) removeUtxo(id bc.Hash) {
+	if _, ok := b.utxos[id]; !ok {
+		return
+	}
+	delete(b.utxos, id)
+	for i, orderID := range b.utxoOrder {
+		if orderID == id {
+			b.utxoOrder = append(b.utxoOrder[:i], b.utxoOrder[i+1:]...)
+			return
+		}
+	}
+}
+
+func spendInput(u *utxo) *types.TxInput {
+	return types.NewSpendInput(nil, u.sourceID, u.assetID, u.amount, u.sourcePos, u.controlProgram)
+}
+
+// newTx maps the tx data with its serialized size, the validation charges the
+// storage gas on it
+func newTx(data types.TxData) (*types.Tx, error) {
+	var buf bytes.Buffer
+	if _, err := data.WriteTo(&buf); err != nil {
+		return nil, errors.Wrap(err, "serialize tx")
+	}
+
+	data.SerializedSize = uint64(buf.Len())
+	return types.NewTx(data), nil
+}
@@ -0,0 +1,68 @@
+package chaingen
+
+import (
+	"testing"
+
+	"github.com/btm-stats/protocol/bc"
+)
+
+func TestGenerateDeterministic(t *testing.T) {
+	build := func() []bc.Hash {
+		gen := New(DefaultConfig())
+		if _, err := gen.Main().Generate(120); err != nil {
+			t.Fatal(err)
+		}
+		fork, err := gen.Main().Fork(110)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fork.Generate(5); err != nil {
+			t.Fatal(err)
+		}
+
+		hashes := []bc.Hash{}
+		for _, block := range gen.Blocks() {
+			hashes = append(hashes, block.Hash())
+		}
+		return hashes
+	}
+
+	first, second := build(), build()
+	if len(first) != 125 || len(first) != len(second) {
+		t.Fatalf("got %d and %d blocks, want 125", len(first), len(second))
+	}
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("block %d differs between two runs with the same seed", i)
+		}
+	}
+}
+
+func TestGenerateSpendsMaturedCoinbase(t *testing.T) {
+	gen := New(DefaultConfig())
+	blocks, err := gen.Main().Generate(110)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, block := range blocks[:100] {
+		if len(block.Transactions) != 1 {
+			t.Fatalf("block %d spends an immature coinbase", block.Height)
+		}
+	}
+
+	txs := 0
+	for _, block := range blocks[100:] {
+		txs += len(block.Transactions) - 1
+	}
+	if txs == 0 {
+		t.Error("no transaction generated after the coinbase maturity")
+	}
+}
+
+func TestForkBeyondTip(t *testing.T) {
+	gen := New(DefaultConfig())
+	if _, err := gen.Main().Fork(1); err == nil {
+		t.Error("fork beyond the tip succeeded")
+	}
+}
@@ -0,0 +1,348 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	cfg "github.com/btm-stats/config"
+	"github.com/btm-stats/database"
+	"github.com/btm-stats/database/leveldb"
+	"github.com/btm-stats/protocol/bc"
+	"github.com/btm-stats/query"
+)
+
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Maintain and inspect the chain store of the data directory",
+}
+
+var dbMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Upgrade the chain store to the current schema and backfill the transaction indexes",
+	Args:  cobra.NoArgs,
+	RunE:  runDBMigrate,
+}
+
+var dbStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Count the keys and their sizes per key prefix",
+	Args:  cobra.NoArgs,
+	RunE:  runDBStats,
+}
+
+var dbGetCmd = &cobra.Command{
+	Use:   "get",
+	Short: "Decode a stored record by hash",
+}
+
+var dbGetBlockCmd = &cobra.Command{
+	Use:   "block <hash>",
+	Short: "Decode a stored block along its transaction status",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runDBGetBlock,
+}
+
+var dbGetHeaderCmd = &cobra.Command{
+	Use:   "header <hash>",
+	Short: "Decode a stored block header",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runDBGetHeader,
+}
+
+var dbGetUtxoCmd = &cobra.Command{
+	Use:   "utxo <output id>",
+	Short: "Decode a stored utxo entry",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runDBGetUtxo,
+}
+
+var dbGetTxStatusCmd = &cobra.Command{
+	Use:   "txstatus <hash>",
+	Short: "Decode the stored transaction status of a block",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runDBGetTxStatus,
+}
+
+var dbScanCmd = &cobra.Command{
+	Use:   "scan",
+	Short: "List the raw records under a key prefix",
+	Args:  cobra.NoArgs,
+	RunE:  runDBScan,
+}
+
+var dbTipCmd = &cobra.Command{
+	Use:   "tip",
+	Short: "Decode the stored chain status",
+	Args:  cobra.NoArgs,
+	RunE:  runDBTip,
+}
+
+func init() {
+	dbMigrateCmd.Flags().Bool("dry-run", false, "Only report the pending upgrade steps and the records they rewrite")
+	dbMigrateCmd.Flags().String("tx_index", config.TxIndex, "Transaction indexer to backfill: kv | null (no index)")
+	dbMigrateCmd.Flags().Bool("spent_index", config.SpentIndex, "Backfill the spending transaction of each spent output")
+
+	dbScanCmd.Flags().String("prefix", "", "Key prefix to scan, like BH: (empty scans every key)")
+	dbScanCmd.Flags().Bool("hex", false, "Read the prefix in hex")
+	dbScanCmd.Flags().Int("limit", 100, "Maximum number of records listed (0 lists all)")
+	dbScanCmd.Flags().Bool("values", false, "List the values in hex along the keys")
+
+	dbGetCmd.AddCommand(dbGetBlockCmd)
+	dbGetCmd.AddCommand(dbGetHeaderCmd)
+	dbGetCmd.AddCommand(dbGetUtxoCmd)
+	dbGetCmd.AddCommand(dbGetTxStatusCmd)
+
+	dbCmd.AddCommand(dbMigrateCmd)
+	dbCmd.AddCommand(dbStatsCmd)
+	dbCmd.AddCommand(dbGetCmd)
+	dbCmd.AddCommand(dbScanCmd)
+	dbCmd.AddCommand(dbTipCmd)
+
+	RootCmd.AddCommand(dbCmd)
+}
+
+// openInspectDB opens the core store read-only, the inspection never changes
+// the data directory and needs the node stopped
+func openInspectDB() (database.DB, error) {
+	db, err := database.NewReadOnlyDB("core", config.DBBackend, config.DBDir())
+	if err != nil {
+		return nil, fmt.Errorf("open the store read-only, is the node stopped? %v", err)
+	}
+	return db, nil
+}
+
+func parseHash(arg string) (*bc.Hash, error) {
+	hash := &bc.Hash{}
+	if err := hash.UnmarshalText([]byte(arg)); err != nil {
+		return nil, fmt.Errorf("invalid hash %q: %v", arg, err)
+	}
+	return hash, nil
+}
+
+// migrateResult is the report of the migrate command
+type migrateResult struct {
+	DryRun        bool                    `json:"dry_run"`
+	StoreSchema   int                     `json:"store_schema"`
+	CurrentSchema int                     `json:"current_schema"`
+	Steps         []leveldb.MigrationStep `json:"steps"`
+	// IndexedBlocks counts the main chain blocks backfilled into the
+	// transaction indexes enabled by the flags
+	IndexedBlocks int `json:"indexed_blocks"`
+}
+
+func runDBMigrate(cmd *cobra.Command, args []string) error {
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return err
+	}
+
+	coreDB, err := database.NewDB("core", config.DBBackend, config.DBDir())
+	if err != nil {
+		return err
+	}
+	defer coreDB.Close()
+
+	schema, err := leveldb.StoreSchema(coreDB)
+	if err != nil {
+		return err
+	}
+
+	steps, err := leveldb.MigrateStore(coreDB, dryRun)
+	if err != nil {
+		return err
+	}
+
+	// the indexes depend on the node config rather than on the schema, a
+	// node turning one on later backfills it by a new run
+	txIndex, err := cmd.Flags().GetString("tx_index")
+	if err != nil {
+		return err
+	}
+	spentIndex, err := cmd.Flags().GetBool("spent_index")
+	if err != nil {
+		return err
+	}
+	indexed, err := leveldb.BackfillTxIndexes(coreDB, txIndex == cfg.TxIndexKV, spentIndex, dryRun)
+	if err != nil {
+		return err
+	}
+
+	return printJSON(&migrateResult{
+		DryRun:        dryRun,
+		StoreSchema:   schema,
+		CurrentSchema: leveldb.CurrentSchema(),
+		Steps:         steps,
+		IndexedBlocks: indexed,
+	})
+}
+
+func runDBStats(cmd *cobra.Command, args []string) error {
+	coreDB, err := openInspectDB()
+	if err != nil {
+		return err
+	}
+	defer coreDB.Close()
+
+	stats, err := leveldb.KeyStats(coreDB)
+	if err != nil {
+		return err
+	}
+	return printJSON(stats)
+}
+
+func runDBGetBlock(cmd *cobra.Command, args []string) error {
+	hash, err := parseHash(args[0])
+	if err != nil {
+		return err
+	}
+
+	coreDB, err := openInspectDB()
+	if err != nil {
+		return err
+	}
+	defer coreDB.Close()
+
+	block := leveldb.GetBlock(coreDB, hash)
+	if block == nil {
+		return fmt.Errorf("can't find the block %s", hash.String())
+	}
+
+	// the status stays unknown when it's missing
+	status, _ := leveldb.GetTransactionStatus(coreDB, hash)
+	annotated, err := query.AnnotateBlock(block, status)
+	if err != nil {
+		return err
+	}
+	return printJSON(annotated)
+}
+
+func runDBGetHeader(cmd *cobra.Command, args []string) error {
+	hash, err := parseHash(args[0])
+	if err != nil {
+		return err
+	}
+
+	coreDB, err := openInspectDB()
+	if err != nil {
+		return err
+	}
+	defer coreDB.Close()
+
+	header, err := leveldb.GetBlockHeader(coreDB, hash)
+	if err != nil {
+		return err
+	}
+	return printJSON(query.AnnotateHeader(header))
+}
+
+func runDBGetUtxo(cmd *cobra.Command, args []string) error {
+	hash, err := parseHash(args[0])
+	if err != nil {
+		return err
+	}
+
+	coreDB, err := openInspectDB()
+	if err != nil {
+		return err
+	}
+	defer coreDB.Close()
+
+	utxo, err := leveldb.GetUtxo(coreDB, hash)
+	if err != nil {
+		return err
+	}
+	return printJSON(utxo)
+}
+
+func runDBGetTxStatus(cmd *cobra.Command, args []string) error {
+	hash, err := parseHash(args[0])
+	if err != nil {
+		return err
+	}
+
+	coreDB, err := openInspectDB()
+	if err != nil {
+		return err
+	}
+	defer coreDB.Close()
+
+	status, err := leveldb.GetTransactionStatus(coreDB, hash)
+	if err != nil {
+		return err
+	}
+	return printJSON(status)
+}
+
+// scanRecord is a raw record listed by the scan command
+type scanRecord struct {
+	Key       string `json:"key"`
+	ValueSize int    `json:"value_size"`
+	Value     string `json:"value,omitempty"`
+}
+
+func runDBScan(cmd *cobra.Command, args []string) error {
+	prefixArg, err := cmd.Flags().GetString("prefix")
+	if err != nil {
+		return err
+	}
+	hexPrefix, err := cmd.Flags().GetBool("hex")
+	if err != nil {
+		return err
+	}
+	limit, err := cmd.Flags().GetInt("limit")
+	if err != nil {
+		return err
+	}
+	values, err := cmd.Flags().GetBool("values")
+	if err != nil {
+		return err
+	}
+
+	prefix := []byte(prefixArg)
+	if hexPrefix {
+		if prefix, err = hex.DecodeString(prefixArg); err != nil {
+			return fmt.Errorf("invalid hex prefix %q: %v", prefixArg, err)
+		}
+	}
+
+	coreDB, err := openInspectDB()
+	if err != nil {
+		return err
+	}
+	defer coreDB.Close()
+
+	iter := coreDB.IteratorPrefix(prefix)
+	defer iter.Release()
+
+	records := []*scanRecord{}
+	for iter.Next() && (limit == 0 || len(records) < limit) {
+		record := &scanRecord{Key: leveldb.FormatKey(iter.Key()), ValueSize: len(iter.Value())}
+		if values {
+			record.Value = hex.EncodeToString(iter.Value())
+		}
+		records = append(records, record)
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	return printJSON(records)
+}
+
+func runDBTip(cmd *cobra.Command, args []string) error {
+	coreDB, err := openInspectDB()
+	if err != nil {
+		return err
+	}
+	defer coreDB.Close()
+
+	status, err := leveldb.GetStoreStatus(coreDB)
+	if err != nil {
+		return err
+	}
+	if status == nil {
+		return fmt.Errorf("the store holds no chain status")
+	}
+	return printJSON(status)
+}
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/btm-stats/protocol/bc/types"
+	"github.com/btm-stats/query"
+)
+
+var decodeCmd = &cobra.Command{
+	Use:   "decode",
+	Short: "Render a raw block or transaction as JSON",
+}
+
+var decodeBlockCmd = &cobra.Command{
+	Use:   "block [hex]",
+	Short: "Render a hex encoded block, read from stdin when omitted",
+	Args:  cobra.MaximumNArgs(1),
+	RunE:  runDecodeBlock,
+}
+
+var decodeTxCmd = &cobra.Command{
+	Use:   "tx [hex]",
+	Short: "Render a hex encoded transaction, read from stdin when omitted",
+	Args:  cobra.MaximumNArgs(1),
+	RunE:  runDecodeTx,
+}
+
+func init() {
+	decodeCmd.AddCommand(decodeBlockCmd)
+	decodeCmd.AddCommand(decodeTxCmd)
+
+	RootCmd.AddCommand(decodeCmd)
+}
+
+// rawInput returns the hex of the argument, or of stdin since the large
+// blocks exceed the argument size limit
+func rawInput(args []string) ([]byte, error) {
+	if len(args) == 1 {
+		return []byte(args[0]), nil
+	}
+
+	raw, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSpace(raw), nil
+}
+
+func printJSON(v interface{}) error {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+func runDecodeBlock(cmd *cobra.Command, args []string) error {
+	raw, err := rawInput(args)
+	if err != nil {
+		return err
+	}
+
+	annotated, err := decodeBlock(raw)
+	if err != nil {
+		return err
+	}
+	return printJSON(annotated)
+}
+
+func decodeBlock(raw []byte) (*query.AnnotatedBlock, error) {
+	block := &types.Block{}
+	if err := block.UnmarshalText(raw); err != nil {
+		return nil, fmt.Errorf("decode block: %v", err)
+	}
+
+	// the status of the transactions is only known to the chain
+	return query.AnnotateBlock(block, nil)
+}
+
+func runDecodeTx(cmd *cobra.Command, args []string) error {
+	raw, err := rawInput(args)
+	if err != nil {
+		return err
+	}
+
+	annotated, err := decodeTx(raw)
+	if err != nil {
+		return err
+	}
+	return printJSON(annotated)
+}
+
+func decodeTx(raw []byte) (*query.AnnotatedTx, error) {
+	tx := &types.Tx{}
+	if err := tx.UnmarshalText(raw); err != nil {
+		return nil, fmt.Errorf("decode transaction: %v", err)
+	}
+	return query.AnnotateTx(tx), nil
+}
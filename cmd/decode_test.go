@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/btm-stats/chaingen"
+	"github.com/btm-stats/query"
+)
+
+func TestDecodeBlockRoundTrip(t *testing.T) {
+	gen := chaingen.New(chaingen.DefaultConfig())
+	blocks, err := gen.Main().Generate(20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, block := range blocks {
+		raw, err := block.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := decodeBlock(raw)
+		if err != nil {
+			t.Fatalf("block at height %d: %v", block.Height, err)
+		}
+		want, err := query.AnnotateBlock(block, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("block at height %d: got %s, want %s", block.Height, mustJSON(t, got), mustJSON(t, want))
+		}
+
+		// the command knows no status of the transactions
+		for _, tx := range got.Transactions {
+			if tx.StatusFail != nil {
+				t.Fatalf("block at height %d: tx %x renders a status", block.Height, tx.ID.Bytes())
+			}
+		}
+
+		for _, tx := range block.Transactions {
+			rawTx, err := tx.MarshalText()
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotTx, err := decodeTx(rawTx)
+			if err != nil {
+				t.Fatalf("tx %x: %v", tx.ID.Bytes(), err)
+			}
+			if !reflect.DeepEqual(gotTx, query.AnnotateTx(tx)) {
+				t.Fatalf("tx %x: got %s, want %s", tx.ID.Bytes(), mustJSON(t, gotTx), mustJSON(t, query.AnnotateTx(tx)))
+			}
+		}
+	}
+}
+
+func TestDecodeBadInput(t *testing.T) {
+	cases := []struct {
+		desc string
+		raw  []byte
+	}{
+		{
+			desc: "not hex",
+			raw:  []byte("zz"),
+		},
+		{
+			desc: "truncated",
+			raw:  []byte(hex.EncodeToString([]byte{0x03, 0x01})),
+		},
+	}
+
+	for _, c := range cases {
+		if _, err := decodeBlock(c.raw); err == nil {
+			t.Errorf("%s: decoded as a block", c.desc)
+		}
+		if _, err := decodeTx(c.raw); err == nil {
+			t.Errorf("%s: decoded as a transaction", c.desc)
+		}
+	}
+}
+
+func mustJSON(t *testing.T, v interface{}) []byte {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/btm-stats/chaingen"
+	"github.com/btm-stats/consensus"
+	"github.com/btm-stats/database"
+	"github.com/btm-stats/database/leveldb"
+	"github.com/btm-stats/protocol"
+)
+
+var genChainCmd = &cobra.Command{
+	Use:   "gen-chain",
+	Short: "Write a deterministic synthetic chain into the data directory",
+	RunE:  runGenChain,
+}
+
+var genChainFlags = struct {
+	blocks    int
+	forks     int
+	forkDepth int
+	chaingen.Config
+}{}
+
+func init() {
+	defaults := chaingen.DefaultConfig()
+	genChainCmd.Flags().IntVar(&genChainFlags.blocks, "blocks", 1000, "Number of blocks of the main chain")
+	genChainCmd.Flags().IntVar(&genChainFlags.forks, "forks", 0, "Number of stale fork branches")
+	genChainCmd.Flags().IntVar(&genChainFlags.forkDepth, "fork_depth", 3, "Number of blocks of each fork branch")
+	genChainCmd.Flags().Int64Var(&genChainFlags.Seed, "seed", defaults.Seed, "Seed of the generator")
+	genChainCmd.Flags().Uint64Var(&genChainFlags.BlockInterval, "block_interval", defaults.BlockInterval, "Seconds between two blocks")
+	genChainCmd.Flags().IntVar(&genChainFlags.MaxSpendTxs, "spends", defaults.MaxSpendTxs, "Max spend transactions per block")
+	genChainCmd.Flags().IntVar(&genChainFlags.MaxIssuanceTxs, "issuances", defaults.MaxIssuanceTxs, "Max issuance transactions per block")
+	genChainCmd.Flags().IntVar(&genChainFlags.NumAssets, "assets", defaults.NumAssets, "Number of distinct issued assets")
+	genChainCmd.Flags().Uint64Var(&genChainFlags.Fee, "fee", defaults.Fee, "Fee of every non coinbase transaction")
+
+	RootCmd.AddCommand(genChainCmd)
+}
+
+func runGenChain(cmd *cobra.Command, args []string) error {
+	if genChainFlags.forks > 0 && genChainFlags.forkDepth >= genChainFlags.blocks {
+		return errors.New("fork_depth must be less than blocks to keep the main chain the best one")
+	}
+
+	// the generated blocks aren't mined, only solonet skips the proof of work
+	consensus.ActiveNetParams = consensus.SoloNetParams
+
+	genConfig := genChainFlags.Config
+	genConfig.ControlProgram = chaingen.OpTrue
+	gen := chaingen.New(&genConfig)
+	mainBranch := gen.Main()
+	if _, err := mainBranch.Generate(genChainFlags.blocks); err != nil {
+		return err
+	}
+
+	// forks start evenly along the main chain and stay shorter than it
+	for i := 0; i < genChainFlags.forks; i++ {
+		height := uint64((i + 1) * (genChainFlags.blocks - genChainFlags.forkDepth) / (genChainFlags.forks + 1))
+		fork, err := mainBranch.Fork(height)
+		if err != nil {
+			return err
+		}
+		if _, err := fork.Generate(genChainFlags.forkDepth); err != nil {
+			return err
+		}
+	}
+
+	coreDB, err := database.NewDB("core", config.DBBackend, config.DBDir())
+	if err != nil {
+		return err
+	}
+	defer coreDB.Close()
+
+	store, err := leveldb.NewStore(coreDB)
+	if err != nil {
+		return err
+	}
+	if status := store.GetStoreStatus(); status != nil && status.Height > 0 {
+		return fmt.Errorf("data directory %s already holds a chain of height %d", config.DBDir(), status.Height)
+	}
+
+	chain, err := protocol.NewChain(store, protocol.NewTxPool())
+	if err != nil {
+		return err
+	}
+
+	for _, block := range gen.Blocks() {
+		if _, err := chain.ProcessBlock(block); err != nil {
+			return fmt.Errorf("process block %d: %v", block.Height, err)
+		}
+	}
+
+	tip := mainBranch.Tip()
+	tipHash := tip.Hash()
+	log.WithFields(log.Fields{
+		"height": tip.Height,
+		"hash":   tipHash.String(),
+		"blocks": len(gen.Blocks()),
+	}).Info("Generated synthetic chain")
+	return nil
+}
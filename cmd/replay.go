@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/btm-stats/consensus"
+	"github.com/btm-stats/database"
+	"github.com/btm-stats/database/leveldb"
+	"github.com/btm-stats/netsync"
+	"github.com/btm-stats/p2p/capture"
+	"github.com/btm-stats/protocol"
+	"github.com/btm-stats/protocol/bc"
+)
+
+const maxReplayNewBlock = 1024
+
+var replayCmd = &cobra.Command{
+	Use:   "replay <capture file>...",
+	Short: "Replay recorded p2p messages against a fresh in-memory chain",
+	Args:  cobra.MinimumNArgs(1),
+	RunE:  runReplay,
+}
+
+func init() {
+	replayCmd.Flags().String("chain_id", config.ChainID, "Select network type")
+
+	RootCmd.AddCommand(replayCmd)
+}
+
+func runReplay(cmd *cobra.Command, args []string) error {
+	netParams, ok := consensus.NetParams[config.ChainID]
+	if !ok {
+		return fmt.Errorf("chain_id[%v] don't exist", config.ChainID)
+	}
+	consensus.ActiveNetParams = netParams
+
+	readers := []*capture.Reader{}
+	for _, path := range args {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		reader, err := capture.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		readers = append(readers, reader)
+	}
+
+	// keep everything the replay writes away from the node's data directory
+	tmpDir, err := ioutil.TempDir("", "bytomd-replay")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config.VaultMode = true
+	config.DBBackend = database.MemDBBackend
+	config.P2P.CaptureDir = ""
+	config.P2P.AddrBook = filepath.Join(tmpDir, "addrbook.json")
+
+	store, err := leveldb.NewStore(database.NewMemDB())
+	if err != nil {
+		return err
+	}
+	txPool := protocol.NewTxPool()
+	chain, err := protocol.NewChain(store, txPool)
+	if err != nil {
+		return err
+	}
+
+	syncManager, err := netsync.NewSyncManager(config, chain, txPool, make(chan *bc.Hash, maxReplayNewBlock))
+	if err != nil {
+		return err
+	}
+
+	result, err := syncManager.Replay(readers)
+	if err != nil {
+		return err
+	}
+
+	out, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
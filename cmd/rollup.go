@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/btm-stats/analytics"
+	"github.com/btm-stats/consensus"
+)
+
+var rollupCmd = &cobra.Command{
+	Use:   "rollup",
+	Short: "Read the activity rollups of the chain store",
+}
+
+var rollupExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the hourly or daily activity buckets as a time series",
+	Args:  cobra.NoArgs,
+	RunE:  runRollupExport,
+}
+
+func init() {
+	rollupExportCmd.Flags().String("format", "csv", "Output format: csv | json")
+	rollupExportCmd.Flags().String("period", analytics.DayPeriod, "Bucket period: hour | day")
+	rollupExportCmd.Flags().String("from", "", "First bucket start, a UTC date like 2018-06-01 or a RFC3339 time (default the first bucket)")
+	rollupExportCmd.Flags().String("to", "", "Last bucket start, a UTC date like 2018-06-01 or a RFC3339 time (default the last bucket)")
+
+	rollupCmd.AddCommand(rollupExportCmd)
+
+	RootCmd.AddCommand(rollupCmd)
+}
+
+func runRollupExport(cmd *cobra.Command, args []string) error {
+	flags := map[string]string{}
+	for _, name := range []string{"format", "period", "from", "to"} {
+		value, err := cmd.Flags().GetString(name)
+		if err != nil {
+			return err
+		}
+		flags[name] = value
+	}
+	if flags["format"] != "csv" && flags["format"] != "json" {
+		return fmt.Errorf("unknown format %q", flags["format"])
+	}
+
+	var err error
+	from, to := uint64(0), uint64(math.MaxUint64)
+	if flags["from"] != "" {
+		if from, err = analytics.ParseTime(flags["from"]); err != nil {
+			return err
+		}
+	}
+	if flags["to"] != "" {
+		if to, err = analytics.ParseTime(flags["to"]); err != nil {
+			return err
+		}
+	}
+
+	coreDB, err := openInspectDB()
+	if err != nil {
+		return err
+	}
+	defer coreDB.Close()
+
+	buckets, err := analytics.ListRollups(coreDB, flags["period"], from, to)
+	if err != nil {
+		return err
+	}
+	if flags["format"] == "json" {
+		return printJSON(buckets)
+	}
+	return writeRollupCSV(buckets)
+}
+
+// writeRollupCSV writes a row per bucket, the volume of each asset of the
+// buckets in its own column with BTM first
+func writeRollupCSV(buckets []*analytics.BucketStats) error {
+	btm := hex.EncodeToString(consensus.BTMAssetID.Bytes())
+	assetSet := map[string]bool{}
+	for _, bucket := range buckets {
+		for asset := range bucket.Volumes {
+			assetSet[asset] = true
+		}
+	}
+	assets := []string{btm}
+	for asset := range assetSet {
+		if asset != btm {
+			assets = append(assets, asset)
+		}
+	}
+	sort.Strings(assets[1:])
+
+	w := csv.NewWriter(os.Stdout)
+	header := []string{"start", "blocks", "avg_interval", "txs", "fees", "active_programs", "new_assets"}
+	for _, asset := range assets {
+		header = append(header, "volume_"+asset)
+	}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+
+	for _, bucket := range buckets {
+		row := []string{
+			time.Unix(int64(bucket.Start), 0).UTC().Format(time.RFC3339),
+			strconv.FormatUint(bucket.Blocks, 10),
+			strconv.FormatFloat(bucket.AvgInterval(), 'f', 2, 64),
+			strconv.FormatUint(bucket.Txs, 10),
+			strconv.FormatUint(bucket.Fees, 10),
+			strconv.FormatUint(bucket.ActivePrograms, 10),
+			strconv.FormatUint(bucket.NewAssets, 10),
+		}
+		for _, asset := range assets {
+			row = append(row, strconv.FormatUint(bucket.Volumes[asset], 10))
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
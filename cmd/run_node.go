@@ -26,6 +26,15 @@ func init() {
 	runNodeCmd.Flags().Bool("vault_mode", config.VaultMode, "Run in the offline enviroment")
 	runNodeCmd.Flags().Bool("web.closed", config.Web.Closed, "Lanch web browser or not")
 	runNodeCmd.Flags().String("chain_id", config.ChainID, "Select network type")
+	runNodeCmd.Flags().String("api_addr", config.ApiAddress, "Listen address of the http api (empty disables it)")
+	runNodeCmd.Flags().String("mode", config.Mode, "Node mode: full | headers (follow the chain by the block headers only)")
+	runNodeCmd.Flags().Uint64("undo_depth", config.UndoDepth, "Number of blocks below the tip that can be detached by a reorg")
+	runNodeCmd.Flags().Uint64("prune_depth", config.PruneDepth, "Number of blocks below the tip whose bodies are kept (0 keeps every body)")
+	runNodeCmd.Flags().String("tx_index", config.TxIndex, "Transaction indexer: kv | null (no index)")
+	runNodeCmd.Flags().Bool("spent_index", config.SpentIndex, "Index the spending transaction of each spent output")
+	runNodeCmd.Flags().Int64("block_cache_size", config.BlockCacheSize, "Size of the block cache in megabytes (0 disables it)")
+	runNodeCmd.Flags().Int64("header_cache_size", config.HeaderCacheSize, "Size of the block header cache in megabytes (0 disables it)")
+	runNodeCmd.Flags().Int64("utxo_cache_size", config.UtxoCacheSize, "Size of the utxo entry cache in megabytes (0 disables it)")
 
 	// p2p flagså
 	runNodeCmd.Flags().String("p2p.laddr", config.P2P.ListenAddress, "Node listen address. (0.0.0.0:0 means any interface, any port)")
@@ -35,6 +44,22 @@ func init() {
 	runNodeCmd.Flags().Int("p2p.max_num_peers", config.P2P.MaxNumPeers, "Set max num peers")
 	runNodeCmd.Flags().Int("p2p.handshake_timeout", config.P2P.HandshakeTimeout, "Set handshake timeout")
 	runNodeCmd.Flags().Int("p2p.dial_timeout", config.P2P.DialTimeout, "Set dial timeout")
+	runNodeCmd.Flags().Int64("p2p.send_rate", config.P2P.SendRate, "Send rate limit of each peer in bytes per second")
+	runNodeCmd.Flags().Int64("p2p.recv_rate", config.P2P.RecvRate, "Receive rate limit of each peer in bytes per second")
+	runNodeCmd.Flags().Int64("p2p.max_total_send_rate", config.P2P.MaxTotalSendRate, "Send rate limit shared by all peers in bytes per second (0 means unlimited)")
+	runNodeCmd.Flags().Int64("p2p.max_total_recv_rate", config.P2P.MaxTotalRecvRate, "Receive rate limit shared by all peers in bytes per second (0 means unlimited)")
+	runNodeCmd.Flags().String("p2p.capture_dir", config.P2P.CaptureDir, "Record the raw messages of every peer into this directory (empty disables it)")
+
+	// analytics flags
+	runNodeCmd.Flags().Bool("analytics.rich_list", config.Analytics.RichList, "Keep the balances per asset and their daily distribution snapshots")
+	runNodeCmd.Flags().Int("analytics.rich_list_size", config.Analytics.RichListSize, "Number of top holders kept in the distribution snapshots")
+	runNodeCmd.Flags().Uint64("analytics.dust_threshold", config.Analytics.DustThreshold, "Balances below the threshold count as dust")
+	runNodeCmd.Flags().Bool("analytics.rollups", config.Analytics.Rollups, "Sum the block activity into hourly and daily buckets")
+	runNodeCmd.Flags().Bool("analytics.anomalies", config.Analytics.Anomalies, "Flag the blocks with odd timestamps or arrivals")
+	runNodeCmd.Flags().Uint64("analytics.future_seconds", config.Analytics.FutureSeconds, "Seconds a block timestamp may be ahead of its arrival")
+	runNodeCmd.Flags().Uint64("analytics.gap_factor", config.Analytics.GapFactor, "Flag the intervals over the factor times the target block time")
+	runNodeCmd.Flags().Int("analytics.burst_blocks", config.Analytics.BurstBlocks, "Number of blocks arriving within the burst seconds flagged as a burst")
+	runNodeCmd.Flags().Uint64("analytics.burst_seconds", config.Analytics.BurstSeconds, "Window of the arrival bursts in seconds")
 
 	// log flags
 	runNodeCmd.Flags().String("log_file", config.LogFile, "Log output file")
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/btm-stats/database"
+	"github.com/btm-stats/database/leveldb"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check the integrity of the stored chain without networking",
+	RunE:  runVerify,
+}
+
+var errChainInconsistent = errors.New("stored chain is inconsistent")
+
+func init() {
+	verifyCmd.Flags().Bool("repair", false, "Point the store tip to the last verified block and rebuild the utxo set")
+
+	RootCmd.AddCommand(verifyCmd)
+}
+
+func runVerify(cmd *cobra.Command, args []string) error {
+	repair, err := cmd.Flags().GetBool("repair")
+	if err != nil {
+		return err
+	}
+
+	coreDB, err := database.NewDB("core", config.DBBackend, config.DBDir())
+	if err != nil {
+		return err
+	}
+	defer coreDB.Close()
+
+	store, err := leveldb.NewStore(coreDB)
+	if err != nil {
+		return err
+	}
+	report, err := store.Verify()
+	if err != nil {
+		return err
+	}
+
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+
+	if report.Err == nil {
+		return nil
+	}
+	if !repair {
+		return errChainInconsistent
+	}
+
+	if err := store.RepairTip(report); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{"height": report.BestHeight, "hash": report.BestHash.String()}).Info("Repaired the store tip")
+	return nil
+}
@@ -16,6 +16,8 @@ type Config struct {
 	Wallet *WalletConfig  `mapstructure:"wallet"`
 	Auth   *RPCAuthConfig `mapstructure:"auth"`
 	Web    *WebConfig     `mapstructure:"web"`
+
+	Analytics *AnalyticsConfig `mapstructure:"analytics"`
 }
 
 // Default configurable parameters.
@@ -26,6 +28,7 @@ func DefaultConfig() *Config {
 		Wallet:     DefaultWalletConfig(),
 		Auth:       DefaultRPCAuthConfig(),
 		Web:        DefaultWebConfig(),
+		Analytics:  DefaultAnalyticsConfig(),
 	}
 }
 
@@ -64,10 +67,14 @@ type BaseConfig struct {
 
 	FilterPeers bool `mapstructure:"filter_peers"` // false
 
-	// What indexer to use for transactions
+	// What indexer to use for transactions: kv | null, kv indexes the main
+	// chain transactions by id in the store
 	TxIndex string `mapstructure:"tx_index"`
 
-	// Database backend: leveldb | memdb
+	// Index the spending transaction of each spent output
+	SpentIndex bool `mapstructure:"spent_index"`
+
+	// Database backend: leveldb | goleveldb | memdb | boltdb
 	DBBackend string `mapstructure:"db_backend"`
 
 	// Database directory
@@ -81,6 +88,24 @@ type BaseConfig struct {
 
 	ApiAddress string `mapstructure:"api_addr"`
 
+	// Node mode: full | headers, a headers node follows the chain by the
+	// block headers only and keeps neither the bodies nor the utxos
+	Mode string `mapstructure:"mode"`
+
+	// Number of blocks below the tip whose undo records are kept, a reorg
+	// can't detach deeper blocks
+	UndoDepth uint64 `mapstructure:"undo_depth"`
+
+	// Number of blocks below the tip whose bodies are kept, the older bodies
+	// and transaction statuses are pruned. Zero keeps every body.
+	PruneDepth uint64 `mapstructure:"prune_depth"`
+
+	// Sizes in megabytes of the store caches of the blocks, the block
+	// headers and the utxo entries, zero disables a cache
+	BlockCacheSize  int64 `mapstructure:"block_cache_size"`
+	HeaderCacheSize int64 `mapstructure:"header_cache_size"`
+	UtxoCacheSize   int64 `mapstructure:"utxo_cache_size"`
+
 	VaultMode bool `mapstructure:"vault_mode"`
 
 	Time time.Time
@@ -97,14 +122,39 @@ func DefaultBaseConfig() BaseConfig {
 		FastSync:          true,
 		FilterPeers:       false,
 		Mining:            false,
-		TxIndex:           "kv",
+		TxIndex:           TxIndexKV,
+		SpentIndex:        false,
 		DBBackend:         "leveldb",
 		DBPath:            "data",
 		KeysPath:          "keystore",
 		HsmUrl:            "",
+		ApiAddress:        "127.0.0.1:9888",
+		Mode:              FullMode,
+		UndoDepth:         1000,
+		PruneDepth:        0,
+		BlockCacheSize:    64,
+		HeaderCacheSize:   8,
+		UtxoCacheSize:     32,
 	}
 }
 
+// Node modes
+const (
+	FullMode    = "full"
+	HeadersMode = "headers"
+)
+
+// Transaction indexers
+const (
+	TxIndexKV   = "kv"
+	TxIndexNull = "null"
+)
+
+// HeadersOnly tells whether the node runs in the headers mode
+func (b BaseConfig) HeadersOnly() bool {
+	return b.Mode == HeadersMode
+}
+
 func (b BaseConfig) DBDir() string {
 	return rootify(b.DBPath, b.RootDir)
 }
@@ -125,6 +175,31 @@ type P2PConfig struct {
 	MaxNumPeers      int    `mapstructure:"max_num_peers"`
 	HandshakeTimeout int    `mapstructure:"handshake_timeout"`
 	DialTimeout      int    `mapstructure:"dial_timeout"`
+
+	// Rate limits of each connection in bytes per second
+	SendRate int64 `mapstructure:"send_rate"`
+	RecvRate int64 `mapstructure:"recv_rate"`
+
+	// Rate limits shared by all the connections in bytes per second, 0 means unlimited
+	MaxTotalSendRate int64 `mapstructure:"max_total_send_rate"`
+	MaxTotalRecvRate int64 `mapstructure:"max_total_recv_rate"`
+
+	// Channels overrides the channel descriptors, keyed by the channel ID in
+	// hex ("40" is the blockchain channel, "00" is the pex channel)
+	Channels map[string]*ChannelConfig `mapstructure:"channels"`
+
+	// CaptureDir enables recording the raw messages of every peer, empty
+	// means disabled
+	CaptureDir string `mapstructure:"capture_dir"`
+}
+
+// ChannelConfig overrides the queue sizes of a p2p channel, zero values keep
+// the reactor's defaults.
+type ChannelConfig struct {
+	Priority            int `mapstructure:"priority"`
+	SendQueueCapacity   int `mapstructure:"send_queue_capacity"`
+	RecvBufferCapacity  int `mapstructure:"recv_buffer_capacity"`
+	RecvMessageCapacity int `mapstructure:"recv_message_capacity"`
 }
 
 // Default configurable p2p parameters.
@@ -138,6 +213,9 @@ func DefaultP2PConfig() *P2PConfig {
 		HandshakeTimeout: 30,
 		DialTimeout:      3,
 		PexReactor:       true,
+		SendRate:         512000, // 500KB/s
+		RecvRate:         512000, // 500KB/s
+		Channels:         map[string]*ChannelConfig{},
 	}
 }
 
@@ -145,6 +223,10 @@ func (p *P2PConfig) AddrBookFile() string {
 	return rootify(p.AddrBook, p.RootDir)
 }
 
+func (p *P2PConfig) CaptureDirPath() string {
+	return rootify(p.CaptureDir, p.RootDir)
+}
+
 //-----------------------------------------------------------------------------
 type WalletConfig struct {
 	Disable bool `mapstructure:"disable"`
@@ -159,6 +241,28 @@ type WebConfig struct {
 	Closed bool `mapstructure:"closed"`
 }
 
+// AnalyticsConfig selects the statistics derived from the main chain
+type AnalyticsConfig struct {
+	// RichList keeps the balances of the control programs per asset and a
+	// daily snapshot of each asset distribution
+	RichList bool `mapstructure:"rich_list"`
+	// Number of top holders kept in the snapshots
+	RichListSize int `mapstructure:"rich_list_size"`
+	// Balances below the threshold count as dust in the snapshots
+	DustThreshold uint64 `mapstructure:"dust_threshold"`
+	// Rollups sums the block activity into hourly and daily buckets
+	Rollups bool `mapstructure:"rollups"`
+	// Anomalies flags the blocks with odd timestamps or arrivals
+	Anomalies bool `mapstructure:"anomalies"`
+	// Seconds a block timestamp may be ahead of its arrival
+	FutureSeconds uint64 `mapstructure:"future_seconds"`
+	// Intervals over the factor times the target block time are flagged
+	GapFactor uint64 `mapstructure:"gap_factor"`
+	// Number of blocks arriving within the burst seconds flagged as a burst
+	BurstBlocks  int    `mapstructure:"burst_blocks"`
+	BurstSeconds uint64 `mapstructure:"burst_seconds"`
+}
+
 // Default configurable rpc's auth parameters.
 func DefaultRPCAuthConfig() *RPCAuthConfig {
 	return &RPCAuthConfig{
@@ -173,6 +277,21 @@ func DefaultWebConfig() *WebConfig {
 	}
 }
 
+// Default configurable analytics parameters.
+func DefaultAnalyticsConfig() *AnalyticsConfig {
+	return &AnalyticsConfig{
+		RichList:      false,
+		RichListSize:  100,
+		DustThreshold: 100000,
+		Rollups:       false,
+		Anomalies:     false,
+		FutureSeconds: 15 * 60,
+		GapFactor:     10,
+		BurstBlocks:   4,
+		BurstSeconds:  60,
+	}
+}
+
 // Default configurable wallet parameters.
 func DefaultWalletConfig() *WalletConfig {
 	return &WalletConfig{
@@ -0,0 +1,137 @@
+// Package difficulty implements the compact difficulty encoding, the work
+// calculation and the retarget rule of the proof of work
+package difficulty
+
+import (
+	"math/big"
+
+	"github.com/btm-stats/consensus"
+	"github.com/btm-stats/mining/tensority"
+	"github.com/btm-stats/protocol/bc"
+)
+
+var (
+	// bigOne is 1 represented as a big.Int. It is defined here to avoid
+	// the overhead of creating it multiple times.
+	bigOne = big.NewInt(1)
+
+	// oneLsh256 is 1 shifted left 256 bits. It is defined here to avoid
+	// the overhead of creating it multiple times.
+	oneLsh256 = new(big.Int).Lsh(bigOne, 256)
+)
+
+// HashToBig converts a bc.Hash into a big.Int that can be used to perform
+// math comparisons.
+func HashToBig(hash *bc.Hash) *big.Int {
+	// reverse the bytes of the hash (little-endian) to use it in the big
+	// package (big-endian)
+	buf := hash.Byte32()
+	blen := len(buf)
+	for i := 0; i < blen/2; i++ {
+		buf[i], buf[blen-1-i] = buf[blen-1-i], buf[i]
+	}
+	return new(big.Int).SetBytes(buf[:])
+}
+
+// CalcWork calculates a work value from difficulty bits, the work of a block
+// is the expected number of hashes needed to meet its target.
+func CalcWork(bits uint64) *big.Int {
+	difficultyNum := CompactToBig(bits)
+	if difficultyNum.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+
+	// (1 << 256) / (difficultyNum + 1)
+	denominator := new(big.Int).Add(difficultyNum, bigOne)
+	return new(big.Int).Div(oneLsh256, denominator)
+}
+
+// CompactToBig converts a compact representation of a whole unsigned integer
+// N to a big.Int. The representation is similar to IEEE754 floating point
+// numbers. Sign is not really being used.
+//
+//	-------------------------------------------------
+//	|   Exponent     |    Sign    |    Mantissa     |
+//	-------------------------------------------------
+//	| 8 bits [63-56] | 1 bit [55] | 55 bits [54-00] |
+//	-------------------------------------------------
+//
+//	N = (-1^sign) * mantissa * 256^(exponent-3)
+func CompactToBig(compact uint64) *big.Int {
+	// Extract the mantissa, sign bit, and exponent.
+	mantissa := compact & 0x007fffffffffffff
+	isNegative := compact&0x0080000000000000 != 0
+	exponent := uint(compact >> 56)
+
+	var bn *big.Int
+	if exponent <= 3 {
+		mantissa >>= 8 * (3 - exponent)
+		bn = big.NewInt(int64(mantissa))
+	} else {
+		bn = big.NewInt(int64(mantissa))
+		bn.Lsh(bn, 8*(exponent-3))
+	}
+
+	if isNegative {
+		bn = bn.Neg(bn)
+	}
+	return bn
+}
+
+// BigToCompact converts a whole number N to a compact representation using
+// an unsigned 64-bit number, it's the reverse of CompactToBig.
+func BigToCompact(n *big.Int) uint64 {
+	if n.Sign() == 0 {
+		return 0
+	}
+
+	var mantissa uint64
+	// Bytes() returns the absolute value of n as a big-endian byte slice
+	exponent := uint(len(n.Bytes()))
+	if exponent <= 3 {
+		mantissa = new(big.Int).Abs(n).Uint64()
+		mantissa <<= 8 * (3 - exponent)
+	} else {
+		// Since the base for the exponent is 256, the exponent can be treated
+		// as the number of bytes to represent the full 256-bit number, the
+		// shifted value keeps the top 3 bytes
+		tn := new(big.Int).Abs(n)
+		mantissa = tn.Rsh(tn, 8*(exponent-3)).Uint64()
+	}
+
+	if mantissa&0x0080000000000000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+
+	compact := uint64(exponent)<<56 | mantissa
+	if n.Sign() < 0 {
+		compact |= 0x0080000000000000
+	}
+	return compact
+}
+
+// CheckProofOfWork checks whether the Tensority hash of the block hash under
+// seed meets the target of the given difficulty bits.
+func CheckProofOfWork(hash, seed *bc.Hash, bits uint64) bool {
+	compareHash := tensority.Hash(hash, seed)
+	return HashToBig(compareHash).Cmp(CompactToBig(bits)) <= 0
+}
+
+// CalcNextRequiredDifficulty returns the bits of the block after lastHeight.
+// The difficulty only changes on a retarget boundary, where the target is
+// scaled by the time the last BlocksPerRetarget blocks took against the
+// expected time.
+func CalcNextRequiredDifficulty(lastHeight, lastBits, lastTimestamp, compareTimestamp uint64) uint64 {
+	if lastHeight%consensus.BlocksPerRetarget != 0 || lastHeight == 0 {
+		return lastBits
+	}
+
+	targetTimeSpan := int64(consensus.BlocksPerRetarget * consensus.TargetSecondsPerBlock)
+	actualTimespan := int64(lastTimestamp - compareTimestamp)
+
+	oldTarget := CompactToBig(lastBits)
+	newTarget := new(big.Int).Mul(oldTarget, big.NewInt(actualTimespan))
+	newTarget.Div(newTarget, big.NewInt(targetTimeSpan))
+	return BigToCompact(newTarget)
+}
@@ -82,6 +82,10 @@ type Params struct {
 	// Name defines a human-readable identifier for the network.
 	Name            string
 	Bech32HRPSegwit string
+
+	// SkipPoW accepts blocks without checking the proof of work, it's only
+	// meant for the local single node network
+	SkipPoW bool
 }
 
 // ActiveNetParams is ...
@@ -110,4 +114,5 @@ var TestNetParams = Params{
 var SoloNetParams = Params{
 	Name:            "solo",
 	Bech32HRPSegwit: "sm",
+	SkipPoW:         true,
 }
@@ -0,0 +1,289 @@
+package database
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"time"
+
+	"github.com/boltdb/bolt"
+	cmn "github.com/tendermint/tmlibs/common"
+)
+
+const (
+	// boltIteratorChunk is how many pairs an iterator reads per transaction,
+	// a long scan never pins a read transaction the writers would wait on
+	boltIteratorChunk = 1024
+	boltOpenTimeout   = 5 * time.Second
+	// boltInitialMmapSize maps the file far beyond its size up front, bolt
+	// remaps a growing file only once no read transaction is open, so a
+	// smaller map would make the writes wait on the snapshots
+	boltInitialMmapSize = 1 << 30
+)
+
+var boltBucket = []byte("default")
+
+func init() {
+	registerDBCreator(BoltDBBackend, func(name string, dir string) (DB, error) {
+		return NewBoltDB(name, dir)
+	})
+	registerReadOnlyDBCreator(BoltDBBackend, func(name string, dir string) (DB, error) {
+		return NewReadOnlyBoltDB(name, dir)
+	})
+}
+
+// BoltDB is the embedded B+tree backend, the whole store is the single file
+// name.bolt
+type BoltDB struct {
+	db *bolt.DB
+}
+
+// NewBoltDB opens or creates the store name under dir
+func NewBoltDB(name string, dir string) (*BoltDB, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path.Join(dir, name+".bolt"), 0600, &bolt.Options{Timeout: boltOpenTimeout, InitialMmapSize: boltInitialMmapSize})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltDB{db: db}, nil
+}
+
+// NewReadOnlyBoltDB opens the existing store name under dir for reading
+func NewReadOnlyBoltDB(name string, dir string) (*BoltDB, error) {
+	file := path.Join(dir, name+".bolt")
+	if _, err := os.Stat(file); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(file, 0600, &bolt.Options{Timeout: boltOpenTimeout, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltDB{db: db}, nil
+}
+
+// Get returns the value of the key
+func (db *BoltDB) Get(key []byte) []byte {
+	var value []byte
+	if err := db.db.View(func(tx *bolt.Tx) error {
+		value = boltGet(tx, key)
+		return nil
+	}); err != nil {
+		cmn.PanicCrisis(err)
+	}
+	return value
+}
+
+// Has checks whether the key is set
+func (db *BoltDB) Has(key []byte) bool {
+	return db.Get(key) != nil
+}
+
+// Set sets the value of the key, bolt syncs every write transaction
+func (db *BoltDB) Set(key, value []byte) {
+	if err := db.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put(key, value)
+	}); err != nil {
+		cmn.PanicCrisis(err)
+	}
+}
+
+// SetSync sets the value of the key
+func (db *BoltDB) SetSync(key, value []byte) {
+	db.Set(key, value)
+}
+
+// Delete removes the key
+func (db *BoltDB) Delete(key []byte) {
+	if err := db.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete(key)
+	}); err != nil {
+		cmn.PanicCrisis(err)
+	}
+}
+
+// NewBatch returns a batch written by a single write transaction
+func (db *BoltDB) NewBatch() Batch {
+	return &boltBatch{db: db.db}
+}
+
+// IteratorRange iterates the keys of [start, limit)
+func (db *BoltDB) IteratorRange(start, limit []byte) Iterator {
+	return newBoltIterator(db.db.View, start, limit)
+}
+
+// IteratorPrefix iterates the keys starting with the prefix
+func (db *BoltDB) IteratorPrefix(prefix []byte) Iterator {
+	return db.IteratorRange(prefix, PrefixLimit(prefix))
+}
+
+// NewSnapshot holds a read transaction until it's released, the writes
+// growing the file past the initial map wait for the open snapshots
+func (db *BoltDB) NewSnapshot() (Snapshot, error) {
+	tx, err := db.db.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	return &boltSnapshot{tx: tx}, nil
+}
+
+// Close closes the store
+func (db *BoltDB) Close() {
+	db.db.Close()
+}
+
+// boltGet copies the value out of the transaction, it's only valid within
+func boltGet(tx *bolt.Tx, key []byte) []byte {
+	value := tx.Bucket(boltBucket).Get(key)
+	if value == nil {
+		return nil
+	}
+	return append([]byte{}, value...)
+}
+
+type boltBatch struct {
+	db  *bolt.DB
+	ops []memOp
+}
+
+func (b *boltBatch) Set(key, value []byte) {
+	b.ops = append(b.ops, memOp{key: append([]byte{}, key...), value: append([]byte{}, value...)})
+}
+
+func (b *boltBatch) Delete(key []byte) {
+	b.ops = append(b.ops, memOp{key: append([]byte{}, key...), delete: true})
+}
+
+func (b *boltBatch) Write() error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		for _, op := range b.ops {
+			var err error
+			if op.delete {
+				err = bucket.Delete(op.key)
+			} else {
+				err = bucket.Put(op.key, op.value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+type boltSnapshot struct {
+	tx *bolt.Tx
+}
+
+func (s *boltSnapshot) view(fn func(*bolt.Tx) error) error {
+	return fn(s.tx)
+}
+
+func (s *boltSnapshot) Get(key []byte) []byte {
+	return boltGet(s.tx, key)
+}
+
+func (s *boltSnapshot) Has(key []byte) bool {
+	return s.Get(key) != nil
+}
+
+func (s *boltSnapshot) IteratorRange(start, limit []byte) Iterator {
+	return newBoltIterator(s.view, start, limit)
+}
+
+func (s *boltSnapshot) IteratorPrefix(prefix []byte) Iterator {
+	return s.IteratorRange(prefix, PrefixLimit(prefix))
+}
+
+func (s *boltSnapshot) Release() {
+	s.tx.Rollback()
+}
+
+// boltIterator reads the range chunk by chunk, each chunk within its own
+// view of the store
+type boltIterator struct {
+	view  func(func(*bolt.Tx) error) error
+	start []byte
+	limit []byte
+	chunk *kvIterator
+	done  bool
+	err   error
+}
+
+func newBoltIterator(view func(func(*bolt.Tx) error) error, start, limit []byte) *boltIterator {
+	return &boltIterator{view: view, start: start, limit: limit}
+}
+
+func (it *boltIterator) fill() {
+	chunk := &kvIterator{cur: -1}
+	it.err = it.view(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(boltBucket).Cursor()
+		key, value := cursor.First()
+		if it.start != nil {
+			key, value = cursor.Seek(it.start)
+		}
+
+		for ; key != nil && len(chunk.keys) < boltIteratorChunk; key, value = cursor.Next() {
+			if it.limit != nil && bytes.Compare(key, it.limit) >= 0 {
+				key = nil
+				break
+			}
+			chunk.keys = append(chunk.keys, append([]byte{}, key...))
+			chunk.values = append(chunk.values, append([]byte{}, value...))
+		}
+
+		if key == nil {
+			it.done = true
+		} else {
+			it.start = append([]byte{}, key...)
+		}
+		return nil
+	})
+	it.chunk = chunk
+}
+
+func (it *boltIterator) Next() bool {
+	for {
+		if it.chunk != nil && it.chunk.Next() {
+			return true
+		}
+		if it.done || it.err != nil {
+			return false
+		}
+		it.fill()
+	}
+}
+
+func (it *boltIterator) Key() []byte {
+	if it.chunk == nil {
+		return nil
+	}
+	return it.chunk.Key()
+}
+
+func (it *boltIterator) Value() []byte {
+	if it.chunk == nil {
+		return nil
+	}
+	return it.chunk.Value()
+}
+
+func (it *boltIterator) Release() {
+	it.chunk, it.done = nil, true
+}
+
+func (it *boltIterator) Error() error {
+	return it.err
+}
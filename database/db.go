@@ -0,0 +1,129 @@
+// Package database defines the key-value storage the chain store runs on and
+// its backends, selected by name through BaseConfig.DBBackend.
+package database
+
+import (
+	"sort"
+
+	"github.com/btm-stats/errors"
+)
+
+// The names of the storage backends
+const (
+	LevelDBBackend   = "leveldb" // legacy name of goleveldb
+	GoLevelDBBackend = "goleveldb"
+	MemDBBackend     = "memdb"
+	BoltDBBackend    = "boltdb"
+)
+
+// DB is an ordered key-value store. The reads return nil for missing keys and
+// the writes outside a batch panic on failure, a batch reports its failure.
+type DB interface {
+	Reader
+
+	Set(key, value []byte)
+	SetSync(key, value []byte)
+	Delete(key []byte)
+	NewBatch() Batch
+
+	// NewSnapshot returns a consistent read-only view of the current state,
+	// it must be released once done.
+	NewSnapshot() (Snapshot, error)
+	Close()
+}
+
+// Reader is the read side shared by the store and its snapshots
+type Reader interface {
+	Get(key []byte) []byte
+	Has(key []byte) bool
+
+	// IteratorRange iterates the keys of [start, limit) in ascending order,
+	// a nil start begins at the first key and a nil limit runs to the last.
+	IteratorRange(start, limit []byte) Iterator
+	IteratorPrefix(prefix []byte) Iterator
+}
+
+// Batch collects writes applied atomically by Write, either all or none of
+// them reach the store.
+type Batch interface {
+	Set(key, value []byte)
+	Delete(key []byte)
+	Write() error
+}
+
+// Iterator walks a key range, it must be released once done
+type Iterator interface {
+	Next() bool
+	Key() []byte
+	Value() []byte
+	Release()
+	Error() error
+}
+
+// Snapshot is a read-only view of the store at the time it was taken
+type Snapshot interface {
+	Reader
+	Release()
+}
+
+// ErrUnknownBackend is returned when the configured backend isn't registered
+var ErrUnknownBackend = errors.New("unknown db backend")
+
+type dbCreator func(name string, dir string) (DB, error)
+
+var (
+	backends         = map[string]dbCreator{}
+	readOnlyBackends = map[string]dbCreator{}
+)
+
+func registerDBCreator(backend string, creator dbCreator) {
+	backends[backend] = creator
+}
+
+func registerReadOnlyDBCreator(backend string, creator dbCreator) {
+	readOnlyBackends[backend] = creator
+}
+
+// Backends returns the names of the registered backends
+func Backends() []string {
+	names := []string{}
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewDB opens the store name under dir with the backend
+func NewDB(name string, backend string, dir string) (DB, error) {
+	creator, ok := backends[backend]
+	if !ok {
+		return nil, errors.WithDetailf(ErrUnknownBackend, "backend %q, expect one of %v", backend, Backends())
+	}
+	return creator(name, dir)
+}
+
+// NewReadOnlyDB opens the existing store name under dir for reading, the
+// writes on it panic. The backends lock the store, it fails while a node
+// has it open.
+func NewReadOnlyDB(name string, backend string, dir string) (DB, error) {
+	creator, ok := readOnlyBackends[backend]
+	if !ok {
+		return nil, errors.WithDetailf(ErrUnknownBackend, "backend %q can't be opened read-only", backend)
+	}
+	return creator(name, dir)
+}
+
+// PrefixLimit returns the smallest key greater than all the keys starting
+// with the prefix, nil when there is none. It's the limit of the range
+// iterating the prefix.
+func PrefixLimit(prefix []byte) []byte {
+	limit := append([]byte{}, prefix...)
+	for i := len(limit) - 1; i >= 0; i-- {
+		if limit[i] < 0xff {
+			limit[i]++
+			return limit[:i+1]
+		}
+	}
+	return nil
+}
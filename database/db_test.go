@@ -0,0 +1,199 @@
+package database
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func testBackends(t *testing.T, fn func(t *testing.T, db DB)) {
+	for _, backend := range []string{MemDBBackend, GoLevelDBBackend, BoltDBBackend} {
+		t.Run(backend, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "db_test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			db, err := NewDB("test", backend, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			fn(t, db)
+		})
+	}
+}
+
+func collect(iter Iterator) []string {
+	defer iter.Release()
+
+	keys := []string{}
+	for iter.Next() {
+		keys = append(keys, string(iter.Key())+"="+string(iter.Value()))
+	}
+	return keys
+}
+
+func checkKeys(t *testing.T, name string, got []string, want ...string) {
+	if len(got) != len(want) {
+		t.Fatalf("%s: got %v, want %v", name, got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("%s: got %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestGetSetDelete(t *testing.T) {
+	testBackends(t, func(t *testing.T, db DB) {
+		if db.Get([]byte("a")) != nil || db.Has([]byte("a")) {
+			t.Fatal("missing key is found")
+		}
+
+		db.Set([]byte("a"), []byte("1"))
+		if got := db.Get([]byte("a")); !bytes.Equal(got, []byte("1")) || !db.Has([]byte("a")) {
+			t.Fatalf("got %q, want %q", got, "1")
+		}
+
+		db.Delete([]byte("a"))
+		if db.Get([]byte("a")) != nil {
+			t.Fatal("deleted key is found")
+		}
+	})
+}
+
+func TestIterators(t *testing.T) {
+	testBackends(t, func(t *testing.T, db DB) {
+		for _, key := range []string{"a", "b:1", "b:2", "b\xff", "c"} {
+			db.Set([]byte(key), []byte("v"))
+		}
+
+		checkKeys(t, "all", collect(db.IteratorRange(nil, nil)), "a=v", "b:1=v", "b:2=v", "b\xff=v", "c=v")
+		checkKeys(t, "range", collect(db.IteratorRange([]byte("b"), []byte("b:2"))), "b:1=v")
+		checkKeys(t, "open limit", collect(db.IteratorRange([]byte("b:2"), nil)), "b:2=v", "b\xff=v", "c=v")
+		checkKeys(t, "prefix", collect(db.IteratorPrefix([]byte("b:"))), "b:1=v", "b:2=v")
+		checkKeys(t, "empty prefix", collect(db.IteratorPrefix([]byte("d"))))
+	})
+}
+
+func TestLongIterator(t *testing.T) {
+	testBackends(t, func(t *testing.T, db DB) {
+		batch := db.NewBatch()
+		for i := 0; i < 3*boltIteratorChunk+7; i++ {
+			batch.Set([]byte{'k', byte(i >> 8), byte(i)}, []byte("v"))
+		}
+		if err := batch.Write(); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := len(collect(db.IteratorPrefix([]byte("k")))); got != 3*boltIteratorChunk+7 {
+			t.Fatalf("got %d keys, want %d", got, 3*boltIteratorChunk+7)
+		}
+	})
+}
+
+func TestBatch(t *testing.T) {
+	testBackends(t, func(t *testing.T, db DB) {
+		db.Set([]byte("a"), []byte("1"))
+
+		batch := db.NewBatch()
+		batch.Delete([]byte("a"))
+		batch.Set([]byte("b"), []byte("2"))
+		if db.Has([]byte("b")) {
+			t.Fatal("batch is visible before written")
+		}
+
+		if err := batch.Write(); err != nil {
+			t.Fatal(err)
+		}
+		checkKeys(t, "after batch", collect(db.IteratorRange(nil, nil)), "b=2")
+	})
+}
+
+func TestSnapshot(t *testing.T) {
+	testBackends(t, func(t *testing.T, db DB) {
+		db.Set([]byte("a"), []byte("1"))
+
+		snapshot, err := db.NewSnapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer snapshot.Release()
+
+		db.Set([]byte("a"), []byte("2"))
+		db.Set([]byte("b"), []byte("2"))
+
+		if got := snapshot.Get([]byte("a")); !bytes.Equal(got, []byte("1")) {
+			t.Fatalf("snapshot got %q, want %q", got, "1")
+		}
+		if snapshot.Has([]byte("b")) {
+			t.Fatal("snapshot sees a later write")
+		}
+		checkKeys(t, "snapshot", collect(snapshot.IteratorPrefix(nil)), "a=1")
+	})
+}
+
+func TestUnknownBackend(t *testing.T) {
+	if _, err := NewDB("test", "nosuchdb", ""); err == nil {
+		t.Fatal("unknown backend is opened")
+	}
+}
+
+func TestPrefixLimit(t *testing.T) {
+	cases := []struct {
+		prefix []byte
+		want   []byte
+	}{
+		{[]byte("ab"), []byte("ac")},
+		{[]byte{'a', 0xff}, []byte("b")},
+		{[]byte{0xff, 0xff}, nil},
+		{nil, nil},
+	}
+	for _, c := range cases {
+		if got := PrefixLimit(c.prefix); !bytes.Equal(got, c.want) {
+			t.Errorf("PrefixLimit(%x) = %x, want %x", c.prefix, got, c.want)
+		}
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	for _, backend := range []string{GoLevelDBBackend, BoltDBBackend} {
+		t.Run(backend, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "db_test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			if _, err := NewReadOnlyDB("test", backend, dir); err == nil {
+				t.Fatal("missing store is opened read-only")
+			}
+
+			db, err := NewDB("test", backend, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			db.Set([]byte("a"), []byte("1"))
+			db.Close()
+
+			db, err = NewReadOnlyDB("test", backend, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			if got := db.Get([]byte("a")); !bytes.Equal(got, []byte("1")) {
+				t.Fatalf("got %q, want %q", got, "1")
+			}
+			batch := db.NewBatch()
+			batch.Set([]byte("b"), []byte("2"))
+			if err := batch.Write(); err == nil {
+				t.Fatal("read-only store accepts a write")
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package database
+
+import (
+	"path"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	cmn "github.com/tendermint/tmlibs/common"
+)
+
+func init() {
+	dbCreator := func(name string, dir string) (DB, error) {
+		return NewGoLevelDB(name, dir)
+	}
+	registerDBCreator(LevelDBBackend, dbCreator)
+	registerDBCreator(GoLevelDBBackend, dbCreator)
+
+	readOnlyCreator := func(name string, dir string) (DB, error) {
+		return NewReadOnlyGoLevelDB(name, dir)
+	}
+	registerReadOnlyDBCreator(LevelDBBackend, readOnlyCreator)
+	registerReadOnlyDBCreator(GoLevelDBBackend, readOnlyCreator)
+}
+
+// GoLevelDB is the goleveldb backend, the store is the directory name.db
+type GoLevelDB struct {
+	db *leveldb.DB
+}
+
+// NewGoLevelDB opens or creates the store name under dir
+func NewGoLevelDB(name string, dir string) (*GoLevelDB, error) {
+	db, err := leveldb.OpenFile(path.Join(dir, name+".db"), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &GoLevelDB{db: db}, nil
+}
+
+// NewReadOnlyGoLevelDB opens the existing store name under dir for reading
+func NewReadOnlyGoLevelDB(name string, dir string) (*GoLevelDB, error) {
+	db, err := leveldb.OpenFile(path.Join(dir, name+".db"), &opt.Options{ReadOnly: true, ErrorIfMissing: true})
+	if err != nil {
+		return nil, err
+	}
+	return &GoLevelDB{db: db}, nil
+}
+
+// Get returns the value of the key
+func (db *GoLevelDB) Get(key []byte) []byte {
+	return levelGet(db.db.Get(key, nil))
+}
+
+// Has checks whether the key is set
+func (db *GoLevelDB) Has(key []byte) bool {
+	return levelHas(db.db.Has(key, nil))
+}
+
+// Set sets the value of the key
+func (db *GoLevelDB) Set(key, value []byte) {
+	if err := db.db.Put(key, value, nil); err != nil {
+		cmn.PanicCrisis(err)
+	}
+}
+
+// SetSync sets the value of the key and syncs it to the disk
+func (db *GoLevelDB) SetSync(key, value []byte) {
+	if err := db.db.Put(key, value, &opt.WriteOptions{Sync: true}); err != nil {
+		cmn.PanicCrisis(err)
+	}
+}
+
+// Delete removes the key
+func (db *GoLevelDB) Delete(key []byte) {
+	if err := db.db.Delete(key, nil); err != nil {
+		cmn.PanicCrisis(err)
+	}
+}
+
+// NewBatch returns a batch written by a single leveldb write
+func (db *GoLevelDB) NewBatch() Batch {
+	return &goLevelDBBatch{db: db.db, batch: new(leveldb.Batch)}
+}
+
+// IteratorRange iterates the keys of [start, limit)
+func (db *GoLevelDB) IteratorRange(start, limit []byte) Iterator {
+	return db.db.NewIterator(&util.Range{Start: start, Limit: limit}, nil)
+}
+
+// IteratorPrefix iterates the keys starting with the prefix
+func (db *GoLevelDB) IteratorPrefix(prefix []byte) Iterator {
+	return db.db.NewIterator(util.BytesPrefix(prefix), nil)
+}
+
+// NewSnapshot returns a leveldb snapshot of the current state
+func (db *GoLevelDB) NewSnapshot() (Snapshot, error) {
+	snapshot, err := db.db.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &goLevelDBSnapshot{snapshot: snapshot}, nil
+}
+
+// Close closes the store
+func (db *GoLevelDB) Close() {
+	db.db.Close()
+}
+
+type goLevelDBBatch struct {
+	db    *leveldb.DB
+	batch *leveldb.Batch
+}
+
+func (b *goLevelDBBatch) Set(key, value []byte) {
+	b.batch.Put(key, value)
+}
+
+func (b *goLevelDBBatch) Delete(key []byte) {
+	b.batch.Delete(key)
+}
+
+func (b *goLevelDBBatch) Write() error {
+	return b.db.Write(b.batch, nil)
+}
+
+type goLevelDBSnapshot struct {
+	snapshot *leveldb.Snapshot
+}
+
+func (s *goLevelDBSnapshot) Get(key []byte) []byte {
+	return levelGet(s.snapshot.Get(key, nil))
+}
+
+func (s *goLevelDBSnapshot) Has(key []byte) bool {
+	return levelHas(s.snapshot.Has(key, nil))
+}
+
+func (s *goLevelDBSnapshot) IteratorRange(start, limit []byte) Iterator {
+	return s.snapshot.NewIterator(&util.Range{Start: start, Limit: limit}, nil)
+}
+
+func (s *goLevelDBSnapshot) IteratorPrefix(prefix []byte) Iterator {
+	return s.snapshot.NewIterator(util.BytesPrefix(prefix), nil)
+}
+
+func (s *goLevelDBSnapshot) Release() {
+	s.snapshot.Release()
+}
+
+func levelGet(value []byte, err error) []byte {
+	if err == leveldb.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		cmn.PanicCrisis(err)
+	}
+	return value
+}
+
+func levelHas(ok bool, err error) bool {
+	if err != nil {
+		cmn.PanicCrisis(err)
+	}
+	return ok
+}
@@ -1,6 +1,7 @@
 package leveldb
 
 import (
+	"fmt"
 	"sync"
 
 	"github.com/golang/groupcache/lru"
@@ -10,18 +11,195 @@ import (
 	"github.com/btm-stats/protocol/bc/types"
 )
 
-const maxCachedBlocks = 30
+// Default sizes of the store caches in bytes
+const (
+	DefaultBlockCacheSize  = 64 << 20
+	DefaultHeaderCacheSize = 8 << 20
+	DefaultUtxoCacheSize   = 32 << 20
+)
+
+// blockHeaderSize approximates the memory held by a cached block header
+const blockHeaderSize = 256
+
+// CacheStats reports the usage of a store cache since the start
+type CacheStats struct {
+	Name      string `json:"name"`
+	Entries   int    `json:"entries"`
+	Size      int64  `json:"size"`
+	MaxSize   int64  `json:"max_size"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+}
+
+type sizedEntry struct {
+	value interface{}
+	size  int64
+}
+
+// sizedCache is a LRU bounded by the total size of its values, a zero max
+// size disables it
+type sizedCache struct {
+	name string
+
+	mu      sync.Mutex
+	lru     *lru.Cache
+	size    int64
+	maxSize int64
+	// gen counts the removals, a value read before a removal may be stale
+	gen uint64
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+func newSizedCache(name string, maxSize int64) *sizedCache {
+	c := &sizedCache{name: name, lru: lru.New(0), maxSize: maxSize}
+	c.lru.OnEvicted = func(key lru.Key, value interface{}) {
+		c.size -= value.(*sizedEntry).size
+	}
+	return c
+}
+
+func (c *sizedCache) get(key interface{}) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.lru.Get(key)
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	return entry.(*sizedEntry).value, true
+}
+
+func (c *sizedCache) add(key, value interface{}, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.addLocked(key, value, size)
+}
+
+// generation returns the count of removals, to be passed to fill
+func (c *sizedCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// fill adds a value read from the db at the generation, unless a removal
+// since then may have made it stale
+func (c *sizedCache) fill(gen uint64, key, value interface{}, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen == c.gen {
+		c.addLocked(key, value, size)
+	}
+}
+
+func (c *sizedCache) addLocked(key, value interface{}, size int64) {
+	if size > c.maxSize {
+		return
+	}
+
+	c.lru.Remove(key)
+	c.lru.Add(key, &sizedEntry{value: value, size: size})
+	c.size += size
+	c.evictLocked()
+}
+
+func (c *sizedCache) remove(key interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Remove(key)
+	c.gen++
+}
+
+func (c *sizedCache) setMaxSize(maxSize int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxSize = maxSize
+	c.evictLocked()
+}
+
+func (c *sizedCache) evictLocked() {
+	for c.size > c.maxSize && c.lru.Len() > 0 {
+		c.lru.RemoveOldest()
+		c.evictions++
+	}
+}
+
+func (c *sizedCache) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Name:      c.name,
+		Entries:   c.lru.Len(),
+		Size:      c.size,
+		MaxSize:   c.maxSize,
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+	}
+}
+
+// blockSize approximates the memory held by a cached block by its
+// serialized size
+func blockSize(block *types.Block) int64 {
+	size := int64(blockHeaderSize)
+	for _, tx := range block.Transactions {
+		size += int64(tx.SerializedSize)
+	}
+	return size
+}
 
-func newBlockCache(fillFn func(hash *bc.Hash) *types.Block) blockCache {
+func newBlockCache(maxSize int64, fillFn func(hash *bc.Hash) *types.Block) blockCache {
 	return blockCache{
-		lru:    lru.New(maxCachedBlocks),
+		cache:  newSizedCache("block", maxSize),
 		fillFn: fillFn,
 	}
 }
 
 type blockCache struct {
-	mu     sync.Mutex
-	lru    *lru.Cache
+	cache  *sizedCache
 	fillFn func(hash *bc.Hash) *types.Block
 	single singleflight.Group
 }
+
+func (c *blockCache) lookup(hash *bc.Hash) (*types.Block, error) {
+	if b, ok := c.get(hash); ok {
+		return b, nil
+	}
+
+	block, err := c.single.Do(hash.String(), func() (interface{}, error) {
+		gen := c.cache.generation()
+		b := c.fillFn(hash)
+		if b == nil {
+			return nil, fmt.Errorf("There are no block with given hash %s", hash.String())
+		}
+
+		c.cache.fill(gen, *hash, b, blockSize(b))
+		return b, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return block.(*types.Block), nil
+}
+
+func (c *blockCache) get(hash *bc.Hash) (*types.Block, bool) {
+	block, ok := c.cache.get(*hash)
+	if block == nil {
+		return nil, ok
+	}
+	return block.(*types.Block), ok
+}
+
+func (c *blockCache) add(block *types.Block) {
+	c.cache.add(block.Hash(), block, blockSize(block))
+}
+
+func (c *blockCache) remove(hash *bc.Hash) {
+	c.cache.remove(*hash)
+}
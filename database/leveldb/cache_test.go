@@ -0,0 +1,88 @@
+package leveldb
+
+import (
+	"testing"
+
+	"github.com/btm-stats/protocol/bc"
+	"github.com/btm-stats/protocol/bc/types"
+)
+
+func TestSizedCache(t *testing.T) {
+	c := newSizedCache("test", 10)
+	c.add("a", 1, 4)
+	c.add("b", 2, 4)
+	if _, ok := c.get("a"); !ok {
+		t.Fatal("a is missing")
+	}
+
+	// b is the least recently used, replacing a keeps the size in check
+	c.add("a", 3, 5)
+	c.add("c", 4, 4)
+	if _, ok := c.get("b"); ok {
+		t.Fatal("b isn't evicted")
+	}
+	if v, ok := c.get("a"); !ok || v.(int) != 3 {
+		t.Fatalf("got a %v, want 3", v)
+	}
+
+	// a value larger than the cache isn't kept
+	c.add("d", 5, 11)
+	if _, ok := c.get("d"); ok {
+		t.Fatal("d is kept above the max size")
+	}
+
+	want := CacheStats{Name: "test", Entries: 2, Size: 9, MaxSize: 10, Hits: 2, Misses: 2, Evictions: 1}
+	if got := c.stats(); got != want {
+		t.Fatalf("got stats %+v, want %+v", got, want)
+	}
+
+	c.setMaxSize(0)
+	if got := c.stats(); got.Entries != 0 || got.Size != 0 || got.Evictions != 3 {
+		t.Fatalf("got stats %+v after disabling", got)
+	}
+}
+
+func TestSizedCacheFill(t *testing.T) {
+	c := newSizedCache("test", 10)
+	gen := c.generation()
+	c.remove("a")
+	c.fill(gen, "a", 1, 1)
+	if _, ok := c.get("a"); ok {
+		t.Fatal("a value read before a removal is filled")
+	}
+
+	c.fill(c.generation(), "a", 2, 1)
+	if v, ok := c.get("a"); !ok || v.(int) != 2 {
+		t.Fatalf("got a %v, want 2", v)
+	}
+}
+
+func TestBlockCacheLookupFill(t *testing.T) {
+	block := &types.Block{BlockHeader: types.BlockHeader{Height: 1}}
+	hash := block.Hash()
+
+	var c blockCache
+	pruned := false
+	c = newBlockCache(DefaultBlockCacheSize, func(*bc.Hash) *types.Block {
+		// the block is pruned while the lookup reads it
+		if !pruned {
+			pruned = true
+			c.remove(&hash)
+		}
+		return block
+	})
+
+	if _, err := c.lookup(&hash); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.get(&hash); ok {
+		t.Fatal("a block read before a removal is cached")
+	}
+
+	if _, err := c.lookup(&hash); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.get(&hash); !ok {
+		t.Fatal("the block isn't cached")
+	}
+}
@@ -0,0 +1,183 @@
+package leveldb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/big"
+
+	"github.com/btm-stats/database"
+	"github.com/btm-stats/encoding/blockchain"
+	"github.com/btm-stats/encoding/bufpool"
+	"github.com/btm-stats/errors"
+	"github.com/btm-stats/protocol/bc"
+	"github.com/btm-stats/protocol/state"
+)
+
+// indexSnapshotChunk is the number of heights a chunk of the block index
+// snapshot covers, a chunk is written once the tip is a whole chunk above it
+const indexSnapshotChunk = 4096
+
+var (
+	indexSnapshotKey    = []byte("indexSnapshot")
+	indexSnapshotPrefix = []byte("IS:")
+)
+
+func calcIndexChunkKey(chunk uint64) []byte {
+	buf := [8]byte{}
+	binary.BigEndian.PutUint64(buf[:], chunk)
+	return append(append([]byte{}, indexSnapshotPrefix...), buf[:]...)
+}
+
+// indexSnapshotHeight returns the height below which the snapshot holds
+// every node of the block index
+func (s *Store) indexSnapshotHeight() uint64 {
+	data := s.db.Get(indexSnapshotKey)
+	if len(data) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(data)
+}
+
+func setIndexSnapshotHeight(batch database.Batch, height uint64) {
+	buf := [8]byte{}
+	binary.BigEndian.PutUint64(buf[:], height)
+	batch.Set(indexSnapshotKey, buf[:])
+}
+
+// encodeIndexChunk serializes the nodes as a count followed by the fields of
+// each node, the parent by its hash
+func encodeIndexChunk(nodes []*state.BlockNode) ([]byte, error) {
+	buf := bufpool.Get()
+	defer bufpool.Put(buf)
+
+	if _, err := blockchain.WriteVarint31(buf, uint64(len(nodes))); err != nil {
+		return nil, err
+	}
+	for _, node := range nodes {
+		parentHash := bc.Hash{}
+		if node.Parent != nil {
+			parentHash = node.Parent.Hash
+		}
+
+		seed := bc.Hash{}
+		if node.Seed != nil {
+			seed = *node.Seed
+		}
+
+		for _, hash := range []*bc.Hash{&node.Hash, &parentHash, &seed} {
+			if _, err := hash.WriteTo(buf); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := blockchain.WriteVarstr31(buf, node.WorkSum.Bytes()); err != nil {
+			return nil, err
+		}
+		for _, v := range []uint64{node.Version, node.Height, node.Timestamp, node.Nonce, node.Bits} {
+			if _, err := blockchain.WriteVarint63(buf, v); err != nil {
+				return nil, err
+			}
+		}
+		for _, hash := range []*bc.Hash{&node.TransactionsMerkleRoot, &node.TransactionStatusHash} {
+			if _, err := hash.WriteTo(buf); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return append([]byte(nil), buf.Bytes()...), nil
+}
+
+// decodeIndexChunk adds the nodes of the chunk to the index, the parents are
+// in the index already
+func decodeIndexChunk(data []byte, index *state.BlockIndex) error {
+	r := blockchain.NewReader(data)
+	n, err := blockchain.ReadVarint31(r)
+	if err != nil {
+		return err
+	}
+
+	for ; n > 0; n-- {
+		node := &state.BlockNode{Seed: &bc.Hash{}}
+		var parentHash bc.Hash
+		for _, hash := range []*bc.Hash{&node.Hash, &parentHash, node.Seed} {
+			if _, err := hash.ReadFrom(r); err != nil {
+				return err
+			}
+		}
+
+		workSum, err := blockchain.ReadVarstr31(r)
+		if err != nil {
+			return err
+		}
+		node.WorkSum = new(big.Int).SetBytes(workSum)
+
+		for _, v := range []*uint64{&node.Version, &node.Height, &node.Timestamp, &node.Nonce, &node.Bits} {
+			if *v, err = blockchain.ReadVarint63(r); err != nil {
+				return err
+			}
+		}
+		for _, hash := range []*bc.Hash{&node.TransactionsMerkleRoot, &node.TransactionStatusHash} {
+			if _, err := hash.ReadFrom(r); err != nil {
+				return err
+			}
+		}
+
+		if node.Height > 0 {
+			if node.Parent = index.GetNode(&parentHash); node.Parent == nil {
+				return fmt.Errorf("parent %s of block %s is missing", parentHash.String(), node.Hash.String())
+			}
+		}
+		index.AddNode(node)
+	}
+
+	if trailing := r.Len(); trailing > 0 {
+		return fmt.Errorf("trailing garbage (%d bytes)", trailing)
+	}
+	return nil
+}
+
+// loadIndexSnapshot rebuilds the block index from the snapshot, it returns
+// the height the headers replay starts from
+func (s *Store) loadIndexSnapshot() (*state.BlockIndex, uint64, error) {
+	blockIndex := state.NewBlockIndex()
+	height := s.indexSnapshotHeight()
+	for chunk := uint64(0); chunk < height/indexSnapshotChunk; chunk++ {
+		data := s.db.Get(calcIndexChunkKey(chunk))
+		if data == nil {
+			return nil, 0, fmt.Errorf("block index snapshot chunk %d is missing", chunk)
+		}
+		if err := decodeIndexChunk(data, blockIndex); err != nil {
+			return nil, 0, errors.Wrapf(err, "decode block index snapshot chunk %d", chunk)
+		}
+	}
+	return blockIndex, height, nil
+}
+
+// saveIndexSnapshot extends the snapshot by the next chunk once the tip is a
+// whole chunk above it, the side blocks rarely show up that deep
+func (s *Store) saveIndexSnapshot(batch database.Batch, node *state.BlockNode) error {
+	if s.index == nil {
+		return nil
+	}
+
+	height := s.indexSnapshotHeight()
+	if node.Height < height+2*indexSnapshotChunk {
+		return nil
+	}
+
+	data, err := encodeIndexChunk(s.index.NodesInHeights(height, height+indexSnapshotChunk))
+	if err != nil {
+		return err
+	}
+
+	batch.Set(calcIndexChunkKey(height/indexSnapshotChunk), data)
+	setIndexSnapshotHeight(batch, height+indexSnapshotChunk)
+	return nil
+}
+
+// invalidateIndexSnapshot shrinks the snapshot to the chunks below a newly
+// stored block, its chunk is replayed from the headers until written again
+func (s *Store) invalidateIndexSnapshot(batch database.Batch, height uint64) {
+	if height < s.indexSnapshotHeight() {
+		setIndexSnapshotHeight(batch, height-height%indexSnapshotChunk)
+	}
+}
@@ -0,0 +1,150 @@
+package leveldb
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/btm-stats/config"
+	"github.com/btm-stats/database"
+	"github.com/btm-stats/protocol/bc"
+	"github.com/btm-stats/protocol/state"
+)
+
+// newHeadersStore stores a chain of headers above the genesis, with a side
+// block every hundred heights, and returns the tip
+func newHeadersStore(tb testing.TB, height uint64) (*Store, bc.Hash) {
+	store, err := NewStore(database.NewMemDB())
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	header := config.GenesisBlock().BlockHeader
+	var parent *state.BlockNode
+	for {
+		node, err := state.NewBlockNode(&header, parent)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		if err := store.SaveBlockHeader(&header, nil); err != nil {
+			tb.Fatal(err)
+		}
+		if header.Height == height {
+			return store, node.Hash
+		}
+
+		if header.Height%100 == 50 {
+			side := header
+			side.Height++
+			side.PreviousBlockHash = node.Hash
+			side.Nonce++
+			if err := store.SaveBlockHeader(&side, nil); err != nil {
+				tb.Fatal(err)
+			}
+		}
+
+		parent = node
+		header.Height++
+		header.PreviousBlockHash = node.Hash
+		header.Timestamp++
+	}
+}
+
+// writeIndexSnapshot writes every chunk the tip allows
+func writeIndexSnapshot(tb testing.TB, store *Store, tip bc.Hash) {
+	index, err := store.LoadBlockIndex()
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	for {
+		height := store.indexSnapshotHeight()
+		batch := store.db.NewBatch()
+		if err := store.saveIndexSnapshot(batch, index.GetNode(&tip)); err != nil {
+			tb.Fatal(err)
+		}
+		if err := batch.Write(); err != nil {
+			tb.Fatal(err)
+		}
+		if store.indexSnapshotHeight() == height {
+			return
+		}
+	}
+}
+
+func compareNodes(t *testing.T, got, want *state.BlockNode) {
+	if got == nil || want == nil {
+		t.Fatalf("got node %v, want %v", got, want)
+	}
+	if got.Hash != want.Hash || got.Height != want.Height || got.WorkSum.Cmp(want.WorkSum) != 0 {
+		t.Fatalf("got node %d %s, want %d %s", got.Height, got.Hash.String(), want.Height, want.Hash.String())
+	}
+	if !reflect.DeepEqual(got.BlockHeader(), want.BlockHeader()) {
+		t.Fatalf("node %d rebuilds a different header", got.Height)
+	}
+	if (got.Parent == nil) != (want.Parent == nil) || (got.Parent != nil && got.Parent.Hash != want.Parent.Hash) {
+		t.Fatalf("node %d has a different parent", got.Height)
+	}
+}
+
+func TestIndexSnapshot(t *testing.T) {
+	height := uint64(3*indexSnapshotChunk + 10)
+	store, tip := newHeadersStore(t, height)
+	want, err := store.LoadBlockIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeIndexSnapshot(t, store, tip)
+	if got := store.indexSnapshotHeight(); got != 2*indexSnapshotChunk {
+		t.Fatalf("snapshot covers %d heights, want %d", got, 2*indexSnapshotChunk)
+	}
+
+	// a side block below the snapshot shrinks it back to the chunk holding it
+	sideParent := want.NodesInHeights(100, 101)[0]
+	side := *sideParent.BlockHeader()
+	side.Height++
+	side.PreviousBlockHash = sideParent.Hash
+	side.Nonce += 7
+	if err := store.SaveBlockHeader(&side, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := store.indexSnapshotHeight(); got != 0 {
+		t.Fatalf("snapshot covers %d heights after a side block at %d", got, side.Height)
+	}
+	if want, err = store.LoadBlockIndex(); err != nil {
+		t.Fatal(err)
+	}
+	writeIndexSnapshot(t, store, tip)
+
+	got, err := store.LoadBlockIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantNodes := want.NodesInHeights(0, height+1)
+	if gotNodes := got.NodesInHeights(0, height+1); len(gotNodes) != len(wantNodes) {
+		t.Fatalf("got %d nodes, want %d", len(gotNodes), len(wantNodes))
+	}
+	for _, node := range wantNodes {
+		compareNodes(t, got.GetNode(&node.Hash), node)
+	}
+}
+
+func BenchmarkLoadBlockIndex(b *testing.B) {
+	const height = 50000
+
+	for _, snapshot := range []bool{false, true} {
+		store, tip := newHeadersStore(b, height)
+		if snapshot {
+			writeIndexSnapshot(b, store, tip)
+		}
+
+		b.Run(fmt.Sprintf("snapshot=%v", snapshot), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := store.LoadBlockIndex(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package leveldb
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/btm-stats/database"
+	"github.com/btm-stats/database/storage"
+	"github.com/btm-stats/errors"
+	"github.com/btm-stats/protocol"
+	"github.com/btm-stats/protocol/bc"
+	"github.com/btm-stats/protocol/bc/types"
+)
+
+// storeKeys names the key prefixes and the single keys of the store, the
+// analytics records sharing the db are named by their literal prefixes
+var storeKeys = []struct {
+	prefix []byte
+	name   string
+}{
+	{blockPrefix, "block bodies"},
+	{blockHeaderPrefix, "block headers"},
+	{txStatusPrefix, "transaction statuses"},
+	{[]byte(utxoPreFix), "utxos"},
+	{undoPrefix, "undo records"},
+	{prunedBlockPrefix, "pruned blocks"},
+	{txIndexPrefix, "transaction index"},
+	{spentIndexPrefix, "spent output index"},
+	{indexSnapshotPrefix, "block index snapshot"},
+	{blockStoreKey, "chain status"},
+	{headersStoreKey, "headers mode mark"},
+	{reorgStateKey, "reorganization in progress"},
+	{storeSchemaKey, "store schema"},
+	{prunedHeightKey, "pruned height"},
+	{indexSnapshotKey, "block index snapshot height"},
+	{[]byte("AF:"), "analytics follower heights"},
+	{[]byte("RB:"), "rich list balances"},
+	{[]byte("RS:"), "rich list snapshots"},
+	{[]byte("RT:"), "rich list totals"},
+	{[]byte("RU:"), "rollup buckets"},
+	{[]byte("RP:"), "rollup active programs"},
+	{[]byte("RN:"), "rollup new assets"},
+	{[]byte("AE:"), "block anomalies"},
+}
+
+// PrefixStats sums the records under a key prefix of the store
+type PrefixStats struct {
+	Prefix     string `json:"prefix"`
+	Name       string `json:"name"`
+	Keys       int    `json:"keys"`
+	KeyBytes   int64  `json:"key_bytes"`
+	ValueBytes int64  `json:"value_bytes"`
+}
+
+// KeyStats walks the whole store and sums the records per known prefix, the
+// unknown keys are summed under an empty prefix
+func KeyStats(db database.Reader) ([]*PrefixStats, error) {
+	stats := make([]*PrefixStats, len(storeKeys)+1)
+	for i, key := range storeKeys {
+		stats[i] = &PrefixStats{Prefix: string(key.prefix), Name: key.name}
+	}
+	stats[len(storeKeys)] = &PrefixStats{Name: "unknown"}
+
+	iter := db.IteratorRange(nil, nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		stat := stats[keyPrefixIndex(iter.Key())]
+		stat.Keys++
+		stat.KeyBytes += int64(len(iter.Key()))
+		stat.ValueBytes += int64(len(iter.Value()))
+	}
+	return stats, iter.Error()
+}
+
+func keyPrefixIndex(key []byte) int {
+	for i, storeKey := range storeKeys {
+		if bytes.HasPrefix(key, storeKey.prefix) {
+			return i
+		}
+	}
+	return len(storeKeys)
+}
+
+// FormatKey renders the key as its known prefix followed by the hex of the
+// rest, the unknown keys are rendered in hex
+func FormatKey(key []byte) string {
+	i := keyPrefixIndex(key)
+	if i == len(storeKeys) {
+		return hex.EncodeToString(key)
+	}
+
+	prefix := storeKeys[i].prefix
+	return string(prefix) + hex.EncodeToString(key[len(prefix):])
+}
+
+// GetBlockHeader returns the stored header of the block, the header index is
+// keyed by height first so the lookup by hash walks the whole index
+func GetBlockHeader(db database.Reader, hash *bc.Hash) (*types.BlockHeader, error) {
+	iter := db.IteratorPrefix(blockHeaderPrefix)
+	defer iter.Release()
+
+	for iter.Next() {
+		if !bytes.HasSuffix(iter.Key(), hash.Bytes()) {
+			continue
+		}
+
+		header := &types.BlockHeader{}
+		if err := header.UnmarshalText(iter.Value()); err != nil {
+			return nil, errors.Wrap(err, "unmarshaling block header")
+		}
+		return header, nil
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	return nil, errors.New("can't find the block header by given hash")
+}
+
+// GetTransactionStatus returns the stored status of the block transactions
+func GetTransactionStatus(db database.Reader, hash *bc.Hash) (*bc.TransactionStatus, error) {
+	data := db.Get(calcTxStatusKey(hash))
+	if data == nil {
+		return nil, errors.New("can't find the transaction status by given hash")
+	}
+
+	ts := &bc.TransactionStatus{}
+	if err := proto.Unmarshal(data, ts); err != nil {
+		return nil, errors.Wrap(err, "unmarshaling transaction status")
+	}
+	return ts, nil
+}
+
+// GetUtxo returns the stored utxo entry of the output
+func GetUtxo(db database.Reader, hash *bc.Hash) (*storage.UtxoEntry, error) {
+	return getUtxo(db, hash)
+}
+
+// GetStoreStatus decodes the stored chain status, nil for an empty store
+func GetStoreStatus(db database.Reader) (*protocol.BlockStoreState, error) {
+	data := db.Get(blockStoreKey)
+	if data == nil {
+		return nil, nil
+	}
+
+	status := &protocol.BlockStoreState{}
+	if err := json.Unmarshal(data, status); err != nil {
+		return nil, errors.Wrap(err, "unmarshaling chain status")
+	}
+	return status, nil
+}
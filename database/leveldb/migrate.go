@@ -0,0 +1,153 @@
+package leveldb
+
+import (
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/btm-stats/database"
+	"github.com/btm-stats/errors"
+)
+
+const (
+	// schemaHexBlocks is the schema of the stores written before the schema
+	// key, the blocks are hex encoded
+	schemaHexBlocks = 0
+	// schemaBinaryBlocks stores the blocks in their binary serialization
+	schemaBinaryBlocks = 1
+
+	migrateBatchSize = 1000
+)
+
+var (
+	storeSchemaKey = []byte("storeSchema")
+
+	errNewerSchema = errors.New("store schema is newer than supported")
+)
+
+// A migration upgrades the store from the schema right before its own. It
+// must resume where an interrupted run stopped, and only count the records
+// it would rewrite on a dry run.
+type migration struct {
+	schema      int
+	description string
+	migrate     func(db database.DB, dryRun bool) (int, error)
+}
+
+// migrations is the ordered list of the upgrade steps, a change of the
+// stored format appends its step here
+var migrations = []migration{
+	{schema: schemaBinaryBlocks, description: "encode the stored blocks in binary", migrate: migrateBinaryBlocks},
+}
+
+// MigrationStep describes an upgrade step of the store schema
+type MigrationStep struct {
+	Schema      int    `json:"schema"`
+	Description string `json:"description"`
+	Records     int    `json:"records"`
+}
+
+// CurrentSchema returns the schema written by this version
+func CurrentSchema() int {
+	return migrations[len(migrations)-1].schema
+}
+
+// StoreSchema returns the schema of the store, the stores predating the
+// schema key hold hex blocks
+func StoreSchema(db database.DB) (int, error) {
+	data := db.Get(storeSchemaKey)
+	if data == nil {
+		return schemaHexBlocks, nil
+	}
+
+	schema, err := strconv.Atoi(string(data))
+	if err != nil {
+		return 0, errors.Wrap(err, "parse store schema")
+	}
+	return schema, nil
+}
+
+// MigrateStore runs the steps upgrading the store to the current schema, the
+// schema moves after each step so an interrupted run resumes on the step it
+// stopped in. A dry run only reports the steps and the records they rewrite.
+func MigrateStore(db database.DB, dryRun bool) ([]MigrationStep, error) {
+	schema, err := StoreSchema(db)
+	if err != nil {
+		return nil, err
+	}
+	if schema > CurrentSchema() {
+		return nil, errors.WithDetailf(errNewerSchema, "store schema %d, supported schema %d", schema, CurrentSchema())
+	}
+
+	steps := []MigrationStep{}
+	for _, m := range migrations {
+		if m.schema <= schema {
+			continue
+		}
+
+		log.WithFields(log.Fields{"schema": m.schema, "step": m.description, "dry_run": dryRun}).Info("start to migrate the store")
+		count, err := m.migrate(db, dryRun)
+		if err != nil {
+			return nil, errors.Wrapf(err, "migrate store to schema %d", m.schema)
+		}
+
+		steps = append(steps, MigrationStep{Schema: m.schema, Description: m.description, Records: count})
+		if dryRun {
+			continue
+		}
+
+		db.SetSync(storeSchemaKey, []byte(strconv.Itoa(m.schema)))
+		log.WithFields(log.Fields{"schema": m.schema, "records": count}).Info("migrated the store")
+	}
+	return steps, nil
+}
+
+// migrateProgress logs the progress of a long migration step
+func migrateProgress(schema int, count int) {
+	log.WithFields(log.Fields{"schema": schema, "records": count}).Info("migrating the store")
+}
+
+// migrateBinaryBlocks rewrites the hex blocks in binary, the blocks already
+// rewritten by an interrupted run are skipped
+func migrateBinaryBlocks(db database.DB, dryRun bool) (int, error) {
+	iter := db.IteratorPrefix(blockPrefix)
+	defer iter.Release()
+
+	count := 0
+	batch := db.NewBatch()
+	pending := 0
+	for iter.Next() {
+		if !isHexBlock(iter.Value()) {
+			continue
+		}
+		if dryRun {
+			count++
+			continue
+		}
+
+		block, err := decodeBlock(iter.Value())
+		if err != nil {
+			return count, errors.Wrapf(err, "decode block %x", iter.Key()[len(blockPrefix):])
+		}
+
+		data, err := block.MarshalBinary()
+		if err != nil {
+			return count, err
+		}
+
+		batch.Set(append([]byte{}, iter.Key()...), data)
+		if pending++; pending == migrateBatchSize {
+			if err := batch.Write(); err != nil {
+				return count, err
+			}
+			count += pending
+			batch, pending = db.NewBatch(), 0
+			migrateProgress(schemaBinaryBlocks, count)
+		}
+	}
+
+	if err := batch.Write(); err != nil {
+		return count, err
+	}
+	return count + pending, nil
+}
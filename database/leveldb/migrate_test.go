@@ -0,0 +1,166 @@
+package leveldb
+
+import (
+	"bytes"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/btm-stats/database"
+	"github.com/btm-stats/errors"
+	"github.com/btm-stats/protocol/bc/types"
+)
+
+func newMigrateTestBlocks(n int) []*types.Block {
+	blocks := []*types.Block{}
+	for i := 0; i < n; i++ {
+		blocks = append(blocks, &types.Block{BlockHeader: types.BlockHeader{Version: 1, Height: uint64(i), Timestamp: 1528945000 + uint64(i)*150, Nonce: uint64(i)}})
+	}
+	return blocks
+}
+
+func TestIsHexBlock(t *testing.T) {
+	block := newMigrateTestBlocks(1)[0]
+	hexData, err := block.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	binaryData, err := block.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		data []byte
+		want bool
+	}{
+		{data: hexData, want: true},
+		{data: binaryData, want: false},
+		{data: nil, want: false},
+	}
+	for i, c := range cases {
+		if got := isHexBlock(c.data); got != c.want {
+			t.Errorf("case %d: got hex %v, want %v", i, got, c.want)
+		}
+	}
+
+	for _, data := range [][]byte{hexData, binaryData} {
+		got, err := decodeBlock(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Hash() != block.Hash() {
+			t.Errorf("got block %x, want %x", got.Hash().Bytes(), block.Hash().Bytes())
+		}
+	}
+}
+
+func TestMigrateBinaryBlocks(t *testing.T) {
+	db := database.NewMemDB()
+	blocks := newMigrateTestBlocks(5)
+	stored := map[string][]byte{}
+	for i, block := range blocks {
+		// the last block was rewritten by an interrupted run
+		marshal := block.MarshalText
+		if i == len(blocks)-1 {
+			marshal = block.MarshalBinary
+		}
+		data, err := marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		hash := block.Hash()
+		db.Set(calcBlockKey(&hash), data)
+		stored[string(calcBlockKey(&hash))] = data
+	}
+
+	count, err := migrateBinaryBlocks(db, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != len(blocks)-1 {
+		t.Errorf("dry run got %d blocks, want %d", count, len(blocks)-1)
+	}
+	for key, data := range stored {
+		if !bytes.Equal(db.Get([]byte(key)), data) {
+			t.Fatalf("dry run rewrote block %x", key)
+		}
+	}
+
+	if count, err = migrateBinaryBlocks(db, false); err != nil {
+		t.Fatal(err)
+	}
+	if count != len(blocks)-1 {
+		t.Errorf("got %d migrated blocks, want %d", count, len(blocks)-1)
+	}
+	for _, block := range blocks {
+		hash := block.Hash()
+		data := db.Get(calcBlockKey(&hash))
+		if isHexBlock(data) {
+			t.Errorf("block %d is still hex", block.Height)
+		}
+		if got := GetBlock(db, &hash); got == nil || got.Hash() != hash {
+			t.Errorf("block %d isn't readable after the migration", block.Height)
+		}
+	}
+
+	// a rerun has nothing left to rewrite
+	if count, err = migrateBinaryBlocks(db, false); err != nil || count != 0 {
+		t.Errorf("rerun got %d migrated blocks err = %v, want none", count, err)
+	}
+}
+
+func TestMigrateStore(t *testing.T) {
+	db := database.NewMemDB()
+	blocks := newMigrateTestBlocks(3)
+	for _, block := range blocks {
+		data, err := block.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		hash := block.Hash()
+		db.Set(calcBlockKey(&hash), data)
+	}
+	before := dumpPrefix(db, []byte{})
+
+	steps, err := MigrateStore(db, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != len(migrations) || steps[0].Schema != schemaBinaryBlocks || steps[0].Records != len(blocks) {
+		t.Errorf("dry run got steps %+v, want %d steps rewriting %d blocks first", steps, len(migrations), len(blocks))
+	}
+	if after := dumpPrefix(db, []byte{}); !reflect.DeepEqual(after, before) {
+		t.Fatal("dry run wrote to the store")
+	}
+	if schema, err := StoreSchema(db); err != nil || schema != schemaHexBlocks {
+		t.Fatalf("dry run got schema %d err = %v, want %d", schema, err, schemaHexBlocks)
+	}
+
+	if steps, err = MigrateStore(db, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != len(migrations) || steps[0].Records != len(blocks) {
+		t.Errorf("got steps %+v, want %d steps rewriting %d blocks first", steps, len(migrations), len(blocks))
+	}
+	if schema, err := StoreSchema(db); err != nil || schema != CurrentSchema() {
+		t.Fatalf("got schema %d err = %v, want %d", schema, err, CurrentSchema())
+	}
+	for _, block := range blocks {
+		hash := block.Hash()
+		if isHexBlock(db.Get(calcBlockKey(&hash))) {
+			t.Errorf("block %d is still hex", block.Height)
+		}
+	}
+
+	// a migrated store has no step left
+	if steps, err = MigrateStore(db, false); err != nil || len(steps) != 0 {
+		t.Errorf("rerun got steps %+v err = %v, want none", steps, err)
+	}
+
+	db.Set(storeSchemaKey, []byte(strconv.Itoa(CurrentSchema()+1)))
+	if _, err := MigrateStore(db, false); errors.Root(err) != errNewerSchema {
+		t.Errorf("got err %v on a newer schema, want %v", err, errNewerSchema)
+	}
+}
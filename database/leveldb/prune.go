@@ -0,0 +1,128 @@
+package leveldb
+
+import (
+	"encoding/binary"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/btm-stats/database"
+	"github.com/btm-stats/errors"
+	"github.com/btm-stats/protocol"
+	"github.com/btm-stats/protocol/bc"
+	"github.com/btm-stats/protocol/state"
+)
+
+// maxPruneHeights bounds the heights pruned by a single chain status write,
+// enabling the pruning on a long chain catches up over the next blocks
+const maxPruneHeights = 100
+
+var (
+	prunedHeightKey   = []byte("prunedHeight")
+	prunedBlockPrefix = []byte("PB:")
+)
+
+// A Deriver derives per-block data like stats and indexes from the block
+// bodies, the pruning keeps the bodies above its derived height
+type Deriver interface {
+	DerivedHeight() uint64
+}
+
+func calcPrunedBlockKey(hash *bc.Hash) []byte {
+	return append(prunedBlockPrefix, hash.Bytes()...)
+}
+
+// SetPruneDepth sets the number of blocks below the tip whose bodies are
+// kept, zero keeps every body
+func (s *Store) SetPruneDepth(depth uint64) {
+	s.pruneDepth = depth
+}
+
+// AddDeriver holds the pruning back until the deriver is done with the bodies
+func (s *Store) AddDeriver(deriver Deriver) {
+	s.derivers = append(s.derivers, deriver)
+}
+
+// PrunedHeight returns the height up to which the block bodies are pruned,
+// the genesis block is never pruned
+func (s *Store) PrunedHeight() uint64 {
+	data := s.db.Get(prunedHeightKey)
+	if len(data) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(data)
+}
+
+// prunedBlockError tells a pruned body apart from a missing one
+func (s *Store) prunedBlockError(hash *bc.Hash, err error) error {
+	if !s.db.Has(calcPrunedBlockKey(hash)) {
+		return err
+	}
+	return errors.WithDetailf(protocol.ErrBlockPruned, "block %s", hash.String())
+}
+
+// pruneLimit returns the highest height whose bodies may be pruned along the
+// new tip
+func (s *Store) pruneLimit(node *state.BlockNode) uint64 {
+	if s.pruneDepth == 0 || node.Height <= s.pruneDepth {
+		return 0
+	}
+
+	limit := node.Height - s.pruneDepth
+	for _, deriver := range s.derivers {
+		if derived := deriver.DerivedHeight(); derived < limit {
+			limit = derived
+		}
+	}
+	return limit
+}
+
+// pruneBlocks deletes the bodies and the transaction statuses of the blocks
+// sinking below the prune depth, side chain blocks included. The headers stay
+// for the block index and a marker tells the pruned blocks apart. It returns
+// the pruned hashes, their cached bodies are dropped once the batch is written.
+func (s *Store) pruneBlocks(batch database.Batch, node *state.BlockNode) []bc.Hash {
+	limit := s.pruneLimit(node)
+	from := s.PrunedHeight() + 1
+	if limit < from {
+		return nil
+	}
+	if limit >= from+maxPruneHeights {
+		limit = from + maxPruneHeights - 1
+	}
+
+	pruned := []bc.Hash{}
+	for height := from; height <= limit; height++ {
+		for _, hash := range s.blockHashesAtHeight(height) {
+			batch.Delete(calcBlockKey(&hash))
+			batch.Delete(calcTxStatusKey(&hash))
+			batch.Set(calcPrunedBlockKey(&hash), []byte{})
+			pruned = append(pruned, hash)
+		}
+	}
+
+	buf := [8]byte{}
+	binary.BigEndian.PutUint64(buf[:], limit)
+	batch.Set(prunedHeightKey, buf[:])
+	log.WithFields(log.Fields{"from": from, "to": limit, "blocks": len(pruned)}).Debug("prune block bodies")
+	return pruned
+}
+
+// blockHashesAtHeight returns the hashes of the stored headers at the height
+func (s *Store) blockHashesAtHeight(height uint64) []bc.Hash {
+	prefix := calcBlockHeaderHeightKey(height)
+	iter := s.db.IteratorPrefix(prefix)
+	defer iter.Release()
+
+	hashes := []bc.Hash{}
+	for iter.Next() {
+		key := iter.Key()
+		if len(key) != len(prefix)+32 {
+			continue
+		}
+
+		var hashBytes [32]byte
+		copy(hashBytes[:], key[len(prefix):])
+		hashes = append(hashes, bc.NewHash(hashBytes))
+	}
+	return hashes
+}
@@ -0,0 +1,93 @@
+package leveldb
+
+import (
+	"testing"
+
+	"github.com/btm-stats/database"
+	"github.com/btm-stats/errors"
+	"github.com/btm-stats/protocol"
+	"github.com/btm-stats/protocol/bc"
+	"github.com/btm-stats/protocol/state"
+)
+
+type testDeriver uint64
+
+func (d testDeriver) DerivedHeight() uint64 {
+	return uint64(d)
+}
+
+func TestPruneBlocks(t *testing.T) {
+	store, err := NewStore(database.NewMemDB())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocks := newMigrateTestBlocks(9)
+	hashes := []bc.Hash{}
+	for _, block := range blocks {
+		if err := store.SaveBlock(block, bc.NewTransactionStatus()); err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, block.Hash())
+	}
+	// the cached body is pruned along the stored one
+	if _, err := store.GetBlock(&hashes[1]); err != nil {
+		t.Fatal(err)
+	}
+	store.SetPruneDepth(3)
+
+	cases := []struct {
+		desc       string
+		tip        uint64
+		deriver    uint64
+		wantPruned uint64
+	}{
+		{desc: "the chain isn't deep enough yet", tip: 3, wantPruned: 0},
+		{desc: "prune below the depth", tip: 6, wantPruned: 3},
+		{desc: "the deriver holds the pruning back", tip: 8, deriver: 4, wantPruned: 4},
+	}
+
+	for _, c := range cases {
+		if c.deriver != 0 {
+			store.AddDeriver(testDeriver(c.deriver))
+		}
+
+		batch := store.db.NewBatch()
+		pruned := store.pruneBlocks(batch, &state.BlockNode{Height: c.tip, Hash: hashes[c.tip]})
+		// the bodies are still served until the batch lands
+		for i := range pruned {
+			if _, err := store.GetBlock(&pruned[i]); err != nil {
+				t.Fatalf("%s: got err %v before the prune is written", c.desc, err)
+			}
+		}
+		if err := batch.Write(); err != nil {
+			t.Fatal(err)
+		}
+		for i := range pruned {
+			store.cache.remove(&pruned[i])
+		}
+
+		if got := store.PrunedHeight(); got != c.wantPruned {
+			t.Errorf("%s: got pruned height %d, want %d", c.desc, got, c.wantPruned)
+		}
+		for height, hash := range hashes {
+			block, err := store.GetBlock(&hash)
+			if pruned := height != 0 && uint64(height) <= c.wantPruned; pruned {
+				if errors.Root(err) != protocol.ErrBlockPruned {
+					t.Errorf("%s: height %d got err %v, want %v", c.desc, height, err, protocol.ErrBlockPruned)
+				}
+			} else if err != nil || block.Hash() != hash {
+				t.Errorf("%s: height %d got err %v, want the block", c.desc, height, err)
+			}
+
+			if header, err := store.GetBlockHeader(&hash); err != nil || header.Hash() != hash {
+				t.Errorf("%s: height %d got header err %v, want the header", c.desc, height, err)
+			}
+		}
+	}
+
+	// a missing block isn't taken for a pruned one
+	if _, err := store.GetBlock(&bc.Hash{V0: 1}); err == nil || errors.Root(err) == protocol.ErrBlockPruned {
+		t.Errorf("got err %v for a missing block, want a not found err", err)
+	}
+}
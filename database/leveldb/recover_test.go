@@ -0,0 +1,181 @@
+package leveldb
+
+import (
+	"testing"
+
+	"github.com/btm-stats/chaingen"
+	"github.com/btm-stats/consensus"
+	"github.com/btm-stats/database"
+	"github.com/btm-stats/errors"
+	"github.com/btm-stats/protocol"
+	"github.com/btm-stats/protocol/bc/types"
+	"github.com/btm-stats/protocol/state"
+)
+
+var errCrashed = errors.New("store crashed")
+
+// crashStore stops committing the chain statuses once crashed, like a node
+// killed between the detach and the attach of a reorganization
+type crashStore struct {
+	*Store
+	crashed bool
+}
+
+func (s *crashStore) SaveChainStatus(node *state.BlockNode, view *state.UtxoViewpoint) error {
+	if s.crashed {
+		return errCrashed
+	}
+	return s.Store.SaveChainStatus(node, view)
+}
+
+// newReorgCrashStore connects a main chain up to height 5, then crashes the
+// reorganization to a fork of height 6 from height 2 once the main chain is
+// detached. It returns the main and the fork blocks above the genesis.
+func newReorgCrashStore(t *testing.T) (*Store, []*types.Block, []*types.Block) {
+	gen := chaingen.New(chaingen.DefaultConfig())
+	mainBlocks, err := gen.Main().Generate(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fork, err := gen.Main().Fork(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forkBlocks, err := fork.Generate(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewStore(database.NewMemDB())
+	if err != nil {
+		t.Fatal(err)
+	}
+	crash := &crashStore{Store: store}
+	chain, err := protocol.NewChain(crash, protocol.NewTxPool())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range mainBlocks {
+		if _, err := chain.ProcessBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	crash.crashed = true
+	for _, block := range forkBlocks[:3] {
+		if _, err := chain.ProcessBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := chain.ProcessBlock(forkBlocks[3]); errors.Root(err) != errCrashed {
+		t.Fatalf("got err %v on the reorganization, want %v", err, errCrashed)
+	}
+
+	// the detach is committed along the reorganization state
+	forkPoint, forkTip, mainTip := mainBlocks[1].Hash(), forkBlocks[3].Hash(), mainBlocks[4].Hash()
+	reorg := store.GetReorgState()
+	if status := store.GetStoreStatus(); *status.Hash != forkPoint {
+		t.Fatalf("got stored tip at height %d, want the fork point", status.Height)
+	}
+	if reorg == nil || *reorg.Target != forkTip || *reorg.Origin != mainTip {
+		t.Fatalf("got reorganization state %v, want the fork tip from the main tip", reorg)
+	}
+	return store, mainBlocks, forkBlocks
+}
+
+func TestRecoverInterruptedReorg(t *testing.T) {
+	defer func(params consensus.Params) { consensus.ActiveNetParams = params }(consensus.ActiveNetParams)
+	consensus.ActiveNetParams = consensus.SoloNetParams
+
+	cases := []struct {
+		desc    string
+		corrupt func(store *Store, forkBlocks []*types.Block)
+		// wantMain tells the chain goes back to the main tip
+		wantMain bool
+	}{
+		{
+			desc:    "roll forward to the target",
+			corrupt: func(*Store, []*types.Block) {},
+		},
+		{
+			desc: "roll back to the origin when the target can't be attached",
+			corrupt: func(store *Store, forkBlocks []*types.Block) {
+				hash := forkBlocks[3].Hash()
+				store.db.Delete(calcBlockKey(&hash))
+			},
+			wantMain: true,
+		},
+	}
+
+	for _, c := range cases {
+		store, mainBlocks, forkBlocks := newReorgCrashStore(t)
+		c.corrupt(store, forkBlocks)
+
+		restarted, err := NewStore(store.db)
+		if err != nil {
+			t.Fatalf("%s: %v", c.desc, err)
+		}
+		chain, err := protocol.NewChain(restarted, protocol.NewTxPool())
+		if err != nil {
+			t.Fatalf("%s: %v", c.desc, err)
+		}
+
+		want := forkBlocks[3]
+		if c.wantMain {
+			want = mainBlocks[4]
+		}
+		if hash := want.Hash(); *chain.BestBlockHash() != hash || *restarted.GetStoreStatus().Hash != hash {
+			t.Errorf("%s: got best block at height %d, want %x at height %d", c.desc, chain.BestBlockHeight(), hash.Bytes(), want.Height)
+		}
+		if reorg := restarted.GetReorgState(); reorg != nil {
+			t.Errorf("%s: got reorganization state %v after the recovery", c.desc, reorg)
+		}
+
+		report, err := restarted.Verify()
+		if err != nil {
+			t.Fatalf("%s: %v", c.desc, err)
+		}
+		if report.Err != nil || report.BestHeight != want.Height {
+			t.Errorf("%s: got err %v best height %d, want no err at %d", c.desc, report.Err, report.BestHeight, want.Height)
+		}
+	}
+}
+
+func TestRepairTipThenRestart(t *testing.T) {
+	defer func(params consensus.Params) { consensus.ActiveNetParams = params }(consensus.ActiveNetParams)
+	consensus.ActiveNetParams = consensus.SoloNetParams
+
+	store, mainBlocks, _ := newReorgCrashStore(t)
+	report, err := store.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RepairTip(report); err != nil {
+		t.Fatal(err)
+	}
+	if reorg := store.GetReorgState(); reorg != nil {
+		t.Fatalf("got reorganization state %v after the repair", reorg)
+	}
+
+	// the restarted chain stays on the repaired tip instead of resuming the
+	// reorganization
+	restarted, err := NewStore(store.db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := protocol.NewChain(restarted, protocol.NewTxPool())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := mainBlocks[1].Hash(); *chain.BestBlockHash() != want {
+		t.Fatalf("got best block at height %d, want the repaired tip at height %d", chain.BestBlockHeight(), mainBlocks[1].Height)
+	}
+
+	report, err = restarted.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Err != nil || report.StoreHeight != mainBlocks[1].Height {
+		t.Errorf("got err %v store height %d after the restart, want no err at %d", report.Err, report.StoreHeight, mainBlocks[1].Height)
+	}
+}
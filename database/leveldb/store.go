@@ -1,13 +1,20 @@
 package leveldb
 
 import (
-	dbm "github.com/tendermint/tmlibs/db"
-	"github.com/btm-stats/protocol/bc"
-	"github.com/btm-stats/protocol/bc/types"
+	"encoding/binary"
+	"encoding/json"
 
+	"github.com/golang/protobuf/proto"
+	log "github.com/sirupsen/logrus"
+	"github.com/tendermint/tmlibs/common"
+
+	"github.com/btm-stats/database"
 	"github.com/btm-stats/database/storage"
+	"github.com/btm-stats/errors"
+	"github.com/btm-stats/protocol"
+	"github.com/btm-stats/protocol/bc"
+	"github.com/btm-stats/protocol/bc/types"
 	"github.com/btm-stats/protocol/state"
-	"encoding/json"
 )
 
 var (
@@ -15,42 +22,126 @@ var (
 	blockPrefix       = []byte("B:")
 	blockHeaderPrefix = []byte("BH:")
 	txStatusPrefix    = []byte("BTS:")
+	// headersStoreKey marks a store of the headers mode, its headers have
+	// no body
+	headersStoreKey = []byte("headersStore")
+	// reorgStateKey holds the reorganization a crash may interrupt between
+	// the detach and the attach of the blocks
+	reorgStateKey = []byte("reorgState")
 )
 
 // A Store encapsulates storage for blockchain validation.
 // It satisfies the interface protocol.Store, and provides additional
 // methods for querying current data.
 type Store struct {
-	db    dbm.DB
-	cache blockCache
+	db          database.DB
+	cache       blockCache
+	headerCache *sizedCache
+	utxoCache   *sizedCache
+	undoDepth   uint64
+	pruneDepth  uint64
+	derivers    []Deriver
+	txIndex     bool
+	spentIndex  bool
+
+	// index is the loaded block index, the snapshot chunks are written from it
+	index *state.BlockIndex
+}
+
+func loadBlockStoreStateJSON(db database.DB) *protocol.BlockStoreState {
+	bytes := db.Get(blockStoreKey)
+	if bytes == nil {
+		return nil
+	}
+	bsj := &protocol.BlockStoreState{}
+	if err := json.Unmarshal(bytes, bsj); err != nil {
+		common.PanicCrisis(common.Fmt("Could not unmarshal bytes: %X", bytes))
+	}
+	return bsj
 }
 
 func calcBlockKey(hash *bc.Hash) []byte {
 	return append(blockPrefix, hash.Bytes()...)
 }
 
+// the big endian height keeps the header index sorted by height
+func calcBlockHeaderKey(height uint64, hash *bc.Hash) []byte {
+	return append(calcBlockHeaderHeightKey(height), hash.Bytes()...)
+}
+
+// calcBlockHeaderHeightKey is the prefix of the headers at the height
+func calcBlockHeaderHeightKey(height uint64) []byte {
+	buf := [8]byte{}
+	binary.BigEndian.PutUint64(buf[:], height)
+	return append(append([]byte{}, blockHeaderPrefix...), buf[:]...)
+}
+
+func calcTxStatusKey(hash *bc.Hash) []byte {
+	return append(txStatusPrefix, hash.Bytes()...)
+}
+
+// isHexBlock tells the hex blocks of the stores not migrated yet apart, a
+// block starts with its serialization flags so the binary form never begins
+// with the '0' character
+func isHexBlock(data []byte) bool {
+	return len(data) > 0 && data[0] == '0'
+}
+
+// decodeBlock parses a stored block, binary or hex
+func decodeBlock(data []byte) (*types.Block, error) {
+	block := &types.Block{}
+	if isHexBlock(data) {
+		return block, block.UnmarshalText(data)
+	}
+	return block, block.UnmarshalBinary(data)
+}
+
 // GetBlock return the block by given hash
-func GetBlock(db dbm.DB, hash *bc.Hash) *types.Block {
+func GetBlock(db database.Reader, hash *bc.Hash) *types.Block {
 	bytez := db.Get(calcBlockKey(hash))
 	if bytez == nil {
 		return nil
 	}
 
-	block := &types.Block{}
-	block.UnmarshalText(bytez)
+	block, err := decodeBlock(bytez)
+	if err != nil {
+		log.WithFields(log.Fields{"hash": hash.String(), "err": err}).Error("fail on decode stored block")
+		return nil
+	}
 	return block
 }
 
 
-// NewStore creates and returns a new Store object.
-func NewStore(db dbm.DB) *Store {
-	cache := newBlockCache(func(hash *bc.Hash) *types.Block {
+// NewStore creates and returns a new Store object, the store is first
+// migrated to the current schema.
+func NewStore(db database.DB) (*Store, error) {
+	if _, err := MigrateStore(db, false); err != nil {
+		return nil, err
+	}
+
+	cache := newBlockCache(DefaultBlockCacheSize, func(hash *bc.Hash) *types.Block {
 		return GetBlock(db, hash)
 	})
 	return &Store{
-		db:    db,
-		cache: cache,
-	}
+		db:          db,
+		cache:       cache,
+		headerCache: newSizedCache("header", DefaultHeaderCacheSize),
+		utxoCache:   newSizedCache("utxo", DefaultUtxoCacheSize),
+		undoDepth:   DefaultUndoDepth,
+	}, nil
+}
+
+// SetCacheSizes bounds the block, header and utxo caches in bytes, a zero
+// size disables the cache
+func (s *Store) SetCacheSizes(block, header, utxo int64) {
+	s.cache.cache.setMaxSize(block)
+	s.headerCache.setMaxSize(header)
+	s.utxoCache.setMaxSize(utxo)
+}
+
+// CacheStats returns the usage of the block, header and utxo caches
+func (s *Store) CacheStats() []CacheStats {
+	return []CacheStats{s.cache.cache.stats(), s.headerCache.stats(), s.utxoCache.stats()}
 }
 
 // GetUtxo will search the utxo in db
@@ -64,28 +155,65 @@ func (s *Store) BlockExist(hash *bc.Hash) bool {
 	return err == nil && block != nil
 }
 
-// GetBlock return the block by given hash
+// GetBlock return the block by given hash, the pruned blocks fail on
+// protocol.ErrBlockPruned
 func (s *Store) GetBlock(hash *bc.Hash) (*types.Block, error) {
-	return s.cache.lookup(hash)
+	block, err := s.cache.lookup(hash)
+	if err != nil {
+		return nil, s.prunedBlockError(hash, err)
+	}
+	return block, nil
+}
+
+// GetBlockHeader return the block header by given hash, the headers of the
+// pruned blocks included
+func (s *Store) GetBlockHeader(hash *bc.Hash) (*types.BlockHeader, error) {
+	if header, ok := s.headerCache.get(*hash); ok {
+		return header.(*types.BlockHeader), nil
+	}
+	if block, ok := s.cache.get(hash); ok {
+		s.headerCache.add(*hash, &block.BlockHeader, blockHeaderSize)
+		return &block.BlockHeader, nil
+	}
+
+	// the index locates the header key, the headers of a store without a
+	// loaded index are scanned for
+	var header *types.BlockHeader
+	if node := s.indexNode(hash); node != nil {
+		data := s.db.Get(calcBlockHeaderKey(node.Height, hash))
+		if data == nil {
+			return nil, errors.New("can't find the block header by given hash")
+		}
+		header = &types.BlockHeader{}
+		if err := header.UnmarshalText(data); err != nil {
+			return nil, errors.Wrap(err, "unmarshaling block header")
+		}
+	} else {
+		var err error
+		if header, err = GetBlockHeader(s.db, hash); err != nil {
+			return nil, err
+		}
+	}
+
+	s.headerCache.add(*hash, header, blockHeaderSize)
+	return header, nil
+}
+
+func (s *Store) indexNode(hash *bc.Hash) *state.BlockNode {
+	if s.index == nil {
+		return nil
+	}
+	return s.index.GetNode(hash)
 }
 
 // GetTransactionsUtxo will return all the utxo that related to the input txs
 func (s *Store) GetTransactionsUtxo(view *state.UtxoViewpoint, txs []*bc.Tx) error {
-	return getTransactionsUtxo(s.db, view, txs)
+	return getTransactionsUtxo(s.db, s.utxoCache, view, txs)
 }
 
 // GetTransactionStatus will return the utxo that related to the block hash
 func (s *Store) GetTransactionStatus(hash *bc.Hash) (*bc.TransactionStatus, error) {
-	data := s.db.Get(calcTxStatusKey(hash))
-	if data == nil {
-		return nil, errors.New("can't find the transaction status by given hash")
-	}
-
-	ts := &bc.TransactionStatus{}
-	if err := proto.Unmarshal(data, ts); err != nil {
-		return nil, errors.Wrap(err, "unmarshaling transaction status")
-	}
-	return ts, nil
+	return GetTransactionStatus(s.db, hash)
 }
 
 // GetStoreStatus return the BlockStoreStateJSON
@@ -93,9 +221,31 @@ func (s *Store) GetStoreStatus() *protocol.BlockStoreState {
 	return loadBlockStoreStateJSON(s.db)
 }
 
+// GetReorgState returns the reorganization a crash interrupted, nil when the
+// chain status isn't in the middle of one
+func (s *Store) GetReorgState() *protocol.ReorgState {
+	bytes := s.db.Get(reorgStateKey)
+	if bytes == nil {
+		return nil
+	}
+	reorg := &protocol.ReorgState{}
+	if err := json.Unmarshal(bytes, reorg); err != nil {
+		common.PanicCrisis(common.Fmt("Could not unmarshal bytes: %X", bytes))
+	}
+	return reorg
+}
+
+// LoadBlockIndex rebuilds the block index from its snapshot, the headers
+// above the snapshot are replayed. A broken snapshot falls back on replaying
+// every header.
 func (s *Store) LoadBlockIndex() (*state.BlockIndex, error) {
-	blockIndex := state.NewBlockIndex()
-	bhIter := s.db.IteratorPrefix(blockHeaderPrefix)
+	blockIndex, height, err := s.loadIndexSnapshot()
+	if err != nil {
+		log.WithField("err", err).Warning("fail on load the block index snapshot, replay every block header")
+		blockIndex, height = state.NewBlockIndex(), 0
+	}
+
+	bhIter := s.db.IteratorRange(calcBlockHeaderHeightKey(height), database.PrefixLimit(blockHeaderPrefix))
 	defer bhIter.Release()
 
 	var lastNode *state.BlockNode
@@ -106,7 +256,7 @@ func (s *Store) LoadBlockIndex() (*state.BlockIndex, error) {
 		}
 
 		var parent *state.BlockNode
-		if lastNode == nil || lastNode.Hash == bh.PreviousBlockHash {
+		if lastNode != nil && lastNode.Hash == bh.PreviousBlockHash {
 			parent = lastNode
 		} else {
 			parent = blockIndex.GetNode(&bh.PreviousBlockHash)
@@ -121,12 +271,12 @@ func (s *Store) LoadBlockIndex() (*state.BlockIndex, error) {
 		lastNode = node
 	}
 
+	s.index = blockIndex
 	return blockIndex, nil
 }
 
-// SaveBlock persists a new block in the protocol.
-func (s *Store) SaveBlock(block *types.Block, ts *bc.TransactionStatus) error {
-	binaryBlock, err := block.MarshalText()
+func saveBlock(batch database.Batch, block *types.Block, ts *bc.TransactionStatus) error {
+	binaryBlock, err := block.MarshalBinary()
 	if err != nil {
 		return errors.Wrap(err, "Marshal block meta")
 	}
@@ -142,29 +292,159 @@ func (s *Store) SaveBlock(block *types.Block, ts *bc.TransactionStatus) error {
 	}
 
 	blockHash := block.Hash()
-	batch := s.db.NewBatch()
 	batch.Set(calcBlockKey(&blockHash), binaryBlock)
 	batch.Set(calcBlockHeaderKey(block.Height, &blockHash), binaryBlockHeader)
 	batch.Set(calcTxStatusKey(&blockHash), binaryTxStatus)
-	batch.Write()
+	return nil
+}
+
+// saveChainStatus adds the status to the batch, a nil reorg clears the
+// reorganization state of the store. It returns the hashes of the blocks
+// pruned in the batch.
+func (s *Store) saveChainStatus(batch database.Batch, node *state.BlockNode, view *state.UtxoViewpoint, reorg *protocol.ReorgState) ([]bc.Hash, error) {
+	var pruned []bc.Hash
+	if view != nil {
+		if err := saveUtxoView(batch, view); err != nil {
+			return nil, err
+		}
+		if err := saveUndos(batch, view); err != nil {
+			return nil, err
+		}
+		if err := s.saveTxIndexes(batch, view); err != nil {
+			return nil, err
+		}
+		pruneUndos(s.db, batch, node, s.undoDepth)
+		pruned = s.pruneBlocks(batch, node)
+	}
+	if err := s.saveIndexSnapshot(batch, node); err != nil {
+		return nil, err
+	}
+
+	bytes, err := json.Marshal(protocol.BlockStoreState{Height: node.Height, Hash: &node.Hash})
+	if err != nil {
+		return nil, err
+	}
+
+	batch.Set(blockStoreKey, bytes)
+	if reorg == nil {
+		batch.Delete(reorgStateKey)
+		return pruned, nil
+	}
 
+	rawReorg, err := json.Marshal(reorg)
+	if err != nil {
+		return nil, err
+	}
+	batch.Set(reorgStateKey, rawReorg)
+	return pruned, nil
+}
+
+// SaveBlock persists a new block in the protocol, the block stays off the
+// main chain until the chain status moves to it.
+func (s *Store) SaveBlock(block *types.Block, ts *bc.TransactionStatus) error {
+	batch := s.db.NewBatch()
+	if err := saveBlock(batch, block, ts); err != nil {
+		return err
+	}
+	s.invalidateIndexSnapshot(batch, block.Height)
+	if err := batch.Write(); err != nil {
+		return err
+	}
+
+	blockHash := block.Hash()
 	log.WithFields(log.Fields{"height": block.Height, "hash": blockHash.String()}).Info("block saved on disk")
 	return nil
 }
 
-// SaveChainStatus save the core's newest status && delete old status
+// SaveBlockAndChainStatus persists a block extending the main chain along
+// the utxo view and the new status in a single batch, a crash leaves either
+// none or all of them.
+func (s *Store) SaveBlockAndChainStatus(block *types.Block, ts *bc.TransactionStatus, node *state.BlockNode, view *state.UtxoViewpoint) error {
+	batch := s.db.NewBatch()
+	if err := saveBlock(batch, block, ts); err != nil {
+		return err
+	}
+	pruned, err := s.saveChainStatus(batch, node, view, nil)
+	if err != nil {
+		return err
+	}
+	s.invalidateIndexSnapshot(batch, block.Height)
+	if err := s.writeUtxoBatch(batch, view, pruned); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{"height": block.Height, "hash": node.Hash.String()}).Info("block saved on disk and connected")
+	return nil
+}
+
+// SaveBlockHeader persists the header of a block whose body isn't kept, the
+// headers mode only stores the header index. A not nil node becomes the new
+// status in the same batch.
+func (s *Store) SaveBlockHeader(header *types.BlockHeader, node *state.BlockNode) error {
+	binaryBlockHeader, err := header.MarshalText()
+	if err != nil {
+		return errors.Wrap(err, "Marshal block header")
+	}
+
+	blockHash := header.Hash()
+	batch := s.db.NewBatch()
+	batch.Set(calcBlockHeaderKey(header.Height, &blockHash), binaryBlockHeader)
+	batch.Set(headersStoreKey, []byte{1})
+	if node != nil {
+		if _, err := s.saveChainStatus(batch, node, nil, nil); err != nil {
+			return err
+		}
+	}
+	s.invalidateIndexSnapshot(batch, header.Height)
+	if err := batch.Write(); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{"height": header.Height, "hash": blockHash.String()}).Debug("block header saved on disk")
+	return nil
+}
+
+// SaveChainStatus save the core's newest status && delete old status, the
+// undo records of the view are written in the same batch. A nil view leaves
+// the utxos untouched.
 func (s *Store) SaveChainStatus(node *state.BlockNode, view *state.UtxoViewpoint) error {
 	batch := s.db.NewBatch()
-	if err := saveUtxoView(batch, view); err != nil {
+	pruned, err := s.saveChainStatus(batch, node, view, nil)
+	if err != nil {
 		return err
 	}
+	return s.writeUtxoBatch(batch, view, pruned)
+}
 
-	bytes, err := json.Marshal(protocol.BlockStoreState{Height: node.Height, Hash: &node.Hash})
+// SaveReorgStatus saves the status of the fork point once a reorganization
+// detached the old chain, the reorganization state is written in the same
+// batch so a crash before the attach is resumed on startup
+func (s *Store) SaveReorgStatus(node *state.BlockNode, view *state.UtxoViewpoint, reorg *protocol.ReorgState) error {
+	batch := s.db.NewBatch()
+	pruned, err := s.saveChainStatus(batch, node, view, reorg)
 	if err != nil {
 		return err
 	}
+	return s.writeUtxoBatch(batch, view, pruned)
+}
 
-	batch.Set(blockStoreKey, bytes)
-	batch.Write()
+// writeUtxoBatch writes the batch holding the view, the cached entries of the
+// view are dropped before the write so none is served stale while it lands,
+// and again after it for the entries filled by the lookups racing the write.
+// The bodies of the pruned blocks are only dropped after the write, a lookup
+// racing it would cache them again from the db.
+func (s *Store) writeUtxoBatch(batch database.Batch, view *state.UtxoViewpoint, pruned []bc.Hash) error {
+	if view == nil {
+		return batch.Write()
+	}
+
+	removeCachedUtxos(s.utxoCache, view)
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	removeCachedUtxos(s.utxoCache, view)
+	for i := range pruned {
+		s.cache.remove(&pruned[i])
+	}
 	return nil
 }
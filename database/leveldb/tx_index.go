@@ -0,0 +1,289 @@
+package leveldb
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/btm-stats/consensus"
+	"github.com/btm-stats/database"
+	"github.com/btm-stats/encoding/blockchain"
+	"github.com/btm-stats/encoding/bufpool"
+	"github.com/btm-stats/errors"
+	"github.com/btm-stats/protocol/bc"
+	"github.com/btm-stats/protocol/bc/types"
+	"github.com/btm-stats/protocol/state"
+)
+
+var (
+	txIndexPrefix    = []byte("TX:")
+	spentIndexPrefix = []byte("SO:")
+)
+
+var (
+	errTxNotFound    = errors.New("can't find the transaction by given id")
+	errSpendNotFound = errors.New("can't find the spending transaction of the output")
+	errTxIndexOff    = errors.New("the transaction index is disabled")
+	errSpentIndexOff = errors.New("the spent output index is disabled")
+)
+
+// TxLocation locates a transaction of the main chain
+type TxLocation struct {
+	BlockHash   bc.Hash `json:"block_hash"`
+	BlockHeight uint64  `json:"block_height"`
+	Position    uint64  `json:"position"`
+}
+
+func calcTxIndexKey(txID *bc.Hash) []byte {
+	return append(append([]byte{}, txIndexPrefix...), txID.Bytes()...)
+}
+
+func calcSpentIndexKey(outputID *bc.Hash) []byte {
+	return append(append([]byte{}, spentIndexPrefix...), outputID.Bytes()...)
+}
+
+func encodeTxLocation(loc *TxLocation) ([]byte, error) {
+	buf := bufpool.Get()
+	defer bufpool.Put(buf)
+
+	if _, err := loc.BlockHash.WriteTo(buf); err != nil {
+		return nil, err
+	}
+	for _, v := range []uint64{loc.BlockHeight, loc.Position} {
+		if _, err := blockchain.WriteVarint63(buf, v); err != nil {
+			return nil, err
+		}
+	}
+	return append([]byte(nil), buf.Bytes()...), nil
+}
+
+func decodeTxLocation(data []byte) (*TxLocation, error) {
+	r := blockchain.NewReader(data)
+	loc := &TxLocation{}
+	if _, err := loc.BlockHash.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	for _, v := range []*uint64{&loc.BlockHeight, &loc.Position} {
+		var err error
+		if *v, err = blockchain.ReadVarint63(r); err != nil {
+			return nil, err
+		}
+	}
+
+	if trailing := r.Len(); trailing > 0 {
+		return nil, fmt.Errorf("trailing garbage (%d bytes)", trailing)
+	}
+	return loc, nil
+}
+
+// SetTxIndex turns on the index of the main chain transactions by id, and the
+// index of the spending transaction by spent output id. The blocks connected
+// while an index is off stay out of it.
+func (s *Store) SetTxIndex(txIndex, spentIndex bool) {
+	s.txIndex = txIndex
+	s.spentIndex = spentIndex
+}
+
+// saveTxIndexes follows the blocks attached to and detached from the view,
+// the entries of the detached blocks are deleted before the attached ones
+// are written since a transaction may move between them
+func (s *Store) saveTxIndexes(batch database.Batch, view *state.UtxoViewpoint) error {
+	for _, blockTxs := range view.DetachedTxs {
+		if s.txIndex {
+			for _, txID := range blockTxs.TxIDs {
+				batch.Delete(calcTxIndexKey(&txID))
+			}
+		}
+		if s.spentIndex {
+			for outputID := range blockTxs.Spends {
+				batch.Delete(calcSpentIndexKey(&outputID))
+			}
+		}
+	}
+
+	for _, blockTxs := range view.AttachedTxs {
+		if s.txIndex {
+			for i, txID := range blockTxs.TxIDs {
+				data, err := encodeTxLocation(&TxLocation{BlockHash: blockTxs.Hash, BlockHeight: blockTxs.Height, Position: uint64(i)})
+				if err != nil {
+					return err
+				}
+				batch.Set(calcTxIndexKey(&txID), data)
+			}
+		}
+		if s.spentIndex {
+			for outputID, txID := range blockTxs.Spends {
+				batch.Set(calcSpentIndexKey(&outputID), txID.Bytes())
+			}
+		}
+	}
+	return nil
+}
+
+// GetTxLocation returns the main chain block holding the transaction
+func (s *Store) GetTxLocation(txID *bc.Hash) (*TxLocation, error) {
+	if !s.txIndex {
+		return nil, errTxIndexOff
+	}
+
+	data := s.db.Get(calcTxIndexKey(txID))
+	if data == nil {
+		return nil, errors.WithDetailf(errTxNotFound, "tx %s", txID.String())
+	}
+
+	loc, err := decodeTxLocation(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode tx location")
+	}
+
+	// an index turned off during a reorg may point at a side chain block
+	if s.index != nil && !s.index.InMainchain(loc.BlockHash) {
+		return nil, errors.WithDetailf(errTxNotFound, "tx %s", txID.String())
+	}
+	return loc, nil
+}
+
+// GetTransaction returns the main chain transaction by its id along its
+// location, the transactions of the pruned blocks fail on
+// protocol.ErrBlockPruned
+func (s *Store) GetTransaction(txID *bc.Hash) (*types.Tx, *TxLocation, error) {
+	loc, err := s.GetTxLocation(txID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, err := s.GetBlock(&loc.BlockHash)
+	if err != nil {
+		return nil, nil, err
+	}
+	if loc.Position >= uint64(len(block.Transactions)) || block.Transactions[loc.Position].ID != *txID {
+		return nil, nil, fmt.Errorf("the tx index of %s is inconsistent with block %s", txID.String(), loc.BlockHash.String())
+	}
+	return block.Transactions[loc.Position], loc, nil
+}
+
+// GetSpendingTx returns the id of the main chain transaction spending the
+// output
+func (s *Store) GetSpendingTx(outputID *bc.Hash) (*bc.Hash, error) {
+	if !s.spentIndex {
+		return nil, errSpentIndexOff
+	}
+
+	data := s.db.Get(calcSpentIndexKey(outputID))
+	if len(data) != 32 {
+		return nil, errors.WithDetailf(errSpendNotFound, "output %s", outputID.String())
+	}
+
+	var hashBytes [32]byte
+	copy(hashBytes[:], data)
+	txID := bc.NewHash(hashBytes)
+
+	// a spend indexed before a reorg turned the index off may be on a side
+	// chain, the spender is located like any indexed transaction
+	if s.txIndex {
+		if _, err := s.GetTxLocation(&txID); err != nil {
+			return nil, errors.WithDetailf(errSpendNotFound, "output %s", outputID.String())
+		}
+	}
+	return &txID, nil
+}
+
+// indexedBlockTxs lists the transactions of a stored block and the outputs
+// they spend like the utxo view applying it, the failed transactions only
+// spend their BTM inputs
+func indexedBlockTxs(block *types.Block, txStatus *bc.TransactionStatus) (*state.BlockTxs, error) {
+	blockTxs := &state.BlockTxs{Hash: block.Hash(), Height: block.Height, Spends: make(map[bc.Hash]bc.Hash)}
+	for i, tx := range block.Transactions {
+		blockTxs.TxIDs = append(blockTxs.TxIDs, tx.ID)
+		statusFail, err := txStatus.GetStatus(i)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, prevout := range tx.SpentOutputIDs {
+			spentOutput, ok := tx.Entries[prevout].(*bc.Output)
+			if !ok {
+				return nil, fmt.Errorf("tx %s spends unknown output %s", tx.ID.String(), prevout.String())
+			}
+			if statusFail && *spentOutput.Source.Value.AssetId != *consensus.BTMAssetID {
+				continue
+			}
+			blockTxs.Spends[prevout] = tx.ID
+		}
+	}
+	return blockTxs, nil
+}
+
+// BackfillTxIndexes walks the main chain down from the tip and fills the
+// enabled indexes with the transactions and the spent outputs of the blocks
+// connected before them. The blocks already indexed by an earlier run are
+// skipped, and the pruned blocks have no body left to index. A dry run only
+// counts the blocks it would index.
+func BackfillTxIndexes(db database.DB, txIndex, spentIndex, dryRun bool) (int, error) {
+	status, err := GetStoreStatus(db)
+	if err != nil || status == nil || (!txIndex && !spentIndex) {
+		return 0, err
+	}
+
+	indexer := &Store{db: db, txIndex: txIndex, spentIndex: spentIndex}
+	view := state.NewUtxoViewpoint()
+	count := 0
+	for hash, height := *status.Hash, status.Height; ; height-- {
+		data := db.Get(calcBlockHeaderKey(height, &hash))
+		if data == nil {
+			return count, fmt.Errorf("can't find the main chain header %d %s", height, hash.String())
+		}
+		header := &types.BlockHeader{}
+		if err := header.UnmarshalText(data); err != nil {
+			return count, errors.Wrap(err, "unmarshaling block header")
+		}
+
+		if block := GetBlock(db, &hash); block != nil {
+			txStatus, err := GetTransactionStatus(db, &hash)
+			if err != nil {
+				return count, err
+			}
+			blockTxs, err := indexedBlockTxs(block, txStatus)
+			if err != nil {
+				return count, errors.Wrapf(err, "index block %d", height)
+			}
+			if !indexer.blockIndexed(blockTxs) {
+				view.AttachedTxs = append(view.AttachedTxs, blockTxs)
+			}
+		}
+
+		if len(view.AttachedTxs) == migrateBatchSize || height == 0 {
+			if !dryRun {
+				batch := db.NewBatch()
+				if err := indexer.saveTxIndexes(batch, view); err != nil {
+					return count, err
+				}
+				if err := batch.Write(); err != nil {
+					return count, err
+				}
+			}
+			count += len(view.AttachedTxs)
+			view = state.NewUtxoViewpoint()
+			log.WithFields(log.Fields{"blocks": count, "dry_run": dryRun}).Info("backfilling the transaction indexes")
+		}
+		if height == 0 {
+			return count, nil
+		}
+		hash = header.PreviousBlockHash
+	}
+}
+
+// blockIndexed tells whether the enabled indexes already hold the block
+func (s *Store) blockIndexed(blockTxs *state.BlockTxs) bool {
+	if s.txIndex && len(blockTxs.TxIDs) > 0 && !s.db.Has(calcTxIndexKey(&blockTxs.TxIDs[0])) {
+		return false
+	}
+	if s.spentIndex {
+		for outputID := range blockTxs.Spends {
+			if !s.db.Has(calcSpentIndexKey(&outputID)) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,246 @@
+package leveldb
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/btm-stats/consensus"
+	"github.com/btm-stats/database"
+	"github.com/btm-stats/protocol"
+	"github.com/btm-stats/protocol/bc"
+	"github.com/btm-stats/protocol/bc/types"
+	"github.com/btm-stats/protocol/state"
+)
+
+func TestTxIndexReorg(t *testing.T) {
+	store, err := NewStore(database.NewMemDB())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetTxIndex(true, true)
+
+	txA, txB := bc.Hash{V0: 1}, bc.Hash{V0: 2}
+	output := bc.Hash{V0: 3}
+	detached := &state.BlockTxs{Hash: bc.Hash{V0: 10}, Height: 5, TxIDs: []bc.Hash{txA, txB}, Spends: map[bc.Hash]bc.Hash{output: txB}}
+	attached := &state.BlockTxs{Hash: bc.Hash{V0: 11}, Height: 5, TxIDs: []bc.Hash{txB}, Spends: map[bc.Hash]bc.Hash{}}
+
+	for _, view := range []*state.UtxoViewpoint{
+		{AttachedTxs: []*state.BlockTxs{detached}},
+		{DetachedTxs: []*state.BlockTxs{detached}, AttachedTxs: []*state.BlockTxs{attached}},
+	} {
+		batch := store.db.NewBatch()
+		if err := store.saveTxIndexes(batch, view); err != nil {
+			t.Fatal(err)
+		}
+		if err := batch.Write(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := store.GetTxLocation(&txA); err == nil {
+		t.Fatal("the tx of the detached block is still indexed")
+	}
+	if _, err := store.GetSpendingTx(&output); err == nil {
+		t.Fatal("the spend of the detached block is still indexed")
+	}
+
+	loc, err := store.GetTxLocation(&txB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (&TxLocation{BlockHash: attached.Hash, BlockHeight: 5, Position: 0}); !reflect.DeepEqual(loc, want) {
+		t.Fatalf("got location %+v, want %+v", loc, want)
+	}
+}
+
+func TestSpendingTxOffMainChain(t *testing.T) {
+	store, err := NewStore(database.NewMemDB())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetTxIndex(true, true)
+
+	genesis, err := state.NewBlockNode(&types.BlockHeader{Version: 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.index = state.NewBlockIndex()
+	store.index.AddNode(genesis)
+	store.index.SetMainChain(genesis)
+
+	// the index was off while a reorg detached the spender to a side chain
+	output, spender := bc.Hash{V0: 3}, bc.Hash{V0: 2}
+	store.db.Set(calcSpentIndexKey(&output), spender.Bytes())
+	for _, c := range []struct {
+		desc      string
+		blockHash bc.Hash
+		wantErr   bool
+	}{
+		{desc: "spender on a side chain", blockHash: bc.Hash{V0: 11}, wantErr: true},
+		{desc: "spender on the main chain", blockHash: genesis.Hash},
+	} {
+		data, err := encodeTxLocation(&TxLocation{BlockHash: c.blockHash, BlockHeight: 0})
+		if err != nil {
+			t.Fatal(err)
+		}
+		store.db.Set(calcTxIndexKey(&spender), data)
+
+		got, err := store.GetSpendingTx(&output)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("%s: got spending tx %x, want an err", c.desc, got.Bytes())
+			}
+			continue
+		}
+		if err != nil || *got != spender {
+			t.Errorf("%s: got spending tx %v err = %v, want %x", c.desc, got, err, spender.Bytes())
+		}
+	}
+}
+
+// newBackfillTestDB stores a main chain of 4 blocks connected before the
+// indexes, the body of the block at height 1 is pruned. It returns the blocks
+// and the transfer and the failed transactions of the tip.
+func newBackfillTestDB(t *testing.T) (database.DB, []*types.Block, *types.Tx, *types.Tx) {
+	db := database.NewMemDB()
+	btm, asset := *consensus.BTMAssetID, bc.AssetID{V0: 1}
+	program := []byte{0x51}
+	transfer := types.NewTx(types.TxData{
+		Version:        1,
+		SerializedSize: 100,
+		Inputs:         []*types.TxInput{types.NewSpendInput(nil, bc.Hash{V0: 1}, btm, 100, 0, program)},
+		Outputs:        []*types.TxOutput{types.NewTxOutput(btm, 90, program)},
+	})
+	// the failed transaction only spends its BTM input
+	failed := types.NewTx(types.TxData{
+		Version:        1,
+		SerializedSize: 100,
+		Inputs: []*types.TxInput{
+			types.NewSpendInput(nil, bc.Hash{V0: 2}, btm, 100, 0, program),
+			types.NewSpendInput(nil, bc.Hash{V0: 3}, asset, 5, 0, program),
+		},
+		Outputs: []*types.TxOutput{types.NewTxOutput(btm, 90, program), types.NewTxOutput(asset, 5, program)},
+	})
+
+	blocks := []*types.Block{}
+	var parent bc.Hash
+	for height := uint64(0); height <= 3; height++ {
+		coinbase := types.NewTx(types.TxData{
+			Version:        1,
+			SerializedSize: 1,
+			Inputs:         []*types.TxInput{types.NewCoinbaseInput([]byte{byte(height)})},
+			Outputs:        []*types.TxOutput{types.NewTxOutput(btm, 100, program)},
+		})
+		block := &types.Block{
+			BlockHeader:  types.BlockHeader{Version: 1, Height: height, PreviousBlockHash: parent},
+			Transactions: []*types.Tx{coinbase},
+		}
+		txStatus := bc.NewTransactionStatus()
+		txStatus.SetStatus(0, false)
+		if height == 3 {
+			block.Transactions = append(block.Transactions, transfer, failed)
+			txStatus.SetStatus(1, false)
+			txStatus.SetStatus(2, true)
+		}
+
+		batch := db.NewBatch()
+		if err := saveBlock(batch, block, txStatus); err != nil {
+			t.Fatal(err)
+		}
+		if err := batch.Write(); err != nil {
+			t.Fatal(err)
+		}
+		parent = block.Hash()
+		blocks = append(blocks, block)
+	}
+	// the pruned block has no body left to index
+	prunedHash := blocks[1].Hash()
+	db.Delete(calcBlockKey(&prunedHash))
+	data, err := json.Marshal(protocol.BlockStoreState{Height: 3, Hash: &parent})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Set(blockStoreKey, data)
+	return db, blocks, transfer, failed
+}
+
+func TestBackfillTxIndexes(t *testing.T) {
+	db, blocks, transfer, failed := newBackfillTestDB(t)
+	count, err := BackfillTxIndexes(db, true, true, true)
+	if err != nil || count != 3 {
+		t.Fatalf("dry run got %d blocks err = %v, want 3", count, err)
+	}
+	if indexed := dumpPrefix(db, txIndexPrefix); len(indexed) != 0 {
+		t.Fatalf("dry run indexed %d transactions", len(indexed))
+	}
+
+	if count, err = BackfillTxIndexes(db, true, true, false); err != nil || count != 3 {
+		t.Fatalf("got %d indexed blocks err = %v, want 3", count, err)
+	}
+	store := &Store{db: db, txIndex: true, spentIndex: true}
+	for _, block := range blocks {
+		for i, tx := range block.Transactions {
+			loc, err := store.GetTxLocation(&tx.ID)
+			if block.Height == 1 {
+				if err == nil {
+					t.Errorf("the tx of the pruned block %d is indexed", block.Height)
+				}
+				continue
+			}
+			if want := (&TxLocation{BlockHash: block.Hash(), BlockHeight: block.Height, Position: uint64(i)}); err != nil || !reflect.DeepEqual(loc, want) {
+				t.Errorf("block %d tx %d got location %+v err = %v, want %+v", block.Height, i, loc, err, want)
+			}
+		}
+	}
+
+	wantSpends := map[bc.Hash]bc.Hash{
+		transfer.SpentOutputIDs[0]: transfer.ID,
+		failed.SpentOutputIDs[0]:   failed.ID,
+	}
+	if spends := dumpPrefix(db, spentIndexPrefix); len(spends) != len(wantSpends) {
+		t.Errorf("got %d spent outputs, want %d", len(spends), len(wantSpends))
+	}
+	for outputID, want := range wantSpends {
+		if got, err := store.GetSpendingTx(&outputID); err != nil || *got != want {
+			t.Errorf("output %x got spending tx %v err = %v, want %x", outputID.Bytes(), got, err, want.Bytes())
+		}
+	}
+
+	// a rerun has nothing left to index
+	if count, err = BackfillTxIndexes(db, true, true, false); err != nil || count != 0 {
+		t.Errorf("rerun got %d indexed blocks err = %v, want none", count, err)
+	}
+}
+
+func TestBackfillConfiguredIndexes(t *testing.T) {
+	cases := []struct {
+		desc       string
+		txIndex    bool
+		spentIndex bool
+		wantTxs    int
+		wantSpends int
+	}{
+		{desc: "indexes off", wantTxs: 0, wantSpends: 0},
+		{desc: "tx index only", txIndex: true, wantTxs: 5, wantSpends: 0},
+		{desc: "spent index only", spentIndex: true, wantTxs: 0, wantSpends: 2},
+	}
+
+	for _, c := range cases {
+		db, _, _, _ := newBackfillTestDB(t)
+		if _, err := BackfillTxIndexes(db, c.txIndex, c.spentIndex, false); err != nil {
+			t.Fatalf("%s: %v", c.desc, err)
+		}
+		if got := len(dumpPrefix(db, txIndexPrefix)); got != c.wantTxs {
+			t.Errorf("%s: got %d indexed transactions, want %d", c.desc, got, c.wantTxs)
+		}
+		if got := len(dumpPrefix(db, spentIndexPrefix)); got != c.wantSpends {
+			t.Errorf("%s: got %d indexed spent outputs, want %d", c.desc, got, c.wantSpends)
+		}
+
+		// a rerun finds every block in the enabled indexes
+		if count, err := BackfillTxIndexes(db, c.txIndex, c.spentIndex, true); err != nil || count != 0 {
+			t.Errorf("%s: rerun got %d blocks err = %v, want none", c.desc, count, err)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package leveldb
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/btm-stats/database"
+	"github.com/btm-stats/database/storage"
+	"github.com/btm-stats/encoding/blockchain"
+	"github.com/btm-stats/encoding/bufpool"
+	"github.com/btm-stats/errors"
+	"github.com/btm-stats/protocol/bc"
+	"github.com/btm-stats/protocol/state"
+)
+
+// DefaultUndoDepth is the number of blocks below the tip whose undo records
+// are kept, deeper reorganizations can't be detached
+const DefaultUndoDepth = 1000
+
+var undoPrefix = []byte("UD:")
+
+func calcUndoKey(hash *bc.Hash) []byte {
+	return append(undoPrefix, hash.Bytes()...)
+}
+
+// encodeUndo serializes the undo record as a count followed by the output
+// ids and their marshaled utxo entries
+func encodeUndo(undo state.BlockUndo) ([]byte, error) {
+	buf := bufpool.Get()
+	defer bufpool.Put(buf)
+
+	if _, err := blockchain.WriteVarint31(buf, uint64(len(undo))); err != nil {
+		return nil, err
+	}
+	for hash, entry := range undo {
+		data, err := proto.Marshal(entry)
+		if err != nil {
+			return nil, errors.Wrap(err, "marshaling utxo entry")
+		}
+		if _, err := hash.WriteTo(buf); err != nil {
+			return nil, err
+		}
+		if _, err := blockchain.WriteVarstr31(buf, data); err != nil {
+			return nil, err
+		}
+	}
+	return append([]byte(nil), buf.Bytes()...), nil
+}
+
+func decodeUndo(data []byte) (state.BlockUndo, error) {
+	r := blockchain.NewReader(data)
+	n, err := blockchain.ReadVarint31(r)
+	if err != nil {
+		return nil, err
+	}
+
+	undo := state.BlockUndo{}
+	for ; n > 0; n-- {
+		var hash bc.Hash
+		if _, err := hash.ReadFrom(r); err != nil {
+			return nil, err
+		}
+		data, err := blockchain.ReadVarstr31(r)
+		if err != nil {
+			return nil, err
+		}
+
+		entry := &storage.UtxoEntry{}
+		if err := proto.Unmarshal(data, entry); err != nil {
+			return nil, errors.Wrap(err, "unmarshaling utxo entry")
+		}
+		undo[hash] = entry
+	}
+
+	if trailing := r.Len(); trailing > 0 {
+		return nil, fmt.Errorf("trailing garbage (%d bytes)", trailing)
+	}
+	return undo, nil
+}
+
+// saveUndos writes the undo records of the blocks applied to the view and
+// drops the ones of the detached blocks
+func saveUndos(batch database.Batch, view *state.UtxoViewpoint) error {
+	for hash, undo := range view.Undos {
+		data, err := encodeUndo(undo)
+		if err != nil {
+			return err
+		}
+		batch.Set(calcUndoKey(&hash), data)
+	}
+	for _, hash := range view.Detached {
+		batch.Delete(calcUndoKey(&hash))
+	}
+	return nil
+}
+
+// pruneUndos drops the undo records of the main chain blocks sinking below
+// the undo depth, the walk stops at the first block already pruned
+func pruneUndos(db database.DB, batch database.Batch, node *state.BlockNode, depth uint64) {
+	if node.Height < depth {
+		return
+	}
+
+	for i := uint64(0); i < depth && node != nil; i++ {
+		node = node.Parent
+	}
+	for ; node != nil; node = node.Parent {
+		key := calcUndoKey(&node.Hash)
+		if db.Get(key) == nil {
+			return
+		}
+		batch.Delete(key)
+	}
+}
+
+// GetBlockUndo returns the utxo entries spent by the block
+func (s *Store) GetBlockUndo(hash *bc.Hash) (state.BlockUndo, error) {
+	data := s.db.Get(calcUndoKey(hash))
+	if data == nil {
+		return nil, errors.New("can't find the undo record by given hash")
+	}
+
+	undo, err := decodeUndo(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding block undo")
+	}
+	return undo, nil
+}
+
+// SetUndoDepth sets the number of blocks below the tip whose undo records are
+// kept
+func (s *Store) SetUndoDepth(depth uint64) {
+	s.undoDepth = depth
+}
@@ -0,0 +1,180 @@
+package leveldb
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/btm-stats/consensus"
+	"github.com/btm-stats/database"
+	"github.com/btm-stats/database/storage"
+	"github.com/btm-stats/protocol/bc"
+	"github.com/btm-stats/protocol/bc/types"
+	"github.com/btm-stats/protocol/state"
+)
+
+// dumpPrefix returns the records of the db under the prefix
+func dumpPrefix(db database.DB, prefix []byte) map[string]string {
+	iter := db.IteratorPrefix(prefix)
+	defer iter.Release()
+
+	records := map[string]string{}
+	for iter.Next() {
+		records[string(iter.Key())] = string(iter.Value())
+	}
+	return records
+}
+
+// writeUtxoView stores the view and its undo records like the chain status
+func writeUtxoView(t *testing.T, store *Store, view *state.UtxoViewpoint) {
+	removeCachedUtxos(store.utxoCache, view)
+	batch := store.db.NewBatch()
+	if err := saveUtxoView(batch, view); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveUndos(batch, view); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Write(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUndoRoundTrip(t *testing.T) {
+	store, err := NewStore(database.NewMemDB())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	btm, asset := *consensus.BTMAssetID, bc.AssetID{V0: 1}
+	program := []byte{0x51}
+	coinbase := types.NewTx(types.TxData{
+		Version:        1,
+		SerializedSize: 1,
+		Inputs:         []*types.TxInput{types.NewCoinbaseInput([]byte{2})},
+		Outputs:        []*types.TxOutput{types.NewTxOutput(btm, 100, program)},
+	})
+	transfer := types.NewTx(types.TxData{
+		Version:        1,
+		SerializedSize: 100,
+		Inputs:         []*types.TxInput{types.NewSpendInput(nil, bc.Hash{V0: 1}, btm, 100, 0, program)},
+		Outputs:        []*types.TxOutput{types.NewTxOutput(btm, 90, program)},
+	})
+	// the failed transaction only spends its BTM input
+	failed := types.NewTx(types.TxData{
+		Version:        1,
+		SerializedSize: 100,
+		Inputs: []*types.TxInput{
+			types.NewSpendInput(nil, bc.Hash{V0: 2}, btm, 100, 0, program),
+			types.NewSpendInput(nil, bc.Hash{V0: 3}, asset, 5, 0, program),
+		},
+		Outputs: []*types.TxOutput{
+			types.NewTxOutput(btm, 90, program),
+			types.NewTxOutput(asset, 5, program),
+		},
+	})
+	block := types.MapBlock(&types.Block{
+		BlockHeader:  types.BlockHeader{Version: 1, Height: 2},
+		Transactions: []*types.Tx{coinbase, transfer, failed},
+	})
+	txStatus := bc.NewTransactionStatus()
+	txStatus.SetStatus(0, false)
+	txStatus.SetStatus(1, false)
+	txStatus.SetStatus(2, true)
+
+	seed := state.NewUtxoViewpoint()
+	for _, tx := range block.Transactions[1:] {
+		for _, prevout := range tx.SpentOutputIDs {
+			seed.Entries[prevout] = storage.NewUtxoEntry(false, 1, false)
+		}
+	}
+	writeUtxoView(t, store, seed)
+	before := dumpPrefix(store.db, []byte(utxoPreFix))
+
+	view := state.NewUtxoViewpoint()
+	if err := store.GetTransactionsUtxo(view, block.Transactions); err != nil {
+		t.Fatal(err)
+	}
+	if err := view.ApplyBlock(block, txStatus); err != nil {
+		t.Fatal(err)
+	}
+	writeUtxoView(t, store, view)
+
+	wantUndo := state.BlockUndo{
+		transfer.SpentOutputIDs[0]: storage.NewUtxoEntry(false, 1, false),
+		failed.SpentOutputIDs[0]:   storage.NewUtxoEntry(false, 1, false),
+	}
+	undo, err := store.GetBlockUndo(&block.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(undo, wantUndo) {
+		t.Fatalf("got undo %v, want %v", undo, wantUndo)
+	}
+	if _, err := getUtxo(store.db, &failed.SpentOutputIDs[1]); err != nil {
+		t.Error("the failed transaction spent its asset input")
+	}
+
+	view = state.NewUtxoViewpoint()
+	if err := store.GetTransactionsUtxo(view, block.Transactions); err != nil {
+		t.Fatal(err)
+	}
+	if err := view.DetachBlock(block, txStatus, undo); err != nil {
+		t.Fatal(err)
+	}
+	writeUtxoView(t, store, view)
+
+	if after := dumpPrefix(store.db, []byte(utxoPreFix)); !reflect.DeepEqual(after, before) {
+		t.Errorf("got utxos %v after the detach, want %v", after, before)
+	}
+	if _, err := store.GetBlockUndo(&block.ID); err == nil {
+		t.Error("the undo record of the detached block is kept")
+	}
+}
+
+func TestPruneUndos(t *testing.T) {
+	db := database.NewMemDB()
+	var nodes []*state.BlockNode
+	var parent *state.BlockNode
+	for height := uint64(0); height <= 10; height++ {
+		parent = &state.BlockNode{Parent: parent, Height: height, Hash: bc.Hash{V0: height + 1}}
+		nodes = append(nodes, parent)
+		db.Set(calcUndoKey(&parent.Hash), []byte{0})
+	}
+
+	cases := []struct {
+		tip        uint64
+		depth      uint64
+		restore    []uint64
+		wantPruned []uint64
+	}{
+		// the chain isn't deep enough yet
+		{tip: 2, depth: 3},
+		{tip: 3, depth: 3, wantPruned: []uint64{0}},
+		{tip: 8, depth: 3, wantPruned: []uint64{0, 1, 2, 3, 4, 5}},
+		// the walk stops at the records already pruned, so it doesn't reach
+		// the restored record below them
+		{tip: 9, depth: 3, restore: []uint64{1}, wantPruned: []uint64{0, 2, 3, 4, 5, 6}},
+	}
+
+	for i, c := range cases {
+		for _, height := range c.restore {
+			db.Set(calcUndoKey(&nodes[height].Hash), []byte{0})
+		}
+
+		batch := db.NewBatch()
+		pruneUndos(db, batch, nodes[c.tip], c.depth)
+		if err := batch.Write(); err != nil {
+			t.Fatal(err)
+		}
+
+		pruned := map[uint64]bool{}
+		for _, height := range c.wantPruned {
+			pruned[height] = true
+		}
+		for _, node := range nodes {
+			if got := db.Get(calcUndoKey(&node.Hash)) == nil; got != pruned[node.Height] {
+				t.Errorf("case %d: height %d got pruned %v, want %v", i, node.Height, got, pruned[node.Height])
+			}
+		}
+	}
+}
@@ -1,8 +1,7 @@
 package leveldb
 
 import (
-	dbm "github.com/tendermint/tmlibs/db"
-
+	"github.com/btm-stats/database"
 	"github.com/btm-stats/database/storage"
 	"github.com/btm-stats/errors"
 	"github.com/btm-stats/protocol/bc"
@@ -16,16 +15,24 @@ func calcUtxoKey(hash *bc.Hash) []byte {
 	return []byte(utxoPreFix + hash.String())
 }
 
-func getTransactionsUtxo(db dbm.DB, view *state.UtxoViewpoint, txs []*bc.Tx) error {
+// getTransactionsUtxo loads the utxos spent by the txs into the view, the
+// cache keeps the raw entries so the view gets its own copy to spend
+func getTransactionsUtxo(db database.Reader, cache *sizedCache, view *state.UtxoViewpoint, txs []*bc.Tx) error {
 	for _, tx := range txs {
 		for _, prevout := range tx.SpentOutputIDs {
 			if view.HasUtxo(&prevout) {
 				continue
 			}
 
-			data := db.Get(calcUtxoKey(&prevout))
-			if data == nil {
-				continue
+			var data []byte
+			if cached, ok := cache.get(prevout); ok {
+				data = cached.([]byte)
+			} else {
+				gen := cache.generation()
+				if data = db.Get(calcUtxoKey(&prevout)); data == nil {
+					continue
+				}
+				cache.fill(gen, prevout, data, int64(len(data)))
 			}
 
 			var utxo storage.UtxoEntry
@@ -40,7 +47,7 @@ func getTransactionsUtxo(db dbm.DB, view *state.UtxoViewpoint, txs []*bc.Tx) err
 	return nil
 }
 
-func getUtxo(db dbm.DB, hash *bc.Hash) (*storage.UtxoEntry, error) {
+func getUtxo(db database.Reader, hash *bc.Hash) (*storage.UtxoEntry, error) {
 	var utxo storage.UtxoEntry
 	data := db.Get(calcUtxoKey(hash))
 	if data == nil {
@@ -52,7 +59,7 @@ func getUtxo(db dbm.DB, hash *bc.Hash) (*storage.UtxoEntry, error) {
 	return &utxo, nil
 }
 
-func saveUtxoView(batch dbm.Batch, view *state.UtxoViewpoint) error {
+func saveUtxoView(batch database.Batch, view *state.UtxoViewpoint) error {
 	for key, entry := range view.Entries {
 		if entry.Spent && !entry.IsCoinBase {
 			batch.Delete(calcUtxoKey(&key))
@@ -68,6 +75,13 @@ func saveUtxoView(batch dbm.Batch, view *state.UtxoViewpoint) error {
 	return nil
 }
 
-func SaveUtxoView(batch dbm.Batch, view *state.UtxoViewpoint) error {
+// removeCachedUtxos drops the entries of a written view from the cache
+func removeCachedUtxos(cache *sizedCache, view *state.UtxoViewpoint) {
+	for key := range view.Entries {
+		cache.remove(key)
+	}
+}
+
+func SaveUtxoView(batch database.Batch, view *state.UtxoViewpoint) error {
 	return saveUtxoView(batch, view)
 }
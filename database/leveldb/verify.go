@@ -0,0 +1,537 @@
+package leveldb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/btm-stats/config"
+	"github.com/btm-stats/consensus"
+	"github.com/btm-stats/database"
+	"github.com/btm-stats/database/storage"
+	"github.com/btm-stats/errors"
+	"github.com/btm-stats/protocol"
+	"github.com/btm-stats/protocol/bc"
+	"github.com/btm-stats/protocol/bc/types"
+	"github.com/btm-stats/protocol/state"
+)
+
+var (
+	errNothingToRepair = errors.New("no verified block to repair the store tip to")
+	errPrunedStore     = errors.New("can't verify a pruned store, the utxo replay needs every block body")
+	errHeadersStore    = errors.New("can't verify a headers mode store, it keeps no block body")
+	errRepairDerived   = errors.New("the analytics are derived beyond the repaired tip")
+	errRepairSpends    = errors.New("can't clean the spent output index of an unreadable block")
+)
+
+// followerPrefix holds the heights the analytics are derived up to
+var followerPrefix = []byte("AF:")
+
+// VerifyError is an inconsistency found by Verify
+type VerifyError struct {
+	Height uint64  `json:"height"`
+	Hash   bc.Hash `json:"hash"`
+	Reason string  `json:"reason"`
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("block %d %s: %s", e.Height, e.Hash.String(), e.Reason)
+}
+
+// VerifyReport is the result of a store verification
+type VerifyReport struct {
+	Headers     int          `json:"headers"`
+	Blocks      int          `json:"main_chain_blocks"`
+	Utxos       int          `json:"utxos"`
+	StoreHeight uint64       `json:"store_height"`
+	StoreHash   *bc.Hash     `json:"store_hash"`
+	BestHeight  uint64       `json:"verified_height"`
+	BestHash    *bc.Hash     `json:"verified_hash"`
+	Err         *VerifyError `json:"error,omitempty"`
+
+	// utxo set replayed up to BestHash and the main chain blocks above it,
+	// used by RepairTip. The blocks are only those of the store tip when
+	// tipKnown.
+	view     map[bc.Hash]*storage.UtxoEntry
+	above    []bc.Hash
+	tipKnown bool
+}
+
+func (r *VerifyReport) fail(height uint64, hash *bc.Hash, format string, v ...interface{}) {
+	if r.Err == nil {
+		r.Err = &VerifyError{Height: height, Hash: *hash, Reason: fmt.Sprintf(format, v...)}
+	}
+}
+
+// verifyEntry is a block of the header index, its body is checked on the walk
+// of the index and loaded again on the replay so only one is held at a time
+type verifyEntry struct {
+	header *types.BlockHeader
+	status *bc.TransactionStatus
+	err    bool // the entry failed on the structure checks
+}
+
+// Verify walks the stored chain and checks the header index against the
+// block bodies, the merkle roots, the transaction status and the utxo set.
+// Only the first inconsistency is reported, the returned error is kept for
+// failures on reading the database.
+func (s *Store) Verify() (*VerifyReport, error) {
+	if s.db.Get(headersStoreKey) != nil {
+		return nil, errHeadersStore
+	}
+	if height := s.PrunedHeight(); height > 0 {
+		return nil, errors.WithDetailf(errPrunedStore, "bodies pruned up to height %d", height)
+	}
+
+	report := &VerifyReport{}
+	entries, err := s.verifyEntries(report)
+	if err != nil {
+		return nil, err
+	}
+
+	chain := s.verifyMainChain(report, entries)
+	s.verifyReplay(report, chain)
+	if report.Err == nil {
+		if err := s.verifyUtxos(report); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// verifyEntries checks every block of the header index on its own
+func (s *Store) verifyEntries(report *VerifyReport) (map[bc.Hash]*verifyEntry, error) {
+	entries := make(map[bc.Hash]*verifyEntry)
+	iter := s.db.IteratorPrefix(blockHeaderPrefix)
+	defer iter.Release()
+
+	for iter.Next() {
+		report.Headers++
+		key := iter.Key()
+		if len(key) != len(blockHeaderPrefix)+8+32 {
+			return nil, fmt.Errorf("malformed block header key %x", key)
+		}
+
+		height := binary.BigEndian.Uint64(key[len(blockHeaderPrefix):])
+		var hashBytes [32]byte
+		copy(hashBytes[:], key[len(blockHeaderPrefix)+8:])
+		hash := bc.NewHash(hashBytes)
+
+		entry := &verifyEntry{header: &types.BlockHeader{}}
+		entries[hash] = entry
+		if !s.verifyEntry(report, entry, height, &hash, iter.Value()) {
+			entry.err = true
+		}
+	}
+	return entries, nil
+}
+
+func (s *Store) verifyEntry(report *VerifyReport, entry *verifyEntry, height uint64, hash *bc.Hash, rawHeader []byte) bool {
+	if err := entry.header.UnmarshalText(rawHeader); err != nil {
+		report.fail(height, hash, "undecodable header: %v", err)
+		return false
+	}
+	if headerHash := entry.header.Hash(); headerHash != *hash || entry.header.Height != height {
+		report.fail(height, hash, "header index key doesn't match header %d %s", entry.header.Height, headerHash.String())
+		return false
+	}
+
+	rawBlock := s.db.Get(calcBlockKey(hash))
+	if rawBlock == nil {
+		report.fail(height, hash, "block body is missing")
+		return false
+	}
+	block, err := decodeBlock(rawBlock)
+	if err != nil {
+		report.fail(height, hash, "undecodable block body: %v", err)
+		return false
+	}
+	if blockHash := block.Hash(); blockHash != *hash {
+		report.fail(height, hash, "block body hash is %s", blockHash.String())
+		return false
+	}
+
+	bcTxs := make([]*bc.Tx, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		bcTxs[i] = tx.Tx
+	}
+	merkleRoot, err := bc.TxMerkleRoot(bcTxs)
+	if err != nil {
+		report.fail(height, hash, "fail on calc tx merkle root: %v", err)
+		return false
+	}
+	if merkleRoot != entry.header.TransactionsMerkleRoot {
+		report.fail(height, hash, "tx merkle root mismatch, calculated %s", merkleRoot.String())
+		return false
+	}
+
+	rawStatus := s.db.Get(calcTxStatusKey(hash))
+	if rawStatus == nil {
+		report.fail(height, hash, "transaction status is missing")
+		return false
+	}
+	entry.status = &bc.TransactionStatus{}
+	if err := proto.Unmarshal(rawStatus, entry.status); err != nil {
+		report.fail(height, hash, "undecodable transaction status: %v", err)
+		return false
+	}
+	if len(entry.status.VerifyStatus) != len(block.Transactions) {
+		report.fail(height, hash, "transaction status has %d results for %d txs", len(entry.status.VerifyStatus), len(block.Transactions))
+		return false
+	}
+
+	statusRoot, err := bc.TxStatusMerkleRoot(entry.status.VerifyStatus)
+	if err != nil {
+		report.fail(height, hash, "fail on calc tx status merkle root: %v", err)
+		return false
+	}
+	if statusRoot != entry.header.TransactionStatusHash {
+		report.fail(height, hash, "tx status merkle root mismatch, calculated %s", statusRoot.String())
+		return false
+	}
+	return true
+}
+
+// verifyMainChain returns the chain from the genesis to the store tip, when
+// the tip is unknown the highest linked header is used instead
+func (s *Store) verifyMainChain(report *VerifyReport, entries map[bc.Hash]*verifyEntry) []*verifyEntry {
+	tipHash := (*bc.Hash)(nil)
+	if status := loadBlockStoreStateJSON(s.db); status != nil {
+		report.StoreHeight, report.StoreHash = status.Height, status.Hash
+		if _, ok := entries[*status.Hash]; ok {
+			tipHash, report.tipKnown = status.Hash, true
+		} else {
+			report.fail(status.Height, status.Hash, "store tip is not in the header index")
+		}
+	}
+
+	if tipHash == nil {
+		var best *types.BlockHeader
+		for _, entry := range entries {
+			if best == nil || entry.header.Height > best.Height {
+				best = entry.header
+			}
+		}
+		if best == nil {
+			return nil
+		}
+		hash := best.Hash()
+		tipHash = &hash
+	}
+
+	chain := []*verifyEntry{}
+	for hash := *tipHash; ; {
+		entry := entries[hash]
+		chain = append(chain, entry)
+		if entry.header.Height == 0 {
+			break
+		}
+
+		parent, ok := entries[entry.header.PreviousBlockHash]
+		if !ok {
+			report.fail(entry.header.Height, &hash, "previous block %s is not in the header index", entry.header.PreviousBlockHash.String())
+			chain = nil
+			break
+		}
+		if parent.header.Height+1 != entry.header.Height {
+			report.fail(entry.header.Height, &hash, "previous block height is %d", parent.header.Height)
+			chain = nil
+			break
+		}
+		hash = entry.header.PreviousBlockHash
+	}
+
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	if len(chain) > 0 {
+		genesisHash := config.GenesisBlock().Hash()
+		if chain[0].header.Hash() != genesisHash {
+			hash := chain[0].header.Hash()
+			report.fail(0, &hash, "chain doesn't start at the genesis block %s", genesisHash.String())
+			return nil
+		}
+	}
+	return chain
+}
+
+// verifyReplay rebuilds the utxo set along the main chain, it stops at the
+// first block failing a check. The blocks past it are kept for RepairTip.
+func (s *Store) verifyReplay(report *VerifyReport, chain []*verifyEntry) {
+	view := make(map[bc.Hash]*storage.UtxoEntry)
+	for i, entry := range chain {
+		hash := entry.header.Hash()
+		if entry.err || !s.replayEntry(report, view, entry, &hash) {
+			for _, above := range chain[i:] {
+				report.above = append(report.above, above.header.Hash())
+			}
+			break
+		}
+
+		report.Blocks++
+		report.BestHeight, report.BestHash = entry.header.Height, &hash
+	}
+	report.view = view
+}
+
+func (s *Store) replayEntry(report *VerifyReport, view map[bc.Hash]*storage.UtxoEntry, entry *verifyEntry, hash *bc.Hash) bool {
+	block, err := decodeBlock(s.db.Get(calcBlockKey(hash)))
+	if err != nil {
+		report.fail(entry.header.Height, hash, "undecodable block body: %v", err)
+		return false
+	}
+	if err := replayBlock(view, block, entry.status); err != nil {
+		report.fail(entry.header.Height, hash, "utxo replay: %v", err)
+		return false
+	}
+	return true
+}
+
+// replayBlock applies the block the same way the chain updates its utxo view,
+// the spent outputs are dropped like the store drops them but the coinbase
+// ones
+func replayBlock(view map[bc.Hash]*storage.UtxoEntry, block *types.Block, status *bc.TransactionStatus) error {
+	for i, tx := range block.Transactions {
+		statusFail, err := status.GetStatus(i)
+		if err != nil {
+			return err
+		}
+
+		for _, prevout := range tx.SpentOutputIDs {
+			spentOutput, ok := tx.Entries[prevout].(*bc.Output)
+			if !ok {
+				return fmt.Errorf("tx %s spends unknown output %s", tx.ID.String(), prevout.String())
+			}
+			if statusFail && *spentOutput.Source.Value.AssetId != *consensus.BTMAssetID {
+				continue
+			}
+
+			entry, ok := view[prevout]
+			if !ok || entry.Spent {
+				return fmt.Errorf("tx %s spends missing utxo %s", tx.ID.String(), prevout.String())
+			}
+			if entry.IsCoinBase && entry.BlockHeight+consensus.CoinbasePendingBlockNumber > block.Height {
+				return fmt.Errorf("tx %s spends immature coinbase %s", tx.ID.String(), prevout.String())
+			}
+			entry.SpendOutput()
+			if !entry.IsCoinBase {
+				delete(view, prevout)
+			}
+		}
+
+		for _, id := range tx.ResultIds {
+			output, ok := tx.Entries[*id].(*bc.Output)
+			if !ok {
+				// retirements don't create utxo
+				continue
+			}
+			if statusFail && *output.Source.Value.AssetId != *consensus.BTMAssetID {
+				continue
+			}
+			view[*id] = storage.NewUtxoEntry(i == 0, block.Height, false)
+		}
+	}
+	return nil
+}
+
+// verifyUtxos compares the stored utxo set with the replayed one
+func (s *Store) verifyUtxos(report *VerifyReport) error {
+	tip := report.BestHash
+	if tip == nil {
+		return nil
+	}
+
+	seen := make(map[bc.Hash]bool)
+	iter := s.db.IteratorPrefix([]byte(utxoPreFix))
+	defer iter.Release()
+
+	for iter.Next() {
+		report.Utxos++
+		var hash bc.Hash
+		if err := hash.UnmarshalText(iter.Key()[len(utxoPreFix):]); err != nil {
+			return errors.Wrap(err, "malformed utxo key")
+		}
+
+		stored := &storage.UtxoEntry{}
+		if err := proto.Unmarshal(iter.Value(), stored); err != nil {
+			return errors.Wrap(err, "unmarshaling utxo entry")
+		}
+
+		expect, ok := report.view[hash]
+		if !ok || (expect.Spent && !expect.IsCoinBase) {
+			report.fail(report.BestHeight, tip, "stored utxo %s doesn't exist in the replay", hash.String())
+			return nil
+		}
+		if !proto.Equal(stored, expect) {
+			report.fail(report.BestHeight, tip, "stored utxo %s is %v, replay gives %v", hash.String(), stored, expect)
+			return nil
+		}
+		seen[hash] = true
+	}
+
+	for hash, entry := range report.view {
+		if entry.Spent && !entry.IsCoinBase {
+			continue
+		}
+		if !seen[hash] {
+			report.fail(report.BestHeight, tip, "utxo %s is missing in the store", hash.String())
+			return nil
+		}
+	}
+	return nil
+}
+
+// RepairTip points the store tip to the last verified block of the report
+// and replaces the utxo set by the replayed one. The blocks above the tip are
+// removed along their undo records, transaction indexes and index snapshot
+// chunks, and an interrupted reorganization is dropped. The store is refused
+// when the analytics are derived beyond the tip.
+func (s *Store) RepairTip(report *VerifyReport) error {
+	if report.BestHash == nil || report.view == nil {
+		return errNothingToRepair
+	}
+	if s.db.Get(headersStoreKey) != nil {
+		return errHeadersStore
+	}
+	if height := s.PrunedHeight(); height > 0 {
+		return errors.WithDetailf(errPrunedStore, "bodies pruned up to height %d", height)
+	}
+	if err := s.checkDerivedHeights(report.BestHeight); err != nil {
+		return err
+	}
+
+	batch := s.db.NewBatch()
+	iter := s.db.IteratorPrefix([]byte(utxoPreFix))
+	for iter.Next() {
+		batch.Delete(append([]byte(nil), iter.Key()...))
+	}
+	iter.Release()
+
+	for hash, entry := range report.view {
+		if entry.Spent && !entry.IsCoinBase {
+			continue
+		}
+		b, err := proto.Marshal(entry)
+		if err != nil {
+			return errors.Wrap(err, "marshaling utxo entry")
+		}
+		batch.Set(calcUtxoKey(&hash), b)
+	}
+
+	if err := s.removeTxIndexesAbove(batch, report); err != nil {
+		return err
+	}
+	removed := s.removeBlocksAbove(batch, report.BestHeight)
+	s.invalidateIndexSnapshot(batch, report.BestHeight+1)
+
+	rawStatus, err := json.Marshal(protocol.BlockStoreState{Height: report.BestHeight, Hash: report.BestHash})
+	if err != nil {
+		return err
+	}
+	batch.Set(blockStoreKey, rawStatus)
+	// the repaired tip is where the chain resumes, not an interrupted reorganization
+	batch.Delete(reorgStateKey)
+	if err := batch.Write(); err != nil {
+		return err
+	}
+
+	for _, hash := range removed {
+		s.cache.remove(&hash)
+		s.headerCache.remove(hash)
+	}
+	return nil
+}
+
+// checkDerivedHeights refuses a tip below the heights the analytics are
+// derived up to, they can't be detached once the blocks are removed
+func (s *Store) checkDerivedHeights(height uint64) error {
+	iter := s.db.IteratorPrefix(followerPrefix)
+	defer iter.Release()
+
+	for iter.Next() {
+		if value := iter.Value(); len(value) >= 8 && binary.BigEndian.Uint64(value) > height {
+			name := string(iter.Key()[len(followerPrefix):])
+			return errors.WithDetailf(errRepairDerived, "%s derived up to height %d, drop its records first", name, binary.BigEndian.Uint64(value))
+		}
+	}
+	return nil
+}
+
+// removeTxIndexesAbove deletes the index entries of the main chain blocks
+// above the verified tip. The transaction index locates its entries by
+// height, the spent output index needs the bodies of the blocks.
+func (s *Store) removeTxIndexesAbove(batch database.Batch, report *VerifyReport) error {
+	iter := s.db.IteratorPrefix(txIndexPrefix)
+	for iter.Next() {
+		loc, err := decodeTxLocation(iter.Value())
+		if err != nil || loc.BlockHeight > report.BestHeight {
+			batch.Delete(append([]byte(nil), iter.Key()...))
+		}
+	}
+	iter.Release()
+
+	spentIndex := hasPrefix(s.db, spentIndexPrefix)
+	if spentIndex && report.StoreHash != nil && !report.tipKnown {
+		return errors.WithDetailf(errRepairSpends, "store tip %s is not in the header index", report.StoreHash.String())
+	}
+	for _, hash := range report.above {
+		blockTxs, err := s.readBlockTxs(&hash)
+		if err != nil {
+			if spentIndex {
+				return errors.WithDetailf(errRepairSpends, "block %s: %v", hash.String(), err)
+			}
+			continue
+		}
+		for outputID := range blockTxs.Spends {
+			batch.Delete(calcSpentIndexKey(&outputID))
+		}
+	}
+	return nil
+}
+
+func (s *Store) readBlockTxs(hash *bc.Hash) (*state.BlockTxs, error) {
+	block, err := decodeBlock(s.db.Get(calcBlockKey(hash)))
+	if err != nil {
+		return nil, err
+	}
+	txStatus, err := GetTransactionStatus(s.db, hash)
+	if err != nil {
+		return nil, err
+	}
+	return indexedBlockTxs(block, txStatus)
+}
+
+// removeBlocksAbove deletes the headers, bodies, statuses and undo records of
+// every block above the height, the side chain ones included, so no restart
+// brings them back. It returns the hashes of the removed blocks.
+func (s *Store) removeBlocksAbove(batch database.Batch, height uint64) []bc.Hash {
+	removed := []bc.Hash{}
+	iter := s.db.IteratorRange(calcBlockHeaderHeightKey(height+1), database.PrefixLimit(blockHeaderPrefix))
+	defer iter.Release()
+
+	for iter.Next() {
+		key := iter.Key()
+		if len(key) != len(blockHeaderPrefix)+8+32 {
+			continue
+		}
+		var hashBytes [32]byte
+		copy(hashBytes[:], key[len(blockHeaderPrefix)+8:])
+		hash := bc.NewHash(hashBytes)
+
+		batch.Delete(append([]byte(nil), key...))
+		batch.Delete(calcBlockKey(&hash))
+		batch.Delete(calcTxStatusKey(&hash))
+		batch.Delete(calcUndoKey(&hash))
+		removed = append(removed, hash)
+	}
+	return removed
+}
+
+// hasPrefix tells whether the db holds a record under the prefix
+func hasPrefix(db database.DB, prefix []byte) bool {
+	iter := db.IteratorPrefix(prefix)
+	defer iter.Release()
+	return iter.Next()
+}
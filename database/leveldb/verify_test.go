@@ -0,0 +1,293 @@
+package leveldb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/btm-stats/chaingen"
+	"github.com/btm-stats/consensus"
+	"github.com/btm-stats/database"
+	"github.com/btm-stats/database/storage"
+	"github.com/btm-stats/errors"
+	"github.com/btm-stats/protocol"
+	"github.com/btm-stats/protocol/bc"
+	"github.com/btm-stats/protocol/bc/types"
+	"github.com/btm-stats/protocol/state"
+)
+
+// verifyTestHeight is past the coinbase maturity so the chain spends outputs
+var verifyTestHeight = int(consensus.CoinbasePendingBlockNumber) + 10
+
+func newVerifyTestStatus(block *types.Block) *bc.TransactionStatus {
+	status := bc.NewTransactionStatus()
+	for i := range block.Transactions {
+		status.SetStatus(i, false)
+	}
+	return status
+}
+
+// newVerifyTestStore stores a generated main chain with its utxo set and
+// transaction indexes, like the chain status after connecting every block
+func newVerifyTestStore(t *testing.T) (*Store, []*types.Block) {
+	gen := chaingen.New(chaingen.DefaultConfig())
+	if _, err := gen.Main().Generate(verifyTestHeight); err != nil {
+		t.Fatal(err)
+	}
+	blocks := gen.Main().Blocks()
+
+	store, err := NewStore(database.NewMemDB())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetTxIndex(true, true)
+
+	view := make(map[bc.Hash]*storage.UtxoEntry)
+	txsView := &state.UtxoViewpoint{}
+	for _, block := range blocks {
+		status := newVerifyTestStatus(block)
+		if err := store.SaveBlock(block, status); err != nil {
+			t.Fatal(err)
+		}
+		if err := replayBlock(view, block, status); err != nil {
+			t.Fatal(err)
+		}
+		blockTxs, err := indexedBlockTxs(block, status)
+		if err != nil {
+			t.Fatal(err)
+		}
+		txsView.AttachedTxs = append(txsView.AttachedTxs, blockTxs)
+	}
+
+	batch := store.db.NewBatch()
+	for hash, entry := range view {
+		data, err := proto.Marshal(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		batch.Set(calcUtxoKey(&hash), data)
+	}
+	if err := store.saveTxIndexes(batch, txsView); err != nil {
+		t.Fatal(err)
+	}
+	tipHash := blocks[len(blocks)-1].Hash()
+	rawStatus, err := json.Marshal(protocol.BlockStoreState{Height: uint64(verifyTestHeight), Hash: &tipHash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch.Set(blockStoreKey, rawStatus)
+	if err := batch.Write(); err != nil {
+		t.Fatal(err)
+	}
+	return store, blocks
+}
+
+func TestVerify(t *testing.T) {
+	const corruptHeight = 50
+
+	cases := []struct {
+		desc       string
+		corrupt    func(store *Store, blocks []*types.Block)
+		wantHeight uint64
+		wantReason string
+	}{
+		{
+			desc:    "consistent store",
+			corrupt: func(*Store, []*types.Block) {},
+		},
+		{
+			desc: "missing body",
+			corrupt: func(store *Store, blocks []*types.Block) {
+				hash := blocks[corruptHeight].Hash()
+				store.db.Delete(calcBlockKey(&hash))
+			},
+			wantHeight: corruptHeight,
+			wantReason: "block body is missing",
+		},
+		{
+			desc: "bad utxo",
+			corrupt: func(store *Store, blocks []*types.Block) {
+				coinbase := blocks[corruptHeight].Transactions[0]
+				data, _ := proto.Marshal(storage.NewUtxoEntry(true, corruptHeight+1, false))
+				store.db.Set(calcUtxoKey(coinbase.ResultIds[0]), data)
+			},
+			wantHeight: uint64(verifyTestHeight),
+			wantReason: "replay gives",
+		},
+		{
+			desc: "broken parent link",
+			corrupt: func(store *Store, blocks []*types.Block) {
+				hash := blocks[corruptHeight].Hash()
+				store.db.Delete(calcBlockHeaderKey(corruptHeight, &hash))
+			},
+			wantHeight: corruptHeight + 1,
+			wantReason: "is not in the header index",
+		},
+	}
+
+	for _, c := range cases {
+		store, blocks := newVerifyTestStore(t)
+		c.corrupt(store, blocks)
+
+		report, err := store.Verify()
+		if err != nil {
+			t.Fatalf("%s: %v", c.desc, err)
+		}
+		if c.wantReason == "" {
+			if report.Err != nil || report.BestHeight != uint64(verifyTestHeight) || report.Blocks != len(blocks) {
+				t.Errorf("%s: got err %v best height %d blocks %d, want no err at %d", c.desc, report.Err, report.BestHeight, report.Blocks, verifyTestHeight)
+			}
+			continue
+		}
+
+		if report.Err == nil || report.Err.Height != c.wantHeight || !strings.Contains(report.Err.Reason, c.wantReason) {
+			t.Errorf("%s: got err %v, want %q at height %d", c.desc, report.Err, c.wantReason, c.wantHeight)
+		}
+	}
+}
+
+func TestVerifyBoundsReplayView(t *testing.T) {
+	store, _ := newVerifyTestStore(t)
+	report, err := store.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the replay view holds the unspent outputs and the spent coinbases only
+	for hash, entry := range report.view {
+		if entry.Spent && !entry.IsCoinBase {
+			t.Fatalf("replay view keeps the spent output %s", hash.String())
+		}
+	}
+	if len(report.view) != report.Utxos {
+		t.Errorf("got %d replayed utxos, want the %d stored", len(report.view), report.Utxos)
+	}
+}
+
+func TestRepairTip(t *testing.T) {
+	const corruptHeight = uint64(100)
+
+	store, blocks := newVerifyTestStore(t)
+	// the status of the block no longer matches its header
+	corrupted := blocks[corruptHeight].Hash()
+	status := newVerifyTestStatus(blocks[corruptHeight])
+	status.SetStatus(0, true)
+	data, err := proto.Marshal(status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.db.Set(calcTxStatusKey(&corrupted), data)
+	for _, block := range blocks[corruptHeight:] {
+		hash := block.Hash()
+		store.db.Set(calcUndoKey(&hash), []byte{0})
+	}
+
+	report, err := store.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Err == nil || report.BestHeight != corruptHeight-1 {
+		t.Fatalf("got err %v best height %d, want an err above %d", report.Err, report.BestHeight, corruptHeight-1)
+	}
+
+	// the analytics derived past the tip can't be detached afterwards
+	followerKey := append(append([]byte{}, followerPrefix...), "richlist"...)
+	buf := make([]byte, 40)
+	binary.BigEndian.PutUint64(buf, corruptHeight)
+	store.db.Set(followerKey, buf)
+	if err := store.RepairTip(report); errors.Root(err) != errRepairDerived {
+		t.Fatalf("got err %v, want %v", err, errRepairDerived)
+	}
+	binary.BigEndian.PutUint64(buf, corruptHeight-1)
+	store.db.Set(followerKey, buf)
+
+	if err := store.RepairTip(report); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err = store.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Err != nil || report.StoreHeight != corruptHeight-1 || report.BestHeight != corruptHeight-1 {
+		t.Fatalf("got err %v store height %d best height %d after the repair, want no err at %d", report.Err, report.StoreHeight, report.BestHeight, corruptHeight-1)
+	}
+
+	for _, block := range blocks[corruptHeight:] {
+		hash := block.Hash()
+		for _, key := range [][]byte{calcBlockHeaderKey(block.Height, &hash), calcBlockKey(&hash), calcTxStatusKey(&hash), calcUndoKey(&hash)} {
+			if store.db.Get(key) != nil {
+				t.Fatalf("key %s of the block at height %d is left", FormatKey(key), block.Height)
+			}
+		}
+		for _, tx := range block.Transactions {
+			if store.db.Get(calcTxIndexKey(&tx.ID)) != nil {
+				t.Fatalf("tx %s of the block at height %d is still indexed", tx.ID.String(), block.Height)
+			}
+			for _, prevout := range tx.SpentOutputIDs {
+				if store.db.Get(calcSpentIndexKey(&prevout)) != nil {
+					t.Fatalf("spent output %s of the block at height %d is still indexed", prevout.String(), block.Height)
+				}
+			}
+		}
+	}
+	kept := blocks[corruptHeight-1].Transactions[0].ID
+	if _, err := store.GetTxLocation(&kept); err != nil {
+		t.Errorf("the tx of the repaired tip isn't indexed: %v", err)
+	}
+}
+
+func TestRepairTipRefusals(t *testing.T) {
+	cases := []struct {
+		desc    string
+		corrupt func(store *Store, blocks []*types.Block)
+		wantErr error
+	}{
+		{
+			desc: "headers mode store",
+			corrupt: func(store *Store, blocks []*types.Block) {
+				hash := blocks[50].Hash()
+				store.db.Delete(calcBlockKey(&hash))
+				store.db.Set(headersStoreKey, []byte{1})
+			},
+			wantErr: errHeadersStore,
+		},
+		{
+			desc: "pruned store",
+			corrupt: func(store *Store, blocks []*types.Block) {
+				hash := blocks[50].Hash()
+				store.db.Delete(calcBlockKey(&hash))
+				pruned := make([]byte, 8)
+				binary.BigEndian.PutUint64(pruned, 10)
+				store.db.Set(prunedHeightKey, pruned)
+			},
+			wantErr: errPrunedStore,
+		},
+		{
+			desc: "spent index of an unreadable block",
+			corrupt: func(store *Store, blocks []*types.Block) {
+				hash := blocks[50].Hash()
+				store.db.Delete(calcBlockKey(&hash))
+			},
+			wantErr: errRepairSpends,
+		},
+	}
+
+	for _, c := range cases {
+		store, blocks := newVerifyTestStore(t)
+		hash := blocks[50].Hash()
+		store.db.Delete(calcTxStatusKey(&hash))
+		report, err := store.Verify()
+		if err != nil {
+			t.Fatalf("%s: %v", c.desc, err)
+		}
+
+		c.corrupt(store, blocks)
+		if err := store.RepairTip(report); errors.Root(err) != c.wantErr {
+			t.Errorf("%s: got err %v, want %v", c.desc, err, c.wantErr)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package database
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+)
+
+func init() {
+	registerDBCreator(MemDBBackend, func(name string, dir string) (DB, error) {
+		return NewMemDB(), nil
+	})
+}
+
+// MemDB keeps the store in memory, it's meant for the tests and the tools
+// replaying the chain
+type MemDB struct {
+	mtx sync.RWMutex
+	db  map[string][]byte
+}
+
+// NewMemDB returns an empty in-memory store
+func NewMemDB() *MemDB {
+	return &MemDB{db: make(map[string][]byte)}
+}
+
+// Get returns the value of the key
+func (db *MemDB) Get(key []byte) []byte {
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+	return db.db[string(key)]
+}
+
+// Has checks whether the key is set
+func (db *MemDB) Has(key []byte) bool {
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+	_, ok := db.db[string(key)]
+	return ok
+}
+
+// Set sets the value of the key
+func (db *MemDB) Set(key, value []byte) {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	db.db[string(key)] = append([]byte{}, value...)
+}
+
+// SetSync sets the value of the key, there is nothing to sync in memory
+func (db *MemDB) SetSync(key, value []byte) {
+	db.Set(key, value)
+}
+
+// Delete removes the key
+func (db *MemDB) Delete(key []byte) {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	delete(db.db, string(key))
+}
+
+// NewBatch returns a batch applied under a single lock
+func (db *MemDB) NewBatch() Batch {
+	return &memBatch{db: db}
+}
+
+// IteratorRange iterates the keys of [start, limit) as they were at the call
+func (db *MemDB) IteratorRange(start, limit []byte) Iterator {
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+	return newMemIterator(db.db, start, limit)
+}
+
+// IteratorPrefix iterates the keys starting with the prefix
+func (db *MemDB) IteratorPrefix(prefix []byte) Iterator {
+	return db.IteratorRange(prefix, PrefixLimit(prefix))
+}
+
+// NewSnapshot copies the store
+func (db *MemDB) NewSnapshot() (Snapshot, error) {
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+
+	snapshot := &MemDB{db: make(map[string][]byte, len(db.db))}
+	for key, value := range db.db {
+		snapshot.db[key] = value
+	}
+	return &memSnapshot{snapshot}, nil
+}
+
+// Close does nothing, the content lives as long as the MemDB
+func (db *MemDB) Close() {}
+
+type memSnapshot struct {
+	*MemDB
+}
+
+func (s *memSnapshot) Release() {}
+
+type memBatch struct {
+	db  *MemDB
+	ops []memOp
+}
+
+type memOp struct {
+	key    []byte
+	value  []byte
+	delete bool
+}
+
+func (b *memBatch) Set(key, value []byte) {
+	b.ops = append(b.ops, memOp{key: append([]byte{}, key...), value: append([]byte{}, value...)})
+}
+
+func (b *memBatch) Delete(key []byte) {
+	b.ops = append(b.ops, memOp{key: append([]byte{}, key...), delete: true})
+}
+
+func (b *memBatch) Write() error {
+	b.db.mtx.Lock()
+	defer b.db.mtx.Unlock()
+
+	for _, op := range b.ops {
+		if op.delete {
+			delete(b.db.db, string(op.key))
+		} else {
+			b.db.db[string(op.key)] = op.value
+		}
+	}
+	return nil
+}
+
+// kvIterator walks key-value pairs already sorted by key
+type kvIterator struct {
+	keys   [][]byte
+	values [][]byte
+	cur    int
+}
+
+// newMemIterator collects the pairs of [start, limit), the caller holds the
+// lock of the map
+func newMemIterator(db map[string][]byte, start, limit []byte) *kvIterator {
+	keys := []string{}
+	for key := range db {
+		if start != nil && bytes.Compare([]byte(key), start) < 0 {
+			continue
+		}
+		if limit != nil && bytes.Compare([]byte(key), limit) >= 0 {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	iter := &kvIterator{cur: -1}
+	for _, key := range keys {
+		iter.keys = append(iter.keys, []byte(key))
+		iter.values = append(iter.values, db[key])
+	}
+	return iter
+}
+
+func (it *kvIterator) Next() bool {
+	if it.cur >= len(it.keys) {
+		return false
+	}
+	it.cur++
+	return it.cur < len(it.keys)
+}
+
+func (it *kvIterator) Key() []byte {
+	if it.cur < 0 || it.cur >= len(it.keys) {
+		return nil
+	}
+	return it.keys[it.cur]
+}
+
+func (it *kvIterator) Value() []byte {
+	if it.cur < 0 || it.cur >= len(it.keys) {
+		return nil
+	}
+	return it.values[it.cur]
+}
+
+func (it *kvIterator) Release() {
+	it.keys, it.values = nil, nil
+}
+
+func (it *kvIterator) Error() error {
+	return nil
+}
@@ -0,0 +1,22 @@
+// Package json holds the helpers rendering the chain types in JSON
+package json
+
+import "encoding/hex"
+
+// HexBytes is a byte slice rendered in hex instead of base64
+type HexBytes []byte
+
+// MarshalText fulfills the encoding.TextMarshaler interface.
+func (h HexBytes) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(h)), nil
+}
+
+// UnmarshalText fulfills the encoding.TextUnmarshaler interface.
+func (h *HexBytes) UnmarshalText(text []byte) error {
+	b := make([]byte, hex.DecodedLen(len(text)))
+	if _, err := hex.Decode(b, text); err != nil {
+		return err
+	}
+	*h = b
+	return nil
+}
@@ -0,0 +1,84 @@
+// Package checked implements basic arithmetic operations on integers that
+// report whether the result overflowed.
+package checked
+
+import (
+	"errors"
+	"math"
+)
+
+// ErrOverflow is the common error of overflowed operations
+var ErrOverflow = errors.New("arithmetic overflow")
+
+// AddInt64 returns a + b with an integer overflow check.
+func AddInt64(a, b int64) (sum int64, ok bool) {
+	if (b > 0 && a > math.MaxInt64-b) || (b < 0 && a < math.MinInt64-b) {
+		return 0, false
+	}
+	return a + b, true
+}
+
+// SubInt64 returns a - b with an integer overflow check.
+func SubInt64(a, b int64) (diff int64, ok bool) {
+	if (b > 0 && a < math.MinInt64+b) || (b < 0 && a > math.MaxInt64+b) {
+		return 0, false
+	}
+	return a - b, true
+}
+
+// MulInt64 returns a * b with an integer overflow check.
+func MulInt64(a, b int64) (product int64, ok bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	if (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+		return 0, false
+	}
+	c := a * b
+	return c, c/b == a
+}
+
+// DivInt64 returns a / b with an integer overflow check, division by zero
+// is reported as overflow too.
+func DivInt64(a, b int64) (quotient int64, ok bool) {
+	if b == 0 || (a == math.MinInt64 && b == -1) {
+		return 0, false
+	}
+	return a / b, true
+}
+
+// ModInt64 returns a % b with an integer overflow check, division by zero
+// is reported as overflow too.
+func ModInt64(a, b int64) (remainder int64, ok bool) {
+	if b == 0 || (a == math.MinInt64 && b == -1) {
+		return 0, false
+	}
+	return a % b, true
+}
+
+// NegateInt64 returns -a with an integer overflow check.
+func NegateInt64(a int64) (negated int64, ok bool) {
+	if a == math.MinInt64 {
+		return 0, false
+	}
+	return -a, true
+}
+
+// LshiftInt64 returns a << b with an integer overflow check.
+func LshiftInt64(a, b int64) (result int64, ok bool) {
+	if b < 0 || b >= 64 {
+		return 0, false
+	}
+	c := a << uint64(b)
+	return c, c>>uint64(b) == a
+}
+
+// AddUint64 returns a + b with an integer overflow check.
+func AddUint64(a, b uint64) (sum uint64, ok bool) {
+	return a + b, math.MaxUint64-a >= b
+}
+
+// AddUint32 returns a + b with an integer overflow check.
+func AddUint32(a, b uint32) (sum uint32, ok bool) {
+	return a + b, math.MaxUint32-a >= b
+}
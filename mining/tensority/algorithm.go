@@ -0,0 +1,20 @@
+// Package tensority implements the CPU verification of Tensority, the proof
+// of work of Bytom. The hash of a block header walks a chain of int8 matrix
+// multiplications over matrices derived from the seed of its epoch.
+package tensority
+
+import (
+	"github.com/btm-stats/protocol/bc"
+)
+
+var defaultSeedCache = newSeedCache(maxSeedCached)
+
+// Hash returns the Tensority hash of the block header hash under seed, the
+// seed matrices are kept in a LRU cache shared by all callers
+func Hash(hash, seed *bc.Hash) *bc.Hash {
+	return hashWithMatrices(hash, defaultSeedCache.get(seed))
+}
+
+func hashWithMatrices(hash *bc.Hash, matrices []int8) *bc.Hash {
+	return hashMatrix(mulMatrix(hash.Bytes(), matrices))
+}
@@ -0,0 +1,154 @@
+package tensority
+
+import (
+	"testing"
+
+	"github.com/btm-stats/consensus"
+	"github.com/btm-stats/protocol/bc"
+)
+
+func TestSeedCacheReuse(t *testing.T) {
+	cache := newSeedCache(maxSeedCached)
+	matrices := cache.get(consensus.InitialSeed)
+	if again := cache.get(consensus.InitialSeed); &again[0] != &matrices[0] {
+		t.Error("cached seed matrices are derived again")
+	}
+
+	fresh := calcSeedMatrices(consensus.InitialSeed)
+	for i := range fresh {
+		if fresh[i] != matrices[i] {
+			t.Fatalf("seed matrices differ at %d", i)
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping tensority hash in short mode")
+	}
+
+	hash := &bc.Hash{V0: 1, V1: 2, V2: 3, V3: 4}
+	want := Hash(hash, consensus.InitialSeed)
+	if got := Hash(hash, consensus.InitialSeed); *got != *want {
+		t.Errorf("got hash %x, want %x", got.Bytes(), want.Bytes())
+	}
+
+	other := &bc.Hash{V0: 1, V1: 2, V2: 3, V3: 5}
+	if got := Hash(other, consensus.InitialSeed); *got == *want {
+		t.Errorf("different headers got the same hash %x", got.Bytes())
+	}
+}
+
+func TestHashKnownAnswers(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping tensority hash in short mode")
+	}
+
+	cases := []struct {
+		blockHeader [32]byte
+		seed        [32]byte
+		hash        [32]byte
+	}{
+		{
+			// the known answer of the upstream Bytom implementation
+			blockHeader: [32]byte{
+				0xd0, 0xda, 0xd7, 0x3f, 0xb2, 0xda, 0xbf, 0x33,
+				0x53, 0xfd, 0xa1, 0x55, 0x71, 0xb4, 0xe5, 0xf6,
+				0xac, 0x62, 0xff, 0x18, 0x7b, 0x35, 0x4f, 0xad,
+				0xd4, 0x84, 0x0d, 0x9f, 0xf2, 0xf1, 0xaf, 0xdf,
+			},
+			seed: [32]byte{
+				0x07, 0x37, 0x52, 0x07, 0x81, 0x34, 0x5b, 0x11,
+				0xb7, 0xbd, 0x0f, 0x84, 0x3c, 0x1b, 0xdd, 0x9a,
+				0xea, 0x81, 0xb6, 0xda, 0x94, 0xfd, 0x14, 0x1c,
+				0xc9, 0xf2, 0xdf, 0x53, 0xac, 0x67, 0x44, 0xd2,
+			},
+			hash: [32]byte{
+				0xe3, 0x5d, 0xa5, 0x47, 0x95, 0xd8, 0x2f, 0x85,
+				0x49, 0xc0, 0xe5, 0x80, 0xcb, 0xf2, 0xe3, 0x75,
+				0x7a, 0xb5, 0xef, 0x8f, 0xed, 0x1b, 0xdb, 0xe4,
+				0x39, 0x41, 0x6c, 0x7e, 0x6f, 0x8d, 0xf2, 0x27,
+			},
+		},
+		{
+			// the zero header and seed, pinned to catch a change of the
+			// int8 fold on a degenerate input
+			hash: [32]byte{
+				0xc5, 0xd4, 0xa1, 0x9c, 0xe8, 0x42, 0xfe, 0xe4,
+				0x09, 0x69, 0x6d, 0x14, 0xe4, 0x83, 0xf9, 0xef,
+				0xe4, 0xa7, 0xec, 0xc0, 0x36, 0xd1, 0xcf, 0xeb,
+				0xa0, 0x19, 0x9f, 0x13, 0xf1, 0x4d, 0xc9, 0x0f,
+			},
+		},
+	}
+
+	for i, c := range cases {
+		blockHeader, seed := bc.NewHash(c.blockHeader), bc.NewHash(c.seed)
+		if got, want := Hash(&blockHeader, &seed), bc.NewHash(c.hash); *got != want {
+			t.Errorf("case %d: got hash %x, want %x", i, got.Bytes(), want.Bytes())
+		}
+	}
+}
+
+func TestSeedCacheEviction(t *testing.T) {
+	cache := newSeedCache(2)
+	seeds := []*bc.Hash{{V0: 1}, {V0: 2}, {V0: 1}, {V0: 3}}
+	for _, seed := range seeds {
+		cache.get(seed)
+	}
+
+	if cache.len() != 2 {
+		t.Fatalf("got %d cached seeds, want 2", cache.len())
+	}
+	if cache.contains(&bc.Hash{V0: 2}) {
+		t.Error("the least recently used seed isn't evicted")
+	}
+	for _, seed := range []*bc.Hash{{V0: 1}, {V0: 3}} {
+		if !cache.contains(seed) {
+			t.Errorf("seed %x is evicted", seed.Bytes())
+		}
+	}
+}
+
+func TestMulTransposedIdentity(t *testing.T) {
+	// the fold keeps the non negative int8 products as they are
+	m := make([]int8, matSize*matSize)
+	for i := range m {
+		m[i] = int8(i * 7 % 128)
+	}
+
+	dst := make([]int8, matSize*matSize)
+	mulTransposed(dst, identityMatrix(), m)
+	for row := 0; row < matSize; row++ {
+		for col := 0; col < matSize; col++ {
+			if got, want := dst[row*matSize+col], m[col*matSize+row]; got != want {
+				t.Fatalf("element %d,%d got %d, want %d", row, col, got, want)
+			}
+		}
+	}
+}
+
+func BenchmarkHash(b *testing.B) {
+	hash := &bc.Hash{V0: 1, V1: 2, V2: 3, V3: 4}
+	Hash(hash, consensus.InitialSeed)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		hash.V0 = uint64(i)
+		Hash(hash, consensus.InitialSeed)
+	}
+}
+
+func BenchmarkHashColdSeed(b *testing.B) {
+	hash := &bc.Hash{V0: 1, V1: 2, V2: 3, V3: 4}
+	for i := 0; i < b.N; i++ {
+		seed := &bc.Hash{V0: uint64(i)}
+		hashWithMatrices(hash, calcSeedMatrices(seed))
+	}
+}
+
+func BenchmarkSeedMatrices(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		calcSeedMatrices(&bc.Hash{V0: uint64(i)})
+	}
+}
@@ -0,0 +1,63 @@
+package tensority
+
+import (
+	"sync"
+
+	"github.com/golang/groupcache/lru"
+
+	"github.com/btm-stats/protocol/bc"
+)
+
+// maxSeedCached bounds the seed matrices kept in memory, each of them takes
+// 16MB. The seed only changes every SeedPerRetarget blocks so a syncing node
+// keeps hitting the newest entry, the older ones serve forks across a seed
+// boundary.
+const maxSeedCached = 4
+
+type seedMatrices struct {
+	once sync.Once
+	data []int8
+}
+
+// seedCache is a LRU cache of the matrices derived from the seeds
+type seedCache struct {
+	mtx      sync.Mutex
+	lruCache *lru.Cache
+}
+
+func newSeedCache(size int) *seedCache {
+	return &seedCache{lruCache: lru.New(size)}
+}
+
+// get returns the matrices of the seed, deriving them on a cache miss
+func (c *seedCache) get(seed *bc.Hash) []int8 {
+	c.mtx.Lock()
+	var matrices *seedMatrices
+	if v, ok := c.lruCache.Get(*seed); ok {
+		matrices = v.(*seedMatrices)
+	} else {
+		matrices = &seedMatrices{}
+		c.lruCache.Add(*seed, matrices)
+	}
+	c.mtx.Unlock()
+
+	// the derivation runs outside of the lock so the other seeds aren't
+	// blocked, concurrent callers of the same seed wait for a single one
+	matrices.once.Do(func() {
+		matrices.data = calcSeedMatrices(seed)
+	})
+	return matrices.data
+}
+
+func (c *seedCache) len() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.lruCache.Len()
+}
+
+func (c *seedCache) contains(seed *bc.Hash) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	_, ok := c.lruCache.Get(*seed)
+	return ok
+}
@@ -0,0 +1,118 @@
+package tensority
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/btm-stats/crypto/sha3pool"
+	"github.com/btm-stats/protocol/bc"
+)
+
+const (
+	matSize   = 1 << 8 // rows and columns of a matrix
+	matNum    = 1 << 8 // number of matrices derived from a seed
+	mulLanes  = 4      // independent multiplication chains, one per 8 bytes of the header hash
+	mulRounds = 2      // times a lane walks its sequence of 32 matrices
+)
+
+// mulMatrix multiplies the seed matrices in the order picked by the header
+// hash. Every lane starts from the identity and folds each product back to
+// int8, the lanes are summed into the result.
+func mulMatrix(headerHash []byte, matrices []int8) []uint8 {
+	lanes := make([][]int8, mulLanes)
+	var wg sync.WaitGroup
+	for i := 0; i < mulLanes; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			var sequence [32]byte
+			sha3pool.Sum256(sequence[:], headerHash[i*8:(i+1)*8])
+
+			ma := identityMatrix()
+			mc := make([]int8, matSize*matSize)
+			for round := 0; round < mulRounds; round++ {
+				for _, index := range sequence {
+					mb := matrices[int(index)*matSize*matSize : (int(index)+1)*matSize*matSize]
+					mulTransposed(mc, ma, mb)
+					ma, mc = mc, ma
+				}
+			}
+			lanes[i] = ma
+		}(i)
+	}
+	wg.Wait()
+
+	result := make([]uint8, matSize*matSize)
+	for i := range result {
+		var sum int32
+		for _, lane := range lanes {
+			sum += int32(lane[i])
+		}
+		result[i] = uint8(sum)
+	}
+	return result
+}
+
+// mulTransposed sets dst to a multiplied by the transpose of b, each element
+// is folded to int8 by adding its two low bytes
+func mulTransposed(dst, a, b []int8) {
+	for row := 0; row < matSize; row++ {
+		ar := a[row*matSize : (row+1)*matSize]
+		for col := 0; col < matSize; col++ {
+			br := b[col*matSize : (col+1)*matSize]
+			br = br[:len(ar)]
+
+			var v int32
+			for k, x := range ar {
+				v += int32(x) * int32(br[k])
+			}
+			dst[row*matSize+col] = int8((v & 0xff) + ((v >> 8) & 0xff))
+		}
+	}
+}
+
+func identityMatrix() []int8 {
+	m := make([]int8, matSize*matSize)
+	for i := 0; i < matSize; i++ {
+		m[i*matSize+i] = 1
+	}
+	return m
+}
+
+// hashMatrix packs every row of the result into 64 words, reduces the rows
+// pairwise with fnv and hashes the remaining row with SHA3-256
+func hashMatrix(result []uint8) *bc.Hash {
+	var mat32 [matSize][matSize / 4]uint32
+	for i := 0; i < matSize; i++ {
+		row := result[i*matSize : (i+1)*matSize]
+		for j := 0; j < matSize/4; j++ {
+			mat32[i][j] = uint32(row[j+192])<<24 |
+				uint32(row[j+128])<<16 |
+				uint32(row[j+64])<<8 |
+				uint32(row[j])
+		}
+	}
+
+	for k := matSize; k > 1; k = k / 2 {
+		for j := 0; j < k/2; j++ {
+			for i := 0; i < matSize/4; i++ {
+				mat32[j][i] = fnv(mat32[j][i], mat32[j+k/2][i])
+			}
+		}
+	}
+
+	data := make([]byte, matSize)
+	for i, w := range mat32[0] {
+		binary.LittleEndian.PutUint32(data[i*4:], w)
+	}
+
+	var h [32]byte
+	sha3pool.Sum256(h[:], data)
+	hash := bc.NewHash(h)
+	return &hash
+}
+
+func fnv(a, b uint32) uint32 {
+	return a*0x01000193 ^ b
+}
@@ -0,0 +1,51 @@
+package tensority
+
+import (
+	"github.com/btm-stats/crypto/sha3pool"
+	"github.com/btm-stats/protocol/bc"
+)
+
+// smixRounds is the number of smix rounds needed to fill the seed matrices
+const smixRounds = matNum * matSize * matSize / 4 / (smixN * smixWords)
+
+// extendBytes appends round chained SHA3-256 hashes of the seed to it
+func extendBytes(seed []byte, round int) []byte {
+	extSeed := make([]byte, len(seed)*(round+1))
+	copy(extSeed, seed)
+	for i := 0; i < round; i++ {
+		sha3pool.Sum256(extSeed[(i+1)*32:(i+2)*32], extSeed[i*32:(i+1)*32])
+	}
+	return extSeed
+}
+
+// calcSeedMatrices derives the matNum matrices of the seed. The seed is
+// extended to a 128 bytes smix block and every smix round fills 128KB of the
+// matrices with its intermediate blocks.
+func calcSeedMatrices(seed *bc.Hash) []int8 {
+	extSeed := extendBytes(seed.Bytes(), 3)
+	v := make([]uint32, smixN*smixWords)
+	matrices := make([]int8, matNum*matSize*matSize)
+
+	const half = smixN / 2
+	for round := 0; round < smixRounds; round++ {
+		smix(extSeed, v)
+
+		// the first and the second halves of the block pairs are laid out
+		// apart from each other
+		start := round * len(v)
+		for j := 0; j < half; j++ {
+			putWords(matrices, start+j*32, v[j*64:j*64+32])
+			putWords(matrices, start+half*32+j*32, v[j*64+32:j*64+64])
+		}
+	}
+	return matrices
+}
+
+// putWords writes the little endian bytes of words into the matrices from
+// the word offset on
+func putWords(matrices []int8, offset int, words []uint32) {
+	for i, w := range words {
+		b := matrices[(offset+i)*4 : (offset+i)*4+4]
+		b[0], b[1], b[2], b[3] = int8(w), int8(w>>8), int8(w>>16), int8(w>>24)
+	}
+}
@@ -0,0 +1,61 @@
+package tensority
+
+import (
+	"encoding/binary"
+
+	"golang.org/x/crypto/salsa20/salsa"
+)
+
+const (
+	smixN     = 1 << 10 // CPU and memory cost of a smix round
+	smixWords = 32      // uint32 words of a smix block, scrypt's r is 1
+)
+
+// smix is the sequential memory-hard mixing function of scrypt, b is mixed
+// in place and v is left with the N intermediate blocks
+func smix(b []byte, v []uint32) {
+	var x [smixWords]uint32
+	for i := range x {
+		x[i] = binary.LittleEndian.Uint32(b[i*4:])
+	}
+
+	for i := 0; i < smixN; i++ {
+		copy(v[i*smixWords:], x[:])
+		blockMix(&x)
+	}
+	for i := 0; i < smixN; i++ {
+		j := int(x[16] & (smixN - 1))
+		for k := range x {
+			x[k] ^= v[j*smixWords+k]
+		}
+		blockMix(&x)
+	}
+
+	for i, w := range x {
+		binary.LittleEndian.PutUint32(b[i*4:], w)
+	}
+}
+
+// blockMix chains salsa20/8 over the two 64 bytes halves of the block
+func blockMix(x *[smixWords]uint32) {
+	var t [16]uint32
+	copy(t[:], x[16:])
+	for i := 0; i < 2; i++ {
+		for k := range t {
+			t[k] ^= x[i*16+k]
+		}
+		salsa208(&t)
+		copy(x[i*16:], t[:])
+	}
+}
+
+func salsa208(t *[16]uint32) {
+	var in, out [64]byte
+	for i, w := range t {
+		binary.LittleEndian.PutUint32(in[i*4:], w)
+	}
+	salsa.Core208(&out, &in)
+	for i := range t {
+		t[i] = binary.LittleEndian.Uint32(out[i*4:])
+	}
+}
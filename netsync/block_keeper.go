@@ -9,6 +9,7 @@ import (
 	"github.com/btm-stats/p2p"
 	"github.com/btm-stats/protocol"
 	"github.com/btm-stats/protocol/bc/types"
+	"github.com/btm-stats/protocol/validation"
 )
 
 const (
@@ -18,37 +19,46 @@ const (
 	syncTimeout        = 30 * time.Second
 	requestRetryTicker = 15 * time.Second
 
-	maxBlocksPending = 1024
-	maxtxsPending    = 32768
-	maxQuitReq       = 256
+	maxBlocksPending  = 1024
+	maxHeadersPending = 16
+	maxtxsPending     = 32768
+	maxQuitReq        = 256
 )
 
 var (
-	errGetBlockTimeout = errors.New("Get block Timeout")
-	errPeerDropped     = errors.New("Peer dropped")
-	errGetBlockByHash  = errors.New("Get block by hash error")
-	errBroadcastStatus = errors.New("Broadcast new status block error")
-	errReqBlock        = errors.New("Request block error")
-	errPeerNotRegister = errors.New("peer is not registered")
+	errGetBlockTimeout   = errors.New("Get block Timeout")
+	errGetHeadersTimeout = errors.New("Get headers timeout")
+	errPeerDropped       = errors.New("Peer dropped")
+	errGetBlockByHash    = errors.New("Get block by hash error")
+	errBroadcastStatus   = errors.New("Broadcast new status block error")
+	errReqBlock          = errors.New("Request block error")
+	errPeerNotRegister   = errors.New("peer is not registered")
+	errBlockNotFound     = errors.New("peer hasn't the block")
 )
 
 //TODO: add retry mechanism
 type blockKeeper struct {
 	chain *protocol.Chain
 	sw    *p2p.Switch
-	peers *peerSet
+	peers   *peerSet
+	rejects *validation.RejectCounter
 
 	pendingProcessCh chan *blockPending
+	headersProcessCh chan *headersPending
+	notFoundCh       chan *blockNotFound
 	txsProcessCh     chan *txsNotify
 	quitReqBlockCh   chan *string
 }
 
-func newBlockKeeper(chain *protocol.Chain, sw *p2p.Switch, peers *peerSet, quitReqBlockCh chan *string) *blockKeeper {
+func newBlockKeeper(chain *protocol.Chain, sw *p2p.Switch, peers *peerSet, rejects *validation.RejectCounter, quitReqBlockCh chan *string) *blockKeeper {
 	bk := &blockKeeper{
 		chain:            chain,
 		sw:               sw,
 		peers:            peers,
+		rejects:          rejects,
 		pendingProcessCh: make(chan *blockPending, maxBlocksPending),
+		headersProcessCh: make(chan *headersPending, maxHeadersPending),
+		notFoundCh:       make(chan *blockNotFound, maxHeadersPending),
 		txsProcessCh:     make(chan *txsNotify, maxtxsPending),
 		quitReqBlockCh:   quitReqBlockCh,
 	}
@@ -60,12 +70,32 @@ func (bk *blockKeeper) AddBlock(block *types.Block, peerID string) {
 	bk.pendingProcessCh <- &blockPending{block: block, peerID: peerID}
 }
 
+// AddHeaders hands the headers to the pending request, the unsolicited ones
+// are dropped once the queue is full rather than blocking the receive routine
+func (bk *blockKeeper) AddHeaders(headers []*types.BlockHeader, peerID string) {
+	select {
+	case bk.headersProcessCh <- &headersPending{headers: headers, peerID: peerID}:
+	default:
+		log.WithField("peerID", peerID).Warning("drop unsolicited headers")
+	}
+}
+
+// BlockNotFound hands the refusal of a block request to the pending request,
+// the unsolicited ones are dropped once the queue is full
+func (bk *blockKeeper) BlockNotFound(height uint64, pruned bool, peerID string) {
+	select {
+	case bk.notFoundCh <- &blockNotFound{height: height, pruned: pruned, peerID: peerID}:
+	default:
+		log.WithField("peerID", peerID).Warning("drop unsolicited block not found")
+	}
+}
+
 func (bk *blockKeeper) AddTx(tx *types.Tx, peerID string) {
 	bk.txsProcessCh <- &txsNotify{tx: tx, peerID: peerID}
 }
 
 func (bk *blockKeeper) IsCaughtUp() bool {
-	_, height := bk.peers.BestPeer()
+	_, height := bk.peers.BestPeer(0)
 	return bk.chain.BestBlockHeight() < height
 }
 
@@ -88,6 +118,10 @@ func (bk *blockKeeper) BlockRequestWorker(peerID string, maxPeerHeight uint64) e
 			reqNum = num
 		}
 		block, err := bk.BlockRequest(peerID, reqNum)
+		if errors.Root(err) == errPeerPruned || errors.Root(err) == errBlockNotFound {
+			log.WithFields(log.Fields{"peerID": peerID, "height": reqNum}).Info(err)
+			break
+		}
 		if errors.Root(err) == errPeerDropped || errors.Root(err) == errGetBlockTimeout || errors.Root(err) == errReqBlock {
 			log.WithField("Peer abnormality. PeerID: ", peerID).Info(err)
 			if bkPeer == nil {
@@ -100,6 +134,7 @@ func (bk *blockKeeper) BlockRequestWorker(peerID string, maxPeerHeight uint64) e
 		}
 		isOrphan, err = bk.chain.ProcessBlock(block)
 		if err != nil {
+			reason := bk.rejects.Add(err)
 			if bkPeer == nil {
 				log.Info("peer is deleted")
 				break
@@ -108,7 +143,7 @@ func (bk *blockKeeper) BlockRequestWorker(peerID string, maxPeerHeight uint64) e
 				bk.sw.AddBannedPeer(swPeer)
 				bk.sw.StopPeerGracefully(swPeer)
 			}
-			log.WithField("hash:", block.Hash()).Errorf("blockKeeper fail process block %v ", err)
+			log.WithFields(log.Fields{"hash": block.Hash(), "reason": reason}).Errorf("blockKeeper fail process block %v ", err)
 			break
 		}
 		if isOrphan {
@@ -153,6 +188,9 @@ func (bk *blockKeeper) BlockRequest(peerID string, height uint64) (*types.Block,
 	var block *types.Block
 
 	if err := bk.blockRequest(peerID, height); err != nil {
+		if errors.Root(err) == errPeerPruned {
+			return nil, err
+		}
 		return nil, errReqBlock
 	}
 	retryTicker := time.Tick(requestRetryTicker)
@@ -171,6 +209,14 @@ func (bk *blockKeeper) BlockRequest(peerID string, height uint64) (*types.Block,
 				continue
 			}
 			return block, nil
+		case notFound := <-bk.notFoundCh:
+			if notFound.peerID != peerID || notFound.height != height {
+				continue
+			}
+			if notFound.pruned {
+				return nil, errors.WithDetailf(errPeerPruned, "height %d", height)
+			}
+			return nil, errors.WithDetailf(errBlockNotFound, "height %d", height)
 		case <-retryTicker:
 			if err := bk.blockRequest(peerID, height); err != nil {
 				return nil, errReqBlock
@@ -187,6 +233,95 @@ func (bk *blockKeeper) BlockRequest(peerID string, height uint64) (*types.Block,
 	}
 }
 
+// HeadersRequestWorker follows the chain of the peer by its headers only, the
+// block locator lets the peer answer from the fork point
+func (bk *blockKeeper) HeadersRequestWorker(peerID string, maxPeerHeight uint64) error {
+	bkPeer, ok := bk.peers.Peer(peerID)
+	if !ok {
+		log.Info("peer is not registered")
+		return errPeerNotRegister
+	}
+	swPeer := bkPeer.getPeer()
+	for height := bk.chain.BestBlockHeight(); height < maxPeerHeight; {
+		headers, err := bk.HeadersRequest(peerID)
+		if err != nil {
+			log.WithField("Peer abnormality. PeerID: ", peerID).Info(err)
+			log.Info("Block keeper request headers error. Stop peer.")
+			bk.sw.StopPeerGracefully(swPeer)
+			return err
+		}
+		if err := bk.ProcessHeaders(peerID, headers); err != nil {
+			return err
+		}
+
+		// a peer answering nothing better than our chain is done
+		bestHeight := bk.chain.BestBlockHeight()
+		if len(headers) == 0 || bestHeight == height {
+			break
+		}
+		height = bestHeight
+	}
+	log.Info("Header sync complete. height:", bk.chain.BestBlockHeight())
+	return nil
+}
+
+// HeadersRequest asks the peer for the headers following our best chain
+func (bk *blockKeeper) HeadersRequest(peerID string) ([]*types.BlockHeader, error) {
+	if err := bk.peers.requestHeaders(peerID, bk.chain.BlockLocator()); err != nil {
+		return nil, errReqBlock
+	}
+	syncWait := time.NewTimer(syncTimeout)
+	defer syncWait.Stop()
+
+	for {
+		select {
+		case pendingResponse := <-bk.headersProcessCh:
+			if pendingResponse.peerID != peerID {
+				log.Warning("From different peer")
+				continue
+			}
+			return pendingResponse.headers, nil
+		case <-syncWait.C:
+			log.Warning("Request headers timeout")
+			return nil, errGetHeadersTimeout
+		case peerid := <-bk.quitReqBlockCh:
+			if *peerid == peerID {
+				log.Info("Quite headers request worker")
+				return nil, errPeerDropped
+			}
+		}
+	}
+}
+
+// ProcessHeaders extends the block index by the headers of the peer, the
+// peer sending an invalid header is punished like for an invalid block
+func (bk *blockKeeper) ProcessHeaders(peerID string, headers []*types.BlockHeader) error {
+	for _, header := range headers {
+		err := bk.chain.ProcessBlockHeader(header)
+		if err == nil {
+			continue
+		}
+
+		hash := header.Hash()
+		if errors.Root(err) == protocol.ErrOrphanHeader {
+			log.WithFields(log.Fields{"hash": hash.String(), "height": header.Height}).Debug("skip orphan block header")
+			return nil
+		}
+
+		reason := bk.rejects.Add(err)
+		if bkPeer, ok := bk.peers.Peer(peerID); ok {
+			if ban := bkPeer.addBanScore(20, 0, "block header process error"); ban {
+				swPeer := bkPeer.getPeer()
+				bk.sw.AddBannedPeer(swPeer)
+				bk.sw.StopPeerGracefully(swPeer)
+			}
+		}
+		log.WithFields(log.Fields{"hash": hash.String(), "reason": reason}).Errorf("blockKeeper fail process block header %v ", err)
+		return err
+	}
+	return nil
+}
+
 func (bk *blockKeeper) txsProcessWorker() {
 	for txsResponse := range bk.txsProcessCh {
 		tx := txsResponse.tx
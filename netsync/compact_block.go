@@ -0,0 +1,230 @@
+package netsync
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/btm-stats/crypto/sha3pool"
+	"github.com/btm-stats/errors"
+	"github.com/btm-stats/p2p"
+	"github.com/btm-stats/protocol"
+	"github.com/btm-stats/protocol/bc"
+	"github.com/btm-stats/protocol/bc/types"
+)
+
+const (
+	compactBlockVersion     = 1
+	maxCompactBlockTxs      = 1 << 16
+	maxPendingCompactBlocks = 16
+	compactBlockTimeout     = 30 * time.Second
+)
+
+var (
+	errCompactBlockSize  = errors.New("compact block has too many transactions")
+	errPrefilledIndex    = errors.New("compact block has a bad prefilled transaction index")
+	errUnrequestedTxs    = errors.New("block transactions aren't requested")
+	errBlockTxsMismatch  = errors.New("block transactions mismatch the request")
+	errCompactMerkleRoot = errors.New("compact block transactions mismatch the merkle root")
+	compactBlockNodeInfo = fmt.Sprintf("compact_block=%d", compactBlockVersion)
+)
+
+// supportCompactBlock checks whether the peer announced the compact block
+// protocol in its node info, the old peers keep receiving the full blocks
+func supportCompactBlock(nodeInfo *p2p.NodeInfo) bool {
+	return hasNodeInfo(nodeInfo, compactBlockNodeInfo)
+}
+
+// shortIDKey salts the short ids with the block hash and the nonce of the
+// sender, so colliding transactions can't be crafted in advance
+func shortIDKey(blockHash *bc.Hash, nonce uint64) [32]byte {
+	data := make([]byte, 40)
+	copy(data, blockHash.Bytes())
+	binary.LittleEndian.PutUint64(data[32:], nonce)
+
+	var key [32]byte
+	sha3pool.Sum256(key[:], data)
+	return key
+}
+
+// shortTxID returns the 48 bits short id of the transaction under key
+func shortTxID(key *[32]byte, txID *bc.Hash) uint64 {
+	data := make([]byte, 64)
+	copy(data, key[:])
+	copy(data[32:], txID.Bytes())
+
+	var h [32]byte
+	sha3pool.Sum256(h[:], data)
+	return binary.LittleEndian.Uint64(h[:8]) & 0xffffffffffff
+}
+
+// compactBlock is a block being rebuilt from a compact block message
+type compactBlock struct {
+	peerID   string
+	header   *types.BlockHeader
+	txs      []*types.Tx
+	fromPool []uint32 // indexes of the transactions taken from the mempool
+	missing  []uint32 // indexes of the transactions requested from the peer
+	added    time.Time
+}
+
+// block assembles the transactions once they are all known, a short id
+// collision shows up as a merkle root mismatch
+func (cb *compactBlock) block() (*types.Block, error) {
+	bcTxs := make([]*bc.Tx, len(cb.txs))
+	for i, tx := range cb.txs {
+		bcTxs[i] = tx.Tx
+	}
+
+	merkleRoot, err := bc.TxMerkleRoot(bcTxs)
+	if err != nil {
+		return nil, err
+	}
+	if merkleRoot != cb.header.TransactionsMerkleRoot {
+		return nil, errCompactMerkleRoot
+	}
+	return &types.Block{BlockHeader: *cb.header, Transactions: cb.txs}, nil
+}
+
+// txSource is the mempool the compact blocks are rebuilt from
+type txSource interface {
+	GetTransactions() []*protocol.TxDesc
+}
+
+// compactBlockPool rebuilds the compact blocks from the mempool and keeps the
+// ones waiting for their missing transactions
+type compactBlockPool struct {
+	mtx     sync.Mutex
+	txPool  txSource
+	pending map[bc.Hash]*compactBlock
+}
+
+func newCompactBlockPool(txPool txSource) *compactBlockPool {
+	return &compactBlockPool{
+		txPool:  txPool,
+		pending: make(map[bc.Hash]*compactBlock),
+	}
+}
+
+// reconstruct fills the compact block with the prefilled and the mempool
+// transactions. It returns the block when nothing is missing, otherwise the
+// indexes of the transactions to request from the peer.
+func (cp *compactBlockPool) reconstruct(peerID string, version int, header *types.BlockHeader, msg *CompactBlockMessage) (*types.Block, []uint32, error) {
+	total := len(msg.ShortIDs) + len(msg.PrefilledTxs)
+	if total > maxCompactBlockTxs {
+		return nil, nil, errors.WithDetailf(errCompactBlockSize, "%d transactions", total)
+	}
+
+	txs := make([]*types.Tx, total)
+	for _, prefilled := range msg.PrefilledTxs {
+		if int(prefilled.Index) >= total || txs[prefilled.Index] != nil {
+			return nil, nil, errors.WithDetailf(errPrefilledIndex, "index %d", prefilled.Index)
+		}
+
+		tx, err := decodeTx(prefilled.RawTx, version)
+		if err != nil {
+			return nil, nil, err
+		}
+		txs[prefilled.Index] = tx
+	}
+
+	hash := header.Hash()
+	key := shortIDKey(&hash, msg.Nonce)
+	mempool := make(map[uint64]*types.Tx)
+	for _, desc := range cp.txPool.GetTransactions() {
+		shortID := shortTxID(&key, &desc.Tx.ID)
+		if _, ok := mempool[shortID]; ok {
+			// colliding transactions of the mempool are requested instead
+			mempool[shortID] = nil
+			continue
+		}
+		mempool[shortID] = desc.Tx
+	}
+
+	cb := &compactBlock{peerID: peerID, header: header, txs: txs, added: time.Now()}
+	shortIDs := msg.ShortIDs
+	for i := range txs {
+		if txs[i] != nil {
+			continue
+		}
+
+		if tx := mempool[shortIDs[0]]; tx != nil {
+			txs[i] = tx
+			cb.fromPool = append(cb.fromPool, uint32(i))
+		} else {
+			cb.missing = append(cb.missing, uint32(i))
+		}
+		shortIDs = shortIDs[1:]
+	}
+
+	if len(cb.missing) == 0 {
+		block, err := cb.block()
+		if err == nil {
+			return block, nil, nil
+		}
+		if err != errCompactMerkleRoot || len(cb.fromPool) == 0 {
+			return nil, nil, err
+		}
+
+		// one of the mempool transactions has the short id of another, the
+		// peer sends all of them instead
+		cb.missing = cb.fromPool
+	}
+
+	cp.add(&hash, cb)
+	return nil, cb.missing, nil
+}
+
+// complete fills the missing transactions of a pending compact block with the
+// ones sent by the peer
+func (cp *compactBlockPool) complete(peerID string, version int, msg *BlockTxnMessage) (*types.Block, error) {
+	hash := msg.GetHash()
+	cp.mtx.Lock()
+	cb, ok := cp.pending[*hash]
+	if ok && cb.peerID == peerID {
+		delete(cp.pending, *hash)
+	}
+	cp.mtx.Unlock()
+
+	if !ok || cb.peerID != peerID {
+		return nil, errors.WithDetailf(errUnrequestedTxs, "block %s", hash.String())
+	}
+
+	txs, err := msg.GetTransactions(version)
+	if err != nil {
+		return nil, err
+	}
+	if len(txs) != len(cb.missing) {
+		return nil, errors.WithDetailf(errBlockTxsMismatch, "requested %d transactions, got %d", len(cb.missing), len(txs))
+	}
+
+	for i, index := range cb.missing {
+		cb.txs[index] = txs[i]
+	}
+	return cb.block()
+}
+
+// add keeps the compact block until its transactions arrive, the expired and
+// the oldest compact blocks are dropped to bound the pool
+func (cp *compactBlockPool) add(hash *bc.Hash, cb *compactBlock) {
+	cp.mtx.Lock()
+	defer cp.mtx.Unlock()
+
+	var oldestHash bc.Hash
+	var oldest *compactBlock
+	for h, pending := range cp.pending {
+		if time.Since(pending.added) > compactBlockTimeout {
+			delete(cp.pending, h)
+			continue
+		}
+		if oldest == nil || pending.added.Before(oldest.added) {
+			oldestHash, oldest = h, pending
+		}
+	}
+
+	if oldest != nil && len(cp.pending) >= maxPendingCompactBlocks {
+		delete(cp.pending, oldestHash)
+	}
+	cp.pending[*hash] = cb
+}
@@ -0,0 +1,215 @@
+package netsync
+
+import (
+	"testing"
+
+	"github.com/btm-stats/consensus"
+	"github.com/btm-stats/errors"
+	"github.com/btm-stats/protocol"
+	"github.com/btm-stats/protocol/bc"
+	"github.com/btm-stats/protocol/bc/types"
+)
+
+// testTxPool is a mempool holding the given transactions, a transaction
+// listed twice collides with itself
+type testTxPool []*types.Tx
+
+func (p testTxPool) GetTransactions() []*protocol.TxDesc {
+	descs := []*protocol.TxDesc{}
+	for _, tx := range p {
+		descs = append(descs, &protocol.TxDesc{Tx: tx})
+	}
+	return descs
+}
+
+func newCompactTestTx(source byte) *types.Tx {
+	return types.NewTx(types.TxData{
+		Version:        1,
+		SerializedSize: 100,
+		Inputs:         []*types.TxInput{types.NewSpendInput(nil, bc.NewHash([32]byte{source}), *consensus.BTMAssetID, 100, 0, opTrue)},
+		Outputs:        []*types.TxOutput{types.NewTxOutput(*consensus.BTMAssetID, 100, opTrue)},
+	})
+}
+
+func newCompactTestBlock(txs []*types.Tx) *types.Block {
+	bcTxs := []*bc.Tx{}
+	for _, tx := range txs {
+		bcTxs = append(bcTxs, tx.Tx)
+	}
+	merkleRoot, _ := bc.TxMerkleRoot(bcTxs)
+
+	return &types.Block{
+		BlockHeader: types.BlockHeader{
+			Version:         1,
+			Height:          1,
+			BlockCommitment: types.BlockCommitment{TransactionsMerkleRoot: merkleRoot},
+		},
+		Transactions: txs,
+	}
+}
+
+func TestCompactBlockReconstruct(t *testing.T) {
+	coinbase := types.NewTx(types.TxData{
+		Version:        1,
+		SerializedSize: 1,
+		Inputs:         []*types.TxInput{types.NewCoinbaseInput([]byte{1})},
+		Outputs:        []*types.TxOutput{types.NewTxOutput(*consensus.BTMAssetID, 100, opTrue)},
+	})
+	tx1, tx2, tx3, other := newCompactTestTx(1), newCompactTestTx(2), newCompactTestTx(3), newCompactTestTx(4)
+	block := newCompactTestBlock([]*types.Tx{coinbase, tx1, tx2, tx3})
+	hash := block.Hash()
+	key := shortIDKey(&hash, 7)
+
+	cases := []struct {
+		desc        string
+		pool        testTxPool
+		modify      func(*CompactBlockMessage)
+		wantMissing []uint32
+		wantErr     error
+	}{
+		{
+			desc: "all in the mempool",
+			pool: testTxPool{tx1, tx2, tx3, other},
+		},
+		{
+			desc:        "missing from the mempool",
+			pool:        testTxPool{tx1, tx3},
+			wantMissing: []uint32{2},
+		},
+		{
+			desc:        "colliding mempool short ids",
+			pool:        testTxPool{tx1, tx2, tx2, tx3},
+			wantMissing: []uint32{2},
+		},
+		{
+			// the short id of tx1 picks another mempool transaction, the
+			// merkle root mismatch requests all the mempool transactions
+			desc:        "short id of another mempool transaction",
+			pool:        testTxPool{tx2, tx3, other},
+			modify:      func(msg *CompactBlockMessage) { msg.ShortIDs[0] = shortTxID(&key, &other.ID) },
+			wantMissing: []uint32{1, 2, 3},
+		},
+		{
+			// with nothing taken from the mempool the mismatch is the peer's
+			desc: "misordered prefilled transactions",
+			pool: testTxPool{tx1, tx2, tx3},
+			modify: func(msg *CompactBlockMessage) {
+				msg.ShortIDs, msg.PrefilledTxs = nil, nil
+				for i, tx := range []*types.Tx{coinbase, tx2, tx1, tx3} {
+					rawTx, _ := encodeTx(tx, binaryVersion)
+					msg.PrefilledTxs = append(msg.PrefilledTxs, PrefilledTx{Index: uint32(i), RawTx: rawTx})
+				}
+			},
+			wantErr: errCompactMerkleRoot,
+		},
+		{
+			desc:    "prefilled index out of range",
+			pool:    testTxPool{tx1, tx2, tx3},
+			modify:  func(msg *CompactBlockMessage) { msg.PrefilledTxs[0].Index = 4 },
+			wantErr: errPrefilledIndex,
+		},
+		{
+			desc:    "duplicated prefilled index",
+			pool:    testTxPool{tx1, tx2, tx3},
+			modify:  func(msg *CompactBlockMessage) { msg.PrefilledTxs = append(msg.PrefilledTxs, msg.PrefilledTxs[0]) },
+			wantErr: errPrefilledIndex,
+		},
+		{
+			desc:    "too many transactions",
+			pool:    testTxPool{tx1, tx2, tx3},
+			modify:  func(msg *CompactBlockMessage) { msg.ShortIDs = make([]uint64, maxCompactBlockTxs) },
+			wantErr: errCompactBlockSize,
+		},
+	}
+
+	for _, c := range cases {
+		msg, err := NewCompactBlockMessage(block, 7, binaryVersion)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.modify != nil {
+			c.modify(msg)
+		}
+		header, err := msg.GetHeader(binaryVersion)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cp := newCompactBlockPool(c.pool)
+		got, missing, err := cp.reconstruct("peer", binaryVersion, header, msg)
+		if errors.Root(err) != c.wantErr {
+			t.Errorf("%s: got err = %v, want %v", c.desc, err, c.wantErr)
+			continue
+		}
+		if c.wantErr != nil {
+			continue
+		}
+
+		if len(missing) != len(c.wantMissing) {
+			t.Errorf("%s: got missing %v, want %v", c.desc, missing, c.wantMissing)
+			continue
+		}
+		for i := range missing {
+			if missing[i] != c.wantMissing[i] {
+				t.Errorf("%s: got missing %v, want %v", c.desc, missing, c.wantMissing)
+				break
+			}
+		}
+
+		if len(missing) != 0 {
+			// the peer sends the requested transactions
+			txnMsg, err := NewBlockTxnMessage(block, missing, binaryVersion)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, err = cp.complete("peer", binaryVersion, txnMsg); err != nil {
+				t.Errorf("%s: complete: %v", c.desc, err)
+				continue
+			}
+		}
+
+		if got.Hash() != hash || len(got.Transactions) != len(block.Transactions) {
+			t.Errorf("%s: got block %x with %d transactions, want %x with %d", c.desc, got.Hash().Bytes(), len(got.Transactions), hash.Bytes(), len(block.Transactions))
+			continue
+		}
+		for i, tx := range got.Transactions {
+			if tx.ID != block.Transactions[i].ID {
+				t.Errorf("%s: transaction %d got %x, want %x", c.desc, i, tx.ID.Bytes(), block.Transactions[i].ID.Bytes())
+			}
+		}
+	}
+}
+
+func TestCompactBlockComplete(t *testing.T) {
+	tx1, tx2 := newCompactTestTx(1), newCompactTestTx(2)
+	block := newCompactTestBlock([]*types.Tx{tx1, tx2})
+	msg, err := NewCompactBlockMessage(block, 7, binaryVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := msg.GetHeader(binaryVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cp := newCompactBlockPool(testTxPool{})
+	if _, missing, err := cp.reconstruct("peer", binaryVersion, header, msg); err != nil || len(missing) != 1 {
+		t.Fatalf("got missing %v err = %v, want one missing", missing, err)
+	}
+
+	txnMsg, err := NewBlockTxnMessage(block, []uint32{0, 1}, binaryVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the transactions are only taken from the peer they were requested from
+	if _, err := cp.complete("other", binaryVersion, txnMsg); errors.Root(err) != errUnrequestedTxs {
+		t.Errorf("other peer got err = %v, want %v", err, errUnrequestedTxs)
+	}
+	if _, err := cp.complete("peer", binaryVersion, txnMsg); errors.Root(err) != errBlockTxsMismatch {
+		t.Errorf("extra transactions got err = %v, want %v", err, errBlockTxsMismatch)
+	}
+	// the mismatch drops the pending compact block
+	if _, err := cp.complete("peer", binaryVersion, txnMsg); errors.Root(err) != errUnrequestedTxs {
+		t.Errorf("dropped compact block got err = %v, want %v", err, errUnrequestedTxs)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	core "github.com/btm-stats/protocol"
 	"github.com/btm-stats/protocol/bc"
 	"github.com/btm-stats/protocol/bc/types"
+	"github.com/btm-stats/protocol/validation"
 )
 
 const (
@@ -23,9 +24,10 @@ var (
 // Fetcher is responsible for accumulating block announcements from various peers
 // and scheduling them for retrieval.
 type Fetcher struct {
-	chain *core.Chain
-	sw    *p2p.Switch
-	peers *peerSet
+	chain   *core.Chain
+	sw      *p2p.Switch
+	peers   *peerSet
+	rejects *validation.RejectCounter
 
 	// Various event channels
 	newMinedBlock chan *blockPending
@@ -38,11 +40,12 @@ type Fetcher struct {
 }
 
 //NewFetcher New creates a block fetcher to retrieve blocks of the new mined.
-func NewFetcher(chain *core.Chain, sw *p2p.Switch, peers *peerSet) *Fetcher {
+func NewFetcher(chain *core.Chain, sw *p2p.Switch, peers *peerSet, rejects *validation.RejectCounter) *Fetcher {
 	return &Fetcher{
 		chain:         chain,
 		sw:            sw,
 		peers:         peers,
+		rejects:       rejects,
 		newMinedBlock: make(chan *blockPending),
 		quit:          make(chan struct{}),
 		queue:         prque.New(),
@@ -147,7 +150,8 @@ func (f *Fetcher) insert(peerID string, block *types.Block) {
 	log.Info("Importing propagated block", " from peer: ", peerID, " height: ", block.Height)
 	// Run the actual import and log any issues
 	if _, err := f.chain.ProcessBlock(block); err != nil {
-		log.Info("Propagated block import failed", " from peer: ", peerID, " height: ", block.Height, "err: ", err)
+		reason := f.rejects.Add(err)
+		log.Info("Propagated block import failed", " from peer: ", peerID, " height: ", block.Height, " reason: ", reason, " err: ", err)
 		fPeer, ok := f.peers.Peer(peerID)
 		if !ok {
 			return
@@ -3,17 +3,20 @@ package netsync
 import (
 
 	cfg "github.com/btm-stats/config"
+	"github.com/btm-stats/database"
 	core "github.com/btm-stats/protocol"
 	dbm "github.com/tendermint/tmlibs/db"
 	cmn "github.com/tendermint/tmlibs/common"
 
 	"github.com/btm-stats/protocol/bc"
+	"github.com/btm-stats/protocol/validation"
 	"github.com/tendermint/go-crypto"
 	"github.com/btm-stats/p2p/pex"
 	"github.com/btm-stats/p2p"
 	"github.com/btm-stats/version"
 	"strings"
 	"github.com/tendermint/go-wire"
+	log "github.com/sirupsen/logrus"
 )
 
 //SyncManager Sync Manager is responsible for the business layer information synchronization
@@ -27,6 +30,7 @@ type SyncManager struct {
 	fetcher     *Fetcher
 	blockKeeper *blockKeeper
 	peers       *peerSet
+	rejects     *validation.RejectCounter
 
 	newBlockCh    chan *bc.Hash
 	newPeerCh     chan struct{}
@@ -34,6 +38,7 @@ type SyncManager struct {
 	dropPeerCh    chan *string
 	quitSync      chan struct{}
 	config        *cfg.Config
+	headersOnly   bool // follow the chain by the block headers only
 	synchronising int32
 }
 
@@ -41,28 +46,35 @@ type SyncManager struct {
 func NewSyncManager(config *cfg.Config, chain *core.Chain, txPool *core.TxPool, newBlockCh chan *bc.Hash) (*SyncManager, error) {
 	// Create the protocol manager with the base fields
 	manager := &SyncManager{
-		txPool:     txPool,
-		chain:      chain,
-		privKey:    crypto.GenPrivKeyEd25519(),
-		config:     config,
-		quitSync:   make(chan struct{}),
-		newBlockCh: newBlockCh,
-		newPeerCh:  make(chan struct{}),
-		txSyncCh:   make(chan *txsync),
-		dropPeerCh: make(chan *string, maxQuitReq),
-		peers:      newPeerSet(),
+		txPool:      txPool,
+		chain:       chain,
+		privKey:     crypto.GenPrivKeyEd25519(),
+		config:      config,
+		headersOnly: config.HeadersOnly(),
+		quitSync:    make(chan struct{}),
+		newBlockCh:  newBlockCh,
+		newPeerCh:   make(chan struct{}),
+		txSyncCh:    make(chan *txsync),
+		dropPeerCh:  make(chan *string, maxQuitReq),
+		peers:       newPeerSet(),
+		rejects:     validation.NewRejectCounter(),
 	}
 
-	trustHistoryDB := dbm.NewDB("trusthistory", config.DBBackend, config.DBDir())
+	// the trust history stays on tmlibs, which has no bolt backend
+	trustHistoryBackend := config.DBBackend
+	if trustHistoryBackend == database.BoltDBBackend {
+		trustHistoryBackend = dbm.GoLevelDBBackendStr
+	}
+	trustHistoryDB := dbm.NewDB("trusthistory", trustHistoryBackend, config.DBDir())
 	addrBook := pex.NewAddrBook(config.P2P.AddrBookFile(), config.P2P.AddrBookStrict)
 	manager.sw = p2p.NewSwitch(config.P2P, addrBook, trustHistoryDB)
 
 	pexReactor := pex.NewPEXReactor(addrBook)
 	manager.sw.AddReactor("PEX", pexReactor)
 
-	manager.blockKeeper = newBlockKeeper(manager.chain, manager.sw, manager.peers, manager.dropPeerCh)
-	//manager.fetcher = NewFetcher(chain, manager.sw, manager.peers)
-	protocolReactor := NewProtocolReactor(chain, txPool, manager.sw, manager.blockKeeper, manager.fetcher, manager.peers, manager.newPeerCh, manager.txSyncCh, manager.dropPeerCh)
+	manager.blockKeeper = newBlockKeeper(manager.chain, manager.sw, manager.peers, manager.rejects, manager.dropPeerCh)
+	manager.fetcher = NewFetcher(chain, manager.sw, manager.peers, manager.rejects)
+	protocolReactor := NewProtocolReactor(chain, txPool, manager.sw, manager.blockKeeper, manager.fetcher, manager.peers, newCompactBlockPool(txPool), manager.headersOnly, manager.newPeerCh, manager.txSyncCh, manager.dropPeerCh)
 	manager.sw.AddReactor("PROTOCOL", protocolReactor)
 
 	// Create & add listener
@@ -98,8 +110,17 @@ func (sm *SyncManager) makeNodeInfo(listenerStatus bool) *p2p.NodeInfo {
 		Other: []string{
 			cmn.Fmt("wire_version=%v", wire.Version),
 			cmn.Fmt("p2p_version=%v", p2p.Version),
+			compactBlockNodeInfo,
+			protocolVersionNodeInfo,
+			headersNodeInfo,
 		},
 	}
+	if sm.headersOnly {
+		nodeInfo.Other = append(nodeInfo.Other, headersOnlyNodeInfo)
+	}
+	if sm.config.PruneDepth != 0 {
+		nodeInfo.Other = append(nodeInfo.Other, cmn.Fmt(pruneDepthNodeInfo, sm.config.PruneDepth))
+	}
 
 	if !sm.sw.IsListening() {
 		return nodeInfo
@@ -126,4 +147,85 @@ func (sm *SyncManager) netStart() error {
 //Start start sync manager service
 func (sm *SyncManager) Start() {
 	go sm.netStart()
+	// broadcast transactions
+	go sm.txBroadcastLoop()
+	// broadcast mined blocks
+	go sm.minedBroadcastLoop()
+	// start sync handlers
+	go sm.syncer()
+	go sm.txsyncLoop()
+}
+
+//Stop stop sync manager
+func (sm *SyncManager) Stop() {
+	close(sm.quitSync)
+	sm.sw.Stop()
+}
+
+func (sm *SyncManager) txBroadcastLoop() {
+	newTxCh := sm.txPool.GetNewTxCh()
+	for {
+		select {
+		case newTx := <-newTxCh:
+			peers, err := sm.peers.BroadcastTx(newTx)
+			if err != nil {
+				log.Errorf("Broadcast new tx error. %v", err)
+				continue
+			}
+			for _, smPeer := range peers {
+				if smPeer == nil {
+					continue
+				}
+				swPeer := smPeer.getPeer()
+				log.Info("Tx broadcast error. Stop Peer.")
+				sm.sw.StopPeerGracefully(swPeer)
+			}
+		case <-sm.quitSync:
+			return
+		}
+	}
+}
+
+func (sm *SyncManager) minedBroadcastLoop() {
+	for {
+		select {
+		case blockHash := <-sm.newBlockCh:
+			block, err := sm.chain.GetBlockByHash(blockHash)
+			if err != nil {
+				log.Errorf("Failed on mined broadcast loop get block %v", err)
+				continue
+			}
+			peers, err := sm.peers.BroadcastMinedBlock(block)
+			if err != nil {
+				log.Errorf("Broadcast mine block error. %v", err)
+				continue
+			}
+			for _, smPeer := range peers {
+				if smPeer == nil {
+					continue
+				}
+				swPeer := smPeer.getPeer()
+				log.Info("New mined block broadcast error. Stop Peer.")
+				sm.sw.StopPeerGracefully(swPeer)
+			}
+		case <-sm.quitSync:
+			return
+		}
+	}
+}
+
+//Switch get sync manager switch
+func (sm *SyncManager) Switch() *p2p.Switch {
+	return sm.sw
+}
+
+//RejectedBlocks get the number of blocks rejected by the validation, keyed by
+//the reject reason
+func (sm *SyncManager) RejectedBlocks() map[string]uint64 {
+	return sm.rejects.Counts()
+}
+
+//NodeInfo get P2P peer node info
+func (sm *SyncManager) NodeInfo() *p2p.NodeInfo {
+	return sm.sw.NodeInfo()
 }
@@ -15,12 +15,20 @@ import (
 const (
 	BlockRequestByte   = byte(0x10)
 	BlockResponseByte  = byte(0x11)
+	GetHeadersByte     = byte(0x12)
+	HeadersByte        = byte(0x13)
+	BlockNotFoundByte  = byte(0x14)
 	StatusRequestByte  = byte(0x20)
 	StatusResponseByte = byte(0x21)
 	NewTransactionByte = byte(0x30)
 	NewMineBlockByte   = byte(0x40)
+	CompactBlockByte   = byte(0x41)
+	GetBlockTxnByte    = byte(0x42)
+	BlockTxnByte       = byte(0x43)
 
 	maxBlockchainResponseSize = 22020096 + 2
+	maxBlockLocatorSize       = 64
+	maxHeadersPerMsg          = 2000
 )
 
 // BlockchainMessage is a generic message for this reactor.
@@ -30,10 +38,16 @@ var _ = wire.RegisterInterface(
 	struct{ BlockchainMessage }{},
 	wire.ConcreteType{&BlockRequestMessage{}, BlockRequestByte},
 	wire.ConcreteType{&BlockResponseMessage{}, BlockResponseByte},
+	wire.ConcreteType{&GetHeadersMessage{}, GetHeadersByte},
+	wire.ConcreteType{&HeadersMessage{}, HeadersByte},
+	wire.ConcreteType{&BlockNotFoundMessage{}, BlockNotFoundByte},
 	wire.ConcreteType{&StatusRequestMessage{}, StatusRequestByte},
 	wire.ConcreteType{&StatusResponseMessage{}, StatusResponseByte},
 	wire.ConcreteType{&TransactionNotifyMessage{}, NewTransactionByte},
 	wire.ConcreteType{&MineBlockMessage{}, NewMineBlockByte},
+	wire.ConcreteType{&CompactBlockMessage{}, CompactBlockByte},
+	wire.ConcreteType{&GetBlockTxnMessage{}, GetBlockTxnByte},
+	wire.ConcreteType{&BlockTxnMessage{}, BlockTxnByte},
 )
 
 type blockPending struct {
@@ -41,6 +55,17 @@ type blockPending struct {
 	peerID string
 }
 
+type headersPending struct {
+	headers []*types.BlockHeader
+	peerID  string
+}
+
+type blockNotFound struct {
+	height uint64
+	pruned bool
+	peerID string
+}
+
 type txsNotify struct {
 	tx     *types.Tx
 	peerID string
@@ -58,6 +83,57 @@ func DecodeMessage(bz []byte) (msgType byte, msg BlockchainMessage, err error) {
 	return
 }
 
+// encodeBlock serializes the block for a peer of the protocol version
+func encodeBlock(block *types.Block, version int) ([]byte, error) {
+	if version >= binaryVersion {
+		return block.MarshalBinary()
+	}
+	return block.MarshalText()
+}
+
+// decodeBlock parses a block sent by a peer of the protocol version
+func decodeBlock(raw []byte, version int) (*types.Block, error) {
+	block := &types.Block{}
+	if version >= binaryVersion {
+		return block, block.UnmarshalBinary(raw)
+	}
+	return block, block.UnmarshalText(raw)
+}
+
+// encodeHeader serializes the block header for a peer of the protocol version
+func encodeHeader(header *types.BlockHeader, version int) ([]byte, error) {
+	if version >= binaryVersion {
+		return header.MarshalBinary()
+	}
+	return header.MarshalText()
+}
+
+// decodeHeader parses a block header sent by a peer of the protocol version
+func decodeHeader(raw []byte, version int) (*types.BlockHeader, error) {
+	header := &types.BlockHeader{}
+	if version >= binaryVersion {
+		return header, header.UnmarshalBinary(raw)
+	}
+	return header, header.UnmarshalText(raw)
+}
+
+// encodeTx serializes the tx for a peer of the protocol version
+func encodeTx(tx *types.Tx, version int) ([]byte, error) {
+	if version >= binaryVersion {
+		return tx.TxData.MarshalBinary()
+	}
+	return tx.TxData.MarshalText()
+}
+
+// decodeTx parses a tx sent by a peer of the protocol version
+func decodeTx(raw []byte, version int) (*types.Tx, error) {
+	tx := &types.Tx{}
+	if version >= binaryVersion {
+		return tx, tx.UnmarshalBinary(raw)
+	}
+	return tx, tx.UnmarshalText(raw)
+}
+
 //BlockRequestMessage request blocks from remote peers by height/hash
 type BlockRequestMessage struct {
 	Height  uint64
@@ -85,8 +161,8 @@ type BlockResponseMessage struct {
 }
 
 //NewBlockResponseMessage construct bock response msg
-func NewBlockResponseMessage(block *types.Block) (*BlockResponseMessage, error) {
-	rawBlock, err := block.MarshalText()
+func NewBlockResponseMessage(block *types.Block, version int) (*BlockResponseMessage, error) {
+	rawBlock, err := encodeBlock(block, version)
 	if err != nil {
 		return nil, err
 	}
@@ -94,13 +170,8 @@ func NewBlockResponseMessage(block *types.Block) (*BlockResponseMessage, error)
 }
 
 //GetBlock get block from msg
-func (m *BlockResponseMessage) GetBlock() *types.Block {
-	block := &types.Block{
-		BlockHeader:  types.BlockHeader{},
-		Transactions: []*types.Tx{},
-	}
-	block.UnmarshalText(m.RawBlock)
-	return block
+func (m *BlockResponseMessage) GetBlock(version int) (*types.Block, error) {
+	return decodeBlock(m.RawBlock, version)
 }
 
 //String convert msg to string
@@ -108,14 +179,111 @@ func (m *BlockResponseMessage) String() string {
 	return fmt.Sprintf("BlockResponseMessage{Size: %d}", len(m.RawBlock))
 }
 
+//BlockNotFoundMessage answers a block request the peer can't serve, the
+//pruned flag tells a pruned body apart from an unknown block
+type BlockNotFoundMessage struct {
+	Height  uint64
+	RawHash [32]byte
+	Pruned  bool
+}
+
+//NewBlockNotFoundMessage construct the answer of the block request
+func NewBlockNotFoundMessage(request *BlockRequestMessage, pruned bool) *BlockNotFoundMessage {
+	return &BlockNotFoundMessage{Height: request.Height, RawHash: request.RawHash, Pruned: pruned}
+}
+
+//String convert msg to string
+func (m *BlockNotFoundMessage) String() string {
+	if m.Height > 0 {
+		return fmt.Sprintf("BlockNotFoundMessage{Height: %d, Pruned: %v}", m.Height, m.Pruned)
+	}
+	hash := bc.NewHash(m.RawHash)
+	return fmt.Sprintf("BlockNotFoundMessage{Hash: %s, Pruned: %v}", hash.String(), m.Pruned)
+}
+
+//GetHeadersMessage request the main chain headers following the first known
+//hash of the locator, up to the stop hash
+type GetHeadersMessage struct {
+	RawBlockLocator [][32]byte
+	RawStopHash     [32]byte
+}
+
+//NewGetHeadersMessage construct get headers msg, a zero stop hash requests as
+//many headers as a message holds
+func NewGetHeadersMessage(locator []*bc.Hash, stopHash *bc.Hash) *GetHeadersMessage {
+	msg := &GetHeadersMessage{RawStopHash: stopHash.Byte32()}
+	for _, hash := range locator {
+		msg.RawBlockLocator = append(msg.RawBlockLocator, hash.Byte32())
+	}
+	return msg
+}
+
+//GetBlockLocator get the block locator from msg
+func (m *GetHeadersMessage) GetBlockLocator() []*bc.Hash {
+	locator := []*bc.Hash{}
+	for _, rawHash := range m.RawBlockLocator {
+		hash := bc.NewHash(rawHash)
+		locator = append(locator, &hash)
+	}
+	return locator
+}
+
+//GetStopHash get the stop hash from msg
+func (m *GetHeadersMessage) GetStopHash() *bc.Hash {
+	hash := bc.NewHash(m.RawStopHash)
+	return &hash
+}
+
+//String convert msg to string
+func (m *GetHeadersMessage) String() string {
+	stopHash := m.GetStopHash()
+	return fmt.Sprintf("GetHeadersMessage{Locator: %d, Stop hash: %s}", len(m.RawBlockLocator), stopHash.String())
+}
+
+//HeadersMessage response get headers msg
+type HeadersMessage struct {
+	RawHeaders [][]byte
+}
+
+//NewHeadersMessage construct headers msg
+func NewHeadersMessage(headers []*types.BlockHeader, version int) (*HeadersMessage, error) {
+	msg := &HeadersMessage{}
+	for _, header := range headers {
+		rawHeader, err := encodeHeader(header, version)
+		if err != nil {
+			return nil, err
+		}
+		msg.RawHeaders = append(msg.RawHeaders, rawHeader)
+	}
+	return msg, nil
+}
+
+//GetHeaders get block headers from msg
+func (m *HeadersMessage) GetHeaders(version int) ([]*types.BlockHeader, error) {
+	headers := []*types.BlockHeader{}
+	for _, rawHeader := range m.RawHeaders {
+		header, err := decodeHeader(rawHeader, version)
+		if err != nil {
+			return nil, err
+		}
+		headers = append(headers, header)
+	}
+	return headers, nil
+}
+
+//String convert msg to string
+func (m *HeadersMessage) String() string {
+	return fmt.Sprintf("HeadersMessage{Headers: %d}", len(m.RawHeaders))
+}
+
 //TransactionNotifyMessage notify new tx msg
 type TransactionNotifyMessage struct {
 	RawTx []byte
 }
 
 //NewTransactionNotifyMessage construct notify new tx msg
-func NewTransactionNotifyMessage(tx *types.Tx) (*TransactionNotifyMessage, error) {
-	rawTx, err := tx.TxData.MarshalText()
+func NewTransactionNotifyMessage(tx *types.Tx, version int) (*TransactionNotifyMessage, error) {
+	rawTx, err := encodeTx(tx, version)
 	if err != nil {
 		return nil, err
 	}
@@ -123,12 +291,8 @@ func NewTransactionNotifyMessage(tx *types.Tx) (*TransactionNotifyMessage, error
 }
 
 //GetTransaction get tx from msg
-func (m *TransactionNotifyMessage) GetTransaction() (*types.Tx, error) {
-	tx := &types.Tx{}
-	if err := tx.UnmarshalText(m.RawTx); err != nil {
-		return nil, err
-	}
-	return tx, nil
+func (m *TransactionNotifyMessage) GetTransaction(version int) (*types.Tx, error) {
+	return decodeTx(m.RawTx, version)
 }
 
 //String
@@ -185,8 +349,8 @@ type MineBlockMessage struct {
 }
 
 //NewMinedBlockMessage construct new mined block msg
-func NewMinedBlockMessage(block *types.Block) (*MineBlockMessage, error) {
-	rawBlock, err := block.MarshalText()
+func NewMinedBlockMessage(block *types.Block, version int) (*MineBlockMessage, error) {
+	rawBlock, err := encodeBlock(block, version)
 	if err != nil {
 		return nil, err
 	}
@@ -194,15 +358,136 @@ func NewMinedBlockMessage(block *types.Block) (*MineBlockMessage, error) {
 }
 
 //GetMineBlock get mine block from msg
-func (m *MineBlockMessage) GetMineBlock() (*types.Block, error) {
-	block := &types.Block{}
-	if err := block.UnmarshalText(m.RawBlock); err != nil {
-		return nil, err
-	}
-	return block, nil
+func (m *MineBlockMessage) GetMineBlock(version int) (*types.Block, error) {
+	return decodeBlock(m.RawBlock, version)
 }
 
 //String convert msg to string
 func (m *MineBlockMessage) String() string {
 	return fmt.Sprintf("NewMineBlockMessage{Size: %d}", len(m.RawBlock))
 }
+
+//CompactBlockMessage announce a new block by its header and the short ids of
+//the transactions, the coinbase is always prefilled since no peer has it
+type CompactBlockMessage struct {
+	RawHeader    []byte
+	Nonce        uint64
+	ShortIDs     []uint64
+	PrefilledTxs []PrefilledTx
+}
+
+//PrefilledTx a transaction sent in full within a compact block
+type PrefilledTx struct {
+	Index uint32
+	RawTx []byte
+}
+
+//NewCompactBlockMessage construct compact block msg, the nonce salts the
+//short ids of the transactions
+func NewCompactBlockMessage(block *types.Block, nonce uint64, version int) (*CompactBlockMessage, error) {
+	rawHeader, err := encodeBlock(&types.Block{BlockHeader: block.BlockHeader}, version)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &CompactBlockMessage{RawHeader: rawHeader, Nonce: nonce}
+	hash := block.Hash()
+	key := shortIDKey(&hash, nonce)
+	for i, tx := range block.Transactions {
+		if i == 0 {
+			rawTx, err := encodeTx(tx, version)
+			if err != nil {
+				return nil, err
+			}
+			msg.PrefilledTxs = append(msg.PrefilledTxs, PrefilledTx{Index: 0, RawTx: rawTx})
+			continue
+		}
+		msg.ShortIDs = append(msg.ShortIDs, shortTxID(&key, &tx.ID))
+	}
+	return msg, nil
+}
+
+//GetHeader get block header from msg
+func (m *CompactBlockMessage) GetHeader(version int) (*types.BlockHeader, error) {
+	block, err := decodeBlock(m.RawHeader, version)
+	if err != nil {
+		return nil, err
+	}
+	if len(block.Transactions) != 0 {
+		return nil, errors.New("compact block header carries transactions")
+	}
+	return &block.BlockHeader, nil
+}
+
+//String convert msg to string
+func (m *CompactBlockMessage) String() string {
+	return fmt.Sprintf("CompactBlockMessage{ShortIDs: %d, Prefilled: %d}", len(m.ShortIDs), len(m.PrefilledTxs))
+}
+
+//GetBlockTxnMessage request the transactions of a compact block missing from
+//the mempool
+type GetBlockTxnMessage struct {
+	RawHash [32]byte
+	Indexes []uint32
+}
+
+//GetHash get hash
+func (m *GetBlockTxnMessage) GetHash() *bc.Hash {
+	hash := bc.NewHash(m.RawHash)
+	return &hash
+}
+
+//String convert msg to string
+func (m *GetBlockTxnMessage) String() string {
+	hash := m.GetHash()
+	return fmt.Sprintf("GetBlockTxnMessage{Hash: %s, Indexes: %d}", hash.String(), len(m.Indexes))
+}
+
+//BlockTxnMessage response get block txn msg with the requested transactions
+//in the order of the indexes
+type BlockTxnMessage struct {
+	RawHash [32]byte
+	RawTxs  [][]byte
+}
+
+//NewBlockTxnMessage construct block txn msg
+func NewBlockTxnMessage(block *types.Block, indexes []uint32, version int) (*BlockTxnMessage, error) {
+	msg := &BlockTxnMessage{RawHash: block.Hash().Byte32()}
+	for _, index := range indexes {
+		if int(index) >= len(block.Transactions) {
+			return nil, fmt.Errorf("transaction index %d out of range", index)
+		}
+
+		rawTx, err := encodeTx(block.Transactions[index], version)
+		if err != nil {
+			return nil, err
+		}
+		msg.RawTxs = append(msg.RawTxs, rawTx)
+	}
+	return msg, nil
+}
+
+//GetHash get hash
+func (m *BlockTxnMessage) GetHash() *bc.Hash {
+	hash := bc.NewHash(m.RawHash)
+	return &hash
+}
+
+//GetTransactions get txs from msg
+func (m *BlockTxnMessage) GetTransactions(version int) ([]*types.Tx, error) {
+	txs := make([]*types.Tx, 0, len(m.RawTxs))
+	for _, rawTx := range m.RawTxs {
+		tx, err := decodeTx(rawTx, version)
+		if err != nil {
+			return nil, err
+		}
+		txs = append(txs, tx)
+	}
+	return txs, nil
+}
+
+//String convert msg to string
+func (m *BlockTxnMessage) String() string {
+	hash := m.GetHash()
+	return fmt.Sprintf("BlockTxnMessage{Hash: %s, Txs: %d}", hash.String(), len(m.RawTxs))
+}
@@ -0,0 +1,77 @@
+package netsync
+
+import (
+	"testing"
+
+	"github.com/btm-stats/protocol/bc/types"
+)
+
+func TestBlockCodec(t *testing.T) {
+	tx1, tx2 := newCompactTestTx(1), newCompactTestTx(2)
+	block := newCompactTestBlock([]*types.Tx{tx1, tx2})
+	block.Timestamp, block.Nonce, block.Bits = 1528945000, 7, 2161727821137910632
+
+	for _, version := range []int{binaryVersion - 1, binaryVersion} {
+		raw, err := encodeBlock(block, version)
+		if err != nil {
+			t.Fatalf("version %d: %v", version, err)
+		}
+		// the hex encoding of the old peers only has hex digits
+		if isHex := raw[0] == '0'; isHex != (version < binaryVersion) {
+			t.Errorf("version %d: got hex encoding %v", version, isHex)
+		}
+
+		got, err := decodeBlock(raw, version)
+		if err != nil {
+			t.Fatalf("version %d: %v", version, err)
+		}
+		if got.Hash() != block.Hash() || len(got.Transactions) != len(block.Transactions) {
+			t.Fatalf("version %d: got block %x with %d transactions, want %x with %d", version, got.Hash().Bytes(), len(got.Transactions), block.Hash().Bytes(), len(block.Transactions))
+		}
+		for i, tx := range got.Transactions {
+			if tx.ID != block.Transactions[i].ID {
+				t.Errorf("version %d: transaction %d got %x, want %x", version, i, tx.ID.Bytes(), block.Transactions[i].ID.Bytes())
+			}
+		}
+
+		// a peer of the other version can't parse the block
+		other := binaryVersion
+		if version == binaryVersion {
+			other = binaryVersion - 1
+		}
+		if _, err := decodeBlock(raw, other); err == nil {
+			t.Errorf("version %d: decoded by version %d", version, other)
+		}
+	}
+}
+
+func TestHeaderAndTxCodec(t *testing.T) {
+	tx := newCompactTestTx(1)
+	block := newCompactTestBlock([]*types.Tx{tx})
+
+	for _, version := range []int{binaryVersion - 1, binaryVersion} {
+		rawHeader, err := encodeHeader(&block.BlockHeader, version)
+		if err != nil {
+			t.Fatalf("version %d: %v", version, err)
+		}
+		header, err := decodeHeader(rawHeader, version)
+		if err != nil {
+			t.Fatalf("version %d: %v", version, err)
+		}
+		if header.Hash() != block.Hash() {
+			t.Errorf("version %d: got header %x, want %x", version, header.Hash().Bytes(), block.Hash().Bytes())
+		}
+
+		rawTx, err := encodeTx(tx, version)
+		if err != nil {
+			t.Fatalf("version %d: %v", version, err)
+		}
+		gotTx, err := decodeTx(rawTx, version)
+		if err != nil {
+			t.Fatalf("version %d: %v", version, err)
+		}
+		if gotTx.ID != tx.ID {
+			t.Errorf("version %d: got tx %x, want %x", version, gotTx.ID.Bytes(), tx.ID.Bytes())
+		}
+	}
+}
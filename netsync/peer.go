@@ -1,7 +1,11 @@
 package netsync
 
 import (
+	"fmt"
 	log "github.com/sirupsen/logrus"
+	"math/rand"
+	"strconv"
+	"strings"
 	"sync"
 	"github.com/btm-stats/errors"
 	"github.com/btm-stats/protocol/bc"
@@ -13,13 +17,81 @@ import (
 
 const (
 	defaultVersion      = 1
+	binaryVersion       = 2 // blocks and transactions travel in binary instead of hex
 	defaultBanThreshold = uint64(100)
 )
 
+var (
+	protocolVersionNodeInfo = fmt.Sprintf("protocol_version=%d", binaryVersion)
+	// headersNodeInfo announces the peer answers the headers requests
+	headersNodeInfo = "headers=1"
+	// headersOnlyNodeInfo announces the peer runs in the headers mode and
+	// can't serve the blocks
+	headersOnlyNodeInfo = "node_mode=headers"
+	// pruneDepthNodeInfo announces the peer only keeps the block bodies
+	// within the depth below its tip
+	pruneDepthNodeInfo = "prune_depth=%d"
+)
+
+// hasNodeInfo checks whether the peer announced the flag in its node info
+func hasNodeInfo(nodeInfo *p2p.NodeInfo, flag string) bool {
+	if nodeInfo == nil {
+		return false
+	}
+
+	for _, other := range nodeInfo.Other {
+		if other == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// protocolVersion negotiates the protocol version with the peer announcing
+// the node info, peers announcing nothing speak the default version
+func protocolVersion(nodeInfo *p2p.NodeInfo) int {
+	if nodeInfo == nil {
+		return defaultVersion
+	}
+
+	for _, other := range nodeInfo.Other {
+		if !strings.HasPrefix(other, "protocol_version=") {
+			continue
+		}
+
+		version, err := strconv.Atoi(strings.TrimPrefix(other, "protocol_version="))
+		if err != nil || version < defaultVersion {
+			return defaultVersion
+		}
+		if version > binaryVersion {
+			return binaryVersion
+		}
+		return version
+	}
+	return defaultVersion
+}
+
+// pruneDepth returns the depth below its tip the peer keeps the block bodies
+// within, zero for the peers keeping every body
+func pruneDepth(nodeInfo *p2p.NodeInfo) uint64 {
+	if nodeInfo == nil {
+		return 0
+	}
+
+	for _, other := range nodeInfo.Other {
+		var depth uint64
+		if n, err := fmt.Sscanf(other, pruneDepthNodeInfo, &depth); err == nil && n == 1 {
+			return depth
+		}
+	}
+	return 0
+}
+
 var (
 	errClosed            = errors.New("peer set is closed")
 	errAlreadyRegistered = errors.New("peer is already registered")
 	errNotRegistered     = errors.New("peer is not registered")
+	errPeerPruned        = errors.New("peer pruned the block")
 )
 
 type peer struct {
@@ -50,6 +122,19 @@ func (ps *peerSet) Peer(id string) (*peer, bool) {
 	return p, ok
 }
 
+// canServeHeight reports whether the peer still keeps the body of the block
+// at the height, the genesis block is never pruned
+func (p *peer) canServeHeight(height uint64) bool {
+	depth := pruneDepth(p.swPeer.NodeInfo)
+	if depth == 0 || height == 0 {
+		return true
+	}
+
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+	return height+depth > p.height
+}
+
 func (p *peer) getPeer() *p2p.Peer {
 	p.mtx.RLock()
 	defer p.mtx.RUnlock()
@@ -57,15 +142,45 @@ func (p *peer) getPeer() *p2p.Peer {
 	return p.swPeer
 }
 
+type blockMsgKey struct {
+	version int
+	compact bool
+}
+
+// BroadcastMinedBlock sends the block to every peer that doesn't know it yet,
+// the peers supporting compact blocks only get its header and short tx ids.
+// The message of each protocol version is built once.
 func (ps *peerSet) BroadcastMinedBlock(block *types.Block) ([]*peer, error) {
-	msg, err := NewMinedBlockMessage(block)
-	if err != nil {
-		return nil, errors.New("Failed construction block msg")
+	nonce := rand.Uint64()
+	msgs := make(map[blockMsgKey]BlockchainMessage)
+	blockMsg := func(key blockMsgKey) (BlockchainMessage, error) {
+		if msg, ok := msgs[key]; ok {
+			return msg, nil
+		}
+
+		var msg BlockchainMessage
+		var err error
+		if key.compact {
+			msg, err = NewCompactBlockMessage(block, nonce, key.version)
+		} else {
+			msg, err = NewMinedBlockMessage(block, key.version)
+		}
+		if err != nil {
+			return nil, err
+		}
+		msgs[key] = msg
+		return msg, nil
 	}
+
 	hash := block.Hash()
 	peers := ps.PeersWithoutBlock(&hash)
 	abnormalPeers := make([]*peer, 0)
 	for _, peer := range peers {
+		nodeInfo := peer.swPeer.NodeInfo
+		msg, err := blockMsg(blockMsgKey{version: protocolVersion(nodeInfo), compact: supportCompactBlock(nodeInfo)})
+		if err != nil {
+			return nil, errors.New("Failed construction block msg")
+		}
 		if ok := peer.swPeer.TrySend(BlockchainChannel, struct{ BlockchainMessage }{msg}); !ok {
 			abnormalPeers = append(abnormalPeers, peer)
 			continue
@@ -77,6 +192,78 @@ func (ps *peerSet) BroadcastMinedBlock(block *types.Block) ([]*peer, error) {
 	return abnormalPeers, nil
 }
 
+// BroadcastTx sends the transaction to every peer that doesn't know it yet,
+// the peers failed on sending are returned.
+func (ps *peerSet) BroadcastTx(tx *types.Tx) ([]*peer, error) {
+	ps.lock.RLock()
+	peers := make([]*peer, 0, len(ps.peers))
+	for _, p := range ps.peers {
+		p.mtx.RLock()
+		known := p.knownTxs.Exists(tx.ID.String())
+		p.mtx.RUnlock()
+		if !known {
+			peers = append(peers, p)
+		}
+	}
+	ps.lock.RUnlock()
+
+	msgs := make(map[int]*TransactionNotifyMessage)
+	abnormalPeers := make([]*peer, 0)
+	for _, peer := range peers {
+		version := protocolVersion(peer.swPeer.NodeInfo)
+		msg, ok := msgs[version]
+		if !ok {
+			var err error
+			if msg, err = NewTransactionNotifyMessage(tx, version); err != nil {
+				return nil, errors.New("Failed construction tx msg")
+			}
+			msgs[version] = msg
+		}
+
+		if ok := peer.swPeer.TrySend(BlockchainChannel, struct{ BlockchainMessage }{msg}); !ok {
+			abnormalPeers = append(abnormalPeers, peer)
+			continue
+		}
+		peer.mtx.Lock()
+		peer.knownTxs.Insert(tx.ID.String())
+		peer.mtx.Unlock()
+	}
+	return abnormalPeers, nil
+}
+
+// requestHeaders asks the peer for the main chain headers following the
+// locator, as many as a message holds
+func (ps *peerSet) requestHeaders(peerID string, locator []*bc.Hash) error {
+	p, ok := ps.Peer(peerID)
+	if !ok {
+		return errNotRegistered
+	}
+
+	msg := NewGetHeadersMessage(locator, &bc.Hash{})
+	if ok := p.getPeer().TrySend(BlockchainChannel, struct{ BlockchainMessage }{msg}); !ok {
+		return errors.New("send get headers message error")
+	}
+	return nil
+}
+
+// requestBlockByHeight asks the peer for the main chain block at the height,
+// the peers which pruned the body are not asked
+func (ps *peerSet) requestBlockByHeight(peerID string, height uint64) error {
+	p, ok := ps.Peer(peerID)
+	if !ok {
+		return errNotRegistered
+	}
+	if !p.canServeHeight(height) {
+		return errors.WithDetailf(errPeerPruned, "height %d", height)
+	}
+
+	msg := &BlockRequestMessage{Height: height}
+	if ok := p.getPeer().TrySend(BlockchainChannel, struct{ BlockchainMessage }{msg}); !ok {
+		return errors.New("send block request message error")
+	}
+	return nil
+}
+
 // addBanScore increases the persistent and decaying ban score fields by the
 // values passed as parameters. If the resulting score exceeds half of the ban
 // threshold, a warning is logged including the reason provided. Further, if
@@ -104,8 +291,24 @@ func (p *peer) addBanScore(persistent, transient uint64, reason string) bool {
 	return false
 }
 
-// BestPeer retrieves the known peer with the currently highest total difficulty.
-func (ps *peerSet) BestPeer() (*p2p.Peer, uint64) {
+// BestPeer retrieves the known peer with the currently highest total difficulty
+// among the ones serving the blocks, the peers which pruned the block at the
+// height are skipped.
+func (ps *peerSet) BestPeer(height uint64) (*p2p.Peer, uint64) {
+	return ps.bestPeer(func(p *peer) bool {
+		return !hasNodeInfo(p.swPeer.NodeInfo, headersOnlyNodeInfo) && p.canServeHeight(height)
+	})
+}
+
+// BestHeadersPeer retrieves the highest known peer among the ones answering
+// the headers requests.
+func (ps *peerSet) BestHeadersPeer() (*p2p.Peer, uint64) {
+	return ps.bestPeer(func(p *peer) bool {
+		return hasNodeInfo(p.swPeer.NodeInfo, headersNodeInfo)
+	})
+}
+
+func (ps *peerSet) bestPeer(accept func(*peer) bool) (*p2p.Peer, uint64) {
 	ps.lock.RLock()
 	defer ps.lock.RUnlock()
 
@@ -113,6 +316,9 @@ func (ps *peerSet) BestPeer() (*p2p.Peer, uint64) {
 	var bestHeight uint64
 
 	for _, p := range ps.peers {
+		if !accept(p) {
+			continue
+		}
 		if bestPeer == nil || p.height > bestHeight {
 			bestPeer, bestHeight = p.swPeer, p.height
 		}
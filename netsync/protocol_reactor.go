@@ -29,6 +29,8 @@ var (
 	ErrProtocolHandshakeTimeout = errors.New("Protocol handshake timeout")
 	ErrStatusRequest            = errors.New("Status request error")
 	ErrDiffGenesisHash          = errors.New("Different genesis hash")
+
+	errNoFetcher = errors.New("protocol reactor has no fetcher to import the propagated blocks")
 )
 
 // Response describes the response standard.
@@ -55,6 +57,11 @@ type ProtocolReactor struct {
 	sw          *p2p.Switch
 	fetcher     *Fetcher
 	peers       *peerSet
+	compacts    *compactBlockPool
+	headersOnly bool
+	// replay hands the propagated blocks to the block keeper so a capture
+	// replay processes them in order with its frames
+	replay      bool
 	handshakeMu sync.Mutex
 	genesisHash bc.Hash
 
@@ -65,7 +72,7 @@ type ProtocolReactor struct {
 }
 
 // NewProtocolReactor returns the reactor of whole blockchain.
-func NewProtocolReactor(chain *protocol.Chain, txPool *protocol.TxPool, sw *p2p.Switch, blockPeer *blockKeeper, fetcher *Fetcher, peers *peerSet, newPeerCh chan struct{}, txSyncCh chan *txsync, quitReqBlockCh chan *string) *ProtocolReactor {
+func NewProtocolReactor(chain *protocol.Chain, txPool *protocol.TxPool, sw *p2p.Switch, blockPeer *blockKeeper, fetcher *Fetcher, peers *peerSet, compacts *compactBlockPool, headersOnly bool, newPeerCh chan struct{}, txSyncCh chan *txsync, quitReqBlockCh chan *string) *ProtocolReactor {
 	pr := &ProtocolReactor{
 		chain:          chain,
 		blockKeeper:    blockPeer,
@@ -73,6 +80,8 @@ func NewProtocolReactor(chain *protocol.Chain, txPool *protocol.TxPool, sw *p2p.
 		sw:             sw,
 		fetcher:        fetcher,
 		peers:          peers,
+		compacts:       compacts,
+		headersOnly:    headersOnly,
 		newPeerCh:      newPeerCh,
 		txSyncCh:       txSyncCh,
 		quitReqBlockCh: quitReqBlockCh,
@@ -184,6 +193,7 @@ func (pr *ProtocolReactor) Receive(chID byte, src *p2p.Peer, msgBytes []byte) {
 		return
 	}
 	log.WithFields(log.Fields{"peerID": src.Key, "msg": msg}).Info("Receive request")
+	version := protocolVersion(src.NodeInfo)
 
 	switch msg := msg.(type) {
 	case *BlockRequestMessage:
@@ -195,10 +205,12 @@ func (pr *ProtocolReactor) Receive(chID byte, src *p2p.Peer, msgBytes []byte) {
 			block, err = pr.chain.GetBlockByHash(msg.GetHash())
 		}
 		if err != nil {
-			log.Errorf("Fail on BlockRequestMessage get block: %v", err)
+			pruned := errors.Root(err) == protocol.ErrBlockPruned
+			log.WithFields(log.Fields{"peerID": src.Key, "msg": msg, "pruned": pruned}).Debug("refuse the request of a missing block")
+			src.TrySend(BlockchainChannel, struct{ BlockchainMessage }{NewBlockNotFoundMessage(msg, pruned)})
 			return
 		}
-		response, err := NewBlockResponseMessage(block)
+		response, err := NewBlockResponseMessage(block, version)
 		if err != nil {
 			log.Errorf("Fail on BlockRequestMessage create response: %v", err)
 			return
@@ -206,8 +218,46 @@ func (pr *ProtocolReactor) Receive(chID byte, src *p2p.Peer, msgBytes []byte) {
 		src.TrySend(BlockchainChannel, struct{ BlockchainMessage }{response})
 
 	case *BlockResponseMessage:
-		log.Info("BlockResponseMessage height:", msg.GetBlock().Height)
-		pr.blockKeeper.AddBlock(msg.GetBlock(), src.Key)
+		block, err := msg.GetBlock(version)
+		if err != nil {
+			log.Errorf("Error decoding block response %v", err)
+			return
+		}
+		log.Info("BlockResponseMessage height:", block.Height)
+		pr.blockKeeper.AddBlock(block, src.Key)
+
+	case *BlockNotFoundMessage:
+		pr.blockKeeper.BlockNotFound(msg.Height, msg.Pruned, src.Key)
+
+	case *GetHeadersMessage:
+		locator := msg.GetBlockLocator()
+		if len(locator) > maxBlockLocatorSize {
+			log.WithFields(log.Fields{"peerID": src.Key, "size": len(locator)}).Warning("block locator is too large")
+			pr.addBanScore(src, "block locator too large")
+			return
+		}
+		headers := pr.chain.LocateHeaders(locator, msg.GetStopHash(), maxHeadersPerMsg)
+		response, err := NewHeadersMessage(headers, version)
+		if err != nil {
+			log.Errorf("Fail on GetHeadersMessage create response: %v", err)
+			return
+		}
+		src.TrySend(BlockchainChannel, struct{ BlockchainMessage }{response})
+
+	case *HeadersMessage:
+		headers, err := msg.GetHeaders(version)
+		if err != nil {
+			log.Errorf("Error decoding headers %v", err)
+			pr.addBanScore(src, "headers decode error")
+			return
+		}
+		if !pr.headersOnly {
+			// the full node syncs by blocks, the headers only serve the
+			// light clients asking for them
+			log.WithFields(log.Fields{"peerID": src.Key, "headers": len(headers)}).Debug("ignore unrequested headers")
+			return
+		}
+		pr.blockKeeper.AddHeaders(headers, src.Key)
 
 	case *StatusRequestMessage:
 		blockHeader := pr.chain.BestBlockHeader()
@@ -220,10 +270,20 @@ func (pr *ProtocolReactor) Receive(chID byte, src *p2p.Peer, msgBytes []byte) {
 			hash:        msg.GetHash(),
 			genesisHash: msg.GetGenesisHash(),
 		}
-		pr.peerStatusCh <- peerStatus
+		// only AddPeer waits on the channel, drop unsolicited responses
+		// instead of blocking the receive routine
+		select {
+		case pr.peerStatusCh <- peerStatus:
+		default:
+			log.WithField("peerID", src.Key).Warning("drop unsolicited status response")
+		}
 
 	case *TransactionNotifyMessage:
-		tx, err := msg.GetTransaction()
+		if pr.headersOnly {
+			// without utxos the transactions can't be validated
+			return
+		}
+		tx, err := msg.GetTransaction(version)
 		if err != nil {
 			log.Errorf("Error decoding new tx %v", err)
 			return
@@ -231,7 +291,7 @@ func (pr *ProtocolReactor) Receive(chID byte, src *p2p.Peer, msgBytes []byte) {
 		pr.blockKeeper.AddTx(tx, src.Key)
 
 	case *MineBlockMessage:
-		block, err := msg.GetMineBlock()
+		block, err := msg.GetMineBlock(version)
 		if err != nil {
 			log.Errorf("Error decoding mined block %v", err)
 			return
@@ -239,10 +299,102 @@ func (pr *ProtocolReactor) Receive(chID byte, src *p2p.Peer, msgBytes []byte) {
 		// Mark the peer as owning the block and schedule it for import
 		hash := block.Hash()
 		pr.peers.MarkBlock(src.Key, &hash)
-		pr.fetcher.Enqueue(src.Key, block)
 		pr.peers.SetPeerStatus(src.Key, block.Height, &hash)
+		if pr.headersOnly {
+			pr.blockKeeper.ProcessHeaders(src.Key, []*types.BlockHeader{&block.BlockHeader})
+			return
+		}
+		pr.importBlock(src, block)
+
+	case *CompactBlockMessage:
+		pr.handleCompactBlock(src, version, msg)
+
+	case *GetBlockTxnMessage:
+		block, err := pr.chain.GetBlockByHash(msg.GetHash())
+		if err != nil {
+			log.Errorf("Fail on GetBlockTxnMessage get block: %v", err)
+			return
+		}
+		response, err := NewBlockTxnMessage(block, msg.Indexes, version)
+		if err != nil {
+			log.Errorf("Fail on GetBlockTxnMessage create response: %v", err)
+			return
+		}
+		src.TrySend(BlockchainChannel, struct{ BlockchainMessage }{response})
+
+	case *BlockTxnMessage:
+		block, err := pr.compacts.complete(src.Key, version, msg)
+		if err != nil {
+			log.Errorf("Error completing compact block %v", err)
+			pr.addBanScore(src, "compact block completion error")
+			return
+		}
+		pr.importBlock(src, block)
 
 	default:
 		log.Error(cmn.Fmt("Unknown message type %v", reflect.TypeOf(msg)))
 	}
 }
+
+// handleCompactBlock rebuilds the announced block from the mempool, the
+// transactions the mempool lacks are requested from the peer
+func (pr *ProtocolReactor) handleCompactBlock(src *p2p.Peer, version int, msg *CompactBlockMessage) {
+	header, err := msg.GetHeader(version)
+	if err != nil {
+		log.Errorf("Error decoding compact block %v", err)
+		return
+	}
+
+	hash := header.Hash()
+	pr.peers.MarkBlock(src.Key, &hash)
+	pr.peers.SetPeerStatus(src.Key, header.Height, &hash)
+	if pr.headersOnly {
+		// the header is all the headers mode wants of the block
+		pr.blockKeeper.ProcessHeaders(src.Key, []*types.BlockHeader{header})
+		return
+	}
+	if pr.chain.BlockExist(&hash) {
+		return
+	}
+
+	block, missing, err := pr.compacts.reconstruct(src.Key, version, header, msg)
+	if err != nil {
+		log.Errorf("Error rebuilding compact block %v", err)
+		pr.addBanScore(src, "compact block error")
+		return
+	}
+	if block != nil {
+		pr.importBlock(src, block)
+		return
+	}
+
+	log.WithFields(log.Fields{"hash": hash.String(), "missing": len(missing)}).Debug("request compact block transactions")
+	request := &GetBlockTxnMessage{RawHash: hash.Byte32(), Indexes: missing}
+	src.TrySend(BlockchainChannel, struct{ BlockchainMessage }{request})
+}
+
+// importBlock schedules a propagated block for import by the fetcher, a
+// reactor wired without one is a bug and must not drop the blocks silently
+func (pr *ProtocolReactor) importBlock(src *p2p.Peer, block *types.Block) {
+	if pr.replay {
+		pr.blockKeeper.AddBlock(block, src.Key)
+		return
+	}
+	if pr.fetcher == nil {
+		panic(errNoFetcher)
+	}
+	if err := pr.fetcher.Enqueue(src.Key, block); err != nil {
+		log.WithFields(log.Fields{"peer": src.Key, "height": block.Height, "err": err}).Warning("fail on enqueue the propagated block")
+	}
+}
+
+func (pr *ProtocolReactor) addBanScore(src *p2p.Peer, reason string) {
+	prPeer, ok := pr.peers.Peer(src.Key)
+	if !ok {
+		return
+	}
+	if ban := prPeer.addBanScore(20, 0, reason); ban {
+		pr.sw.AddBannedPeer(src)
+		pr.sw.StopPeerGracefully(src)
+	}
+}
@@ -0,0 +1,138 @@
+package netsync
+
+import (
+	"fmt"
+	"io"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/btm-stats/p2p"
+	"github.com/btm-stats/p2p/capture"
+	"github.com/btm-stats/protocol/bc"
+)
+
+// ReplayResult summarizes a replay run
+type ReplayResult struct {
+	Frames        int     `json:"frames"`
+	Delivered     int     `json:"delivered"`
+	Skipped       int     `json:"skipped"`
+	Blocks        int     `json:"blocks"`
+	Orphans       int     `json:"orphans"`
+	InvalidBlocks int     `json:"invalid_blocks"`
+	Height        uint64  `json:"height"`
+	Hash          bc.Hash `json:"hash"`
+
+	RejectReasons map[string]uint64 `json:"reject_reasons"`
+}
+
+type replaySource struct {
+	reader *capture.Reader
+	peer   *p2p.Peer
+	next   *capture.Frame
+}
+
+// Replay feeds the inbound messages of the captures into the reactors, the
+// frames of all the captures are merged by their timestamp. Blocks handed to
+// the block keeper are processed right after each message so the run is
+// deterministic.
+func (sm *SyncManager) Replay(readers []*capture.Reader) (*ReplayResult, error) {
+	sm.sw.Reactor("PROTOCOL").(*ProtocolReactor).replay = true
+	sources := []*replaySource{}
+	for _, reader := range readers {
+		peer := p2p.NewReplayPeer(reader.Header)
+		if err := sm.sw.AddReplayPeer(peer); err != nil {
+			return nil, err
+		}
+
+		source := &replaySource{reader: reader, peer: peer}
+		if err := source.advance(); err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+
+	result := &ReplayResult{}
+	for {
+		source := earliestSource(sources)
+		if source == nil {
+			break
+		}
+
+		frame := source.next
+		result.Frames++
+		if frame.Outbound {
+			result.Skipped++
+		} else if err := sm.replayFrame(source.peer, frame, result); err != nil {
+			log.WithFields(log.Fields{"peer": source.peer.Key, "frame": result.Frames, "err": err}).Warning("skip replay frame")
+			result.Skipped++
+		} else {
+			result.Delivered++
+		}
+
+		if err := source.advance(); err != nil {
+			return nil, err
+		}
+	}
+
+	result.Height = sm.chain.BestBlockHeight()
+	result.Hash = *sm.chain.BestBlockHash()
+	result.RejectReasons = sm.rejects.Counts()
+	return result, nil
+}
+
+func (sm *SyncManager) replayFrame(peer *p2p.Peer, frame *capture.Frame, result *ReplayResult) (err error) {
+	// a panic here is a bug reproduced by the capture, report the frame
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic on channel %X: %v", frame.ChannelID, r)
+		}
+	}()
+
+	if err := sm.sw.DeliverMessage(peer, frame.ChannelID, frame.Payload); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case pending := <-sm.blockKeeper.pendingProcessCh:
+			result.Blocks++
+			isOrphan, err := sm.chain.ProcessBlock(pending.block)
+			if err != nil {
+				reason := sm.rejects.Add(err)
+				log.WithFields(log.Fields{"height": pending.block.Height, "reason": reason, "err": err}).Warning("replay block is invalid")
+				result.InvalidBlocks++
+			} else if isOrphan {
+				result.Orphans++
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+func (s *replaySource) advance() error {
+	frame, err := s.reader.Next()
+	if err == io.EOF {
+		s.next = nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	s.next = frame
+	return nil
+}
+
+func earliestSource(sources []*replaySource) *replaySource {
+	var earliest *replaySource
+	for _, source := range sources {
+		if source.next == nil {
+			continue
+		}
+		if earliest == nil || source.next.Time.Before(earliest.next.Time) {
+			earliest = source
+		}
+	}
+	return earliest
+}
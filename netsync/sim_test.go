@@ -0,0 +1,606 @@
+package netsync
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	gonet "net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	cfg "github.com/btm-stats/config"
+	"github.com/btm-stats/consensus"
+	"github.com/btm-stats/database"
+	"github.com/btm-stats/database/leveldb"
+	"github.com/btm-stats/p2p"
+	"github.com/btm-stats/protocol"
+	"github.com/btm-stats/protocol/bc"
+	"github.com/btm-stats/protocol/bc/types"
+)
+
+const (
+	// the block keeper only syncs with few peers on forceSyncCycle
+	simConvergeTimeout = 3 * forceSyncCycle
+	simPollInterval    = 100 * time.Millisecond
+	// simFuzzAfter lets the handshake of a fuzzed link complete first
+	simFuzzAfter = 500 * time.Millisecond
+)
+
+// opTrue is the control program of the synthetic coinbase outputs, anyone
+// can spend it without a witness
+var opTrue = []byte{0x51}
+
+// pipeListener hands the in-memory connections of the simulation to the
+// switch the same way a tcp listener hands the accepted ones
+type pipeListener struct {
+	conns    chan gonet.Conn
+	addr     *p2p.NetAddress
+	stopOnce sync.Once
+}
+
+func newPipeListener(port uint16) *pipeListener {
+	return &pipeListener{
+		conns: make(chan gonet.Conn, 16),
+		addr:  p2p.NewNetAddressIPPort(gonet.IPv4(127, 0, 0, 1), port),
+	}
+}
+
+func (l *pipeListener) Connections() <-chan gonet.Conn   { return l.conns }
+func (l *pipeListener) InternalAddress() *p2p.NetAddress { return l.addr }
+func (l *pipeListener) ExternalAddress() *p2p.NetAddress { return l.addr }
+func (l *pipeListener) String() string                   { return "pipe " + l.addr.String() }
+
+func (l *pipeListener) Stop() bool {
+	l.stopOnce.Do(func() { close(l.conns) })
+	return true
+}
+
+// simFaults drops and delays the block announcements of a node in place of its
+// mined block relay, a delayed announcement can be overtaken by the next ones
+type simFaults struct {
+	// drop tells whether the announcement of the block to the peer is lost
+	drop     func(block *types.Block, peerID string) bool
+	maxDelay time.Duration
+}
+
+// simNode is one in-process node of the simulation network
+type simNode struct {
+	name       string
+	chain      *protocol.Chain
+	txPool     *protocol.TxPool
+	sm         *SyncManager
+	listener   *pipeListener
+	newBlockCh chan *bc.Hash
+	faults     *simFaults
+}
+
+// simNetwork is a set of nodes whose switches talk over in-memory pipes
+type simNetwork struct {
+	t       *testing.T
+	rootDir string
+	nodes   []*simNode
+}
+
+func newSimNetwork(t *testing.T, n int) *simNetwork {
+	if testing.Short() {
+		t.Skip("skipping simulation network in short mode")
+	}
+
+	// the simulation blocks aren't mined, only solonet skips the proof of work
+	consensus.ActiveNetParams = consensus.SoloNetParams
+
+	rootDir, err := ioutil.TempDir("", "netsync-sim")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	net := &simNetwork{t: t, rootDir: rootDir}
+	for i := 0; i < n; i++ {
+		net.addNode()
+	}
+	return net
+}
+
+func (net *simNetwork) addNode() *simNode {
+	return net.addModeNode(cfg.FullMode)
+}
+
+// addModeNode adds a node running in the given node mode
+func (net *simNetwork) addModeNode(mode string) *simNode {
+	name := fmt.Sprintf("node%d", len(net.nodes))
+	config := cfg.DefaultConfig()
+	config.SetRoot(filepath.Join(net.rootDir, name))
+	config.Moniker = name
+	config.Mode = mode
+	config.ChainID = "solonet"
+	config.VaultMode = true
+	config.DBBackend = "memdb"
+
+	txPool := protocol.NewTxPool()
+	store, err := leveldb.NewStore(database.NewMemDB())
+	if err != nil {
+		net.t.Fatal(err)
+	}
+	chain, err := protocol.NewChain(store, txPool)
+	if err != nil {
+		net.t.Fatal(err)
+	}
+
+	newBlockCh := make(chan *bc.Hash, 1024)
+	sm, err := NewSyncManager(config, chain, txPool, newBlockCh)
+	if err != nil {
+		net.t.Fatal(err)
+	}
+
+	node := &simNode{
+		name:       name,
+		chain:      chain,
+		txPool:     txPool,
+		sm:         sm,
+		listener:   newPipeListener(uint16(46656 + len(net.nodes))),
+		newBlockCh: newBlockCh,
+	}
+	node.start()
+	net.nodes = append(net.nodes, node)
+	net.waitFor("switch start of "+name, func() bool { return sm.Switch().IsRunning() })
+	return node
+}
+
+func (n *simNode) start() {
+	n.sm.sw.AddListener(n.listener)
+	n.sm.Start()
+}
+
+func (n *simNode) stop() {
+	n.sm.Stop()
+}
+
+// announceFaulty sends the block to each peer unless the faults drop it,
+// after a random delay. It stands in for the mined block relay of the sync
+// manager.
+func (n *simNode) announceFaulty(block *types.Block) {
+	hash := block.Hash()
+	for _, p := range n.sm.peers.PeersWithoutBlock(&hash) {
+		p.MarkBlock(&hash)
+		if n.faults.drop != nil && n.faults.drop(block, p.id) {
+			continue
+		}
+
+		msg, err := NewMinedBlockMessage(block, protocolVersion(p.swPeer.NodeInfo))
+		if err != nil {
+			continue
+		}
+		delay := time.Duration(0)
+		if n.faults.maxDelay > 0 {
+			delay = time.Duration(rand.Int63n(int64(n.faults.maxDelay)))
+		}
+		go func(swPeer *p2p.Peer) {
+			time.Sleep(delay)
+			swPeer.TrySend(BlockchainChannel, struct{ BlockchainMessage }{msg})
+		}(p.swPeer)
+	}
+}
+
+// submitTx hands the transaction to the node as if a peer had sent it
+func (n *simNode) submitTx(tx *types.Tx) {
+	n.sm.blockKeeper.AddTx(tx, "")
+}
+
+func (n *simNode) hasTx(txID *bc.Hash) bool {
+	for _, desc := range n.txPool.GetTransactions() {
+		if desc.Tx.ID == *txID {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *simNode) key() string {
+	return n.sm.Switch().NodeInfo().PubKey.KeyString()
+}
+
+func (n *simNode) connected(other *simNode) bool {
+	return n.sm.Switch().Peers().Has(other.key()) && other.sm.Switch().Peers().Has(n.key())
+}
+
+func (net *simNetwork) stop() {
+	for _, node := range net.nodes {
+		node.stop()
+	}
+	os.RemoveAll(net.rootDir)
+}
+
+// connect links two nodes by a pipe, the fuzz config applies to both ends
+// once the handshake is done
+func (net *simNetwork) connect(a, b *simNode, fuzzConfig *p2p.FuzzConnConfig) {
+	c1, c2 := gonet.Pipe()
+	if fuzzConfig != nil {
+		c1 = p2p.FuzzConnAfterFromConfig(c1, simFuzzAfter, fuzzConfig)
+		c2 = p2p.FuzzConnAfterFromConfig(c2, simFuzzAfter, fuzzConfig)
+	}
+	a.listener.conns <- c1
+	b.listener.conns <- c2
+	net.waitFor(fmt.Sprintf("link of %s and %s", a.name, b.name), func() bool { return a.connected(b) })
+}
+
+func (net *simNetwork) connectAll(fuzzConfig *p2p.FuzzConnConfig) {
+	for i := 0; i < len(net.nodes); i++ {
+		for j := i + 1; j < len(net.nodes); j++ {
+			net.connect(net.nodes[i], net.nodes[j], fuzzConfig)
+		}
+	}
+}
+
+// disconnect stops the peer of each side, the pipe closes under both
+func (net *simNetwork) disconnect(a, b *simNode) {
+	if peer := a.sm.Switch().Peers().Get(b.key()); peer != nil {
+		a.sm.Switch().StopPeerGracefully(peer)
+	}
+	net.waitFor(fmt.Sprintf("unlink of %s and %s", a.name, b.name), func() bool {
+		return !a.sm.Switch().Peers().Has(b.key()) && !b.sm.Switch().Peers().Has(a.key())
+	})
+}
+
+// relink connects again the pairs whose fuzzed link was dropped until done
+// is closed
+func (net *simNetwork) relink(fuzzConfig *p2p.FuzzConnConfig, done chan struct{}) {
+	ticker := time.NewTicker(simPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+
+		for i := 0; i < len(net.nodes); i++ {
+			for j := i + 1; j < len(net.nodes); j++ {
+				a, b := net.nodes[i], net.nodes[j]
+				aHas, bHas := a.sm.Switch().Peers().Has(b.key()), b.sm.Switch().Peers().Has(a.key())
+				if aHas || bHas {
+					// a half closed link is torn down by the ping timeout
+					continue
+				}
+				c1, c2 := gonet.Pipe()
+				a.listener.conns <- p2p.FuzzConnAfterFromConfig(c1, simFuzzAfter, fuzzConfig)
+				b.listener.conns <- p2p.FuzzConnAfterFromConfig(c2, simFuzzAfter, fuzzConfig)
+			}
+		}
+	}
+}
+
+func (net *simNetwork) waitFor(what string, cond func() bool) {
+	deadline := time.Now().Add(simConvergeTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			net.t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(simPollInterval)
+	}
+}
+
+// waitConverge waits until every node's best block is the given one
+func (net *simNetwork) waitConverge(nodes []*simNode, hash *bc.Hash) {
+	net.waitFor("convergence on "+hash.String(), func() bool {
+		for _, node := range nodes {
+			if *node.chain.BestBlockHash() != *hash {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// mineBlocks appends count synthetic blocks on top of the node's best block
+// and hands each of them to the mined block relay like the miner does
+func (n *simNode) mineBlocks(t *testing.T, count int) []*types.Block {
+	blocks := []*types.Block{}
+	for i := 0; i < count; i++ {
+		blocks = append(blocks, n.mineBlock(t, 0))
+	}
+	return blocks
+}
+
+// mineBlock appends a block with the given transactions paying fee in total
+func (n *simNode) mineBlock(t *testing.T, fee uint64, txs ...*types.Tx) *types.Block {
+	parent, err := n.chain.GetBlockByHash(n.chain.BestBlockHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := newSimBlock(t, parent, []byte(n.name), fee, txs...)
+	if isOrphan, err := n.chain.ProcessBlock(block); err != nil || isOrphan {
+		t.Fatalf("%s fail on process mined block: orphan %v, err %v", n.name, isOrphan, err)
+	}
+
+	hash := block.Hash()
+	if n.faults != nil {
+		n.announceFaulty(block)
+		return block
+	}
+	n.newBlockCh <- &hash
+	return block
+}
+
+// newSimBlock builds a block paying the coinbase to opTrue, there is no
+// proof of work so the blocks are only accepted by the simulation chains
+func newSimBlock(t *testing.T, parent *types.Block, arbitrary []byte, fee uint64, txs ...*types.Tx) *types.Block {
+	height := parent.Height + 1
+	coinbase := newSimTx(t, types.TxData{
+		Version: 1,
+		Inputs:  []*types.TxInput{types.NewCoinbaseInput(append(arbitrary, byte(height), byte(height>>8)))},
+		Outputs: []*types.TxOutput{types.NewTxOutput(*consensus.BTMAssetID, consensus.BlockSubsidy(height)+fee, opTrue)},
+	})
+	txs = append([]*types.Tx{coinbase}, txs...)
+
+	txStatus := bc.NewTransactionStatus()
+	bcTxs := make([]*bc.Tx, len(txs))
+	for i, tx := range txs {
+		txStatus.SetStatus(i, false)
+		bcTxs[i] = tx.Tx
+	}
+	txStatusHash, err := bc.TxStatusMerkleRoot(txStatus.VerifyStatus)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merkleRoot, err := bc.TxMerkleRoot(bcTxs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &types.Block{
+		BlockHeader: types.BlockHeader{
+			Version:           1,
+			Height:            height,
+			PreviousBlockHash: parent.Hash(),
+			Timestamp:         parent.Timestamp + 1,
+			Bits:              parent.Bits,
+			BlockCommitment: types.BlockCommitment{
+				TransactionsMerkleRoot: merkleRoot,
+				TransactionStatusHash:  txStatusHash,
+			},
+		},
+		Transactions: txs,
+	}
+}
+
+// newSimSpendTx spends the coinbase output of the block back to opTrue
+func newSimSpendTx(t *testing.T, block *types.Block, fee uint64) *types.Tx {
+	coinbase := block.Transactions[0]
+	output := coinbase.Entries[*coinbase.ResultIds[0]].(*bc.Output)
+	amount := output.Source.Value.Amount
+
+	return newSimTx(t, types.TxData{
+		Version: 1,
+		Inputs:  []*types.TxInput{types.NewSpendInput(nil, *output.Source.Ref, *consensus.BTMAssetID, amount, output.Source.Position, opTrue)},
+		Outputs: []*types.TxOutput{types.NewTxOutput(*consensus.BTMAssetID, amount-fee, opTrue)},
+	})
+}
+
+// newSimTx maps the tx data with its serialized size, the validation rejects
+// transactions without it
+func newSimTx(t *testing.T, data types.TxData) *types.Tx {
+	var buf bytes.Buffer
+	if _, err := data.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	data.SerializedSize = uint64(buf.Len())
+	return types.NewTx(data)
+}
+
+func TestSimBlockPropagation(t *testing.T) {
+	net := newSimNetwork(t, 4)
+	defer net.stop()
+	net.connectAll(nil)
+
+	blocks := net.nodes[0].mineBlocks(t, 5)
+	hash := blocks[len(blocks)-1].Hash()
+	net.waitConverge(net.nodes, &hash)
+}
+
+func TestSimLateJoinerSync(t *testing.T) {
+	net := newSimNetwork(t, 3)
+	defer net.stop()
+	net.connect(net.nodes[0], net.nodes[1], nil)
+
+	blocks := net.nodes[0].mineBlocks(t, 10)
+	hash := blocks[len(blocks)-1].Hash()
+	net.waitConverge(net.nodes[:2], &hash)
+
+	// node2 missed every announcement and has to sync by block requests
+	net.connect(net.nodes[2], net.nodes[1], nil)
+	net.waitConverge(net.nodes, &hash)
+}
+
+func TestSimHeadersSync(t *testing.T) {
+	net := newSimNetwork(t, 2)
+	defer net.stop()
+	net.connect(net.nodes[0], net.nodes[1], nil)
+
+	blocks := net.nodes[0].mineBlocks(t, 10)
+	hash := blocks[len(blocks)-1].Hash()
+	net.waitConverge(net.nodes, &hash)
+
+	// the headers node only finds its sync peer among the headers peers and
+	// catches up by the headers requests
+	headersNode := net.addModeNode(cfg.HeadersMode)
+	net.connect(headersNode, net.nodes[1], nil)
+	net.waitConverge(net.nodes, &hash)
+
+	// then it follows the announced blocks by their headers
+	blocks = net.nodes[0].mineBlocks(t, 3)
+	hash = blocks[len(blocks)-1].Hash()
+	net.waitConverge(net.nodes, &hash)
+	if _, err := headersNode.chain.GetBlockByHeight(1); err == nil {
+		t.Error("the headers node stores the block bodies")
+	}
+}
+
+func TestSimReorg(t *testing.T) {
+	net := newSimNetwork(t, 4)
+	defer net.stop()
+
+	// two partitions mining competing chains
+	net.connect(net.nodes[0], net.nodes[1], nil)
+	net.connect(net.nodes[2], net.nodes[3], nil)
+
+	shortChain := net.nodes[0].mineBlocks(t, 3)
+	longChain := net.nodes[2].mineBlocks(t, 6)
+	shortHash := shortChain[len(shortChain)-1].Hash()
+	longHash := longChain[len(longChain)-1].Hash()
+	net.waitConverge(net.nodes[:2], &shortHash)
+	net.waitConverge(net.nodes[2:], &longHash)
+
+	// heal the partition, the short side must reorganize to the long chain
+	net.connect(net.nodes[1], net.nodes[2], nil)
+	net.waitConverge(net.nodes, &longHash)
+
+	for _, node := range net.nodes[:2] {
+		block, err := node.chain.GetBlockByHeight(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block.Hash() != longChain[0].Hash() {
+			t.Errorf("%s keeps the stale block at height 1 after reorg", node.name)
+		}
+	}
+}
+
+func TestSimTxRelay(t *testing.T) {
+	net := newSimNetwork(t, 3)
+	defer net.stop()
+
+	// relay along a line so node2 only gets the tx through node1
+	net.connect(net.nodes[0], net.nodes[1], nil)
+	net.connect(net.nodes[1], net.nodes[2], nil)
+
+	blocks := net.nodes[0].mineBlocks(t, int(consensus.CoinbasePendingBlockNumber)+1)
+	hash := blocks[len(blocks)-1].Hash()
+	net.waitConverge(net.nodes, &hash)
+
+	tx := newSimSpendTx(t, blocks[0], 10000000)
+	net.nodes[0].submitTx(tx)
+
+	net.waitFor("tx relay", func() bool {
+		for _, node := range net.nodes {
+			if !node.hasTx(&tx.ID) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func TestSimCompactBlockRelay(t *testing.T) {
+	net := newSimNetwork(t, 2)
+	defer net.stop()
+	net.connect(net.nodes[0], net.nodes[1], nil)
+
+	blocks := net.nodes[0].mineBlocks(t, int(consensus.CoinbasePendingBlockNumber)+2)
+	hash := blocks[len(blocks)-1].Hash()
+	net.waitConverge(net.nodes, &hash)
+
+	const fee = 10000000
+	relayed := newSimSpendTx(t, blocks[0], fee)
+	net.nodes[0].submitTx(relayed)
+	net.waitFor("tx relay", func() bool { return net.nodes[1].hasTx(&relayed.ID) })
+
+	// node1 rebuilds the relayed tx from its mempool and requests the other
+	unknown := newSimSpendTx(t, blocks[1], fee)
+	block := net.nodes[0].mineBlock(t, 2*fee, relayed, unknown)
+	hash = block.Hash()
+	net.waitConverge(net.nodes, &hash)
+}
+
+func TestSimFuzzedLinks(t *testing.T) {
+	net := newSimNetwork(t, 4)
+	defer net.stop()
+
+	fuzzConfig := &p2p.FuzzConnConfig{
+		Mode:     p2p.FuzzModeDelay,
+		MaxDelay: 50 * time.Millisecond,
+	}
+	net.connectAll(fuzzConfig)
+
+	blocks := net.nodes[0].mineBlocks(t, 3)
+	hash := blocks[len(blocks)-1].Hash()
+	net.waitConverge(net.nodes, &hash)
+
+	// drop a link, the blocks must still flow through the remaining ones
+	net.disconnect(net.nodes[0], net.nodes[3])
+	blocks = net.nodes[0].mineBlocks(t, 3)
+	hash = blocks[len(blocks)-1].Hash()
+	net.waitConverge(net.nodes, &hash)
+}
+
+func TestSimLostAndReorderedAnnouncements(t *testing.T) {
+	net := newSimNetwork(t, 3)
+	defer net.stop()
+
+	// relay along a line so node2 only learns the blocks through node1
+	net.connect(net.nodes[0], net.nodes[1], nil)
+	net.connect(net.nodes[1], net.nodes[2], nil)
+
+	// the even blocks but the last are never announced, the others arrive
+	// out of order
+	const count = 8
+	net.nodes[0].faults = &simFaults{
+		drop: func(block *types.Block, peerID string) bool {
+			return block.Height%2 == 0 && block.Height < count
+		},
+		maxDelay: 300 * time.Millisecond,
+	}
+
+	blocks := net.nodes[0].mineBlocks(t, count)
+	hash := blocks[len(blocks)-1].Hash()
+	net.waitConverge(net.nodes, &hash)
+}
+
+func TestSimDroppedLinks(t *testing.T) {
+	net := newSimNetwork(t, 3)
+	defer net.stop()
+
+	// every read or write may kill the link, the blocks in flight are lost
+	fuzzConfig := &p2p.FuzzConnConfig{
+		Mode:         p2p.FuzzModeDrop,
+		MaxDelay:     20 * time.Millisecond,
+		ProbDropConn: 0.02,
+		ProbSleep:    0.2,
+	}
+	net.connectAll(fuzzConfig)
+
+	stopRelink := make(chan struct{})
+	go net.relink(fuzzConfig, stopRelink)
+	for i := 0; i < 6; i++ {
+		net.nodes[0].mineBlocks(t, 1)
+		time.Sleep(2 * simPollInterval)
+	}
+	close(stopRelink)
+
+	// replace the fuzzed links, the nodes catch up on what they lost
+	for i := 0; i < len(net.nodes); i++ {
+		for j := i + 1; j < len(net.nodes); j++ {
+			a, b := net.nodes[i], net.nodes[j]
+			for _, pair := range [][2]*simNode{{a, b}, {b, a}} {
+				if peer := pair[0].sm.Switch().Peers().Get(pair[1].key()); peer != nil {
+					pair[0].sm.Switch().StopPeerGracefully(peer)
+				}
+			}
+			net.waitFor(fmt.Sprintf("unlink of %s and %s", a.name, b.name), func() bool {
+				return !a.sm.Switch().Peers().Has(b.key()) && !b.sm.Switch().Peers().Has(a.key())
+			})
+			net.connect(a, b, nil)
+		}
+	}
+
+	blocks := net.nodes[0].mineBlocks(t, 1)
+	hash := blocks[0].Hash()
+	net.waitConverge(net.nodes, &hash)
+}
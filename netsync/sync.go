@@ -70,7 +70,10 @@ func (sm *SyncManager) synchronise() {
 		<-sm.dropPeerCh
 	}
 
-	peer, bestHeight := sm.peers.BestPeer()
+	peer, bestHeight := sm.peers.BestPeer(sm.chain.BestBlockHeight() + 1)
+	if sm.headersOnly {
+		peer, bestHeight = sm.peers.BestHeadersPeer()
+	}
 	// Short circuit if no peers are available
 	if peer == nil {
 		return
@@ -82,10 +85,16 @@ func (sm *SyncManager) synchronise() {
 		return
 	}
 
-	if bestHeight > sm.chain.BestBlockHeight() {
-		log.Info("sync peer:", peer.Addr(), " height:", bestHeight)
-		sm.blockKeeper.BlockRequestWorker(peer.Key, bestHeight)
+	if bestHeight <= sm.chain.BestBlockHeight() {
+		return
+	}
+
+	log.Info("sync peer:", peer.Addr(), " height:", bestHeight)
+	if sm.headersOnly {
+		sm.blockKeeper.HeadersRequestWorker(peer.Key, bestHeight)
+		return
 	}
+	sm.blockKeeper.BlockRequestWorker(peer.Key, bestHeight)
 }
 
 // txsyncLoop takes care of the initial transaction sync for each new
@@ -10,14 +10,17 @@ import (
 	"github.com/prometheus/prometheus/util/flock"
 	log "github.com/sirupsen/logrus"
 	cmn "github.com/tendermint/tmlibs/common"
-	dbm "github.com/tendermint/tmlibs/db"
 
+	"github.com/btm-stats/analytics"
+	"github.com/btm-stats/api"
 	cfg "github.com/btm-stats/config"
 	"github.com/btm-stats/netsync"
 	"github.com/btm-stats/protocol"
+	"github.com/btm-stats/database"
 	"github.com/btm-stats/database/leveldb"
 	"github.com/btm-stats/consensus"
 	"github.com/btm-stats/protocol/bc"
+	"github.com/btm-stats/types"
 )
 
 const (
@@ -33,13 +36,37 @@ type Node struct {
 	config *cfg.Config
 
 	syncManager *netsync.SyncManager
-
+	chain       *protocol.Chain
+	api         *api.API
+	followers   []*analytics.Follower
+	anomalies   *analytics.AnomalyDetector
+	evsw        types.EventSwitch
 }
 
 func NewNode(config *cfg.Config) *Node {
+	if config.Mode != cfg.FullMode && config.Mode != cfg.HeadersMode {
+		cmn.Exit(cmn.Fmt("node mode[%v] don't exist", config.Mode))
+	}
+	if config.TxIndex != cfg.TxIndexKV && config.TxIndex != cfg.TxIndexNull {
+		cmn.Exit(cmn.Fmt("tx indexer[%v] don't exist", config.TxIndex))
+	}
+	if config.PruneDepth != 0 && config.PruneDepth < config.UndoDepth {
+		cmn.Exit(cmn.Fmt("prune depth[%v] is below the undo depth[%v], a reorg needs the bodies", config.PruneDepth, config.UndoDepth))
+	}
+
 	// Get store
-	coreDB := dbm.NewDB("core", config.DBBackend, config.DBDir())
-	store := leveldb.NewStore(coreDB)
+	coreDB, err := database.NewDB("core", config.DBBackend, config.DBDir())
+	if err != nil {
+		cmn.Exit(cmn.Fmt("Failed to open the store: %v", err))
+	}
+	store, err := leveldb.NewStore(coreDB)
+	if err != nil {
+		cmn.Exit(cmn.Fmt("Failed to open the store: %v", err))
+	}
+	store.SetUndoDepth(config.UndoDepth)
+	store.SetPruneDepth(config.PruneDepth)
+	store.SetTxIndex(config.TxIndex == cfg.TxIndexKV, config.SpentIndex)
+	store.SetCacheSizes(config.BlockCacheSize<<20, config.HeaderCacheSize<<20, config.UtxoCacheSize<<20)
 
 	txPool := protocol.NewTxPool()
 	chain, err := protocol.NewChain(store, txPool)
@@ -47,6 +74,39 @@ func NewNode(config *cfg.Config) *Node {
 		cmn.Exit(cmn.Fmt("Failed to create chain structure: %v", err))
 	}
 
+	var followers []*analytics.Follower
+	var richList *analytics.RichList
+	if config.Analytics.RichList {
+		richList = analytics.NewRichList(coreDB, config.Analytics.RichListSize, config.Analytics.DustThreshold)
+		followers = append(followers, analytics.NewFollower(coreDB, chain, richList))
+	}
+	var rollups *analytics.Rollups
+	if config.Analytics.Rollups {
+		rollups = analytics.NewRollups(coreDB)
+		followers = append(followers, analytics.NewFollower(coreDB, chain, rollups))
+	}
+	for _, follower := range followers {
+		if config.HeadersOnly() {
+			cmn.Exit("the analytics need the block bodies, they can't run in the headers mode")
+		}
+		if pruned := store.PrunedHeight(); pruned > 0 && follower.DerivedHeight() < pruned {
+			cmn.Exit(cmn.Fmt("the blocks up to %v are pruned, the analytics can't be derived from them", pruned))
+		}
+		store.AddDeriver(follower)
+	}
+
+	evsw := types.NewEventSwitch()
+	var anomalies *analytics.AnomalyDetector
+	if config.Analytics.Anomalies {
+		anomalies = analytics.NewAnomalyDetector(coreDB, analytics.AnomalyConfig{
+			FutureSeconds: config.Analytics.FutureSeconds,
+			GapFactor:     config.Analytics.GapFactor,
+			BurstBlocks:   config.Analytics.BurstBlocks,
+			BurstSeconds:  config.Analytics.BurstSeconds,
+		}, evsw)
+		chain.AddBlockObserver(anomalies)
+	}
+
 	newBlockCh := make(chan *bc.Hash, maxNewBlockChSize)
 
 	syncManager, _ := netsync.NewSyncManager(config, chain, txPool, newBlockCh)
@@ -55,6 +115,14 @@ func NewNode(config *cfg.Config) *Node {
 	node := &Node{
 		config:      config,
 		syncManager: syncManager,
+		chain:       chain,
+		followers:   followers,
+		anomalies:   anomalies,
+		evsw:        evsw,
+	}
+	node.BaseService = *cmn.NewBaseService(nil, "Node", node)
+	if config.ApiAddress != "" {
+		node.api = api.NewAPI(syncManager, chain, store, richList, rollups, anomalies, evsw, config)
 	}
 
 	return node
@@ -91,10 +159,49 @@ func initLogFile(config *cfg.Config) {
 }
 
 func (n *Node) OnStart() error {
-
+	if _, err := n.evsw.Start(); err != nil {
+		return err
+	}
+	if n.anomalies != nil {
+		n.anomalies.Start()
+	}
 	if !n.config.VaultMode {
 		n.syncManager.Start()
 	}
+	if n.api != nil {
+		n.api.StartServer(n.config.ApiAddress)
+	}
+	for _, follower := range n.followers {
+		follower.Start()
+	}
 
 	return nil
 }
+
+func (n *Node) OnStop() {
+	n.BaseService.OnStop()
+	for _, follower := range n.followers {
+		follower.Stop()
+	}
+	if n.api != nil {
+		n.api.StopServer()
+	}
+	if !n.config.VaultMode {
+		n.syncManager.Stop()
+	}
+	if n.anomalies != nil {
+		n.anomalies.Stop()
+	}
+	n.evsw.Stop()
+}
+
+func (n *Node) RunForever() {
+	// Sleep forever and then...
+	cmn.TrapSignal(func() {
+		n.Stop()
+	})
+}
+
+func (n *Node) SyncManager() *netsync.SyncManager {
+	return n.syncManager
+}
@@ -0,0 +1,86 @@
+package p2p
+
+import (
+	log "github.com/sirupsen/logrus"
+	cmn "github.com/tendermint/tmlibs/common"
+
+	"github.com/btm-stats/errors"
+	"github.com/btm-stats/p2p/capture"
+)
+
+var (
+	errUnknownChannel = errors.New("message on unknown channel")
+	errPeerStopped    = errors.New("peer has been stopped")
+)
+
+func (sw *Switch) startCapture(peer *Peer) {
+	if sw.recorder == nil {
+		return
+	}
+
+	header := &capture.Header{
+		PeerID:     peer.Key,
+		RemoteAddr: peer.RemoteAddr,
+		ListenAddr: peer.ListenAddr,
+		Moniker:    peer.Moniker,
+		Version:    peer.Version,
+		Network:    peer.Network,
+		Other:      peer.Other,
+		Outbound:   peer.outbound,
+	}
+	if err := sw.recorder.AddPeer(header); err != nil {
+		log.WithFields(log.Fields{"peer": peer.Key, "err": err}).Error("fail on start p2p message capture")
+		return
+	}
+	peer.recorder = sw.recorder
+}
+
+func (sw *Switch) stopCapture(peer *Peer) {
+	if sw.recorder != nil {
+		sw.recorder.RemovePeer(peer.Key)
+	}
+}
+
+// NewReplayPeer creates a peer without connection standing for the remote
+// side of a capture file, messages sent to it are dropped.
+func NewReplayPeer(header *capture.Header) *Peer {
+	nodeInfo := &NodeInfo{
+		Moniker:    header.Moniker,
+		Network:    header.Network,
+		Version:    header.Version,
+		RemoteAddr: header.RemoteAddr,
+		ListenAddr: header.ListenAddr,
+		Other:      header.Other,
+	}
+	p := &Peer{
+		peerConn: &peerConn{outbound: header.Outbound, config: &PeerConfig{}},
+		NodeInfo: nodeInfo,
+		Key:      header.PeerID,
+		Data:     cmn.NewCMap(),
+	}
+	p.BaseService = *cmn.NewBaseService(nil, "ReplayPeer", p)
+	return p
+}
+
+// AddReplayPeer registers a replay peer on the switch so the reactors can
+// look it up and stop it like a connected one.
+func (sw *Switch) AddReplayPeer(peer *Peer) error {
+	return sw.peers.Add(peer)
+}
+
+// DeliverMessage hands a recorded message to the reactor owning the channel,
+// the same way the MConnection receive routine does.
+func (sw *Switch) DeliverMessage(peer *Peer, chID byte, msgBytes []byte) error {
+	select {
+	case <-peer.Quit:
+		return errPeerStopped
+	default:
+	}
+
+	reactor := sw.reactorsByCh[chID]
+	if reactor == nil {
+		return errors.Wrapf(errUnknownChannel, "channel %X", chID)
+	}
+	reactor.Receive(chID, peer, msgBytes)
+	return nil
+}
@@ -0,0 +1,163 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/btm-stats/errors"
+)
+
+const (
+	fileMagic       = "BTMCAP01"
+	maxHeaderSize   = 4096
+	maxPayloadSize  = 22020096 // same as the MConnection receive message capacity
+	frameHeaderSize = 8 + 1 + 1 + 4
+)
+
+var (
+	errBadMagic    = errors.New("not a capture file")
+	errBadHeader   = errors.New("capture header too large")
+	errBadFrame    = errors.New("capture frame too large")
+	errEmptyPeerID = errors.New("capture header without peer id")
+)
+
+// Header describes the remote peer of a capture file, it is written once at
+// the beginning of the file.
+type Header struct {
+	PeerID     string   `json:"peer_id"`
+	RemoteAddr string   `json:"remote_addr"`
+	ListenAddr string   `json:"listen_addr"`
+	Moniker    string   `json:"moniker"`
+	Version    string   `json:"version"`
+	Network    string   `json:"network"`
+	Other      []string `json:"other,omitempty"`
+	Outbound   bool     `json:"outbound"`
+}
+
+// Frame is a raw message recorded at the connection boundary
+type Frame struct {
+	Time      time.Time
+	Outbound  bool // true for messages sent by us
+	ChannelID byte
+	Payload   []byte
+}
+
+// Writer serializes frames to an underlying writer
+type Writer struct {
+	w *bufio.Writer
+}
+
+// NewWriter writes the file header and returns a frame writer
+func NewWriter(w io.Writer, header *Header) (*Writer, error) {
+	rawHeader, err := json.Marshal(header)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal capture header")
+	}
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(fileMagic); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(bw, binary.BigEndian, uint32(len(rawHeader))); err != nil {
+		return nil, err
+	}
+	if _, err := bw.Write(rawHeader); err != nil {
+		return nil, err
+	}
+	return &Writer{w: bw}, bw.Flush()
+}
+
+// WriteFrame appends one frame, the frame layout is
+// time(8) | direction(1) | channel(1) | len(4) | payload
+func (cw *Writer) WriteFrame(f *Frame) error {
+	var head [frameHeaderSize]byte
+	binary.BigEndian.PutUint64(head[0:8], uint64(f.Time.UnixNano()))
+	if f.Outbound {
+		head[8] = 1
+	}
+	head[9] = f.ChannelID
+	binary.BigEndian.PutUint32(head[10:14], uint32(len(f.Payload)))
+	if _, err := cw.w.Write(head[:]); err != nil {
+		return err
+	}
+	_, err := cw.w.Write(f.Payload)
+	return err
+}
+
+// Flush writes the buffered frames to the underlying writer
+func (cw *Writer) Flush() error {
+	return cw.w.Flush()
+}
+
+// Reader deserializes frames from a capture stream
+type Reader struct {
+	Header *Header
+	r      *bufio.Reader
+}
+
+// NewReader reads the file header and returns a frame reader
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(fileMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return nil, errors.Wrap(err, "read capture magic")
+	}
+	if string(magic) != fileMagic {
+		return nil, errBadMagic
+	}
+
+	var size uint32
+	if err := binary.Read(br, binary.BigEndian, &size); err != nil {
+		return nil, errors.Wrap(err, "read capture header size")
+	}
+	if size > maxHeaderSize {
+		return nil, errBadHeader
+	}
+
+	rawHeader := make([]byte, size)
+	if _, err := io.ReadFull(br, rawHeader); err != nil {
+		return nil, errors.Wrap(err, "read capture header")
+	}
+	header := &Header{}
+	if err := json.Unmarshal(rawHeader, header); err != nil {
+		return nil, errors.Wrap(err, "unmarshal capture header")
+	}
+	if header.PeerID == "" {
+		return nil, errEmptyPeerID
+	}
+	return &Reader{Header: header, r: br}, nil
+}
+
+// Next returns the next frame, io.EOF is returned at the end of the stream
+func (cr *Reader) Next() (*Frame, error) {
+	var head [frameHeaderSize]byte
+	if _, err := io.ReadFull(cr.r, head[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			// a frame cut by a crash, treat it as the end of the capture
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(head[10:14])
+	if size > maxPayloadSize {
+		return nil, errBadFrame
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(cr.r, payload); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+
+	return &Frame{
+		Time:      time.Unix(0, int64(binary.BigEndian.Uint64(head[0:8]))),
+		Outbound:  head[8] == 1,
+		ChannelID: head[9],
+		Payload:   payload,
+	}, nil
+}
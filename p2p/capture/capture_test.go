@@ -0,0 +1,168 @@
+package capture
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testHeader = &Header{
+	PeerID:     "0123456789abcdef0123",
+	RemoteAddr: "127.0.0.1:46656",
+	Moniker:    "peer",
+	Version:    "1.0.0",
+	Network:    "mainnet",
+	Outbound:   true,
+}
+
+func testFrames() []*Frame {
+	return []*Frame{
+		{Time: time.Unix(1527814550, 1), Outbound: true, ChannelID: 0x40, Payload: []byte{0x01, 0x02, 0x03}},
+		{Time: time.Unix(1527814551, 2), ChannelID: 0x40, Payload: []byte{}},
+		{Time: time.Unix(1527814552, 3), ChannelID: 0x41, Payload: bytes.Repeat([]byte{0xff}, 300)},
+	}
+}
+
+// readAll reads the frames of a capture stream up to io.EOF
+func readAll(data []byte) (*Header, []*Frame, error) {
+	reader, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	frames := []*Frame{}
+	for {
+		frame, err := reader.Next()
+		if err == io.EOF {
+			return reader.Header, frames, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		frames = append(frames, frame)
+	}
+}
+
+func equalFrames(a, b *Frame) bool {
+	return a.Time.Equal(b.Time) && a.Outbound == b.Outbound && a.ChannelID == b.ChannelID && bytes.Equal(a.Payload, b.Payload)
+}
+
+func TestWriterReader(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, testHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	headerSize := buf.Len()
+
+	frameEnds := []int{}
+	for _, frame := range testFrames() {
+		if err := writer.WriteFrame(frame); err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		frameEnds = append(frameEnds, buf.Len())
+	}
+
+	header, frames, err := readAll(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(header, testHeader) {
+		t.Errorf("got header %+v, want %+v", header, testHeader)
+	}
+	if len(frames) != len(testFrames()) {
+		t.Fatalf("got %d frames, want %d", len(frames), len(testFrames()))
+	}
+	for i, frame := range testFrames() {
+		if !equalFrames(frames[i], frame) {
+			t.Errorf("frame %d: got %+v, want %+v", i, frames[i], frame)
+		}
+	}
+
+	// a file cut by a crash reads as the frames written in full
+	for size := headerSize; size <= buf.Len(); size++ {
+		_, frames, err := readAll(buf.Bytes()[:size])
+		if err != nil {
+			t.Fatalf("truncated at %d: %v", size, err)
+		}
+
+		want := 0
+		for _, end := range frameEnds {
+			if end <= size {
+				want++
+			}
+		}
+		if len(frames) != want {
+			t.Errorf("truncated at %d: got %d frames, want %d", size, len(frames), want)
+		}
+	}
+
+	// without a complete header there's nothing to replay
+	for size := 0; size < headerSize; size++ {
+		if _, err := NewReader(bytes.NewReader(buf.Bytes()[:size])); err == nil {
+			t.Errorf("truncated header at %d: got no error", size)
+		}
+	}
+}
+
+func TestReaderBadInput(t *testing.T) {
+	if _, err := NewReader(bytes.NewReader([]byte("BTMCAP00\x00\x00\x00\x00"))); err != errBadMagic {
+		t.Errorf("got error %v, want %v", err, errBadMagic)
+	}
+
+	buf := &bytes.Buffer{}
+	if _, err := NewWriter(buf, &Header{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewReader(buf); err != errEmptyPeerID {
+		t.Errorf("got error %v, want %v", err, errEmptyPeerID)
+	}
+}
+
+func TestRecorderFlush(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	recorder, err := NewRecorder(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recorder.Stop()
+
+	if err := recorder.AddPeer(testHeader); err != nil {
+		t.Fatal(err)
+	}
+	recorder.Record(testHeader.PeerID, true, 0x40, []byte{0x01, 0x02})
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"+fileSuffix))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("got capture files %v %v, want one", files, err)
+	}
+
+	// the frame reaches the file while the peer is still connected
+	deadline := time.Now().Add(3 * flushInterval)
+	for {
+		data, err := ioutil.ReadFile(files[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, frames, err := readAll(data); err == nil && len(frames) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the recorded frame isn't flushed to the capture file")
+		}
+		time.Sleep(flushInterval / 10)
+	}
+}
@@ -0,0 +1,163 @@
+package capture
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	cmn "github.com/tendermint/tmlibs/common"
+)
+
+const (
+	fileSuffix = ".cap"
+	// flushInterval bounds the frames a crash loses to the last second
+	flushInterval = time.Second
+)
+
+type peerCapture struct {
+	file   *os.File
+	writer *Writer
+	dirty  bool
+}
+
+// Recorder writes the traffic of every peer into its own capture file, the
+// frames are flushed to the files every flushInterval
+type Recorder struct {
+	mtx   sync.Mutex
+	dir   string
+	peers map[string]*peerCapture
+	quit  chan struct{}
+}
+
+// NewRecorder creates a recorder storing the capture files in dir
+func NewRecorder(dir string) (*Recorder, error) {
+	if err := cmn.EnsureDir(dir, 0700); err != nil {
+		return nil, err
+	}
+	r := &Recorder{
+		dir:   dir,
+		peers: make(map[string]*peerCapture),
+		quit:  make(chan struct{}),
+	}
+	go r.flushRoutine()
+	return r, nil
+}
+
+func (r *Recorder) flushRoutine() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.flush()
+		case <-r.quit:
+			return
+		}
+	}
+}
+
+// flush writes the buffered frames of every peer to its capture file
+func (r *Recorder) flush() {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	for peerID, pc := range r.peers {
+		if !pc.dirty {
+			continue
+		}
+		if err := pc.writer.Flush(); err != nil {
+			log.WithFields(log.Fields{"peer": peerID, "err": err}).Error("fail on flush capture file")
+		}
+		pc.dirty = false
+	}
+}
+
+// AddPeer opens a new capture file for the peer
+func (r *Recorder) AddPeer(header *Header) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if _, ok := r.peers[header.PeerID]; ok {
+		return nil
+	}
+
+	name := fmt.Sprintf("%s-%d%s", shortID(header.PeerID), time.Now().UnixNano(), fileSuffix)
+	file, err := os.OpenFile(filepath.Join(r.dir, name), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	writer, err := NewWriter(file, header)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	r.peers[header.PeerID] = &peerCapture{file: file, writer: writer}
+	return nil
+}
+
+// Record appends a message to the capture file of the peer
+func (r *Recorder) Record(peerID string, outbound bool, chID byte, msgBytes []byte) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	pc, ok := r.peers[peerID]
+	if !ok {
+		return
+	}
+
+	// copy the payload, the reactor contract doesn't allow keeping msgBytes
+	frame := &Frame{
+		Time:      time.Now(),
+		Outbound:  outbound,
+		ChannelID: chID,
+		Payload:   append([]byte(nil), msgBytes...),
+	}
+	if err := pc.writer.WriteFrame(frame); err != nil {
+		log.WithFields(log.Fields{"peer": peerID, "err": err}).Error("fail on record p2p message")
+	}
+	pc.dirty = true
+}
+
+// RemovePeer flushes and closes the capture file of the peer
+func (r *Recorder) RemovePeer(peerID string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.closePeer(peerID)
+}
+
+// Stop ends the periodic flush and closes all the capture files
+func (r *Recorder) Stop() {
+	close(r.quit)
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for peerID := range r.peers {
+		r.closePeer(peerID)
+	}
+}
+
+func (r *Recorder) closePeer(peerID string) {
+	pc, ok := r.peers[peerID]
+	if !ok {
+		return
+	}
+
+	if err := pc.writer.Flush(); err != nil {
+		log.WithFields(log.Fields{"peer": peerID, "err": err}).Error("fail on flush capture file")
+	}
+	pc.file.Close()
+	delete(r.peers, peerID)
+}
+
+func shortID(peerID string) string {
+	if len(peerID) > 12 {
+		return peerID[:12]
+	}
+	return peerID
+}
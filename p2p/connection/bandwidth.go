@@ -0,0 +1,73 @@
+package connection
+
+import (
+	"sync/atomic"
+
+	flow "github.com/tendermint/tmlibs/flowrate"
+)
+
+// BandwidthLimiter caps the aggregate throughput of every MConnection sharing
+// it. A rate of zero means unlimited, a nil limiter never throttles.
+type BandwidthLimiter struct {
+	sendMonitor *flow.Monitor
+	recvMonitor *flow.Monitor
+	sendRate    int64 // atomic
+	recvRate    int64 // atomic
+}
+
+// NewBandwidthLimiter returns a limiter for the given node wide rates in bytes
+// per second.
+func NewBandwidthLimiter(sendRate, recvRate int64) *BandwidthLimiter {
+	return &BandwidthLimiter{
+		sendMonitor: flow.New(0, 0),
+		recvMonitor: flow.New(0, 0),
+		sendRate:    sendRate,
+		recvRate:    recvRate,
+	}
+}
+
+// SetRates changes the node wide rates, it takes effect on the next packet.
+func (b *BandwidthLimiter) SetRates(sendRate, recvRate int64) {
+	atomic.StoreInt64(&b.sendRate, sendRate)
+	atomic.StoreInt64(&b.recvRate, recvRate)
+}
+
+// Rates returns the current node wide send and receive rates.
+func (b *BandwidthLimiter) Rates() (sendRate int64, recvRate int64) {
+	return atomic.LoadInt64(&b.sendRate), atomic.LoadInt64(&b.recvRate)
+}
+
+// Status returns the aggregate send and receive statistics.
+func (b *BandwidthLimiter) Status() (send flow.Status, recv flow.Status) {
+	return b.sendMonitor.Status(), b.recvMonitor.Status()
+}
+
+// blocks until the node wide send budget allows another packet
+func (b *BandwidthLimiter) limitSend(want int) {
+	if b == nil {
+		return
+	}
+	b.sendMonitor.Limit(want, atomic.LoadInt64(&b.sendRate), true)
+}
+
+// blocks until the node wide receive budget allows another packet
+func (b *BandwidthLimiter) limitRecv(want int) {
+	if b == nil {
+		return
+	}
+	b.recvMonitor.Limit(want, atomic.LoadInt64(&b.recvRate), true)
+}
+
+func (b *BandwidthLimiter) updateSend(n int) {
+	if b == nil {
+		return
+	}
+	b.sendMonitor.Update(n)
+}
+
+func (b *BandwidthLimiter) updateRecv(n int) {
+	if b == nil {
+		return
+	}
+	b.recvMonitor.Update(n)
+}
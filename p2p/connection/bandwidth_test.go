@@ -0,0 +1,59 @@
+package connection
+
+import (
+	"testing"
+	"time"
+)
+
+// sendChunks passes n chunks of size bytes through the limiter
+func sendChunks(b *BandwidthLimiter, n, size int) time.Duration {
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		b.limitSend(size)
+		b.updateSend(size)
+	}
+	return time.Since(start)
+}
+
+func TestBandwidthLimiter(t *testing.T) {
+	// 100 bytes a sample of 100ms, the chunks after the first wait a sample
+	limited := NewBandwidthLimiter(1000, 1000)
+	if elapsed := sendChunks(limited, 5, 100); elapsed < 300*time.Millisecond {
+		t.Errorf("sent 500 bytes at 1000B/s in %v, want the limiter to wait", elapsed)
+	}
+
+	unlimited := NewBandwidthLimiter(0, 0)
+	if elapsed := sendChunks(unlimited, 5, 100); elapsed > 100*time.Millisecond {
+		t.Errorf("sent 500 bytes unlimited in %v, want no wait", elapsed)
+	}
+
+	var none *BandwidthLimiter
+	if elapsed := sendChunks(none, 5, 100); elapsed > 100*time.Millisecond {
+		t.Errorf("sent 500 bytes through a nil limiter in %v, want no wait", elapsed)
+	}
+	none.limitRecv(100)
+	none.updateRecv(100)
+}
+
+func TestBandwidthLimiterSetRates(t *testing.T) {
+	limiter := NewBandwidthLimiter(1000, 2000)
+	if send, recv := limiter.Rates(); send != 1000 || recv != 2000 {
+		t.Errorf("got rates %d %d, want 1000 2000", send, recv)
+	}
+
+	limiter.SetRates(0, 3000)
+	if send, recv := limiter.Rates(); send != 0 || recv != 3000 {
+		t.Errorf("got rates %d %d, want 0 3000", send, recv)
+	}
+	if elapsed := sendChunks(limiter, 5, 100); elapsed > 100*time.Millisecond {
+		t.Errorf("sent 500 bytes after lifting the send rate in %v, want no wait", elapsed)
+	}
+}
+
+func TestMConnConfigRates(t *testing.T) {
+	config := DefaultMConnConfig()
+	config.SetRates(10, 20)
+	if send, recv := config.Rates(); send != 10 || recv != 20 {
+		t.Errorf("got rates %d %d, want 10 20", send, recv)
+	}
+}
@@ -87,6 +87,9 @@ type MConnection struct {
 type MConnConfig struct {
 	SendRate int64 `mapstructure:"send_rate"`
 	RecvRate int64 `mapstructure:"recv_rate"`
+
+	// Bandwidth is shared by all the connections created with this config
+	Bandwidth *BandwidthLimiter `mapstructure:"-"`
 }
 
 // DefaultMConnConfig returns the default config.
@@ -97,6 +100,18 @@ func DefaultMConnConfig() *MConnConfig {
 	}
 }
 
+// SetRates changes the per connection rates of every MConnection created with
+// this config, it takes effect on the next packet.
+func (config *MConnConfig) SetRates(sendRate, recvRate int64) {
+	atomic.StoreInt64(&config.SendRate, sendRate)
+	atomic.StoreInt64(&config.RecvRate, recvRate)
+}
+
+// Rates returns the current per connection send and receive rates.
+func (config *MConnConfig) Rates() (sendRate int64, recvRate int64) {
+	return atomic.LoadInt64(&config.SendRate), atomic.LoadInt64(&config.RecvRate)
+}
+
 // NewMConnection wraps net.Conn and creates multiplex connection
 func NewMConnection(conn net.Conn, chDescs []*ChannelDescriptor, onReceive receiveCbFunc, onError errorCbFunc) *MConnection {
 	return NewMConnectionWithConfig(
@@ -343,6 +358,7 @@ func (c *MConnection) sendSomeMsgPackets() bool {
 	// Once we're ready we send more than we asked for,
 	// but amortized it should even out.
 	c.sendMonitor.Limit(maxMsgPacketTotalSize, atomic.LoadInt64(&c.config.SendRate), true)
+	c.config.Bandwidth.limitSend(maxMsgPacketTotalSize)
 
 	// Now send some msgPackets.
 	for i := 0; i < numBatchMsgPackets; i++ {
@@ -387,6 +403,7 @@ func (c *MConnection) sendMsgPacket() bool {
 		return true
 	}
 	c.sendMonitor.Update(int(n))
+	c.config.Bandwidth.updateSend(int(n))
 	c.flushTimer.Set()
 	return false
 }
@@ -401,6 +418,7 @@ FOR_LOOP:
 	for {
 		// Block until .recvMonitor says we can read.
 		c.recvMonitor.Limit(maxMsgPacketTotalSize, atomic.LoadInt64(&c.config.RecvRate), true)
+		c.config.Bandwidth.limitRecv(maxMsgPacketTotalSize)
 
 		/*
 			// Peek into bufReader for debugging
@@ -447,6 +465,7 @@ FOR_LOOP:
 			pkt, n, err := msgPacket{}, int(0), error(nil)
 			wire.ReadBinaryPtr(&pkt, c.bufReader, maxMsgPacketTotalSize, &n, &err)
 			c.recvMonitor.Update(int(n))
+			c.config.Bandwidth.updateRecv(int(n))
 			if err != nil {
 				if c.IsRunning() {
 					log.WithFields(log.Fields{
@@ -12,6 +12,7 @@ import (
 	cmn "github.com/tendermint/tmlibs/common"
 
 	cfg "github.com/btm-stats/config"
+	"github.com/btm-stats/p2p/capture"
 	"github.com/btm-stats/p2p/connection"
 )
 
@@ -33,6 +34,8 @@ type Peer struct {
 	*NodeInfo
 	Key  string
 	Data *cmn.CMap // User data.
+
+	recorder *capture.Recorder // nil unless message capture is enabled
 }
 
 // PeerConfig is a Peer configuration.
@@ -55,9 +58,13 @@ func DefaultPeerConfig(config *cfg.P2PConfig) *PeerConfig {
 		AuthEnc:          true,
 		HandshakeTimeout: time.Duration(config.HandshakeTimeout), // * time.Second,
 		DialTimeout:      time.Duration(config.DialTimeout),      // * time.Second,
-		MConfig:          connection.DefaultMConnConfig(),
-		Fuzz:             false,
-		FuzzConfig:       DefaultFuzzConnConfig(),
+		MConfig: &connection.MConnConfig{
+			SendRate:  config.SendRate,
+			RecvRate:  config.RecvRate,
+			Bandwidth: connection.NewBandwidthLimiter(config.MaxTotalSendRate, config.MaxTotalRecvRate),
+		},
+		Fuzz:       false,
+		FuzzConfig: DefaultFuzzConnConfig(),
 	}
 }
 
@@ -95,8 +102,8 @@ func newOutboundPeerConn(addr *NetAddress, reactorsByCh map[byte]Reactor, chDesc
 	return pc, nil
 }
 
-func newInboundPeerConn(conn net.Conn, reactorsByCh map[byte]Reactor, chDescs []*connection.ChannelDescriptor, onPeerError func(*Peer, interface{}), ourNodePrivKey crypto.PrivKeyEd25519, config *cfg.P2PConfig) (*peerConn, error) {
-	return newPeerConn(conn, false, reactorsByCh, chDescs, onPeerError, ourNodePrivKey, DefaultPeerConfig(config))
+func newInboundPeerConn(conn net.Conn, reactorsByCh map[byte]Reactor, chDescs []*connection.ChannelDescriptor, onPeerError func(*Peer, interface{}), ourNodePrivKey crypto.PrivKeyEd25519, config *PeerConfig) (*peerConn, error) {
+	return newPeerConn(conn, false, reactorsByCh, chDescs, onPeerError, ourNodePrivKey, config)
 }
 
 func newPeerConn(rawConn net.Conn, outbound bool, reactorsByCh map[byte]Reactor, chDescs []*connection.ChannelDescriptor, onPeerError func(*Peer, interface{}), ourNodePrivKey crypto.PrivKeyEd25519, config *PeerConfig) (*peerConn, error) {
@@ -190,7 +197,9 @@ func (p *Peer) OnStart() error {
 // OnStop implements BaseService.
 func (p *Peer) OnStop() {
 	p.BaseService.OnStop()
-	p.mconn.Stop()
+	if p.mconn != nil {
+		p.mconn.Stop()
+	}
 }
 
 // Connection returns underlying MConnection.
@@ -211,7 +220,11 @@ func (p *Peer) Send(chID byte, msg interface{}) bool {
 		// them - while we're looping, one peer may be removed and stopped.
 		return false
 	}
-	return p.mconn.Send(chID, msg)
+	if !p.mconn.Send(chID, msg) {
+		return false
+	}
+	p.recordSend(chID, msg)
+	return true
 }
 
 // TrySend msg to the channel identified by chID byte. Immediately returns
@@ -220,7 +233,20 @@ func (p *Peer) TrySend(chID byte, msg interface{}) bool {
 	if !p.IsRunning() {
 		return false
 	}
-	return p.mconn.TrySend(chID, msg)
+	if !p.mconn.TrySend(chID, msg) {
+		return false
+	}
+	p.recordSend(chID, msg)
+	return true
+}
+
+// recordSend writes an outbound message to the capture file, the message is
+// encoded the same way the MConnection does
+func (p *Peer) recordSend(chID byte, msg interface{}) {
+	if p.recorder == nil {
+		return
+	}
+	p.recorder.Record(p.Key, true, chID, wire.BinaryBytes(msg))
 }
 
 // CanSend returns true if the send queue is not full, false otherwise.
@@ -264,6 +290,9 @@ func createMConnection(conn net.Conn, p *Peer, reactorsByCh map[byte]Reactor, ch
 		if reactor == nil {
 			cmn.PanicSanity(cmn.Fmt("Unknown channel %X", chID))
 		}
+		if p.recorder != nil {
+			p.recorder.Record(p.Key, false, chID, msgBytes)
+		}
 		reactor.Receive(chID, p, msgBytes)
 	}
 
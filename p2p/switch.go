@@ -14,6 +14,7 @@ import (
 
 	cfg "github.com/btm-stats/config"
 	"github.com/btm-stats/errors"
+	"github.com/btm-stats/p2p/capture"
 	"github.com/btm-stats/p2p/connection"
 	"github.com/btm-stats/p2p/trust"
 )
@@ -28,6 +29,7 @@ var (
 	ErrDuplicatePeer     = errors.New("Duplicate peer")
 	ErrConnectSelf       = errors.New("Connect self")
 	ErrConnectBannedPeer = errors.New("Connect banned peer")
+	ErrInvalidRateLimit  = errors.New("Invalid rate limit")
 )
 
 // An AddrBook represents an address book from the pex package, which is used to store peer addresses.
@@ -61,6 +63,7 @@ type Switch struct {
 	addrBook     AddrBook
 	bannedPeer   map[string]time.Time
 	db           dbm.DB
+	recorder     *capture.Recorder
 	mtx          sync.Mutex
 }
 
@@ -85,6 +88,13 @@ func NewSwitch(config *cfg.P2PConfig, addrBook AddrBook, trustHistoryDB dbm.DB)
 			return nil
 		}
 	}
+	if config.CaptureDir != "" {
+		recorder, err := capture.NewRecorder(config.CaptureDirPath())
+		if err != nil {
+			log.WithField("err", err).Error("fail on create p2p message recorder")
+		}
+		sw.recorder = recorder
+	}
 	trust.Init()
 	return sw
 }
@@ -100,6 +110,7 @@ func (sw *Switch) AddReactor(name string, reactor Reactor) Reactor {
 		if sw.reactorsByCh[chID] != nil {
 			cmn.PanicSanity(fmt.Sprintf("Channel %X has multiple reactors %v & %v", chID, sw.reactorsByCh[chID], reactor))
 		}
+		sw.applyChannelConfig(chDesc)
 		sw.chDescs = append(sw.chDescs, chDesc)
 		sw.reactorsByCh[chID] = reactor
 	}
@@ -108,6 +119,27 @@ func (sw *Switch) AddReactor(name string, reactor Reactor) Reactor {
 	return reactor
 }
 
+// applyChannelConfig overwrites the reactor's channel defaults with the values
+// from the p2p config
+func (sw *Switch) applyChannelConfig(chDesc *connection.ChannelDescriptor) {
+	chConfig, ok := sw.Config.Channels[fmt.Sprintf("%02X", chDesc.ID)]
+	if !ok || chConfig == nil {
+		return
+	}
+	if chConfig.Priority > 0 {
+		chDesc.Priority = chConfig.Priority
+	}
+	if chConfig.SendQueueCapacity > 0 {
+		chDesc.SendQueueCapacity = chConfig.SendQueueCapacity
+	}
+	if chConfig.RecvBufferCapacity > 0 {
+		chDesc.RecvBufferCapacity = chConfig.RecvBufferCapacity
+	}
+	if chConfig.RecvMessageCapacity > 0 {
+		chDesc.RecvMessageCapacity = chConfig.RecvMessageCapacity
+	}
+}
+
 // Reactors returns a map of reactors registered on the switch.
 // NOTE: Not goroutine safe.
 func (sw *Switch) Reactors() map[string]Reactor {
@@ -191,6 +223,9 @@ func (sw *Switch) OnStop() {
 	for _, reactor := range sw.reactors {
 		reactor.Stop()
 	}
+	if sw.recorder != nil {
+		sw.recorder.Stop()
+	}
 }
 
 // AddPeer performs the P2P handshake with a peer
@@ -215,9 +250,13 @@ func (sw *Switch) AddPeer(pc *peerConn) error {
 		return err
 	}
 
+	// Capture before start so the handshake messages are recorded too
+	sw.startCapture(peer)
+
 	// Start peer
 	if sw.IsRunning() {
 		if err := sw.startInitPeer(peer); err != nil {
+			sw.stopCapture(peer)
 			return err
 		}
 	}
@@ -226,6 +265,7 @@ func (sw *Switch) AddPeer(pc *peerConn) error {
 	// We start it first so that a peer in the list is safe to Stop.
 	// It should not err since we already checked peers.Has()
 	if err := sw.peers.Add(peer); err != nil {
+		sw.stopCapture(peer)
 		return err
 	}
 
@@ -362,6 +402,7 @@ func (sw *Switch) stopAndRemovePeer(peer *Peer, reason interface{}) {
 	}
 	sw.peers.Remove(peer)
 	peer.Stop()
+	sw.stopCapture(peer)
 }
 
 func (sw *Switch) listenerRoutine(l Listener) {
@@ -390,7 +431,7 @@ func (sw *Switch) listenerRoutine(l Listener) {
 }
 
 func (sw *Switch) addPeerWithConnection(conn net.Conn) error {
-	peerConn, err := newInboundPeerConn(conn, sw.reactorsByCh, sw.chDescs, sw.StopPeerForError, sw.nodePrivKey, sw.Config)
+	peerConn, err := newInboundPeerConn(conn, sw.reactorsByCh, sw.chDescs, sw.StopPeerForError, sw.nodePrivKey, sw.peerConfig)
 	if err != nil {
 		conn.Close()
 		return err
@@ -439,3 +480,39 @@ func (sw *Switch) checkBannedPeer(peer string) error {
 	}
 	return nil
 }
+
+// RateLimits represents the bandwidth limits of the switch in bytes per second
+type RateLimits struct {
+	SendRate         int64 `json:"send_rate"`
+	RecvRate         int64 `json:"recv_rate"`
+	MaxTotalSendRate int64 `json:"max_total_send_rate"`
+	MaxTotalRecvRate int64 `json:"max_total_recv_rate"`
+}
+
+// RateLimits returns the bandwidth limits currently applied to the peers.
+func (sw *Switch) RateLimits() *RateLimits {
+	mConfig := sw.peerConfig.MConfig
+	limits := &RateLimits{}
+	limits.SendRate, limits.RecvRate = mConfig.Rates()
+	limits.MaxTotalSendRate, limits.MaxTotalRecvRate = mConfig.Bandwidth.Rates()
+	return limits
+}
+
+// SetRateLimits adjusts the bandwidth limits at runtime, all the connections
+// share the peer config so both existing and future peers are affected.
+func (sw *Switch) SetRateLimits(limits *RateLimits) error {
+	if limits.SendRate < 0 || limits.RecvRate < 0 || limits.MaxTotalSendRate < 0 || limits.MaxTotalRecvRate < 0 {
+		return ErrInvalidRateLimit
+	}
+
+	mConfig := sw.peerConfig.MConfig
+	mConfig.SetRates(limits.SendRate, limits.RecvRate)
+	mConfig.Bandwidth.SetRates(limits.MaxTotalSendRate, limits.MaxTotalRecvRate)
+	log.WithFields(log.Fields{
+		"send_rate":           limits.SendRate,
+		"recv_rate":           limits.RecvRate,
+		"max_total_send_rate": limits.MaxTotalSendRate,
+		"max_total_recv_rate": limits.MaxTotalRecvRate,
+	}).Info("p2p rate limits updated")
+	return nil
+}
@@ -0,0 +1,51 @@
+package p2p
+
+import (
+	"net"
+
+	cmn "github.com/tendermint/tmlibs/common"
+)
+
+// Connect2Switches connects sw1 and sw2 over an in-memory pipe, when
+// fuzzConfig isn't nil both ends of the pipe are wrapped by a
+// FuzzedConnection so latency and drops can be injected.
+// NOTE: both switches must have their NodeInfo and private key set.
+func Connect2Switches(sw1, sw2 *Switch, fuzzConfig *FuzzConnConfig) error {
+	c1, c2 := net.Pipe()
+	if fuzzConfig != nil {
+		c1 = FuzzConnFromConfig(c1, fuzzConfig)
+		c2 = FuzzConnFromConfig(c2, fuzzConfig)
+	}
+
+	// the handshakes block until both sides take part
+	var err1, err2 error
+	cmn.Parallel(
+		func() { err1 = sw1.addPeerWithConnection(c1) },
+		func() { err2 = sw2.addPeerWithConnection(c2) },
+	)
+	if err1 != nil {
+		return err1
+	}
+	return err2
+}
+
+// ConnectAllSwitches connects every pair of switches with Connect2Switches.
+func ConnectAllSwitches(switches []*Switch, fuzzConfig *FuzzConnConfig) error {
+	for i := 0; i < len(switches); i++ {
+		for j := i + 1; j < len(switches); j++ {
+			if err := Connect2Switches(switches[i], switches[j], fuzzConfig); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DisconnectSwitches stops every peer sw1 has with sw2's node key, both sides
+// notice it when the underlying pipe is closed.
+func DisconnectSwitches(sw1, sw2 *Switch) {
+	key := sw2.NodeInfo().PubKey.KeyString()
+	if peer := sw1.Peers().Get(key); peer != nil {
+		sw1.StopPeerGracefully(peer)
+	}
+}
@@ -48,8 +48,24 @@ func (b *Block) UnmarshalText(text []byte) error {
 	if _, err := hex.Decode(decoded, text); err != nil {
 		return err
 	}
+	return b.UnmarshalBinary(decoded)
+}
+
+// MarshalBinary fulfills the encoding.BinaryMarshaler interface, it's the
+// raw serialization the text form is the hex of.
+func (b *Block) MarshalBinary() ([]byte, error) {
+	buf := bufpool.Get()
+	defer bufpool.Put(buf)
+
+	if _, err := b.WriteTo(buf); err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), buf.Bytes()...), nil
+}
 
-	r := blockchain.NewReader(decoded)
+// UnmarshalBinary fulfills the encoding.BinaryUnmarshaler interface.
+func (b *Block) UnmarshalBinary(data []byte) error {
+	r := blockchain.NewReader(data)
 	if err := b.readFrom(r); err != nil {
 		return err
 	}
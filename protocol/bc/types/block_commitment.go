@@ -1,6 +1,9 @@
 package types
 
 import (
+	"io"
+
+	"github.com/btm-stats/encoding/blockchain"
 	"github.com/btm-stats/protocol/bc"
 )
 
@@ -14,3 +17,19 @@ type BlockCommitment struct {
 	// formed by the hashes of all transaction verify results
 	TransactionStatusHash bc.Hash `json:"transaction_status_hash"`
 }
+
+func (c *BlockCommitment) readFrom(r *blockchain.Reader) error {
+	if _, err := c.TransactionsMerkleRoot.ReadFrom(r); err != nil {
+		return err
+	}
+	_, err := c.TransactionStatusHash.ReadFrom(r)
+	return err
+}
+
+func (c *BlockCommitment) writeTo(w io.Writer) error {
+	if _, err := c.TransactionsMerkleRoot.WriteTo(w); err != nil {
+		return err
+	}
+	_, err := c.TransactionStatusHash.WriteTo(w)
+	return err
+}
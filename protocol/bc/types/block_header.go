@@ -4,7 +4,10 @@ import (
 	"github.com/btm-stats/protocol/bc"
 	"io"
 	"fmt"
+	"encoding/hex"
 	"github.com/btm-stats/encoding/blockchain"
+	"github.com/btm-stats/encoding/bufpool"
+	"github.com/btm-stats/errors"
 )
 
 // BlockHeader defines information about a block and is used in the Bytom
@@ -24,6 +27,67 @@ func (bh *BlockHeader) Hash() bc.Hash {
 	return h
 }
 
+// MarshalText fulfills the json.Marshaler interface, the header is hex
+// encoded like the blocks.
+func (bh *BlockHeader) MarshalText() ([]byte, error) {
+	data, err := bh.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	enc := make([]byte, hex.EncodedLen(len(data)))
+	hex.Encode(enc, data)
+	return enc, nil
+}
+
+// UnmarshalText fulfills the encoding.TextUnmarshaler interface.
+func (bh *BlockHeader) UnmarshalText(text []byte) error {
+	decoded := make([]byte, hex.DecodedLen(len(text)))
+	if _, err := hex.Decode(decoded, text); err != nil {
+		return err
+	}
+	return bh.UnmarshalBinary(decoded)
+}
+
+// MarshalBinary fulfills the encoding.BinaryMarshaler interface, the header
+// is serialized on its own with the SerBlockHeader flag.
+func (bh *BlockHeader) MarshalBinary() ([]byte, error) {
+	buf := bufpool.Get()
+	defer bufpool.Put(buf)
+
+	if _, err := bh.WriteTo(buf); err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), buf.Bytes()...), nil
+}
+
+// UnmarshalBinary fulfills the encoding.BinaryUnmarshaler interface, it
+// rejects the full blocks.
+func (bh *BlockHeader) UnmarshalBinary(data []byte) error {
+	r := blockchain.NewReader(data)
+	serflag, err := bh.readFrom(r)
+	if err != nil {
+		return err
+	}
+	if serflag != SerBlockHeader {
+		return fmt.Errorf("unexpected serialization flags 0x%x for a block header", serflag)
+	}
+
+	if trailing := r.Len(); trailing > 0 {
+		return fmt.Errorf("trailing garbage (%d bytes)", trailing)
+	}
+	return nil
+}
+
+// WriteTo will write the block header to input io.Writer
+func (bh *BlockHeader) WriteTo(w io.Writer) (int64, error) {
+	ew := errors.NewWriter(w)
+	if err := bh.writeTo(ew, SerBlockHeader); err != nil {
+		return 0, err
+	}
+	return ew.Written(), ew.Err()
+}
+
 func (bh *BlockHeader) readFrom(r *blockchain.Reader) (serflag uint8, err error) {
 	var serflags [1]byte
 	io.ReadFull(r, serflags[:])
@@ -57,3 +121,31 @@ func (bh *BlockHeader) readFrom(r *blockchain.Reader) (serflag uint8, err error)
 	}
 	return
 }
+
+func (bh *BlockHeader) writeTo(w io.Writer, serflags uint8) (err error) {
+	if _, err = w.Write([]byte{serflags}); err != nil {
+		return err
+	}
+	if _, err = blockchain.WriteVarint63(w, bh.Version); err != nil {
+		return err
+	}
+	if _, err = blockchain.WriteVarint63(w, bh.Height); err != nil {
+		return err
+	}
+	if _, err = bh.PreviousBlockHash.WriteTo(w); err != nil {
+		return err
+	}
+	if _, err = blockchain.WriteVarint63(w, bh.Timestamp); err != nil {
+		return err
+	}
+	if _, err = blockchain.WriteExtensibleString(w, nil, bh.BlockCommitment.writeTo); err != nil {
+		return err
+	}
+	if _, err = blockchain.WriteVarint63(w, bh.Nonce); err != nil {
+		return err
+	}
+	if _, err = blockchain.WriteVarint63(w, bh.Bits); err != nil {
+		return err
+	}
+	return nil
+}
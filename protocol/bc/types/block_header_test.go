@@ -0,0 +1,105 @@
+package types
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/btm-stats/encoding/blockchain"
+	"github.com/btm-stats/protocol/bc"
+)
+
+func TestBlockHeaderRoundTrip(t *testing.T) {
+	cases := []*BlockHeader{
+		{},
+		{
+			Version:           1,
+			Height:            432234,
+			PreviousBlockHash: bc.NewHash([32]byte{0x0c, 0xbb}),
+			Timestamp:         1528945000,
+			Nonce:             9253507043297,
+			Bits:              2305843009214532812,
+			BlockCommitment: BlockCommitment{
+				TransactionsMerkleRoot: bc.NewHash([32]byte{0xad}),
+				TransactionStatusHash:  bc.NewHash([32]byte{0xb9}),
+			},
+		},
+	}
+
+	for i, header := range cases {
+		buf := &bytes.Buffer{}
+		if err := header.writeTo(buf, SerBlockHeader); err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+
+		got := &BlockHeader{}
+		serflag, err := got.readFrom(blockchain.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		if serflag != SerBlockHeader {
+			t.Errorf("case %d: got serialization flags %d, want %d", i, serflag, SerBlockHeader)
+		}
+		if *got != *header {
+			t.Errorf("case %d: got header %+v, want %+v", i, got, header)
+		}
+
+		text, err := header.MarshalText()
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		got = &BlockHeader{}
+		if err := got.UnmarshalText(text); err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		if got.Hash() != header.Hash() {
+			t.Errorf("case %d: text round trip got hash %x, want %x", i, got.Hash().Bytes(), header.Hash().Bytes())
+		}
+	}
+}
+
+func TestBlockHeaderUnmarshalBinary(t *testing.T) {
+	header := &BlockHeader{Version: 1, Height: 7, Timestamp: 1528945000, Nonce: 3, Bits: 5}
+	data, err := header.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := &Block{BlockHeader: *header}
+	blockData, err := block.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		data    []byte
+		wantErr bool
+	}{
+		{data: data},
+		// a full block isn't a header
+		{data: blockData, wantErr: true},
+		{data: append(append([]byte{}, data...), 0), wantErr: true},
+		{data: append([]byte{0x07}, data[1:]...), wantErr: true},
+		{data: data[:len(data)-1], wantErr: true},
+		{data: nil, wantErr: true},
+	}
+
+	for i, c := range cases {
+		got := &BlockHeader{}
+		err := got.UnmarshalBinary(c.data)
+		if (err != nil) != c.wantErr {
+			t.Errorf("case %d: got err = %v, want err %v", i, err, c.wantErr)
+			continue
+		}
+		if err == nil && *got != *header {
+			t.Errorf("case %d: got header %+v, want %+v", i, got, header)
+		}
+	}
+
+	// the header of a full block reads the same
+	got := &BlockHeader{}
+	if _, err := got.readFrom(blockchain.NewReader(blockData)); err != nil {
+		t.Fatal(err)
+	}
+	if *got != *header {
+		t.Errorf("got header %+v of the block, want %+v", got, header)
+	}
+}
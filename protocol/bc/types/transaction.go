@@ -1,6 +1,8 @@
 package types
 
 import (
+	"bytes"
+
 	"github.com/btm-stats/protocol/bc"
 	"github.com/btm-stats/encoding/blockchain"
 	"github.com/btm-stats/errors"
@@ -36,6 +38,30 @@ func NewTx(data TxData) *Tx {
 	}
 }
 
+// MarshalBinary fulfills the encoding.BinaryMarshaler interface.
+func (tx *TxData) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := tx.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary fulfills the encoding.BinaryUnmarshaler interface, the
+// entries of the transaction are mapped as well.
+func (tx *Tx) UnmarshalBinary(data []byte) error {
+	var txData TxData
+	r := blockchain.NewReader(data)
+	if err := txData.readFrom(r); err != nil {
+		return err
+	}
+
+	if trailing := r.Len(); trailing > 0 {
+		return fmt.Errorf("trailing garbage (%d bytes)", trailing)
+	}
+	*tx = *NewTx(txData)
+	return nil
+}
 
 func (tx *TxData) readFrom(r *blockchain.Reader) (err error) {
 	startSerializedSize := r.Len()
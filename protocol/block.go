@@ -1,11 +1,40 @@
 package protocol
 
 import (
+	"time"
+
 	log "github.com/sirupsen/logrus"
 
+	"github.com/btm-stats/errors"
+	"github.com/btm-stats/protocol/bc"
 	"github.com/btm-stats/protocol/bc/types"
+	"github.com/btm-stats/protocol/state"
+	"github.com/btm-stats/protocol/validation"
 )
 
+// ErrOrphanHeader is returned when a block header extends no known block,
+// the headers mode never keeps orphans.
+var ErrOrphanHeader = errors.New("block header extends an unknown block")
+
+// A BlockObserver is told of every block or header added to the block index
+// along with the time it reached the chain, side chain blocks included. It's
+// called on the block processing path so it has to return quickly.
+type BlockObserver interface {
+	ObserveBlock(node *state.BlockNode, arrival time.Time)
+}
+
+// AddBlockObserver registers an observer of the blocks added to the index, it
+// must be called before the chain processes any block
+func (c *Chain) AddBlockObserver(observer BlockObserver) {
+	c.observers = append(c.observers, observer)
+}
+
+func (c *Chain) notifyObservers(node *state.BlockNode, arrival time.Time) {
+	for _, observer := range c.observers {
+		observer.ObserveBlock(node, arrival)
+	}
+}
+
 type processBlockMsg struct {
 	block *types.Block
 	reply chan processBlockResponse
@@ -23,8 +52,182 @@ func (c *Chain) blockProcesser() {
 	}
 }
 
+// saveBlock validates the block against its parent before it's stored and
+// added to the block index, invalid blocks are never stored. A block extending
+// the main chain is connected in the same commit as it's stored. The
+// validation error keeps its root so callers can tell the reject reason.
+func (c *Chain) saveBlock(block *types.Block) error {
+	bcBlock := types.MapBlock(block)
+	parent := c.index.GetNode(&block.PreviousBlockHash)
+
+	txStatus, err := validation.ValidateBlock(bcBlock, parent)
+	if err != nil {
+		return errors.Wrap(err, "validate block")
+	}
+
+	node, err := state.NewBlockNode(&block.BlockHeader, parent)
+	if err != nil {
+		return err
+	}
+
+	if parent != c.bestNode {
+		if err := c.store.SaveBlock(block, txStatus); err != nil {
+			return err
+		}
+
+		c.orphanManage.Delete(&bcBlock.ID)
+		c.index.AddNode(node)
+		return nil
+	}
+
+	utxoView := state.NewUtxoViewpoint()
+	if err := c.store.GetTransactionsUtxo(utxoView, bcBlock.Transactions); err != nil {
+		return err
+	}
+	if err := utxoView.ApplyBlock(bcBlock, txStatus); err != nil {
+		return err
+	}
+	if err := c.store.SaveBlockAndChainStatus(block, txStatus, node, utxoView); err != nil {
+		return err
+	}
+
+	c.orphanManage.Delete(&bcBlock.ID)
+	c.index.AddNode(node)
+	c.setBestNode(node)
+	return nil
+}
+
+// calcReorganizeNodes returns the nodes to attach from the fork point up to
+// node, and the nodes of the main chain to detach down to the fork point
+func (c *Chain) calcReorganizeNodes(node *state.BlockNode) ([]*state.BlockNode, []*state.BlockNode) {
+	var attachNodes []*state.BlockNode
+	var detachNodes []*state.BlockNode
+
+	attachNode := node
+	for !c.index.InMainchain(attachNode.Hash) {
+		attachNodes = append([]*state.BlockNode{attachNode}, attachNodes...)
+		attachNode = attachNode.Parent
+	}
+
+	for detachNode := c.bestNode; detachNode != attachNode; detachNode = detachNode.Parent {
+		detachNodes = append(detachNodes, detachNode)
+	}
+	return attachNodes, detachNodes
+}
+
+// reorganizeChain switches the main chain to the branch of node. The old
+// chain is detached down to the fork point in a first commit carrying the
+// reorganization state, the new chain is attached in a second one. A failing
+// attach moves the chain back to the tip it left.
+func (c *Chain) reorganizeChain(node *state.BlockNode) error {
+	origin := c.bestNode
+	attachNodes, detachNodes := c.calcReorganizeNodes(node)
+	if len(detachNodes) > 0 {
+		reorg := &ReorgState{Target: &node.Hash, Origin: &origin.Hash}
+		if err := c.detachBlocks(detachNodes, reorg); err != nil {
+			return err
+		}
+	}
+
+	if err := c.attachBlocks(node, attachNodes); err != nil {
+		if len(detachNodes) == 0 {
+			return err
+		}
+		// attaching the old chain back clears the reorganization state
+		if rollbackErr := c.reorganizeChain(origin); rollbackErr != nil {
+			log.WithFields(log.Fields{"height": origin.Height, "hash": origin.Hash.String(), "err": rollbackErr}).Error("fail on attach back the detached chain")
+		}
+		return err
+	}
+	return nil
+}
+
+// detachBlocks moves the chain status to the parent of the last node, the
+// utxos spent by the detached blocks are restored from their undo records
+func (c *Chain) detachBlocks(detachNodes []*state.BlockNode, reorg *ReorgState) error {
+	utxoView := state.NewUtxoViewpoint()
+	for _, detachNode := range detachNodes {
+		block, err := c.store.GetBlock(&detachNode.Hash)
+		if err != nil {
+			return err
+		}
+
+		txStatus, err := c.store.GetTransactionStatus(&detachNode.Hash)
+		if err != nil {
+			return err
+		}
+
+		undo, err := c.store.GetBlockUndo(&detachNode.Hash)
+		if err != nil {
+			return errors.Wrapf(err, "detach block %d beyond the undo depth", detachNode.Height)
+		}
+
+		if err := utxoView.DetachBlock(types.MapBlock(block), txStatus, undo); err != nil {
+			return err
+		}
+		log.WithFields(log.Fields{"height": detachNode.Height, "hash": detachNode.Hash.String()}).Debug("detach from mainchain")
+	}
+
+	fork := detachNodes[len(detachNodes)-1].Parent
+	if err := c.store.SaveReorgStatus(fork, utxoView, reorg); err != nil {
+		return err
+	}
+
+	c.setBestNode(fork)
+	return nil
+}
+
+// attachBlocks moves the chain status from the current tip up to node
+func (c *Chain) attachBlocks(node *state.BlockNode, attachNodes []*state.BlockNode) error {
+	utxoView := state.NewUtxoViewpoint()
+	for _, attachNode := range attachNodes {
+		block, err := c.store.GetBlock(&attachNode.Hash)
+		if err != nil {
+			return err
+		}
+
+		txStatus, err := c.store.GetTransactionStatus(&attachNode.Hash)
+		if err != nil {
+			return err
+		}
+
+		bcBlock := types.MapBlock(block)
+		if err := c.store.GetTransactionsUtxo(utxoView, bcBlock.Transactions); err != nil {
+			return err
+		}
+		if err := utxoView.ApplyBlock(bcBlock, txStatus); err != nil {
+			return err
+		}
+		log.WithFields(log.Fields{"height": attachNode.Height, "hash": attachNode.Hash.String()}).Debug("attach to mainchain")
+	}
+
+	return c.setState(node, utxoView)
+}
+
+// setState writes the utxo view and its undo records along the new tip, then
+// moves the main chain to the tip
+func (c *Chain) setState(node *state.BlockNode, view *state.UtxoViewpoint) error {
+	if err := c.store.SaveChainStatus(node, view); err != nil {
+		return err
+	}
+
+	c.setBestNode(node)
+	return nil
+}
+
+// setBestNode moves the main chain to a tip whose status is already stored
+func (c *Chain) setBestNode(node *state.BlockNode) {
+	c.cond.L.Lock()
+	defer c.cond.L.Unlock()
+
+	c.index.SetMainChain(node)
+	c.bestNode = node
+	c.cond.Broadcast()
+}
+
 // ProcessBlock is the entry for handle block insert
 func (c *Chain) processBlock(block *types.Block) (bool, error) {
+	arrival := time.Now()
 	blockHash := block.Hash()
 	if c.BlockExist(&blockHash) {
 		log.WithFields(log.Fields{"hash": blockHash.String(), "height": block.Height}).Info("block has been processed")
@@ -33,20 +236,22 @@ func (c *Chain) processBlock(block *types.Block) (bool, error) {
 
 	if parent := c.index.GetNode(&block.PreviousBlockHash); parent == nil {
 		c.orphanManage.Add(block)
+		c.orphanManage.setArrival(&blockHash, arrival)
 		return true, nil
 	}
 
 	if err := c.saveBlock(block); err != nil {
 		return false, err
 	}
+	c.notifyObservers(c.index.GetNode(&blockHash), arrival)
 
 	bestBlock := c.saveSubBlock(block)
 	bestBlockHash := bestBlock.Hash()
 	bestNode := c.index.GetNode(&bestBlockHash)
 
-	if bestNode.Parent == c.bestNode {
+	if bestNode == c.bestNode {
 		log.Debug("append block to the end of mainchain")
-		return false, c.connectBlock(bestBlock)
+		return false, nil
 	}
 
 	if bestNode.Height > c.bestNode.Height && bestNode.WorkSum.Cmp(c.bestNode.WorkSum) >= 0 {
@@ -56,6 +261,84 @@ func (c *Chain) processBlock(block *types.Block) (bool, error) {
 	return false, nil
 }
 
+// saveSubBlock connects the orphans extending the block, each is told to the
+// observers with the time it first arrived. It returns the highest block
+// connected, the block itself when no orphan extends it.
+func (c *Chain) saveSubBlock(block *types.Block) *types.Block {
+	blockHash := block.Hash()
+	bestBlock := block
+	for _, orphan := range c.orphanManage.prevOrphanBlocks(&blockHash) {
+		orphanHash := orphan.Hash()
+		arrival := c.orphanManage.takeArrival(&orphanHash)
+		if err := c.saveBlock(orphan); err != nil {
+			log.WithFields(log.Fields{"hash": orphanHash.String(), "height": orphan.Height}).Errorf("fail on save the orphan block: %v", err)
+			continue
+		}
+		c.notifyObservers(c.index.GetNode(&orphanHash), arrival)
+
+		if subBestBlock := c.saveSubBlock(orphan); subBestBlock.Height > bestBlock.Height {
+			bestBlock = subBestBlock
+		}
+	}
+	return bestBlock
+}
+
+// GetTransactionStatus return the status of the transactions of the block
+func (c *Chain) GetTransactionStatus(hash *bc.Hash) (*bc.TransactionStatus, error) {
+	return c.store.GetTransactionStatus(hash)
+}
+
+// ProcessBlockHeader extends the block index by a header whose body is never
+// fetched, it's how the headers mode follows the chain. The best chain moves
+// to the header once it carries the most work, no utxo is touched.
+func (c *Chain) ProcessBlockHeader(header *types.BlockHeader) error {
+	arrival := time.Now()
+	c.cond.L.Lock()
+	defer c.cond.L.Unlock()
+
+	hash := header.Hash()
+	if c.index.GetNode(&hash) != nil {
+		return nil
+	}
+
+	parent := c.index.GetNode(&header.PreviousBlockHash)
+	if parent == nil {
+		return errors.WithDetailf(ErrOrphanHeader, "previous block %s", header.PreviousBlockHash.String())
+	}
+
+	bcBlock := types.MapBlock(&types.Block{BlockHeader: *header})
+	if err := validation.ValidateBlockHeader(bcBlock, parent); err != nil {
+		return errors.Wrap(err, "validate block header")
+	}
+
+	node, err := state.NewBlockNode(header, parent)
+	if err != nil {
+		return err
+	}
+
+	// the header and the status moving to it are stored in the same batch
+	if node.WorkSum.Cmp(c.bestNode.WorkSum) <= 0 {
+		if err := c.store.SaveBlockHeader(header, nil); err != nil {
+			return err
+		}
+
+		c.index.AddNode(node)
+		c.notifyObservers(node, arrival)
+		return nil
+	}
+
+	if err := c.store.SaveBlockHeader(header, node); err != nil {
+		return err
+	}
+
+	c.index.AddNode(node)
+	c.index.SetMainChain(node)
+	c.bestNode = node
+	c.notifyObservers(node, arrival)
+	log.WithFields(log.Fields{"height": node.Height, "hash": hash.String()}).Debug("block header extends the best chain")
+	return nil
+}
+
 // GetBlockByHeight return a block by given height
 func (c *Chain) GetBlockByHeight(height uint64) (*types.Block, error) {
 	node := c.index.NodeByHeight(height)
@@ -64,3 +347,71 @@ func (c *Chain) GetBlockByHeight(height uint64) (*types.Block, error) {
 	}
 	return c.store.GetBlock(&node.Hash)
 }
+
+// GetHeaderByHeight return a main chain block header by given height
+func (c *Chain) GetHeaderByHeight(height uint64) (*types.BlockHeader, error) {
+	node := c.index.NodeByHeight(height)
+	if node == nil {
+		return nil, errors.New("can't find block header in given height")
+	}
+	return node.BlockHeader(), nil
+}
+
+// GetHeaderByHash return a block header by given hash, side chain blocks
+// included
+func (c *Chain) GetHeaderByHash(hash *bc.Hash) (*types.BlockHeader, error) {
+	node := c.index.GetNode(hash)
+	if node == nil {
+		return nil, errors.New("can't find block header by given hash")
+	}
+	return node.BlockHeader(), nil
+}
+
+// BlockLocator returns the hashes of the main chain from the tip back to the
+// genesis, dense near the tip and exponentially sparser further down
+func (c *Chain) BlockLocator() []*bc.Hash {
+	node := c.index.BestNode()
+	locator := []*bc.Hash{}
+	for step := uint64(1); node != nil; {
+		locator = append(locator, &node.Hash)
+		if node.Height == 0 {
+			break
+		}
+
+		height := uint64(0)
+		if node.Height > step {
+			height = node.Height - step
+		}
+		node = c.index.NodeByHeight(height)
+		if len(locator) >= 10 {
+			step *= 2
+		}
+	}
+	return locator
+}
+
+// LocateHeaders returns at most maxHeaders main chain headers following the
+// first locator hash on the main chain, up to and including stopHash
+func (c *Chain) LocateHeaders(locator []*bc.Hash, stopHash *bc.Hash, maxHeaders int) []*types.BlockHeader {
+	startHeight := uint64(0)
+	for _, hash := range locator {
+		if c.index.InMainchain(*hash) {
+			startHeight = c.index.GetNode(hash).Height + 1
+			break
+		}
+	}
+
+	headers := []*types.BlockHeader{}
+	for height := startHeight; len(headers) < maxHeaders; height++ {
+		node := c.index.NodeByHeight(height)
+		if node == nil {
+			break
+		}
+
+		headers = append(headers, node.BlockHeader())
+		if node.Hash == *stopHash {
+			break
+		}
+	}
+	return headers
+}
@@ -0,0 +1,276 @@
+package protocol
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/btm-stats/chaingen"
+	"github.com/btm-stats/config"
+	"github.com/btm-stats/consensus"
+	"github.com/btm-stats/errors"
+	"github.com/btm-stats/protocol/bc"
+	"github.com/btm-stats/protocol/bc/types"
+	"github.com/btm-stats/protocol/state"
+)
+
+// newForkedChain indexes a main chain up to height 9 and a side chain forked
+// after height 4 up to height 7, it returns the nodes of both
+func newForkedChain(t *testing.T) (*Chain, []*state.BlockNode, []*state.BlockNode) {
+	index := state.NewBlockIndex()
+	addNode := func(header *types.BlockHeader, parent *state.BlockNode) *state.BlockNode {
+		node, err := state.NewBlockNode(header, parent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		index.AddNode(node)
+		return node
+	}
+
+	mainNodes := []*state.BlockNode{}
+	var parent *state.BlockNode
+	for height := uint64(0); height <= 9; height++ {
+		header := &types.BlockHeader{Version: 1, Height: height, Timestamp: 1528945000 + height*150}
+		if parent != nil {
+			header.PreviousBlockHash = parent.Hash
+		}
+		parent = addNode(header, parent)
+		mainNodes = append(mainNodes, parent)
+	}
+
+	sideNodes := []*state.BlockNode{}
+	parent = mainNodes[4]
+	for height := uint64(5); height <= 7; height++ {
+		header := &types.BlockHeader{Version: 1, Height: height, PreviousBlockHash: parent.Hash, Timestamp: 1528945000 + height*150, Nonce: 1}
+		parent = addNode(header, parent)
+		sideNodes = append(sideNodes, parent)
+	}
+
+	index.SetMainChain(mainNodes[len(mainNodes)-1])
+	return &Chain{index: index}, mainNodes, sideNodes
+}
+
+func TestLocateHeaders(t *testing.T) {
+	chain, mainNodes, sideNodes := newForkedChain(t)
+
+	cases := []struct {
+		desc        string
+		locator     []*bc.Hash
+		stopHash    *bc.Hash
+		maxHeaders  int
+		wantHeights []uint64
+	}{
+		{
+			desc:        "no locator starts from the genesis",
+			stopHash:    &bc.Hash{},
+			maxHeaders:  3,
+			wantHeights: []uint64{0, 1, 2},
+		},
+		{
+			desc:        "main chain locator",
+			locator:     []*bc.Hash{&mainNodes[3].Hash},
+			stopHash:    &bc.Hash{},
+			maxHeaders:  10,
+			wantHeights: []uint64{4, 5, 6, 7, 8, 9},
+		},
+		{
+			desc:        "side chain locator falls back to the fork point",
+			locator:     []*bc.Hash{&sideNodes[2].Hash, &sideNodes[1].Hash, &mainNodes[4].Hash, &mainNodes[0].Hash},
+			stopHash:    &bc.Hash{},
+			maxHeaders:  10,
+			wantHeights: []uint64{5, 6, 7, 8, 9},
+		},
+		{
+			desc:        "unknown locator starts from the genesis",
+			locator:     []*bc.Hash{&sideNodes[2].Hash, {V0: 1}},
+			stopHash:    &bc.Hash{},
+			maxHeaders:  2,
+			wantHeights: []uint64{0, 1},
+		},
+		{
+			desc:        "stop on the main chain",
+			locator:     []*bc.Hash{&mainNodes[4].Hash},
+			stopHash:    &mainNodes[6].Hash,
+			maxHeaders:  10,
+			wantHeights: []uint64{5, 6},
+		},
+		{
+			desc:        "stop on the side chain is never reached",
+			locator:     []*bc.Hash{&mainNodes[4].Hash},
+			stopHash:    &sideNodes[1].Hash,
+			maxHeaders:  10,
+			wantHeights: []uint64{5, 6, 7, 8, 9},
+		},
+		{
+			desc:        "locator at the tip",
+			locator:     []*bc.Hash{&mainNodes[9].Hash},
+			stopHash:    &bc.Hash{},
+			maxHeaders:  10,
+			wantHeights: []uint64{},
+		},
+	}
+
+	for _, c := range cases {
+		headers := chain.LocateHeaders(c.locator, c.stopHash, c.maxHeaders)
+		if len(headers) != len(c.wantHeights) {
+			t.Errorf("%s: got %d headers, want %d", c.desc, len(headers), len(c.wantHeights))
+			continue
+		}
+		for i, header := range headers {
+			want := mainNodes[c.wantHeights[i]]
+			if header.Hash() != want.Hash {
+				t.Errorf("%s: header %d got %x at height %d, want %x at height %d", c.desc, i, header.Hash().Bytes(), header.Height, want.Hash.Bytes(), want.Height)
+			}
+		}
+	}
+}
+
+func TestBlockLocatorOnFork(t *testing.T) {
+	chain, mainNodes, sideNodes := newForkedChain(t)
+
+	// the locator of the main chain leads a side chain peer to the fork point
+	locator := chain.BlockLocator()
+	if len(locator) != len(mainNodes) || *locator[0] != mainNodes[9].Hash || *locator[len(locator)-1] != mainNodes[0].Hash {
+		t.Fatalf("got locator of %d hashes, want the %d main chain hashes from the tip", len(locator), len(mainNodes))
+	}
+	for _, hash := range locator {
+		for _, side := range sideNodes {
+			if *hash == side.Hash {
+				t.Errorf("locator holds the side block %x", hash.Bytes())
+			}
+		}
+	}
+}
+
+// headerTestStore keeps the headers of the headers mode in memory
+type headerTestStore struct {
+	Store
+	headers []*types.BlockHeader
+	best    *state.BlockNode
+}
+
+func (s *headerTestStore) SaveBlockHeader(header *types.BlockHeader, node *state.BlockNode) error {
+	s.headers = append(s.headers, header)
+	if node != nil {
+		s.best = node
+	}
+	return nil
+}
+
+// newHeaderTestChain returns a chain of the genesis header only and a
+// generator of the main chain blocks to feed it
+func newHeaderTestChain(t *testing.T) (*Chain, *headerTestStore, *chaingen.Generator) {
+	genesis, err := state.NewBlockNode(&config.GenesisBlock().BlockHeader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index := state.NewBlockIndex()
+	index.AddNode(genesis)
+	index.SetMainChain(genesis)
+	store := &headerTestStore{}
+	chain := &Chain{index: index, store: store, bestNode: genesis}
+	chain.cond.L = new(sync.Mutex)
+	return chain, store, chaingen.New(chaingen.DefaultConfig())
+}
+
+func TestProcessBlockHeader(t *testing.T) {
+	defer func(params consensus.Params) { consensus.ActiveNetParams = params }(consensus.ActiveNetParams)
+	consensus.ActiveNetParams = consensus.SoloNetParams
+
+	chain, store, gen := newHeaderTestChain(t)
+	mainBlocks, err := gen.Main().Generate(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fork, err := gen.Main().Fork(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forkBlocks, err := fork.Generate(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the headers extending the tip move the best chain one by one
+	for _, block := range mainBlocks {
+		if err := chain.ProcessBlockHeader(&block.BlockHeader); err != nil {
+			t.Fatalf("header at height %d: %v", block.Height, err)
+		}
+		hash := block.Hash()
+		if chain.bestNode.Hash != hash || store.best == nil || store.best.Hash != hash {
+			t.Fatalf("got best header %x, want the header at height %d", chain.bestNode.Hash.Bytes(), block.Height)
+		}
+	}
+
+	cases := []struct {
+		desc     string
+		header   *types.BlockHeader
+		wantErr  error
+		wantBest *types.Block
+	}{
+		{
+			desc:    "orphan header",
+			header:  &forkBlocks[1].BlockHeader,
+			wantErr: ErrOrphanHeader,
+		},
+		{
+			desc:     "fork header of less work",
+			header:   &forkBlocks[0].BlockHeader,
+			wantBest: mainBlocks[4],
+		},
+		{
+			desc:     "former orphan header of less work",
+			header:   &forkBlocks[1].BlockHeader,
+			wantBest: mainBlocks[4],
+		},
+		{
+			desc:     "fork header of the same work",
+			header:   &forkBlocks[2].BlockHeader,
+			wantBest: mainBlocks[4],
+		},
+		{
+			desc:     "known header",
+			header:   &mainBlocks[2].BlockHeader,
+			wantBest: mainBlocks[4],
+		},
+		{
+			desc:     "fork header of more work",
+			header:   &forkBlocks[3].BlockHeader,
+			wantBest: forkBlocks[3],
+		},
+	}
+
+	for _, c := range cases {
+		saved := len(store.headers)
+		err := chain.ProcessBlockHeader(c.header)
+		if errors.Root(err) != c.wantErr {
+			t.Fatalf("%s: got err %v, want %v", c.desc, err, c.wantErr)
+		}
+		hash := c.header.Hash()
+		if c.wantErr != nil {
+			if chain.index.GetNode(&hash) != nil || len(store.headers) != saved {
+				t.Errorf("%s: the rejected header is indexed", c.desc)
+			}
+			continue
+		}
+
+		if chain.index.GetNode(&hash) == nil {
+			t.Errorf("%s: the header isn't indexed", c.desc)
+		}
+		if want := c.wantBest.Hash(); chain.bestNode.Hash != want || store.best.Hash != want {
+			t.Errorf("%s: got best header %x at height %d, want %x at height %d", c.desc, chain.bestNode.Hash.Bytes(), chain.bestNode.Height, want.Bytes(), c.wantBest.Height)
+		}
+	}
+
+	// the main chain follows the fork down to the fork point
+	for _, block := range mainBlocks[2:] {
+		if chain.index.InMainchain(block.Hash()) {
+			t.Errorf("the detached header at height %d is still on the main chain", block.Height)
+		}
+	}
+	for _, block := range forkBlocks {
+		if !chain.index.InMainchain(block.Hash()) {
+			t.Errorf("the fork header at height %d isn't on the main chain", block.Height)
+		}
+	}
+}
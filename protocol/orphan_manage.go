@@ -2,17 +2,21 @@ package protocol
 
 import (
 	"sync"
+	"time"
+
 	"github.com/btm-stats/protocol/bc"
 	"github.com/btm-stats/protocol/bc/types"
 )
 
-
 // OrphanManage is use to handle all the orphan block
 type OrphanManage struct {
 	//TODO: add orphan cached block limit
 	orphan      map[bc.Hash]*types.Block
 	prevOrphans map[bc.Hash][]*bc.Hash
-	mtx         sync.RWMutex
+	// arrivals of the orphans, the observers are told of an orphan with the
+	// time it first reached the chain once it's connected
+	arrivals map[bc.Hash]time.Time
+	mtx      sync.RWMutex
 }
 
 // NewOrphanManage return a new orphan block
@@ -20,5 +24,50 @@ func NewOrphanManage() *OrphanManage {
 	return &OrphanManage{
 		orphan:      make(map[bc.Hash]*types.Block),
 		prevOrphans: make(map[bc.Hash][]*bc.Hash),
+		arrivals:    make(map[bc.Hash]time.Time),
+	}
+}
+
+// setArrival records the arrival of an orphan, the arrivals of the orphans
+// no longer kept are dropped
+func (o *OrphanManage) setArrival(hash *bc.Hash, arrival time.Time) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	for orphanHash := range o.arrivals {
+		if _, ok := o.orphan[orphanHash]; !ok {
+			delete(o.arrivals, orphanHash)
+		}
+	}
+	if _, ok := o.orphan[*hash]; ok {
+		o.arrivals[*hash] = arrival
+	}
+}
+
+// takeArrival returns and forgets the arrival of an orphan, now when it
+// isn't recorded
+func (o *OrphanManage) takeArrival(hash *bc.Hash) time.Time {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	arrival, ok := o.arrivals[*hash]
+	if !ok {
+		return time.Now()
+	}
+	delete(o.arrivals, *hash)
+	return arrival
+}
+
+// prevOrphanBlocks returns the orphans extending the block
+func (o *OrphanManage) prevOrphanBlocks(hash *bc.Hash) []*types.Block {
+	o.mtx.RLock()
+	defer o.mtx.RUnlock()
+
+	blocks := []*types.Block{}
+	for _, orphanHash := range o.prevOrphans[*hash] {
+		if block, ok := o.orphan[*orphanHash]; ok {
+			blocks = append(blocks, block)
+		}
 	}
+	return blocks
 }
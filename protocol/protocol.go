@@ -2,6 +2,8 @@ package protocol
 
 import (
 	"sync"
+
+	log "github.com/sirupsen/logrus"
 	"github.com/btm-stats/protocol/state"
 	"github.com/btm-stats/errors"
 	"github.com/btm-stats/protocol/bc"
@@ -23,6 +25,7 @@ type Chain struct {
 	txPool         *TxPool
 	store          Store
 	processBlockCh chan *processBlockMsg
+	observers      []BlockObserver
 
 	cond     sync.Cond
 	bestNode *state.BlockNode
@@ -53,6 +56,7 @@ func NewChain(store Store, txPool *TxPool) (*Chain, error) {
 
 	c.bestNode = c.index.GetNode(storeStatus.Hash)
 	c.index.SetMainChain(c.bestNode)
+	c.recoverChainStatus()
 	go c.blockProcesser()
 	return c, nil
 }
@@ -64,10 +68,6 @@ func (c *Chain) initChainStatus() error {
 		txStatus.SetStatus(i, false)
 	}
 
-	if err := c.store.SaveBlock(genesisBlock, txStatus); err != nil {
-		return err
-	}
-
 	utxoView := state.NewUtxoViewpoint()
 	bcBlock := types.MapBlock(genesisBlock)
 	if err := utxoView.ApplyBlock(bcBlock, txStatus); err != nil {
@@ -78,5 +78,32 @@ func (c *Chain) initChainStatus() error {
 	if err != nil {
 		return err
 	}
-	return c.store.SaveChainStatus(node, utxoView)
+	return c.store.SaveBlockAndChainStatus(genesisBlock, txStatus, node, utxoView)
+}
+
+// recoverChainStatus resumes the reorganization a crash interrupted after
+// the old chain was detached, the chain moves on to the target or back to the
+// tip it left. Without a reorganization state the stored status is always
+// consistent with the stored utxos.
+func (c *Chain) recoverChainStatus() {
+	reorg := c.store.GetReorgState()
+	if reorg == nil {
+		return
+	}
+
+	for _, hash := range []*bc.Hash{reorg.Target, reorg.Origin} {
+		node := c.index.GetNode(hash)
+		if node == nil {
+			log.WithField("hash", hash.String()).Warning("the tip of the interrupted reorganization isn't in the block index")
+			continue
+		}
+
+		log.WithFields(log.Fields{"height": node.Height, "hash": node.Hash.String()}).Info("resume the interrupted reorganization")
+		err := c.reorganizeChain(node)
+		if err == nil {
+			return
+		}
+		log.WithFields(log.Fields{"height": node.Height, "err": err}).Warning("fail on resume the interrupted reorganization")
+	}
+	log.WithField("height", c.bestNode.Height).Error("keep the chain status at the fork point of the interrupted reorganization")
 }
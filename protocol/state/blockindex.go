@@ -1,8 +1,12 @@
 package state
 
 import (
+	"sort"
 	"sync"
+	"github.com/btm-stats/consensus"
+	"github.com/btm-stats/consensus/difficulty"
 	"github.com/btm-stats/protocol/bc"
+	"github.com/btm-stats/protocol/bc/types"
 	"math/big"
 )
 
@@ -27,6 +31,66 @@ type BlockNode struct {
 	TransactionStatusHash  bc.Hash
 }
 
+// CalcPastMedianTime calculates the median time of the previous few blocks
+// prior to, and including, the block node.
+func (node *BlockNode) CalcPastMedianTime() uint64 {
+	timestamps := []uint64{}
+	iterNode := node
+	for i := 0; i < consensus.MedianTimeBlocks && iterNode != nil; i++ {
+		timestamps = append(timestamps, iterNode.Timestamp)
+		iterNode = iterNode.Parent
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[len(timestamps)/2]
+}
+
+// CalcNextBits calculates the difficulty bits required by the child of the
+// block node
+func (node *BlockNode) CalcNextBits() uint64 {
+	if node.Height%consensus.BlocksPerRetarget != 0 || node.Height == 0 {
+		return node.Bits
+	}
+
+	compareNode := node.Parent
+	for compareNode.Height%consensus.BlocksPerRetarget != 0 {
+		compareNode = compareNode.Parent
+	}
+	return difficulty.CalcNextRequiredDifficulty(node.Height, node.Bits, node.Timestamp, compareNode.Timestamp)
+}
+
+// CalcNextSeed returns the seed of the child of the block node, the seed
+// changes to the hash of every SeedPerRetarget-th block
+func (node *BlockNode) CalcNextSeed() *bc.Hash {
+	if node.Height == 0 {
+		return consensus.InitialSeed
+	}
+	if node.Height%consensus.SeedPerRetarget == 0 {
+		return &node.Hash
+	}
+	return node.Seed
+}
+
+// BlockHeader rebuilds the header of the block node
+func (node *BlockNode) BlockHeader() *types.BlockHeader {
+	previousBlockHash := bc.Hash{}
+	if node.Parent != nil {
+		previousBlockHash = node.Parent.Hash
+	}
+	return &types.BlockHeader{
+		Version:           node.Version,
+		Height:            node.Height,
+		PreviousBlockHash: previousBlockHash,
+		Timestamp:         node.Timestamp,
+		Nonce:             node.Nonce,
+		Bits:              node.Bits,
+		BlockCommitment: types.BlockCommitment{
+			TransactionsMerkleRoot: node.TransactionsMerkleRoot,
+			TransactionStatusHash:  node.TransactionStatusHash,
+		},
+	}
+}
+
 // BlockIndex is the struct for help chain trace block chain as tree
 type BlockIndex struct {
 	sync.RWMutex
@@ -42,6 +106,55 @@ func (bi *BlockIndex) GetNode(hash *bc.Hash) *BlockNode {
 	return bi.index[*hash]
 }
 
+// BestNode returns the tip of the main chain
+func (bi *BlockIndex) BestNode() *BlockNode {
+	bi.RLock()
+	defer bi.RUnlock()
+	return bi.mainChain[len(bi.mainChain)-1]
+}
+
+// NodesInHeights returns the nodes of every chain within the heights
+// [from, to), sorted by height so a parent comes before its children
+func (bi *BlockIndex) NodesInHeights(from, to uint64) []*BlockNode {
+	bi.RLock()
+	defer bi.RUnlock()
+
+	nodes := []*BlockNode{}
+	for _, node := range bi.index {
+		if node.Height >= from && node.Height < to {
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Height < nodes[j].Height })
+	return nodes
+}
+
+// NodeByHeight returns the main chain node at the height
+func (bi *BlockIndex) NodeByHeight(height uint64) *BlockNode {
+	bi.RLock()
+	defer bi.RUnlock()
+	return bi.nodeByHeight(height)
+}
+
+func (bi *BlockIndex) nodeByHeight(height uint64) *BlockNode {
+	if height >= uint64(len(bi.mainChain)) {
+		return nil
+	}
+	return bi.mainChain[height]
+}
+
+// InMainchain checks whether the block is part of the main chain
+func (bi *BlockIndex) InMainchain(hash bc.Hash) bool {
+	bi.RLock()
+	defer bi.RUnlock()
+
+	node, ok := bi.index[hash]
+	if !ok {
+		return false
+	}
+	return bi.nodeByHeight(node.Height) == node
+}
+
 // SetMainChain will set the the mainChain array
 func (bi *BlockIndex) SetMainChain(node *BlockNode) {
 	bi.Lock()
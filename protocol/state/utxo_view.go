@@ -1,8 +1,170 @@
 package state
 
-import "github.com/btm-stats/database/storage"
+import (
+	"github.com/btm-stats/consensus"
+	"github.com/btm-stats/database/storage"
+	"github.com/btm-stats/errors"
+	"github.com/btm-stats/protocol/bc"
+)
+
+var (
+	errMissingUtxo  = errors.New("fail to find utxo entry")
+	errImmatureUtxo = errors.New("coinbase utxo is not ready for use")
+	errMissingUndo  = errors.New("fail to find the undo entry of the spent utxo")
+	errUnspentUtxo  = errors.New("try to revert an unspent utxo")
+)
+
+// BlockUndo holds the utxo entries a block spent as they were before the
+// block, detaching the block restores them since the spent entries are
+// deleted from the store
+type BlockUndo map[bc.Hash]*storage.UtxoEntry
+
+// BlockTxs lists the transactions of a block attached to or detached from the
+// view, and the spending transaction of each output they spent
+type BlockTxs struct {
+	Hash   bc.Hash
+	Height uint64
+	TxIDs  []bc.Hash
+	Spends map[bc.Hash]bc.Hash
+}
+
+func newBlockTxs(block *bc.Block) *BlockTxs {
+	blockTxs := &BlockTxs{
+		Hash:   block.ID,
+		Height: block.Height,
+		Spends: make(map[bc.Hash]bc.Hash),
+	}
+	for _, tx := range block.Transactions {
+		blockTxs.TxIDs = append(blockTxs.TxIDs, tx.ID)
+	}
+	return blockTxs
+}
 
 // UtxoViewpoint represents a view into the set of unspent transaction outputs
 type UtxoViewpoint struct {
 	Entries map[bc.Hash]*storage.UtxoEntry
+
+	// Undos holds the undo records of the blocks applied to the view, and
+	// Detached the blocks whose undo records are obsolete
+	Undos    map[bc.Hash]BlockUndo
+	Detached []bc.Hash
+
+	// AttachedTxs and DetachedTxs hold the transactions of the blocks applied
+	// to and detached from the view in order, for the transaction indexes
+	AttachedTxs []*BlockTxs
+	DetachedTxs []*BlockTxs
+}
+
+// NewUtxoViewpoint returns a new empty unspent transaction output view.
+func NewUtxoViewpoint() *UtxoViewpoint {
+	return &UtxoViewpoint{
+		Entries: make(map[bc.Hash]*storage.UtxoEntry),
+		Undos:   make(map[bc.Hash]BlockUndo),
+	}
+}
+
+// HasUtxo checks whether the view holds the entry of the output
+func (view *UtxoViewpoint) HasUtxo(hash *bc.Hash) bool {
+	_, ok := view.Entries[*hash]
+	return ok
+}
+
+// ApplyBlock spends the outputs consumed by the block and adds the ones it
+// creates, the spent entries are kept as the undo record of the block
+func (view *UtxoViewpoint) ApplyBlock(block *bc.Block, txStatus *bc.TransactionStatus) error {
+	undo := BlockUndo{}
+	blockTxs := newBlockTxs(block)
+	for i, tx := range block.Transactions {
+		statusFail, err := txStatus.GetStatus(i)
+		if err != nil {
+			return err
+		}
+
+		for _, prevout := range tx.SpentOutputIDs {
+			spentOutput, ok := tx.Entries[prevout].(*bc.Output)
+			if !ok {
+				return errors.WithDetailf(errMissingUtxo, "tx %s spends unknown output %s", tx.ID.String(), prevout.String())
+			}
+			if statusFail && *spentOutput.Source.Value.AssetId != *consensus.BTMAssetID {
+				continue
+			}
+
+			entry, ok := view.Entries[prevout]
+			if !ok || entry.Spent {
+				return errors.WithDetailf(errMissingUtxo, "output %s", prevout.String())
+			}
+			if entry.IsCoinBase && entry.BlockHeight+consensus.CoinbasePendingBlockNumber > block.Height {
+				return errors.WithDetailf(errImmatureUtxo, "output %s", prevout.String())
+			}
+
+			undo[prevout] = storage.NewUtxoEntry(entry.IsCoinBase, entry.BlockHeight, false)
+			entry.SpendOutput()
+			blockTxs.Spends[prevout] = tx.ID
+		}
+
+		for _, id := range tx.ResultIds {
+			output, ok := tx.Entries[*id].(*bc.Output)
+			if !ok {
+				// retirements don't create utxo
+				continue
+			}
+			if statusFail && *output.Source.Value.AssetId != *consensus.BTMAssetID {
+				continue
+			}
+			view.Entries[*id] = storage.NewUtxoEntry(i == 0, block.Height, false)
+		}
+	}
+
+	view.Undos[block.ID] = undo
+	view.AttachedTxs = append(view.AttachedTxs, blockTxs)
+	return nil
+}
+
+// DetachBlock reverts ApplyBlock, the outputs created by the block are marked
+// spent to be deleted and the ones it spent are restored from its undo record
+func (view *UtxoViewpoint) DetachBlock(block *bc.Block, txStatus *bc.TransactionStatus, undo BlockUndo) error {
+	blockTxs := newBlockTxs(block)
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		tx := block.Transactions[i]
+		statusFail, err := txStatus.GetStatus(i)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range tx.ResultIds {
+			output, ok := tx.Entries[*id].(*bc.Output)
+			if !ok {
+				continue
+			}
+			if statusFail && *output.Source.Value.AssetId != *consensus.BTMAssetID {
+				continue
+			}
+			view.Entries[*id] = storage.NewUtxoEntry(false, block.Height, true)
+		}
+
+		for _, prevout := range tx.SpentOutputIDs {
+			spentOutput, ok := tx.Entries[prevout].(*bc.Output)
+			if !ok {
+				return errors.WithDetailf(errMissingUtxo, "tx %s spends unknown output %s", tx.ID.String(), prevout.String())
+			}
+			if statusFail && *spentOutput.Source.Value.AssetId != *consensus.BTMAssetID {
+				continue
+			}
+
+			if entry, ok := view.Entries[prevout]; ok && !entry.Spent {
+				return errors.WithDetailf(errUnspentUtxo, "output %s", prevout.String())
+			}
+			entry, ok := undo[prevout]
+			if !ok {
+				return errors.WithDetailf(errMissingUndo, "output %s", prevout.String())
+			}
+			view.Entries[prevout] = storage.NewUtxoEntry(entry.IsCoinBase, entry.BlockHeight, false)
+			blockTxs.Spends[prevout] = tx.ID
+		}
+	}
+
+	delete(view.Undos, block.ID)
+	view.Detached = append(view.Detached, block.ID)
+	view.DetachedTxs = append(view.DetachedTxs, blockTxs)
+	return nil
 }
@@ -1,17 +1,24 @@
 package protocol
 
 import (
+	"github.com/btm-stats/errors"
 	"github.com/btm-stats/protocol/state"
 	"github.com/btm-stats/database/storage"
 	"github.com/btm-stats/protocol/bc"
 	"github.com/btm-stats/protocol/bc/types"
 )
 
+// ErrBlockPruned is returned when the body of a block is asked after the
+// pruning deleted it, its header stays in the block index
+var ErrBlockPruned = errors.New("block body is pruned")
+
 // Store provides storage interface for blockchain data
 type Store interface {
 	BlockExist(*bc.Hash) bool
 
 	GetBlock(*bc.Hash) (*types.Block, error)
+	GetBlockUndo(*bc.Hash) (state.BlockUndo, error)
+	GetReorgState() *ReorgState
 	GetStoreStatus() *BlockStoreState
 	GetTransactionStatus(*bc.Hash) (*bc.TransactionStatus, error)
 	GetTransactionsUtxo(*state.UtxoViewpoint, []*bc.Tx) error
@@ -19,7 +26,10 @@ type Store interface {
 
 	LoadBlockIndex() (*state.BlockIndex, error)
 	SaveBlock(*types.Block, *bc.TransactionStatus) error
+	SaveBlockAndChainStatus(*types.Block, *bc.TransactionStatus, *state.BlockNode, *state.UtxoViewpoint) error
+	SaveBlockHeader(*types.BlockHeader, *state.BlockNode) error
 	SaveChainStatus(*state.BlockNode, *state.UtxoViewpoint) error
+	SaveReorgStatus(*state.BlockNode, *state.UtxoViewpoint, *ReorgState) error
 }
 
 // BlockStoreState represents the core's db status
@@ -27,3 +37,11 @@ type BlockStoreState struct {
 	Height uint64
 	Hash   *bc.Hash
 }
+
+// ReorgState marks a reorganization whose old chain is detached but whose new
+// chain isn't attached yet, it's stored along the chain status of the fork
+// point and cleared by the next chain status
+type ReorgState struct {
+	Target *bc.Hash // the tip the chain moves to
+	Origin *bc.Hash // the tip the chain left
+}
@@ -50,3 +50,60 @@ func NewTxPool() *TxPool {
 		newTxCh:     make(chan *types.Tx, maxNewTxChSize),
 	}
 }
+
+// GetNewTxCh return a unconfirmed transaction feed channel
+func (tp *TxPool) GetNewTxCh() chan *types.Tx {
+	return tp.newTxCh
+}
+
+// AddTransaction add a verified transaction to pool
+func (tp *TxPool) AddTransaction(tx *types.Tx, height, fee uint64) (*TxDesc, error) {
+	tp.mtx.Lock()
+	defer tp.mtx.Unlock()
+
+	if len(tp.pool) >= maxNewTxNum {
+		return nil, ErrPoolIsFull
+	}
+
+	txD := &TxDesc{
+		Tx:     tx,
+		Added:  time.Now(),
+		Weight: tx.SerializedSize,
+		Height: height,
+		Fee:    fee,
+	}
+	if tx.SerializedSize > 0 {
+		txD.FeePerKB = fee * 1000 / tx.SerializedSize
+	}
+
+	tp.pool[tx.ID] = txD
+	tp.lastUpdated = time.Now().Unix()
+
+	select {
+	case tp.newTxCh <- tx:
+	default:
+		// nobody is draining the feed, the tx is still in the pool
+	}
+	return txD, nil
+}
+
+// HaveTransaction return true if the transaction is in the pool
+func (tp *TxPool) HaveTransaction(txID *bc.Hash) bool {
+	tp.mtx.RLock()
+	defer tp.mtx.RUnlock()
+
+	_, ok := tp.pool[*txID]
+	return ok
+}
+
+// GetTransactions return all the transactions in the pool
+func (tp *TxPool) GetTransactions() []*TxDesc {
+	tp.mtx.RLock()
+	defer tp.mtx.RUnlock()
+
+	txDs := make([]*TxDesc, 0, len(tp.pool))
+	for _, desc := range tp.pool {
+		txDs = append(txDs, desc)
+	}
+	return txDs
+}
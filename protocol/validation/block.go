@@ -0,0 +1,123 @@
+// Package validation implements the consensus checks of the blocks and the
+// transactions, every rejection is a typed error which Reason classifies
+package validation
+
+import (
+	"time"
+
+	"github.com/btm-stats/consensus"
+	"github.com/btm-stats/consensus/difficulty"
+	"github.com/btm-stats/errors"
+	"github.com/btm-stats/protocol/bc"
+	"github.com/btm-stats/protocol/state"
+)
+
+func checkBlockTime(b *bc.Block, parent *state.BlockNode) error {
+	if b.Timestamp > uint64(time.Now().Unix())+consensus.MaxTimeOffsetSeconds {
+		return errors.WithDetailf(ErrBadTimestamp, "block timestamp %d is too far in the future", b.Timestamp)
+	}
+	if medianTime := parent.CalcPastMedianTime(); b.Timestamp <= medianTime {
+		return errors.WithDetailf(ErrBadTimestamp, "block timestamp %d is not after the median time %d", b.Timestamp, medianTime)
+	}
+	return nil
+}
+
+func checkCoinbaseAmount(b *bc.Block, amount uint64) error {
+	if len(b.Transactions) == 0 {
+		return errors.Wrap(ErrWrongCoinbaseTransaction, "block is empty")
+	}
+
+	tx := b.Transactions[0]
+	if len(tx.InputIDs) != 1 {
+		return errors.WithDetailf(ErrWrongCoinbaseTransaction, "coinbase has %d inputs", len(tx.InputIDs))
+	}
+	if _, ok := tx.Entries[tx.InputIDs[0]].(*bc.Coinbase); !ok {
+		return errors.WithDetail(ErrWrongCoinbaseTransaction, "first transaction isn't a coinbase")
+	}
+	if len(tx.TxHeader.ResultIds) != 1 {
+		return errors.WithDetailf(ErrWrongCoinbaseTransaction, "coinbase has %d outputs", len(tx.TxHeader.ResultIds))
+	}
+	output, err := getOutput(tx, *tx.TxHeader.ResultIds[0])
+	if err != nil {
+		return errors.Sub(ErrWrongCoinbaseTransaction, err)
+	}
+	if output.Source.Value.Amount != amount {
+		return errors.WithDetailf(ErrWrongCoinbaseTransaction, "coinbase amount %d, expected %d", output.Source.Value.Amount, amount)
+	}
+	return nil
+}
+
+// ValidateBlockHeader checks the header of a block extending parent
+func ValidateBlockHeader(b *bc.Block, parent *state.BlockNode) error {
+	if b.Version < parent.Version {
+		return errors.WithDetailf(ErrVersionRegression, "previous block verson %d, current block version %d", parent.Version, b.Version)
+	}
+	if b.Height != parent.Height+1 {
+		return errors.WithDetailf(ErrMisorderedBlockHeight, "previous block height %d, current block height %d", parent.Height, b.Height)
+	}
+	if parent.Hash != *b.PreviousBlockId {
+		return errors.WithDetailf(ErrMismatchedBlock, "previous block ID %x, current block wants %x", parent.Hash.Bytes(), b.PreviousBlockId.Bytes())
+	}
+	if bits := parent.CalcNextBits(); b.Bits != bits {
+		return errors.WithDetailf(ErrBadBits, "block bits %d, required bits %d", b.Bits, bits)
+	}
+	if err := checkBlockTime(b, parent); err != nil {
+		return err
+	}
+	if !consensus.ActiveNetParams.SkipPoW && !difficulty.CheckProofOfWork(&b.ID, parent.CalcNextSeed(), b.Bits) {
+		return ErrWorkProof
+	}
+	return nil
+}
+
+// ValidateBlock validates a block and the transactions within, it returns
+// the status of the transactions whose programs failed but which still pay
+// for the gas
+func ValidateBlock(b *bc.Block, parent *state.BlockNode) (*bc.TransactionStatus, error) {
+	if err := ValidateBlockHeader(b, parent); err != nil {
+		return nil, err
+	}
+
+	blockGasSum := uint64(0)
+	coinbaseAmount := consensus.BlockSubsidy(b.BlockHeader.Height)
+	txStatus := bc.NewTransactionStatus()
+
+	for i, tx := range b.Transactions {
+		gasStatus, err := ValidateTx(tx, b)
+		if !gasStatus.GasValid {
+			if err == nil {
+				err = ErrNoGasInput
+			}
+			return nil, errors.Wrapf(err, "validate of transaction %d of %d", i, len(b.Transactions))
+		}
+
+		if err := txStatus.SetStatus(i, err != nil); err != nil {
+			return nil, err
+		}
+		coinbaseAmount += gasStatus.BTMValue
+		if blockGasSum += uint64(gasStatus.GasUsed); blockGasSum > consensus.MaxBlockGas {
+			return nil, ErrOverBlockLimit
+		}
+	}
+
+	if err := checkCoinbaseAmount(b, coinbaseAmount); err != nil {
+		return nil, err
+	}
+
+	txMerkleRoot, err := bc.TxMerkleRoot(b.Transactions)
+	if err != nil {
+		return nil, errors.Wrap(err, "computing transaction id merkle root")
+	}
+	if txMerkleRoot != *b.TransactionsRoot {
+		return nil, errors.WithDetailf(ErrMismatchedMerkleRoot, "transaction id merkle root %x, block wants %x", txMerkleRoot.Bytes(), b.TransactionsRoot.Bytes())
+	}
+
+	txStatusHash, err := bc.TxStatusMerkleRoot(txStatus.VerifyStatus)
+	if err != nil {
+		return nil, errors.Wrap(err, "computing transaction status merkle root")
+	}
+	if txStatusHash != *b.TransactionStatusHash {
+		return nil, errors.WithDetailf(ErrMismatchedTxStatus, "transaction status merkle root %x, block wants %x", txStatusHash.Bytes(), b.TransactionStatusHash.Bytes())
+	}
+	return txStatus, nil
+}
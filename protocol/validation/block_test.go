@@ -0,0 +1,269 @@
+package validation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/btm-stats/consensus"
+	"github.com/btm-stats/protocol/bc"
+	"github.com/btm-stats/protocol/bc/types"
+	"github.com/btm-stats/protocol/state"
+	"github.com/btm-stats/protocol/vm"
+)
+
+var (
+	testAsset    = bc.AssetID{V0: 1}
+	trueProgram  = []byte{byte(vm.OP_TRUE)}
+	failProgram  = []byte{byte(vm.OP_FAIL)}
+	testSpendFee = uint64(1000000)
+)
+
+// newTestParent returns the tip at height 10 of a chain stamped a block per
+// 150s up to 150s ago
+func newTestParent(bits uint64) *state.BlockNode {
+	var node *state.BlockNode
+	start := uint64(time.Now().Unix()) - 11*150
+	for i := uint64(0); i <= 10; i++ {
+		node = &state.BlockNode{Parent: node, Version: 1, Height: i, Timestamp: start + i*150, Bits: bits, Seed: consensus.InitialSeed}
+		node.Hash = bc.NewHash([32]byte{byte(i)})
+	}
+	return node
+}
+
+func newCoinbaseTx(amount uint64, arbitrary []byte) *types.Tx {
+	return types.NewTx(types.TxData{
+		Version:        1,
+		SerializedSize: 1,
+		Inputs:         []*types.TxInput{types.NewCoinbaseInput(arbitrary)},
+		Outputs:        []*types.TxOutput{types.NewTxOutput(*consensus.BTMAssetID, amount, trueProgram)},
+	})
+}
+
+func newSpendInput(source byte, assetID bc.AssetID, amount uint64, program []byte) *types.TxInput {
+	return types.NewSpendInput(nil, bc.NewHash([32]byte{source}), assetID, amount, 0, program)
+}
+
+// newTransferTx spends a BTM output and pays testSpendFee of it as the fee
+func newTransferTx(source byte) *types.Tx {
+	return types.NewTx(types.TxData{
+		Version:        1,
+		SerializedSize: 100,
+		Inputs:         []*types.TxInput{newSpendInput(source, *consensus.BTMAssetID, 100000000, trueProgram)},
+		Outputs:        []*types.TxOutput{types.NewTxOutput(*consensus.BTMAssetID, 100000000-testSpendFee, trueProgram)},
+	})
+}
+
+// newTestBlock builds a block of txs extending parent, the statuses of the
+// failed transactions are committed as failed
+func newTestBlock(parent *state.BlockNode, txs []*types.Tx, failed ...int) *types.Block {
+	block := &types.Block{
+		BlockHeader: types.BlockHeader{
+			Version:           1,
+			Height:            parent.Height + 1,
+			PreviousBlockHash: parent.Hash,
+			Timestamp:         parent.Timestamp + 150,
+			Bits:              parent.Bits,
+		},
+		Transactions: txs,
+	}
+
+	bcTxs := []*bc.Tx{}
+	txStatus := bc.NewTransactionStatus()
+	for i, tx := range txs {
+		bcTxs = append(bcTxs, tx.Tx)
+		txStatus.SetStatus(i, false)
+	}
+	for _, i := range failed {
+		txStatus.SetStatus(i, true)
+	}
+
+	block.TransactionsMerkleRoot, _ = bc.TxMerkleRoot(bcTxs)
+	block.TransactionStatusHash, _ = bc.TxStatusMerkleRoot(txStatus.VerifyStatus)
+	return block
+}
+
+func TestValidateBlock(t *testing.T) {
+	defer func(params consensus.Params) { consensus.ActiveNetParams = params }(consensus.ActiveNetParams)
+	consensus.ActiveNetParams = consensus.SoloNetParams
+
+	parent := newTestParent(2161727821137910632)
+	subsidy := consensus.BlockSubsidy(parent.Height + 1)
+
+	// a transfer whose asset input fails its program but still pays the fee
+	failedTx := types.NewTx(types.TxData{
+		Version:        1,
+		SerializedSize: 100,
+		Inputs: []*types.TxInput{
+			newSpendInput(2, *consensus.BTMAssetID, 100000000, trueProgram),
+			newSpendInput(3, testAsset, 5, failProgram),
+		},
+		Outputs: []*types.TxOutput{
+			types.NewTxOutput(*consensus.BTMAssetID, 100000000-testSpendFee, trueProgram),
+			types.NewTxOutput(testAsset, 5, trueProgram),
+		},
+	})
+
+	// the gas of 51 transactions paying for the most gas is over the block limit
+	heavyTxs := []*types.Tx{newCoinbaseTx(subsidy, nil)}
+	for i := 0; i < 51; i++ {
+		heavyTxs = append(heavyTxs, types.NewTx(types.TxData{
+			Version:        1,
+			SerializedSize: 199000,
+			Inputs:         []*types.TxInput{newSpendInput(byte(10+i), *consensus.BTMAssetID, 41000000, trueProgram)},
+			Outputs:        []*types.TxOutput{types.NewTxOutput(*consensus.BTMAssetID, 1000000, trueProgram)},
+		}))
+	}
+
+	cases := []struct {
+		desc       string
+		txs        []*types.Tx
+		failed     []int
+		modify     func(*types.Block)
+		wantReason RejectReason
+		wantErr    bool
+	}{
+		{
+			desc: "valid block",
+			txs:  []*types.Tx{newCoinbaseTx(subsidy+testSpendFee, nil), newTransferTx(1)},
+		},
+		{
+			desc:   "failed transaction committed as failed",
+			txs:    []*types.Tx{newCoinbaseTx(subsidy+testSpendFee, nil), failedTx},
+			failed: []int{1},
+		},
+		{
+			desc:       "failed transaction committed as passed",
+			txs:        []*types.Tx{newCoinbaseTx(subsidy+testSpendFee, nil), failedTx},
+			wantReason: RejectTxStatus,
+			wantErr:    true,
+		},
+		{
+			desc:       "passed transaction committed as failed",
+			txs:        []*types.Tx{newCoinbaseTx(subsidy+testSpendFee, nil), newTransferTx(1)},
+			failed:     []int{1},
+			wantReason: RejectTxStatus,
+			wantErr:    true,
+		},
+		{
+			desc:       "version regression",
+			txs:        []*types.Tx{newCoinbaseTx(subsidy, nil)},
+			modify:     func(b *types.Block) { b.Version = 0 },
+			wantReason: RejectVersion,
+			wantErr:    true,
+		},
+		{
+			desc:       "skipped height",
+			txs:        []*types.Tx{newCoinbaseTx(subsidy, nil)},
+			modify:     func(b *types.Block) { b.Height++ },
+			wantReason: RejectHeight,
+			wantErr:    true,
+		},
+		{
+			desc:       "unknown parent",
+			txs:        []*types.Tx{newCoinbaseTx(subsidy, nil)},
+			modify:     func(b *types.Block) { b.PreviousBlockHash = bc.Hash{} },
+			wantReason: RejectParent,
+			wantErr:    true,
+		},
+		{
+			desc:       "wrong bits",
+			txs:        []*types.Tx{newCoinbaseTx(subsidy, nil)},
+			modify:     func(b *types.Block) { b.Bits++ },
+			wantReason: RejectBits,
+			wantErr:    true,
+		},
+		{
+			desc:       "timestamp at the median time",
+			txs:        []*types.Tx{newCoinbaseTx(subsidy, nil)},
+			modify:     func(b *types.Block) { b.Timestamp = parent.CalcPastMedianTime() },
+			wantReason: RejectTimestamp,
+			wantErr:    true,
+		},
+		{
+			desc:       "timestamp in the future",
+			txs:        []*types.Tx{newCoinbaseTx(subsidy, nil)},
+			modify:     func(b *types.Block) { b.Timestamp = uint64(time.Now().Unix()) + 2*consensus.MaxTimeOffsetSeconds },
+			wantReason: RejectTimestamp,
+			wantErr:    true,
+		},
+		{
+			desc:       "block gas over the limit",
+			txs:        heavyTxs,
+			wantReason: RejectBlockGas,
+			wantErr:    true,
+		},
+		{
+			desc:       "coinbase missing the fees",
+			txs:        []*types.Tx{newCoinbaseTx(subsidy, nil), newTransferTx(1)},
+			wantReason: RejectCoinbase,
+			wantErr:    true,
+		},
+		{
+			desc:       "coinbase not first",
+			txs:        []*types.Tx{newTransferTx(1), newCoinbaseTx(subsidy+testSpendFee, nil)},
+			wantReason: RejectCoinbase,
+			wantErr:    true,
+		},
+		{
+			desc:       "coinbase arbitrary oversize",
+			txs:        []*types.Tx{newCoinbaseTx(subsidy, make([]byte, consensus.CoinbaseArbitrarySizeLimit+1))},
+			wantReason: RejectCoinbase,
+			wantErr:    true,
+		},
+		{
+			desc:       "wrong merkle root",
+			txs:        []*types.Tx{newCoinbaseTx(subsidy, nil)},
+			modify:     func(b *types.Block) { b.TransactionsMerkleRoot = bc.Hash{} },
+			wantReason: RejectMerkleRoot,
+			wantErr:    true,
+		},
+		{
+			desc:       "invalid transaction",
+			txs:        []*types.Tx{newCoinbaseTx(subsidy, nil), newTransferTx(1), types.NewTx(types.TxData{Version: 2, SerializedSize: 100})},
+			wantReason: RejectTxFormat,
+			wantErr:    true,
+		},
+	}
+
+	for _, c := range cases {
+		block := newTestBlock(parent, c.txs, c.failed...)
+		if c.modify != nil {
+			c.modify(block)
+		}
+
+		txStatus, err := ValidateBlock(types.MapBlock(block), parent)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: got err = %v, want err %v", c.desc, err, c.wantErr)
+			continue
+		}
+		if err != nil {
+			if got := Reason(err); got != c.wantReason {
+				t.Errorf("%s: got reason %s, want %s (%v)", c.desc, got, c.wantReason, err)
+			}
+			continue
+		}
+
+		for i := range c.txs {
+			wantFail := false
+			for _, f := range c.failed {
+				wantFail = wantFail || f == i
+			}
+			if gotFail, _ := txStatus.GetStatus(i); gotFail != wantFail {
+				t.Errorf("%s: transaction %d got failed status %v, want %v", c.desc, i, gotFail, wantFail)
+			}
+		}
+	}
+}
+
+func TestValidateBlockProofOfWork(t *testing.T) {
+	defer func(params consensus.Params) { consensus.ActiveNetParams = params }(consensus.ActiveNetParams)
+	consensus.ActiveNetParams = consensus.MainNetParams
+
+	// no hash meets a zero target
+	parent := newTestParent(0)
+	block := newTestBlock(parent, []*types.Tx{newCoinbaseTx(consensus.BlockSubsidy(parent.Height+1), nil)})
+	_, err := ValidateBlock(types.MapBlock(block), parent)
+	if got := Reason(err); got != RejectProofOfWork {
+		t.Errorf("got reason %s, want %s (%v)", got, RejectProofOfWork, err)
+	}
+}
@@ -0,0 +1,184 @@
+package validation
+
+import (
+	"sync"
+
+	"github.com/btm-stats/errors"
+	"github.com/btm-stats/math/checked"
+)
+
+// Block rejection errors
+var (
+	ErrBadTimestamp          = errors.New("block timestamp is not in the valid range")
+	ErrBadBits               = errors.New("block bits is invalid")
+	ErrMismatchedBlock       = errors.New("mismatched block")
+	ErrMismatchedMerkleRoot  = errors.New("mismatched merkle root")
+	ErrMismatchedTxStatus    = errors.New("mismatched transaction status hash")
+	ErrMisorderedBlockHeight = errors.New("misordered block height")
+	ErrOverBlockLimit        = errors.New("block's gas is over the limit")
+	ErrWorkProof             = errors.New("invalid difficulty proof of work")
+	ErrVersionRegression     = errors.New("version regression")
+)
+
+// Transaction rejection errors
+var (
+	ErrTxVersion                 = errors.New("invalid transaction version")
+	ErrWrongTransactionSize      = errors.New("invalid transaction size")
+	ErrBadTimeRange              = errors.New("invalid transaction time range")
+	ErrNotStandardTx             = errors.New("not standard transaction")
+	ErrWrongCoinbaseTransaction  = errors.New("wrong coinbase transaction")
+	ErrWrongCoinbaseAsset        = errors.New("wrong coinbase assetID")
+	ErrCoinbaseArbitraryOversize = errors.New("coinbase arbitrary size is larger than limit")
+	ErrEmptyResults              = errors.New("transaction has no results")
+	ErrMismatchedAssetID         = errors.New("mismatched asset id")
+	ErrMismatchedPosition        = errors.New("mismatched value source/dest position")
+	ErrMismatchedReference       = errors.New("mismatched reference")
+	ErrMismatchedValue           = errors.New("mismatched value")
+	ErrMissingField              = errors.New("missing required field")
+	ErrMissingEntry              = errors.New("missing entry")
+	ErrEntryType                 = errors.New("invalid entry type")
+	ErrNoSource                  = errors.New("no source for value")
+	ErrOverflow                  = errors.New("arithmetic overflow/underflow")
+	ErrPosition                  = errors.New("invalid source or destination position")
+	ErrUnbalanced                = errors.New("unbalanced asset amount between input and output")
+	ErrOverGasCredit             = errors.New("all gas credit has been spend")
+	ErrGasCalculate              = errors.New("gas usage calculate got a math error")
+	ErrNoGasInput                = errors.New("transaction has no gas input")
+	ErrProgram                   = errors.New("program verification failed")
+)
+
+// RejectReason classifies why a block was rejected
+type RejectReason uint8
+
+// The reject reasons, RejectOther covers the errors that don't come from the
+// validation itself like storage failures
+const (
+	RejectOther RejectReason = iota
+	RejectVersion
+	RejectHeight
+	RejectParent
+	RejectTimestamp
+	RejectBits
+	RejectProofOfWork
+	RejectBlockGas
+	RejectCoinbase
+	RejectMerkleRoot
+	RejectTxStatus
+	RejectTxFormat
+	RejectTxBalance
+	RejectTxGas
+	RejectTxProgram
+)
+
+var rejectReasonNames = map[RejectReason]string{
+	RejectOther:       "other",
+	RejectVersion:     "version",
+	RejectHeight:      "height",
+	RejectParent:      "parent",
+	RejectTimestamp:   "timestamp",
+	RejectBits:        "bits",
+	RejectProofOfWork: "proof_of_work",
+	RejectBlockGas:    "block_gas",
+	RejectCoinbase:    "coinbase",
+	RejectMerkleRoot:  "merkle_root",
+	RejectTxStatus:    "tx_status",
+	RejectTxFormat:    "tx_format",
+	RejectTxBalance:   "tx_balance",
+	RejectTxGas:       "tx_gas",
+	RejectTxProgram:   "tx_program",
+}
+
+func (r RejectReason) String() string {
+	if name, ok := rejectReasonNames[r]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+var rejectReasons = map[error]RejectReason{
+	ErrVersionRegression:     RejectVersion,
+	ErrMisorderedBlockHeight: RejectHeight,
+	ErrMismatchedBlock:       RejectParent,
+	ErrBadTimestamp:          RejectTimestamp,
+	ErrBadBits:               RejectBits,
+	ErrWorkProof:             RejectProofOfWork,
+	ErrOverBlockLimit:        RejectBlockGas,
+	ErrMismatchedMerkleRoot:  RejectMerkleRoot,
+	ErrMismatchedTxStatus:    RejectTxStatus,
+
+	ErrWrongCoinbaseTransaction:  RejectCoinbase,
+	ErrWrongCoinbaseAsset:        RejectCoinbase,
+	ErrCoinbaseArbitraryOversize: RejectCoinbase,
+
+	ErrTxVersion:            RejectTxFormat,
+	ErrWrongTransactionSize: RejectTxFormat,
+	ErrBadTimeRange:         RejectTxFormat,
+	ErrNotStandardTx:        RejectTxFormat,
+	ErrEmptyResults:         RejectTxFormat,
+	ErrMismatchedPosition:   RejectTxFormat,
+	ErrMismatchedReference:  RejectTxFormat,
+	ErrMissingField:         RejectTxFormat,
+	ErrMissingEntry:         RejectTxFormat,
+	ErrEntryType:            RejectTxFormat,
+	ErrPosition:             RejectTxFormat,
+
+	ErrMismatchedAssetID: RejectTxBalance,
+	ErrMismatchedValue:   RejectTxBalance,
+	ErrNoSource:          RejectTxBalance,
+	ErrOverflow:          RejectTxBalance,
+	ErrUnbalanced:        RejectTxBalance,
+	checked.ErrOverflow:  RejectTxBalance,
+
+	ErrOverGasCredit: RejectTxGas,
+	ErrGasCalculate:  RejectTxGas,
+	ErrNoGasInput:    RejectTxGas,
+
+	ErrProgram: RejectTxProgram,
+}
+
+// Reason returns the reject reason of an error returned by ValidateBlock or
+// ValidateBlockHeader
+func Reason(err error) RejectReason {
+	// the root may be an unhashable error like vm.Error, so it can't be
+	// used to index the map
+	root := errors.Root(err)
+	for rejectErr, reason := range rejectReasons {
+		if root == rejectErr {
+			return reason
+		}
+	}
+	return RejectOther
+}
+
+// RejectCounter counts the rejected blocks by reason, it's safe for
+// concurrent use
+type RejectCounter struct {
+	mtx    sync.RWMutex
+	counts map[RejectReason]uint64
+}
+
+// NewRejectCounter creates an empty counter
+func NewRejectCounter() *RejectCounter {
+	return &RejectCounter{counts: make(map[RejectReason]uint64)}
+}
+
+// Add counts the rejection err and returns its reason
+func (c *RejectCounter) Add(err error) RejectReason {
+	reason := Reason(err)
+	c.mtx.Lock()
+	c.counts[reason]++
+	c.mtx.Unlock()
+	return reason
+}
+
+// Counts returns the number of rejections keyed by reason name
+func (c *RejectCounter) Counts() map[string]uint64 {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	counts := make(map[string]uint64, len(c.counts))
+	for reason, n := range c.counts {
+		counts[reason.String()] = n
+	}
+	return counts
+}
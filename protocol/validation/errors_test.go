@@ -0,0 +1,39 @@
+package validation
+
+import (
+	"testing"
+
+	"github.com/btm-stats/errors"
+	"github.com/btm-stats/protocol/vm"
+)
+
+func TestReason(t *testing.T) {
+	cases := []struct {
+		err  error
+		want RejectReason
+	}{
+		{err: ErrWorkProof, want: RejectProofOfWork},
+		{err: errors.Wrap(errors.WithDetail(ErrBadTimestamp, "block timestamp is too early"), "validate block"), want: RejectTimestamp},
+		{err: errors.Sub(ErrProgram, vm.Error{Err: vm.ErrFalseVMResult}), want: RejectTxProgram},
+		{err: vm.Error{Err: vm.ErrFalseVMResult}, want: RejectOther},
+		{err: errors.New("db closed"), want: RejectOther},
+	}
+
+	for i, c := range cases {
+		if got := Reason(c.err); got != c.want {
+			t.Errorf("case %d: got reason %s, want %s", i, got, c.want)
+		}
+	}
+}
+
+func TestRejectCounter(t *testing.T) {
+	counter := NewRejectCounter()
+	counter.Add(ErrMismatchedMerkleRoot)
+	counter.Add(errors.Wrap(ErrMismatchedMerkleRoot, "checking merkle root"))
+	counter.Add(errors.New("db closed"))
+
+	counts := counter.Counts()
+	if counts["merkle_root"] != 2 || counts["other"] != 1 || len(counts) != 2 {
+		t.Errorf("got counts %v", counts)
+	}
+}
@@ -0,0 +1,162 @@
+package validation
+
+import (
+	"math"
+	"testing"
+
+	"github.com/btm-stats/consensus"
+	"github.com/btm-stats/protocol/bc/types"
+)
+
+func TestValidateTx(t *testing.T) {
+	block := types.MapBlock(&types.Block{BlockHeader: types.BlockHeader{Version: 1, Height: 11}})
+	btm := *consensus.BTMAssetID
+
+	cases := []struct {
+		desc       string
+		tx         *types.Tx
+		wantReason RejectReason
+		wantErr    bool
+		wantGas    GasState
+	}{
+		{
+			desc: "transfer",
+			tx:   newTransferTx(1),
+			// TRUE costs 10 and the 100 bytes are charged as storage gas
+			wantGas: GasState{BTMValue: testSpendFee, GasLeft: 4890, GasUsed: 110, GasValid: true, StorageGas: 100},
+		},
+		{
+			desc: "failing asset program still pays the gas",
+			tx: types.NewTx(types.TxData{
+				Version:        1,
+				SerializedSize: 100,
+				Inputs: []*types.TxInput{
+					newSpendInput(1, btm, 100000000, trueProgram),
+					newSpendInput(2, testAsset, 5, failProgram),
+				},
+				Outputs: []*types.TxOutput{
+					types.NewTxOutput(btm, 100000000-testSpendFee, trueProgram),
+					types.NewTxOutput(testAsset, 5, trueProgram),
+				},
+			}),
+			wantReason: RejectTxProgram,
+			wantErr:    true,
+			wantGas:    GasState{BTMValue: testSpendFee, GasLeft: 4889, GasUsed: 111, GasValid: true, StorageGas: 100},
+		},
+		{
+			desc: "failing gas program",
+			tx: types.NewTx(types.TxData{
+				Version:        1,
+				SerializedSize: 100,
+				Inputs:         []*types.TxInput{newSpendInput(1, btm, 100000000, failProgram)},
+				Outputs:        []*types.TxOutput{types.NewTxOutput(btm, 100000000-testSpendFee, trueProgram)},
+			}),
+			wantReason: RejectTxProgram,
+			wantErr:    true,
+		},
+		{
+			desc: "fee under the storage gas",
+			tx: types.NewTx(types.TxData{
+				Version:        1,
+				SerializedSize: 100,
+				Inputs:         []*types.TxInput{newSpendInput(1, btm, 100000000, trueProgram)},
+				Outputs:        []*types.TxOutput{types.NewTxOutput(btm, 100000000-10000, trueProgram)},
+			}),
+			wantReason: RejectTxGas,
+			wantErr:    true,
+		},
+		{
+			desc: "unbalanced asset",
+			tx: types.NewTx(types.TxData{
+				Version:        1,
+				SerializedSize: 100,
+				Inputs: []*types.TxInput{
+					newSpendInput(1, btm, 100000000, trueProgram),
+					newSpendInput(2, testAsset, 5, trueProgram),
+				},
+				Outputs: []*types.TxOutput{
+					types.NewTxOutput(btm, 100000000-testSpendFee, trueProgram),
+					types.NewTxOutput(testAsset, 6, trueProgram),
+				},
+			}),
+			wantReason: RejectTxBalance,
+			wantErr:    true,
+		},
+		{
+			desc: "amount overflow",
+			tx: types.NewTx(types.TxData{
+				Version:        1,
+				SerializedSize: 100,
+				Inputs:         []*types.TxInput{newSpendInput(1, btm, math.MaxInt64+1, trueProgram)},
+				Outputs:        []*types.TxOutput{types.NewTxOutput(btm, 100000000, trueProgram)},
+			}),
+			wantReason: RejectTxBalance,
+			wantErr:    true,
+		},
+		{
+			desc:       "version 2 in a version 1 block",
+			tx:         types.NewTx(types.TxData{Version: 2, SerializedSize: 100}),
+			wantReason: RejectTxFormat,
+			wantErr:    true,
+		},
+		{
+			desc: "no serialized size",
+			tx: types.NewTx(types.TxData{
+				Version: 1,
+				Inputs:  []*types.TxInput{newSpendInput(1, btm, 100000000, trueProgram)},
+				Outputs: []*types.TxOutput{types.NewTxOutput(btm, 100000000-testSpendFee, trueProgram)},
+			}),
+			wantReason: RejectTxFormat,
+			wantErr:    true,
+		},
+		{
+			desc: "time range below the block",
+			tx: types.NewTx(types.TxData{
+				Version:        1,
+				SerializedSize: 100,
+				TimeRange:      5,
+				Inputs:         []*types.TxInput{newSpendInput(1, btm, 100000000, trueProgram)},
+				Outputs:        []*types.TxOutput{types.NewTxOutput(btm, 100000000-testSpendFee, trueProgram)},
+			}),
+			wantReason: RejectTxFormat,
+			wantErr:    true,
+		},
+		{
+			desc: "non-standard gas input",
+			tx: types.NewTx(types.TxData{
+				Version:        1,
+				SerializedSize: 100,
+				Inputs:         []*types.TxInput{newSpendInput(1, btm, 100000000, []byte{0x51, 0x51, 0x9c})},
+				Outputs:        []*types.TxOutput{types.NewTxOutput(btm, 100000000-testSpendFee, trueProgram)},
+			}),
+			wantReason: RejectTxFormat,
+			wantErr:    true,
+		},
+		{
+			desc:       "coinbase outside the block",
+			tx:         newCoinbaseTx(100, nil),
+			wantReason: RejectCoinbase,
+			wantErr:    true,
+		},
+	}
+
+	for _, c := range cases {
+		gasStatus, err := ValidateTx(c.tx.Tx, block)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: got err = %v, want err %v", c.desc, err, c.wantErr)
+			continue
+		}
+		if err != nil {
+			if got := Reason(err); got != c.wantReason {
+				t.Errorf("%s: got reason %s, want %s (%v)", c.desc, got, c.wantReason, err)
+			}
+		}
+		if gasStatus.GasValid != c.wantGas.GasValid {
+			t.Errorf("%s: got gas valid %v, want %v", c.desc, gasStatus.GasValid, c.wantGas.GasValid)
+			continue
+		}
+		if c.wantGas.GasValid && *gasStatus != c.wantGas {
+			t.Errorf("%s: got gas state %+v, want %+v", c.desc, *gasStatus, c.wantGas)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package vm
+
+import "bytes"
+
+func opInvert(vm *virtualMachine) error {
+	if err := vm.applyCost(1); err != nil {
+		return err
+	}
+
+	top, err := vm.top()
+	if err != nil {
+		return err
+	}
+	if err = vm.applyCost(int64(len(top))); err != nil {
+		return err
+	}
+
+	// the top item may be shared with the program or another stack item,
+	// don't rewrite it in place
+	newTop := make([]byte, 0, len(top))
+	for _, b := range top {
+		newTop = append(newTop, ^b)
+	}
+	vm.dataStack[len(vm.dataStack)-1] = newTop
+	return nil
+}
+
+func opAnd(vm *virtualMachine) error {
+	if err := vm.applyCost(1); err != nil {
+		return err
+	}
+
+	b, err := vm.pop(true)
+	if err != nil {
+		return err
+	}
+	a, err := vm.pop(true)
+	if err != nil {
+		return err
+	}
+
+	min := len(a)
+	if len(b) < min {
+		min = len(b)
+	}
+	if err = vm.applyCost(int64(min)); err != nil {
+		return err
+	}
+
+	res := make([]byte, 0, min)
+	for i := 0; i < min; i++ {
+		res = append(res, a[i]&b[i])
+	}
+	return vm.push(res, true)
+}
+
+func opOr(vm *virtualMachine) error {
+	return doOr(vm, false)
+}
+
+func opXor(vm *virtualMachine) error {
+	return doOr(vm, true)
+}
+
+// doOr zero pads the shorter operand to the length of the longer one
+func doOr(vm *virtualMachine, xor bool) error {
+	if err := vm.applyCost(1); err != nil {
+		return err
+	}
+
+	b, err := vm.pop(true)
+	if err != nil {
+		return err
+	}
+	a, err := vm.pop(true)
+	if err != nil {
+		return err
+	}
+
+	max := len(a)
+	if len(b) > max {
+		max = len(b)
+	}
+	if err = vm.applyCost(int64(max)); err != nil {
+		return err
+	}
+
+	res := make([]byte, 0, max)
+	for i := 0; i < max; i++ {
+		var aByte, bByte byte
+		if i < len(a) {
+			aByte = a[i]
+		}
+		if i < len(b) {
+			bByte = b[i]
+		}
+		if xor {
+			res = append(res, aByte^bByte)
+		} else {
+			res = append(res, aByte|bByte)
+		}
+	}
+	return vm.push(res, true)
+}
+
+func opEqual(vm *virtualMachine) error {
+	res, err := doEqual(vm)
+	if err != nil {
+		return err
+	}
+	return vm.pushBool(res, true)
+}
+
+func opEqualVerify(vm *virtualMachine) error {
+	res, err := doEqual(vm)
+	if err != nil {
+		return err
+	}
+	if res {
+		return nil
+	}
+	return ErrVerifyFailed
+}
+
+func doEqual(vm *virtualMachine) (bool, error) {
+	if err := vm.applyCost(1); err != nil {
+		return false, err
+	}
+
+	b, err := vm.pop(true)
+	if err != nil {
+		return false, err
+	}
+	a, err := vm.pop(true)
+	if err != nil {
+		return false, err
+	}
+
+	min := len(a)
+	if len(b) < min {
+		min = len(b)
+	}
+	if err = vm.applyCost(int64(min)); err != nil {
+		return false, err
+	}
+	return bytes.Equal(a, b), nil
+}
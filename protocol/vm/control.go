@@ -0,0 +1,105 @@
+package vm
+
+import "encoding/binary"
+
+func opVerify(vm *virtualMachine) error {
+	if err := vm.applyCost(1); err != nil {
+		return err
+	}
+
+	p, err := vm.pop(true)
+	if err != nil {
+		return err
+	}
+	if AsBool(p) {
+		return nil
+	}
+	return ErrVerifyFailed
+}
+
+func opFail(vm *virtualMachine) error {
+	if err := vm.applyCost(1); err != nil {
+		return err
+	}
+	return ErrReturn
+}
+
+// opCheckPredicate runs a predicate program in a child vm on the top n items
+// of the stack with the given gas limit, 0 means all the gas left
+func opCheckPredicate(vm *virtualMachine) error {
+	if err := vm.applyCost(256); err != nil {
+		return err
+	}
+	vm.deferCost(-256 + 64) // get most of that cost back at the end
+
+	limit, err := vm.popInt64(true)
+	if err != nil {
+		return err
+	}
+	predicate, err := vm.pop(true)
+	if err != nil {
+		return err
+	}
+	n, err := vm.popInt64(true)
+	if err != nil {
+		return err
+	}
+	if limit < 0 {
+		return ErrBadValue
+	}
+
+	l := int64(len(vm.dataStack))
+	if n < 0 {
+		n = l
+	}
+	if n > l {
+		return ErrDataStackUnderflow
+	}
+	if limit == 0 {
+		limit = vm.runLimit
+	}
+	if err = vm.applyCost(limit); err != nil {
+		return err
+	}
+
+	childVM := virtualMachine{
+		context:           vm.context,
+		program:           predicate,
+		runLimit:          limit,
+		depth:             vm.depth + 1,
+		dataStack:         append([][]byte{}, vm.dataStack[l-n:]...),
+		expansionReserved: vm.expansionReserved,
+	}
+	vm.dataStack = vm.dataStack[:l-n]
+
+	childErr := childVM.run()
+
+	vm.deferCost(-childVM.runLimit)
+	vm.deferCost(-stackCost(childVM.dataStack))
+	vm.deferCost(-stackCost(childVM.altStack))
+
+	return vm.pushBool(childErr == nil && !childVM.falseResult(), true)
+}
+
+func opJump(vm *virtualMachine) error {
+	if err := vm.applyCost(1); err != nil {
+		return err
+	}
+	vm.nextPC = binary.LittleEndian.Uint32(vm.data)
+	return nil
+}
+
+func opJumpIf(vm *virtualMachine) error {
+	if err := vm.applyCost(1); err != nil {
+		return err
+	}
+
+	p, err := vm.pop(true)
+	if err != nil {
+		return err
+	}
+	if AsBool(p) {
+		vm.nextPC = binary.LittleEndian.Uint32(vm.data)
+	}
+	return nil
+}
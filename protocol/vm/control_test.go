@@ -0,0 +1,96 @@
+package vm
+
+import "testing"
+
+func TestControlOps(t *testing.T) {
+	cases := []opTest{{
+		op:      OP_VERIFY,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{1}}},
+		wantVM:  &virtualMachine{runLimit: 49999, deferredCost: -9, dataStack: [][]byte{}},
+	}, {
+		op:      OP_VERIFY,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{0, 0}}},
+		wantErr: ErrVerifyFailed,
+	}, {
+		op:      OP_VERIFY,
+		startVM: &virtualMachine{runLimit: 50000},
+		wantErr: ErrDataStackUnderflow,
+	}, {
+		op:      OP_FAIL,
+		startVM: &virtualMachine{runLimit: 50000},
+		wantErr: ErrReturn,
+	}, {
+		op:      OP_JUMP,
+		startVM: &virtualMachine{runLimit: 50000, data: []byte{5, 0, 0, 0}},
+		wantVM:  &virtualMachine{runLimit: 49999, nextPC: 5},
+	}, {
+		op:      OP_JUMPIF,
+		startVM: &virtualMachine{runLimit: 50000, data: []byte{5, 0, 0, 0}, dataStack: [][]byte{{1}}},
+		wantVM:  &virtualMachine{runLimit: 49999, deferredCost: -9, nextPC: 5, dataStack: [][]byte{}},
+	}, {
+		op:      OP_JUMPIF,
+		startVM: &virtualMachine{runLimit: 50000, data: []byte{5, 0, 0, 0}, dataStack: [][]byte{{}}},
+		wantVM:  &virtualMachine{runLimit: 49999, deferredCost: -8, dataStack: [][]byte{}},
+	}}
+	testOps(t, cases)
+}
+
+func TestCheckPredicate(t *testing.T) {
+	cases := []opTest{{
+		// a zero limit hands all the gas left to the predicate
+		op:      OP_CHECKPREDICATE,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{}, {byte(OP_TRUE)}, {}}},
+		wantVM:  &virtualMachine{runLimit: 0, deferredCost: -49951, dataStack: [][]byte{{1}}},
+	}, {
+		op:      OP_CHECKPREDICATE,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{}, {byte(OP_FAIL)}, {}}},
+		wantVM:  &virtualMachine{runLimit: 0, deferredCost: -49952, dataStack: [][]byte{{}}},
+	}, {
+		// the predicate running out of its limit fails the check, not the program
+		op:      OP_CHECKPREDICATE,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{}, {byte(OP_TRUE)}, {1}}},
+		wantVM:  &virtualMachine{runLimit: 49743, deferredCost: -210, dataStack: [][]byte{{}}},
+	}, {
+		op:      OP_CHECKPREDICATE,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{5}, {1}, {byte(OP_5), byte(OP_NUMEQUAL)}, {}}},
+		wantVM:  &virtualMachine{runLimit: 0, deferredCost: -49960, dataStack: [][]byte{{1}}},
+	}, {
+		// a negative count moves the whole stack to the predicate
+		op:      OP_CHECKPREDICATE,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{5}, Int64Bytes(-1), {byte(OP_5), byte(OP_NUMEQUAL)}, {}}},
+		wantVM:  &virtualMachine{runLimit: 0, deferredCost: -49967, dataStack: [][]byte{{1}}},
+	}, {
+		op:      OP_CHECKPREDICATE,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{2}, {byte(OP_TRUE)}, {}}},
+		wantErr: ErrDataStackUnderflow,
+	}, {
+		op:      OP_CHECKPREDICATE,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{}, {byte(OP_TRUE)}, Int64Bytes(-1)}},
+		wantErr: ErrBadValue,
+	}, {
+		op:      OP_CHECKPREDICATE,
+		startVM: &virtualMachine{runLimit: 5000, dataStack: [][]byte{{}, {byte(OP_TRUE)}, {0x10, 0x27}}},
+		wantErr: ErrRunLimitExceeded,
+	}, {
+		op:      OP_CHECKPREDICATE,
+		startVM: &virtualMachine{runLimit: 255, dataStack: [][]byte{{}, {byte(OP_TRUE)}, {}}},
+		wantErr: ErrRunLimitExceeded,
+	}, {
+		op:      OP_CHECKPREDICATE,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{byte(OP_TRUE)}, {}}},
+		wantErr: ErrDataStackUnderflow,
+	}}
+	testOps(t, cases)
+}
+
+func TestCheckPredicateDepth(t *testing.T) {
+	// a predicate that calls itself recurses until the gas runs out
+	prog, err := Assemble("DUP 1 SWAP 0 CHECKPREDICATE VERIFY 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := &virtualMachine{runLimit: 50000, program: prog, dataStack: [][]byte{prog}}
+	if err := vm.run(); err != ErrVerifyFailed {
+		t.Errorf("got err = %v want %v", err, ErrVerifyFailed)
+	}
+}
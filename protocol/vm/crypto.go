@@ -0,0 +1,164 @@
+package vm
+
+import (
+	"crypto/sha256"
+
+	"github.com/tendermint/ed25519"
+	"golang.org/x/crypto/sha3"
+
+	"github.com/btm-stats/crypto"
+	"github.com/btm-stats/math/checked"
+)
+
+func opSha256(vm *virtualMachine) error {
+	return hashOp(vm, func(data []byte) []byte {
+		h := sha256.Sum256(data)
+		return h[:]
+	})
+}
+
+func opSha3(vm *virtualMachine) error {
+	return hashOp(vm, func(data []byte) []byte {
+		h := sha3.Sum256(data)
+		return h[:]
+	})
+}
+
+// hashOp replaces the top item with its hash, hashing costs at least 64
+func hashOp(vm *virtualMachine, hash func([]byte) []byte) error {
+	a, err := vm.pop(false)
+	if err != nil {
+		return err
+	}
+
+	cost := int64(len(a))
+	if cost < 64 {
+		cost = 64
+	}
+	if err = vm.applyCost(cost); err != nil {
+		return err
+	}
+	return vm.push(hash(a), false)
+}
+
+func opHash160(vm *virtualMachine) error {
+	data, err := vm.pop(false)
+	if err != nil {
+		return err
+	}
+	if err = vm.applyCost(int64(len(data) + 64)); err != nil {
+		return err
+	}
+	return vm.push(crypto.Ripemd160(data), false)
+}
+
+func opCheckSig(vm *virtualMachine) error {
+	if err := vm.applyCost(1024); err != nil {
+		return err
+	}
+
+	pubkey, err := vm.pop(true)
+	if err != nil {
+		return err
+	}
+	msg, err := vm.pop(true)
+	if err != nil {
+		return err
+	}
+	sig, err := vm.pop(true)
+	if err != nil {
+		return err
+	}
+	if len(msg) != 32 {
+		return ErrBadValue
+	}
+	return vm.pushBool(verifySig(pubkey, msg, sig), true)
+}
+
+// opCheckMultiSig expects the stack to hold the signatures, the message, the
+// public keys, the quorum and the number of public keys. The signatures must
+// be in the same order as the public keys they are made with.
+func opCheckMultiSig(vm *virtualMachine) error {
+	numPubkeys, err := vm.popInt64(true)
+	if err != nil {
+		return err
+	}
+	pubCost, ok := checked.MulInt64(numPubkeys, 1024)
+	if numPubkeys < 0 || !ok {
+		return ErrBadValue
+	}
+	if err = vm.applyCost(pubCost); err != nil {
+		return err
+	}
+
+	numSigs, err := vm.popInt64(true)
+	if err != nil {
+		return err
+	}
+	if numSigs < 0 || numSigs > numPubkeys || (numPubkeys > 0 && numSigs == 0) {
+		return ErrBadValue
+	}
+
+	pubkeys := make([][]byte, 0, numPubkeys)
+	for i := int64(0); i < numPubkeys; i++ {
+		pubkey, err := vm.pop(true)
+		if err != nil {
+			return err
+		}
+		pubkeys = append(pubkeys, pubkey)
+	}
+
+	msg, err := vm.pop(true)
+	if err != nil {
+		return err
+	}
+	if len(msg) != 32 {
+		return ErrBadValue
+	}
+
+	sigs := make([][]byte, 0, numSigs)
+	for i := int64(0); i < numSigs; i++ {
+		sig, err := vm.pop(true)
+		if err != nil {
+			return err
+		}
+		sigs = append(sigs, sig)
+	}
+
+	for _, pubkey := range pubkeys {
+		if len(pubkey) != ed25519.PublicKeySize {
+			return vm.pushBool(false, true)
+		}
+	}
+
+	// both lists are popped in reverse order, so they still line up
+	for len(sigs) > 0 && len(pubkeys) > 0 {
+		if verifySig(pubkeys[0], msg, sigs[0]) {
+			sigs = sigs[1:]
+		}
+		pubkeys = pubkeys[1:]
+	}
+	return vm.pushBool(len(sigs) == 0, true)
+}
+
+func opTxSigHash(vm *virtualMachine) error {
+	if err := vm.applyCost(256); err != nil {
+		return err
+	}
+	if vm.context.TxSigHash == nil {
+		return ErrContext
+	}
+	return vm.push(vm.context.TxSigHash(), false)
+}
+
+func verifySig(pubkey, msg, sig []byte) bool {
+	if len(pubkey) != ed25519.PublicKeySize || len(sig) != ed25519.SignatureSize {
+		return false
+	}
+
+	var pub [ed25519.PublicKeySize]byte
+	var signature [ed25519.SignatureSize]byte
+	copy(pub[:], pubkey)
+	copy(signature[:], sig)
+	return ed25519.Verify(&pub, msg, &signature)
+}
@@ -0,0 +1,167 @@
+package vm
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// the keys are the RFC 8032 test keys 1 to 3, the signatures are made by an
+// independent ed25519 implementation over testMsg
+var (
+	testMsg = mustDecodeHex("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+
+	testPub1 = mustDecodeHex("d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a")
+	testPub2 = mustDecodeHex("3d4017c3e843895a92b70aa74d1b7ebc9c982ccf2ec4968cc0cd55f12af4660c")
+	testPub3 = mustDecodeHex("fc51cd8e6218a1a38da47ed00230f0580816ed13ba3303ac5deb911548908025")
+
+	testSig1 = mustDecodeHex("00c1db988bb12fd7351a6054ae3fac90fab7e4fc56b1651c7181f5f55f896f663933d3a90605d9058e9d0ac45950ee2d3c9c9b14857415587179fe0ccac35f09")
+	testSig2 = mustDecodeHex("ed19931f49cf7559f1474199dfbcce36cef99ed8c2faf414550fa01c8699bc99ca097b6e4764712829214b328f593b8f1db93ef2965b838d9770663b36882105")
+	testSig3 = mustDecodeHex("ba77465c0cc0b86eea1ace49d96f882e22259bb3990590901d7ac4de6367053303a42deab32cb19d262ccc8fe4e58d1974302f42d53eabd3130b95b3f6105202")
+)
+
+func mustDecodeHex(h string) []byte {
+	bits, err := hex.DecodeString(h)
+	if err != nil {
+		panic(err)
+	}
+	return bits
+}
+
+func TestHashOps(t *testing.T) {
+	cases := []opTest{{
+		op:      OP_SHA256,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{1}}},
+		wantVM: &virtualMachine{runLimit: 49905, dataStack: [][]byte{
+			mustDecodeHex("4bf5122f344554c53bde2ebb8cd2b7e3d1600ad631c385a5d7cce23c7785459a"),
+		}},
+	}, {
+		// hashing costs the item length past 64 bytes
+		op:      OP_SHA256,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{make([]byte, 65)}},
+		wantVM: &virtualMachine{runLimit: 49968, dataStack: [][]byte{
+			mustDecodeHex("98ce42deef51d40269d542f5314bef2c7468d401ad5d85168bfab4c0108f75f7"),
+		}},
+	}, {
+		op:      OP_SHA256,
+		startVM: &virtualMachine{runLimit: 0, dataStack: [][]byte{{1}}},
+		wantErr: ErrRunLimitExceeded,
+	}, {
+		op:      OP_SHA3,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{1}}},
+		wantVM: &virtualMachine{runLimit: 49905, dataStack: [][]byte{
+			mustDecodeHex("2767f15c8af2f2c7225d5273fdd683edc714110a987d1054697c348aed4e6cc7"),
+		}},
+	}, {
+		op:      OP_HASH160,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{1}}},
+		wantVM: &virtualMachine{runLimit: 49916, dataStack: [][]byte{
+			mustDecodeHex("f291ba5015df348c80853fa5bb0f7946f5c9e1b3"),
+		}},
+	}, {
+		op:      OP_SHA3,
+		startVM: &virtualMachine{runLimit: 50000},
+		wantErr: ErrDataStackUnderflow,
+	}, {
+		op:      OP_TXSIGHASH,
+		startVM: &virtualMachine{runLimit: 50000, context: &Context{TxSigHash: func() []byte { return testMsg }}},
+		wantVM:  &virtualMachine{runLimit: 49704, dataStack: [][]byte{testMsg}},
+	}, {
+		op:      OP_TXSIGHASH,
+		startVM: &virtualMachine{runLimit: 50000, context: &Context{}},
+		wantErr: ErrContext,
+	}}
+	testOps(t, cases)
+}
+
+func TestCheckSig(t *testing.T) {
+	cases := []opTest{{
+		op:      OP_CHECKSIG,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{testSig1, testMsg, testPub1}},
+		wantVM:  &virtualMachine{runLimit: 48976, deferredCost: -143, dataStack: [][]byte{{1}}},
+	}, {
+		op:      OP_CHECKSIG,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{testSig2, testMsg, testPub1}},
+		wantVM:  &virtualMachine{runLimit: 48976, deferredCost: -144, dataStack: [][]byte{{}}},
+	}, {
+		// a bad key or signature fails the check, not the program
+		op:      OP_CHECKSIG,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{testSig1[:63], testMsg, testPub1}},
+		wantVM:  &virtualMachine{runLimit: 48976, deferredCost: -143, dataStack: [][]byte{{}}},
+	}, {
+		op:      OP_CHECKSIG,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{testSig1, testMsg[:31], testPub1}},
+		wantErr: ErrBadValue,
+	}, {
+		op:      OP_CHECKSIG,
+		startVM: &virtualMachine{runLimit: 1023, dataStack: [][]byte{testSig1, testMsg, testPub1}},
+		wantErr: ErrRunLimitExceeded,
+	}, {
+		op:      OP_CHECKSIG,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{testMsg, testPub1}},
+		wantErr: ErrDataStackUnderflow,
+	}}
+	testOps(t, cases)
+}
+
+func TestCheckMultiSig(t *testing.T) {
+	cases := []opTest{{
+		op:      OP_CHECKMULTISIG,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{testSig1, testMsg, testPub1, {1}, {1}}},
+		wantVM:  &virtualMachine{runLimit: 48976, deferredCost: -161, dataStack: [][]byte{{1}}},
+	}, {
+		op:      OP_CHECKMULTISIG,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{testSig1, testSig3, testMsg, testPub1, testPub2, testPub3, {2}, {3}}},
+		wantVM:  &virtualMachine{runLimit: 46928, deferredCost: -313, dataStack: [][]byte{{1}}},
+	}, {
+		op:      OP_CHECKMULTISIG,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{testSig2, testSig3, testMsg, testPub1, testPub2, testPub3, {2}, {3}}},
+		wantVM:  &virtualMachine{runLimit: 46928, deferredCost: -313, dataStack: [][]byte{{1}}},
+	}, {
+		// the signatures have to be in the order of their keys
+		op:      OP_CHECKMULTISIG,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{testSig3, testSig1, testMsg, testPub1, testPub2, testPub3, {2}, {3}}},
+		wantVM:  &virtualMachine{runLimit: 46928, deferredCost: -314, dataStack: [][]byte{{}}},
+	}, {
+		// a key can't count twice
+		op:      OP_CHECKMULTISIG,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{testSig1, testSig1, testMsg, testPub1, testPub2, testPub3, {2}, {3}}},
+		wantVM:  &virtualMachine{runLimit: 46928, deferredCost: -314, dataStack: [][]byte{{}}},
+	}, {
+		op:      OP_CHECKMULTISIG,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{testSig1, testSig3, testMsg, testPub1[:31], testPub2, testPub3, {2}, {3}}},
+		wantVM:  &virtualMachine{runLimit: 46928, deferredCost: -313, dataStack: [][]byte{{}}},
+	}, {
+		op:      OP_CHECKMULTISIG,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{testMsg, {}, {}}},
+		wantVM:  &virtualMachine{runLimit: 50000, deferredCost: -47, dataStack: [][]byte{{1}}},
+	}, {
+		op:      OP_CHECKMULTISIG,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{testSig1, testMsg, testPub1, {2}, {1}}},
+		wantErr: ErrBadValue,
+	}, {
+		op:      OP_CHECKMULTISIG,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{testSig1, testMsg, testPub1, {}, {1}}},
+		wantErr: ErrBadValue,
+	}, {
+		op:      OP_CHECKMULTISIG,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{testSig1, testMsg, testPub1, {1}, Int64Bytes(-1)}},
+		wantErr: ErrBadValue,
+	}, {
+		op:      OP_CHECKMULTISIG,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{testSig1, testMsg, testPub1, {1}, {0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}}},
+		wantErr: ErrBadValue,
+	}, {
+		op:      OP_CHECKMULTISIG,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{testSig1, testMsg[:31], testPub1, {1}, {1}}},
+		wantErr: ErrBadValue,
+	}, {
+		op:      OP_CHECKMULTISIG,
+		startVM: &virtualMachine{runLimit: 2047, dataStack: [][]byte{testSig1, testMsg, testPub1, testPub2, {1}, {2}}},
+		wantErr: ErrRunLimitExceeded,
+	}, {
+		op:      OP_CHECKMULTISIG,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{testMsg, testPub1, {1}, {1}}},
+		wantErr: ErrDataStackUnderflow,
+	}}
+	testOps(t, cases)
+}
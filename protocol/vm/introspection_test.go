@@ -0,0 +1,84 @@
+package vm
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestIntrospectionOps(t *testing.T) {
+	assetID := bytes.Repeat([]byte{0xff}, 32)
+	entryID := bytes.Repeat([]byte{0x01}, 32)
+	amount, destPos, height := uint64(5), uint64(0), uint64(7)
+	context := &Context{
+		Code:          []byte{byte(OP_TRUE)},
+		EntryID:       entryID,
+		AssetID:       &assetID,
+		Amount:        &amount,
+		DestPos:       &destPos,
+		SpentOutputID: &entryID,
+		BlockHeight:   &height,
+		CheckOutput: func(index uint64, amount uint64, assetID []byte, vmVersion uint64, code []byte, expansion bool) (bool, error) {
+			return index == 0 && amount == 5 && bytes.Equal(assetID, bytes.Repeat([]byte{0xff}, 32)) && vmVersion == 1 && bytes.Equal(code, []byte{byte(OP_TRUE)}) && !expansion, nil
+		},
+	}
+
+	cases := []opTest{{
+		op:      OP_CHECKOUTPUT,
+		startVM: &virtualMachine{runLimit: 50000, context: context, dataStack: [][]byte{{}, {5}, assetID, {1}, {byte(OP_TRUE)}}},
+		wantVM:  &virtualMachine{runLimit: 49984, deferredCost: -66, dataStack: [][]byte{{1}}},
+	}, {
+		op:      OP_CHECKOUTPUT,
+		startVM: &virtualMachine{runLimit: 50000, context: context, dataStack: [][]byte{{}, {4}, assetID, {1}, {byte(OP_TRUE)}}},
+		wantVM:  &virtualMachine{runLimit: 49984, deferredCost: -67, dataStack: [][]byte{{}}},
+	}, {
+		op:      OP_CHECKOUTPUT,
+		startVM: &virtualMachine{runLimit: 50000, context: context, dataStack: [][]byte{{}, Int64Bytes(-1), assetID, {1}, {byte(OP_TRUE)}}},
+		wantErr: ErrBadValue,
+	}, {
+		op:      OP_CHECKOUTPUT,
+		startVM: &virtualMachine{runLimit: 50000, context: context, dataStack: [][]byte{Int64Bytes(-1), {5}, assetID, {1}, {byte(OP_TRUE)}}},
+		wantErr: ErrBadValue,
+	}, {
+		op:      OP_CHECKOUTPUT,
+		startVM: &virtualMachine{runLimit: 50000, context: context, dataStack: [][]byte{{}, {5}, assetID, Int64Bytes(-1), {byte(OP_TRUE)}}},
+		wantErr: ErrBadValue,
+	}, {
+		op:      OP_CHECKOUTPUT,
+		startVM: &virtualMachine{runLimit: 50000, context: &Context{}, dataStack: [][]byte{{}, {5}, assetID, {1}, {byte(OP_TRUE)}}},
+		wantErr: ErrContext,
+	}, {
+		op:      OP_ASSET,
+		startVM: &virtualMachine{runLimit: 50000, context: context},
+		wantVM:  &virtualMachine{runLimit: 49999, deferredCost: 40, dataStack: [][]byte{assetID}},
+	}, {
+		op:      OP_AMOUNT,
+		startVM: &virtualMachine{runLimit: 50000, context: context},
+		wantVM:  &virtualMachine{runLimit: 49999, deferredCost: 9, dataStack: [][]byte{{5}}},
+	}, {
+		op:      OP_PROGRAM,
+		startVM: &virtualMachine{runLimit: 50000, context: context},
+		wantVM:  &virtualMachine{runLimit: 49999, deferredCost: 9, dataStack: [][]byte{{byte(OP_TRUE)}}},
+	}, {
+		op:      OP_INDEX,
+		startVM: &virtualMachine{runLimit: 50000, context: context},
+		wantVM:  &virtualMachine{runLimit: 49999, deferredCost: 8, dataStack: [][]byte{{}}},
+	}, {
+		op:      OP_ENTRYID,
+		startVM: &virtualMachine{runLimit: 50000, context: context},
+		wantVM:  &virtualMachine{runLimit: 49999, deferredCost: 40, dataStack: [][]byte{entryID}},
+	}, {
+		op:      OP_OUTPUTID,
+		startVM: &virtualMachine{runLimit: 50000, context: context},
+		wantVM:  &virtualMachine{runLimit: 49999, deferredCost: 40, dataStack: [][]byte{entryID}},
+	}, {
+		op:      OP_BLOCKHEIGHT,
+		startVM: &virtualMachine{runLimit: 50000, context: context},
+		wantVM:  &virtualMachine{runLimit: 49999, deferredCost: 9, dataStack: [][]byte{{7}}},
+	}}
+
+	// the ops need their field of the context
+	for _, op := range []Op{OP_ASSET, OP_AMOUNT, OP_INDEX, OP_OUTPUTID, OP_BLOCKHEIGHT} {
+		cases = append(cases, opTest{op: op, startVM: &virtualMachine{runLimit: 50000, context: &Context{}}, wantErr: ErrContext})
+	}
+	testOps(t, cases)
+}
@@ -0,0 +1,291 @@
+package vm
+
+import (
+	"math"
+
+	"github.com/btm-stats/math/checked"
+)
+
+// unaryOp pops one number and pushes the result of fn
+func unaryOp(vm *virtualMachine, cost int64, fn func(n int64) (int64, error)) error {
+	if err := vm.applyCost(cost); err != nil {
+		return err
+	}
+
+	n, err := vm.popInt64(true)
+	if err != nil {
+		return err
+	}
+	res, err := fn(n)
+	if err != nil {
+		return err
+	}
+	return vm.pushInt64(res, true)
+}
+
+// binaryOp pops y then x and pushes the result of fn(x, y)
+func binaryOp(vm *virtualMachine, cost int64, fn func(x, y int64) (int64, error)) error {
+	if err := vm.applyCost(cost); err != nil {
+		return err
+	}
+
+	y, err := vm.popInt64(true)
+	if err != nil {
+		return err
+	}
+	x, err := vm.popInt64(true)
+	if err != nil {
+		return err
+	}
+	res, err := fn(x, y)
+	if err != nil {
+		return err
+	}
+	return vm.pushInt64(res, true)
+}
+
+// compareOp pops y then x and pushes the bool result of fn(x, y)
+func compareOp(vm *virtualMachine, fn func(x, y int64) bool) error {
+	if err := vm.applyCost(2); err != nil {
+		return err
+	}
+
+	y, err := vm.popInt64(true)
+	if err != nil {
+		return err
+	}
+	x, err := vm.popInt64(true)
+	if err != nil {
+		return err
+	}
+	return vm.pushBool(fn(x, y), true)
+}
+
+func rangeCheck(res int64, ok bool) (int64, error) {
+	if !ok {
+		return 0, ErrRange
+	}
+	return res, nil
+}
+
+func op1Add(vm *virtualMachine) error {
+	return unaryOp(vm, 2, func(n int64) (int64, error) { return rangeCheck(checked.AddInt64(n, 1)) })
+}
+
+func op1Sub(vm *virtualMachine) error {
+	return unaryOp(vm, 2, func(n int64) (int64, error) { return rangeCheck(checked.SubInt64(n, 1)) })
+}
+
+func op2Mul(vm *virtualMachine) error {
+	return unaryOp(vm, 2, func(n int64) (int64, error) { return rangeCheck(checked.MulInt64(n, 2)) })
+}
+
+func op2Div(vm *virtualMachine) error {
+	return unaryOp(vm, 2, func(n int64) (int64, error) { return n >> 1, nil })
+}
+
+func opNegate(vm *virtualMachine) error {
+	return unaryOp(vm, 2, func(n int64) (int64, error) { return rangeCheck(checked.NegateInt64(n)) })
+}
+
+func opAbs(vm *virtualMachine) error {
+	return unaryOp(vm, 2, func(n int64) (int64, error) {
+		if n == math.MinInt64 {
+			return 0, ErrRange
+		}
+		if n < 0 {
+			n = -n
+		}
+		return n, nil
+	})
+}
+
+func opNot(vm *virtualMachine) error {
+	if err := vm.applyCost(2); err != nil {
+		return err
+	}
+
+	n, err := vm.popInt64(true)
+	if err != nil {
+		return err
+	}
+	return vm.pushBool(n == 0, true)
+}
+
+func op0NotEqual(vm *virtualMachine) error {
+	if err := vm.applyCost(2); err != nil {
+		return err
+	}
+
+	n, err := vm.popInt64(true)
+	if err != nil {
+		return err
+	}
+	return vm.pushBool(n != 0, true)
+}
+
+func opAdd(vm *virtualMachine) error {
+	return binaryOp(vm, 2, func(x, y int64) (int64, error) { return rangeCheck(checked.AddInt64(x, y)) })
+}
+
+func opSub(vm *virtualMachine) error {
+	return binaryOp(vm, 2, func(x, y int64) (int64, error) { return rangeCheck(checked.SubInt64(x, y)) })
+}
+
+func opMul(vm *virtualMachine) error {
+	return binaryOp(vm, 8, func(x, y int64) (int64, error) { return rangeCheck(checked.MulInt64(x, y)) })
+}
+
+func opDiv(vm *virtualMachine) error {
+	return binaryOp(vm, 8, func(x, y int64) (int64, error) {
+		if y == 0 {
+			return 0, ErrDivZero
+		}
+		return rangeCheck(checked.DivInt64(x, y))
+	})
+}
+
+func opMod(vm *virtualMachine) error {
+	return binaryOp(vm, 8, func(x, y int64) (int64, error) {
+		if y == 0 {
+			return 0, ErrDivZero
+		}
+		res, err := rangeCheck(checked.ModInt64(x, y))
+		if err != nil {
+			return 0, err
+		}
+
+		// Go's modulus operator produces the wrong result for mixed-sign
+		// operands
+		if res != 0 && (x >= 0) != (y >= 0) {
+			res += y
+		}
+		return res, nil
+	})
+}
+
+func opLshift(vm *virtualMachine) error {
+	return binaryOp(vm, 8, func(x, y int64) (int64, error) {
+		if y < 0 {
+			return 0, ErrBadValue
+		}
+		if x == 0 || y == 0 {
+			return x, nil
+		}
+		return rangeCheck(checked.LshiftInt64(x, y))
+	})
+}
+
+func opRshift(vm *virtualMachine) error {
+	return binaryOp(vm, 8, func(x, y int64) (int64, error) {
+		if y < 0 {
+			return 0, ErrBadValue
+		}
+		return x >> uint64(y), nil
+	})
+}
+
+func opBoolAnd(vm *virtualMachine) error {
+	return boolOp(vm, func(a, b bool) bool { return a && b })
+}
+
+func opBoolOr(vm *virtualMachine) error {
+	return boolOp(vm, func(a, b bool) bool { return a || b })
+}
+
+func boolOp(vm *virtualMachine, fn func(a, b bool) bool) error {
+	if err := vm.applyCost(2); err != nil {
+		return err
+	}
+
+	b, err := vm.pop(true)
+	if err != nil {
+		return err
+	}
+	a, err := vm.pop(true)
+	if err != nil {
+		return err
+	}
+	return vm.pushBool(fn(AsBool(a), AsBool(b)), true)
+}
+
+func opNumEqual(vm *virtualMachine) error {
+	return compareOp(vm, func(x, y int64) bool { return x == y })
+}
+
+func opNumEqualVerify(vm *virtualMachine) error {
+	if err := vm.applyCost(2); err != nil {
+		return err
+	}
+
+	y, err := vm.popInt64(true)
+	if err != nil {
+		return err
+	}
+	x, err := vm.popInt64(true)
+	if err != nil {
+		return err
+	}
+	if x == y {
+		return nil
+	}
+	return ErrVerifyFailed
+}
+
+func opNumNotEqual(vm *virtualMachine) error {
+	return compareOp(vm, func(x, y int64) bool { return x != y })
+}
+
+func opLessThan(vm *virtualMachine) error {
+	return compareOp(vm, func(x, y int64) bool { return x < y })
+}
+
+func opGreaterThan(vm *virtualMachine) error {
+	return compareOp(vm, func(x, y int64) bool { return x > y })
+}
+
+func opLessThanOrEqual(vm *virtualMachine) error {
+	return compareOp(vm, func(x, y int64) bool { return x <= y })
+}
+
+func opGreaterThanOrEqual(vm *virtualMachine) error {
+	return compareOp(vm, func(x, y int64) bool { return x >= y })
+}
+
+func opMin(vm *virtualMachine) error {
+	return binaryOp(vm, 2, func(x, y int64) (int64, error) {
+		if x > y {
+			return y, nil
+		}
+		return x, nil
+	})
+}
+
+func opMax(vm *virtualMachine) error {
+	return binaryOp(vm, 2, func(x, y int64) (int64, error) {
+		if x < y {
+			return y, nil
+		}
+		return x, nil
+	})
+}
+
+func opWithin(vm *virtualMachine) error {
+	if err := vm.applyCost(4); err != nil {
+		return err
+	}
+
+	max, err := vm.popInt64(true)
+	if err != nil {
+		return err
+	}
+	min, err := vm.popInt64(true)
+	if err != nil {
+		return err
+	}
+	x, err := vm.popInt64(true)
+	if err != nil {
+		return err
+	}
+	return vm.pushBool(x >= min && x < max, true)
+}
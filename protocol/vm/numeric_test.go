@@ -0,0 +1,270 @@
+package vm
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestNumericOps(t *testing.T) {
+	cases := []opTest{{
+		op:      OP_1ADD,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{2}}},
+		wantVM:  &virtualMachine{runLimit: 49998, dataStack: [][]byte{{3}}},
+	}, {
+		op:      OP_1SUB,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{2}}},
+		wantVM:  &virtualMachine{runLimit: 49998, dataStack: [][]byte{{1}}},
+	}, {
+		op:      OP_2MUL,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{2}}},
+		wantVM:  &virtualMachine{runLimit: 49998, dataStack: [][]byte{{4}}},
+	}, {
+		op:      OP_2DIV,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{2}}},
+		wantVM:  &virtualMachine{runLimit: 49998, dataStack: [][]byte{{1}}},
+	}, {
+		op:      OP_2DIV,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{Int64Bytes(-1)}},
+		wantVM:  &virtualMachine{runLimit: 49998, dataStack: [][]byte{Int64Bytes(-1)}},
+	}, {
+		op:      OP_2DIV,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{Int64Bytes(-3)}},
+		wantVM:  &virtualMachine{runLimit: 49998, dataStack: [][]byte{Int64Bytes(-2)}},
+	}, {
+		op:      OP_NEGATE,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{2}}},
+		wantVM:  &virtualMachine{runLimit: 49998, deferredCost: 7, dataStack: [][]byte{Int64Bytes(-2)}},
+	}, {
+		op:      OP_ABS,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{2}}},
+		wantVM:  &virtualMachine{runLimit: 49998, dataStack: [][]byte{{2}}},
+	}, {
+		op:      OP_ABS,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{Int64Bytes(-2)}},
+		wantVM:  &virtualMachine{runLimit: 49998, deferredCost: -7, dataStack: [][]byte{{2}}},
+	}, {
+		op:      OP_NOT,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{2}}},
+		wantVM:  &virtualMachine{runLimit: 49998, deferredCost: -1, dataStack: [][]byte{{}}},
+	}, {
+		op:      OP_NOT,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{}}},
+		wantVM:  &virtualMachine{runLimit: 49998, deferredCost: 1, dataStack: [][]byte{{1}}},
+	}, {
+		op:      OP_0NOTEQUAL,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{2}}},
+		wantVM:  &virtualMachine{runLimit: 49998, dataStack: [][]byte{{1}}},
+	}, {
+		op:      OP_ADD,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{2}, {1}}},
+		wantVM:  &virtualMachine{runLimit: 49998, deferredCost: -9, dataStack: [][]byte{{3}}},
+	}, {
+		op:      OP_SUB,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{2}, {1}}},
+		wantVM:  &virtualMachine{runLimit: 49998, deferredCost: -9, dataStack: [][]byte{{1}}},
+	}, {
+		op:      OP_MUL,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{2}, {3}}},
+		wantVM:  &virtualMachine{runLimit: 49992, deferredCost: -9, dataStack: [][]byte{{6}}},
+	}, {
+		op:      OP_DIV,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{7}, {2}}},
+		wantVM:  &virtualMachine{runLimit: 49992, deferredCost: -9, dataStack: [][]byte{{3}}},
+	}, {
+		op:      OP_DIV,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{Int64Bytes(-7), {2}}},
+		wantVM:  &virtualMachine{runLimit: 49992, deferredCost: -9, dataStack: [][]byte{Int64Bytes(-3)}},
+	}, {
+		op:      OP_MOD,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{2}, {1}}},
+		wantVM:  &virtualMachine{runLimit: 49992, deferredCost: -10, dataStack: [][]byte{{}}},
+	}, {
+		op:      OP_MOD,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{Int64Bytes(-12), {10}}},
+		wantVM:  &virtualMachine{runLimit: 49992, deferredCost: -16, dataStack: [][]byte{{8}}},
+	}, {
+		op:      OP_MOD,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{12}, Int64Bytes(-10)}},
+		wantVM:  &virtualMachine{runLimit: 49992, deferredCost: -9, dataStack: [][]byte{Int64Bytes(-8)}},
+	}, {
+		op:      OP_MOD,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{Int64Bytes(-12), Int64Bytes(-10)}},
+		wantVM:  &virtualMachine{runLimit: 49992, deferredCost: -16, dataStack: [][]byte{Int64Bytes(-2)}},
+	}, {
+		op:      OP_LSHIFT,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{2}, {1}}},
+		wantVM:  &virtualMachine{runLimit: 49992, deferredCost: -9, dataStack: [][]byte{{4}}},
+	}, {
+		op:      OP_LSHIFT,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{}, {64}}},
+		wantVM:  &virtualMachine{runLimit: 49992, deferredCost: -9, dataStack: [][]byte{{}}},
+	}, {
+		op:      OP_LSHIFT,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{Int64Bytes(-1), {63}}},
+		wantVM:  &virtualMachine{runLimit: 49992, deferredCost: -9, dataStack: [][]byte{Int64Bytes(math.MinInt64)}},
+	}, {
+		op:      OP_RSHIFT,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{2}, {1}}},
+		wantVM:  &virtualMachine{runLimit: 49992, deferredCost: -9, dataStack: [][]byte{{1}}},
+	}, {
+		op:      OP_RSHIFT,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{Int64Bytes(-2), {1}}},
+		wantVM:  &virtualMachine{runLimit: 49992, deferredCost: -9, dataStack: [][]byte{Int64Bytes(-1)}},
+	}, {
+		op:      OP_RSHIFT,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{2}, {64}}},
+		wantVM:  &virtualMachine{runLimit: 49992, deferredCost: -10, dataStack: [][]byte{{}}},
+	}, {
+		op:      OP_BOOLAND,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{2}, {1}}},
+		wantVM:  &virtualMachine{runLimit: 49998, deferredCost: -9, dataStack: [][]byte{{1}}},
+	}, {
+		op:      OP_BOOLAND,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{2}, {0}}},
+		wantVM:  &virtualMachine{runLimit: 49998, deferredCost: -10, dataStack: [][]byte{{}}},
+	}, {
+		op:      OP_BOOLOR,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{}, {1}}},
+		wantVM:  &virtualMachine{runLimit: 49998, deferredCost: -8, dataStack: [][]byte{{1}}},
+	}, {
+		op:      OP_NUMEQUAL,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{2}, {2, 0}}},
+		wantVM:  &virtualMachine{runLimit: 49998, deferredCost: -10, dataStack: [][]byte{{1}}},
+	}, {
+		op:      OP_NUMEQUALVERIFY,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{2}, {2}}},
+		wantVM:  &virtualMachine{runLimit: 49998, deferredCost: -18, dataStack: [][]byte{}},
+	}, {
+		op:      OP_NUMEQUALVERIFY,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{2}, {1}}},
+		wantErr: ErrVerifyFailed,
+	}, {
+		op:      OP_NUMNOTEQUAL,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{2}, {1}}},
+		wantVM:  &virtualMachine{runLimit: 49998, deferredCost: -9, dataStack: [][]byte{{1}}},
+	}, {
+		op:      OP_LESSTHAN,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{2}, {1}}},
+		wantVM:  &virtualMachine{runLimit: 49998, deferredCost: -10, dataStack: [][]byte{{}}},
+	}, {
+		op:      OP_LESSTHAN,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{Int64Bytes(-1), {1}}},
+		wantVM:  &virtualMachine{runLimit: 49998, deferredCost: -16, dataStack: [][]byte{{1}}},
+	}, {
+		op:      OP_LESSTHANOREQUAL,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{2}, {2}}},
+		wantVM:  &virtualMachine{runLimit: 49998, deferredCost: -9, dataStack: [][]byte{{1}}},
+	}, {
+		op:      OP_GREATERTHAN,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{2}, {1}}},
+		wantVM:  &virtualMachine{runLimit: 49998, deferredCost: -9, dataStack: [][]byte{{1}}},
+	}, {
+		op:      OP_GREATERTHANOREQUAL,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{1}, {2}}},
+		wantVM:  &virtualMachine{runLimit: 49998, deferredCost: -10, dataStack: [][]byte{{}}},
+	}, {
+		op:      OP_MIN,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{2}, Int64Bytes(-1)}},
+		wantVM:  &virtualMachine{runLimit: 49998, deferredCost: -9, dataStack: [][]byte{Int64Bytes(-1)}},
+	}, {
+		op:      OP_MAX,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{2}, Int64Bytes(-1)}},
+		wantVM:  &virtualMachine{runLimit: 49998, deferredCost: -16, dataStack: [][]byte{{2}}},
+	}, {
+		op:      OP_WITHIN,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{1}, {1}, {2}}},
+		wantVM:  &virtualMachine{runLimit: 49996, deferredCost: -18, dataStack: [][]byte{{1}}},
+	}, {
+		op:      OP_WITHIN,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{2}, {1}, {2}}},
+		wantVM:  &virtualMachine{runLimit: 49996, deferredCost: -19, dataStack: [][]byte{{}}},
+	}}
+
+	// the checked arithmetic fails instead of wrapping around
+	rangeErrs := []struct {
+		op    Op
+		stack [][]byte
+	}{
+		{OP_1ADD, [][]byte{Int64Bytes(math.MaxInt64)}},
+		{OP_1SUB, [][]byte{Int64Bytes(math.MinInt64)}},
+		{OP_2MUL, [][]byte{Int64Bytes(math.MaxInt64)}},
+		{OP_2MUL, [][]byte{Int64Bytes(math.MinInt64)}},
+		{OP_NEGATE, [][]byte{Int64Bytes(math.MinInt64)}},
+		{OP_ABS, [][]byte{Int64Bytes(math.MinInt64)}},
+		{OP_ADD, [][]byte{Int64Bytes(math.MaxInt64), {1}}},
+		{OP_ADD, [][]byte{Int64Bytes(math.MinInt64), Int64Bytes(-1)}},
+		{OP_SUB, [][]byte{Int64Bytes(math.MinInt64), {1}}},
+		{OP_SUB, [][]byte{Int64Bytes(math.MaxInt64), Int64Bytes(-1)}},
+		{OP_MUL, [][]byte{Int64Bytes(math.MaxInt64), {2}}},
+		{OP_MUL, [][]byte{Int64Bytes(math.MinInt64), Int64Bytes(-1)}},
+		{OP_DIV, [][]byte{Int64Bytes(math.MinInt64), Int64Bytes(-1)}},
+		{OP_MOD, [][]byte{Int64Bytes(math.MinInt64), Int64Bytes(-1)}},
+		{OP_LSHIFT, [][]byte{{1}, {64}}},
+		{OP_LSHIFT, [][]byte{{2}, {63}}},
+		{OP_LSHIFT, [][]byte{Int64Bytes(math.MinInt64), {1}}},
+	}
+	for _, c := range rangeErrs {
+		cases = append(cases, opTest{op: c.op, startVM: &virtualMachine{runLimit: 50000, dataStack: c.stack}, wantErr: ErrRange})
+	}
+
+	badValues := []struct {
+		op    Op
+		stack [][]byte
+		err   error
+	}{
+		{OP_DIV, [][]byte{{2}, {}}, ErrDivZero},
+		{OP_MOD, [][]byte{{2}, {}}, ErrDivZero},
+		{OP_LSHIFT, [][]byte{{2}, Int64Bytes(-1)}, ErrBadValue},
+		{OP_RSHIFT, [][]byte{{2}, Int64Bytes(-1)}, ErrBadValue},
+		{OP_ADD, [][]byte{{2}, {1, 0, 0, 0, 0, 0, 0, 0, 0}}, ErrBadValue},
+		{OP_ADD, [][]byte{{2}}, ErrDataStackUnderflow},
+		{OP_WITHIN, [][]byte{{1}, {2}}, ErrDataStackUnderflow},
+	}
+	for _, c := range badValues {
+		cases = append(cases, opTest{op: c.op, startVM: &virtualMachine{runLimit: 50000, dataStack: c.stack}, wantErr: c.err})
+	}
+
+	// every numeric op runs out of gas before it touches the stack
+	for _, op := range []Op{OP_1ADD, OP_NEGATE, OP_NOT, OP_ADD, OP_MUL, OP_DIV, OP_MOD, OP_LSHIFT, OP_BOOLAND, OP_NUMEQUAL, OP_NUMEQUALVERIFY, OP_WITHIN} {
+		cases = append(cases, opTest{op: op, startVM: &virtualMachine{runLimit: 1, dataStack: [][]byte{{2}, {2}, {2}}}, wantErr: ErrRunLimitExceeded})
+	}
+	testOps(t, cases)
+}
+
+func TestInt64Bytes(t *testing.T) {
+	cases := []struct {
+		n    int64
+		want []byte
+	}{
+		{0, []byte{}},
+		{1, []byte{0x01}},
+		{255, []byte{0xff}},
+		{256, []byte{0x00, 0x01}},
+		{-1, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{math.MaxInt64, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}},
+		{math.MinInt64, []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x80}},
+	}
+
+	for _, c := range cases {
+		got := Int64Bytes(c.n)
+		if !bytes.Equal(got, c.want) {
+			t.Errorf("Int64Bytes(%d) = %x want %x", c.n, got, c.want)
+		}
+		if n, err := AsInt64(got); err != nil || n != c.n {
+			t.Errorf("AsInt64(%x) = %d, %v want %d", got, n, err, c.n)
+		}
+	}
+
+	// non minimal encodings are accepted, longer ones are not
+	if n, err := AsInt64([]byte{0x01, 0x00, 0x00}); err != nil || n != 1 {
+		t.Errorf("AsInt64(010000) = %d, %v want 1", n, err)
+	}
+	if _, err := AsInt64(make([]byte, 9)); err != ErrBadValue {
+		t.Errorf("got err = %v want %v", err, ErrBadValue)
+	}
+
+	if !AsBool([]byte{0x00, 0x01}) || AsBool([]byte{0x00, 0x00}) || AsBool(nil) {
+		t.Error("AsBool is true only when some byte isn't zero")
+	}
+}
@@ -0,0 +1,508 @@
+package vm
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/btm-stats/errors"
+)
+
+type opTest struct {
+	op      Op
+	startVM *virtualMachine
+	wantErr error
+	wantVM  *virtualMachine
+}
+
+func equalStacks(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// testOps runs each op on its start vm and compares the gas, the deferred
+// cost, the program counter and both stacks with the wanted vm
+func testOps(t *testing.T, cases []opTest) {
+	for i, c := range cases {
+		err := ops[c.op].fn(c.startVM)
+		if errors.Root(err) != c.wantErr {
+			t.Errorf("case %d, op %s: got err = %v want %v", i, c.op, err, c.wantErr)
+			continue
+		}
+		if c.wantErr != nil {
+			continue
+		}
+
+		got, want := c.startVM, c.wantVM
+		if got.runLimit != want.runLimit || got.deferredCost != want.deferredCost || got.nextPC != want.nextPC {
+			t.Errorf("case %d, op %s: got runLimit %d deferredCost %d nextPC %d, want %d %d %d", i, c.op, got.runLimit, got.deferredCost, got.nextPC, want.runLimit, want.deferredCost, want.nextPC)
+		}
+		if !equalStacks(got.dataStack, want.dataStack) {
+			t.Errorf("case %d, op %s: got dataStack %x want %x", i, c.op, got.dataStack, want.dataStack)
+		}
+		if !equalStacks(got.altStack, want.altStack) {
+			t.Errorf("case %d, op %s: got altStack %x want %x", i, c.op, got.altStack, want.altStack)
+		}
+	}
+}
+
+func TestParseOp(t *testing.T) {
+	cases := []struct {
+		prog    []byte
+		pc      uint32
+		want    Instruction
+		wantErr error
+	}{{
+		prog: []byte{byte(OP_ADD)},
+		want: Instruction{Op: OP_ADD, Len: 1},
+	}, {
+		prog: []byte{byte(OP_16)},
+		want: Instruction{Op: OP_16, Len: 1, Data: []byte{16}},
+	}, {
+		prog: []byte{byte(Op(0x05)), 1, 1, 1, 1, 1},
+		want: Instruction{Op: Op(0x05), Len: 6, Data: []byte{1, 1, 1, 1, 1}},
+	}, {
+		prog: []byte{byte(Op(0x05)), 1, 1, 1, 1, 1, 255, 255, 255, 255},
+		want: Instruction{Op: Op(0x05), Len: 6, Data: []byte{1, 1, 1, 1, 1}},
+	}, {
+		prog: []byte{byte(OP_PUSHDATA1), 1, 1},
+		want: Instruction{Op: OP_PUSHDATA1, Len: 3, Data: []byte{1}},
+	}, {
+		prog: []byte{byte(OP_PUSHDATA2), 1, 0, 1},
+		want: Instruction{Op: OP_PUSHDATA2, Len: 4, Data: []byte{1}},
+	}, {
+		prog: []byte{byte(OP_PUSHDATA4), 1, 0, 0, 0, 1},
+		want: Instruction{Op: OP_PUSHDATA4, Len: 6, Data: []byte{1}},
+	}, {
+		prog: []byte{byte(OP_JUMP), 5, 0, 0, 0},
+		want: Instruction{Op: OP_JUMP, Len: 5, Data: []byte{5, 0, 0, 0}},
+	}, {
+		prog:    []byte{},
+		wantErr: ErrShortProgram,
+	}, {
+		prog:    []byte{byte(OP_ADD)},
+		pc:      1,
+		wantErr: ErrShortProgram,
+	}, {
+		prog:    []byte{byte(Op(0x05)), 1, 1, 1},
+		wantErr: ErrShortProgram,
+	}, {
+		prog:    []byte{byte(OP_PUSHDATA1)},
+		wantErr: ErrShortProgram,
+	}, {
+		prog:    []byte{byte(OP_PUSHDATA1), 2, 1},
+		wantErr: ErrShortProgram,
+	}, {
+		prog:    []byte{byte(OP_PUSHDATA2), 1},
+		wantErr: ErrShortProgram,
+	}, {
+		prog:    []byte{byte(OP_PUSHDATA4), 1, 0, 0},
+		wantErr: ErrShortProgram,
+	}, {
+		prog:    []byte{byte(OP_PUSHDATA4), 255, 255, 255, 255, 1},
+		wantErr: ErrLongProgram,
+	}, {
+		prog:    []byte{byte(OP_JUMPIF), 5, 0},
+		wantErr: ErrShortProgram,
+	}}
+
+	for i, c := range cases {
+		got, err := ParseOp(c.prog, c.pc)
+		if errors.Root(err) != c.wantErr {
+			t.Errorf("case %d: got err = %v want %v", i, err, c.wantErr)
+			continue
+		}
+		if c.wantErr != nil {
+			continue
+		}
+		if got.Op != c.want.Op || got.Len != c.want.Len || !bytes.Equal(got.Data, c.want.Data) {
+			t.Errorf("case %d: got %+v want %+v", i, got, c.want)
+		}
+	}
+}
+
+func TestParseProgram(t *testing.T) {
+	prog := []byte{byte(OP_2), 0x02, 0xab, 0xcd, byte(OP_JUMPIF), 0, 0, 0, 0, byte(OP_ADD)}
+	insts, err := ParseProgram(prog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Op{OP_2, Op(0x02), OP_JUMPIF, OP_ADD}
+	if len(insts) != len(want) {
+		t.Fatalf("got %d instructions, want %d", len(insts), len(want))
+	}
+	for i, op := range want {
+		if insts[i].Op != op {
+			t.Errorf("instruction %d: got %s want %s", i, insts[i].Op, op)
+		}
+	}
+
+	if _, err := ParseProgram(prog[:3]); err != ErrShortProgram {
+		t.Errorf("got err = %v want %v", err, ErrShortProgram)
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	cases := []struct {
+		src  string
+		want []byte
+	}{
+		{"2 3 ADD 5 NUMEQUAL", []byte{0x52, 0x53, 0x93, 0x55, 0x9c}},
+		{"0 -1 17", []byte{0x00, 0x08, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01, 0x11}},
+		{"0x0102 'ab'", []byte{0x02, 0x01, 0x02, 0x02, 0x61, 0x62}},
+		{"$a JUMP:$a", []byte{0x63, 0x00, 0x00, 0x00, 0x00}},
+		{"1 JUMPIF:$b FAIL $b", []byte{0x51, 0x64, 0x07, 0x00, 0x00, 0x00, 0x6a}},
+	}
+
+	for _, c := range cases {
+		got, err := Assemble(c.src)
+		if err != nil {
+			t.Errorf("Assemble(%q): %v", c.src, err)
+			continue
+		}
+		if !bytes.Equal(got, c.want) {
+			t.Errorf("Assemble(%q) = %x want %x", c.src, got, c.want)
+		}
+	}
+
+	for _, src := range []string{"PUSHDATA1", "JUMP", "FOO", "JUMP:$nowhere", "$a $a"} {
+		if _, err := Assemble(src); err == nil {
+			t.Errorf("Assemble(%q): got no error", src)
+		}
+	}
+}
+
+func TestOpNames(t *testing.T) {
+	for i := 0; i < 256; i++ {
+		info := ops[i]
+		if info.fn == nil {
+			t.Errorf("op 0x%02x has no implementation", i)
+		}
+		if info.op != Op(i) {
+			t.Errorf("op 0x%02x is registered as 0x%02x", i, info.op)
+		}
+	}
+
+	for op, name := range map[Op]string{OP_0: "FALSE", OP_1: "1", OP_16: "16", Op(0x02): "DATA_2", OP_CHECKPREDICATE: "CHECKPREDICATE", Op(0x50): "NOPx50"} {
+		if op.String() != name {
+			t.Errorf("op 0x%02x: got name %s want %s", byte(op), op, name)
+		}
+	}
+
+	// the expansion ops are the unassigned ones, they are the soft fork room
+	for _, op := range []Op{0x50, 0x62, 0x8a, 0xa6, 0xaf, 0xc5, 0xcc, 0xff} {
+		if !isExpansion[op] {
+			t.Errorf("op 0x%02x isn't an expansion op", byte(op))
+		}
+	}
+	for _, op := range []Op{OP_0, OP_1NEGATE, OP_NOP, OP_CHECKPREDICATE, OP_CHECKOUTPUT, OP_BLOCKHEIGHT} {
+		if isExpansion[op] {
+			t.Errorf("op %s is an expansion op", op)
+		}
+	}
+}
+
+func TestPushdataOps(t *testing.T) {
+	cases := []opTest{{
+		op:      OP_FALSE,
+		startVM: &virtualMachine{runLimit: 50000},
+		wantVM:  &virtualMachine{runLimit: 49991, dataStack: [][]byte{{}}},
+	}, {
+		op:      OP_FALSE,
+		startVM: &virtualMachine{runLimit: 1},
+		wantErr: ErrRunLimitExceeded,
+	}, {
+		op:      OP_1NEGATE,
+		startVM: &virtualMachine{runLimit: 50000},
+		wantVM:  &virtualMachine{runLimit: 49983, dataStack: [][]byte{Int64Bytes(-1)}},
+	}, {
+		op:      OP_NOP,
+		startVM: &virtualMachine{runLimit: 50000},
+		wantVM:  &virtualMachine{runLimit: 49999},
+	}, {
+		op:      OP_PUSHDATA1,
+		startVM: &virtualMachine{runLimit: 50000, data: []byte{1, 2}},
+		wantVM:  &virtualMachine{runLimit: 49989, dataStack: [][]byte{{1, 2}}},
+	}, {
+		op:      OP_16,
+		startVM: &virtualMachine{runLimit: 50000, data: []byte{16}},
+		wantVM:  &virtualMachine{runLimit: 49990, dataStack: [][]byte{{16}}},
+	}}
+	testOps(t, cases)
+
+	pushdata := []struct {
+		data []byte
+		want []byte
+	}{
+		{[]byte{}, []byte{byte(OP_0)}},
+		{[]byte{1}, []byte{byte(OP_DATA_1), 1}},
+		{bytes.Repeat([]byte{1}, 75), append([]byte{byte(OP_DATA_75)}, bytes.Repeat([]byte{1}, 75)...)},
+		{bytes.Repeat([]byte{1}, 76), append([]byte{byte(OP_PUSHDATA1), 76}, bytes.Repeat([]byte{1}, 76)...)},
+		{bytes.Repeat([]byte{1}, 256), append([]byte{byte(OP_PUSHDATA2), 0, 1}, bytes.Repeat([]byte{1}, 256)...)},
+		{bytes.Repeat([]byte{1}, 1<<16), append([]byte{byte(OP_PUSHDATA4), 0, 0, 1, 0}, bytes.Repeat([]byte{1}, 1<<16)...)},
+	}
+	for _, c := range pushdata {
+		if got := PushdataBytes(c.data); !bytes.Equal(got, c.want) {
+			t.Errorf("PushdataBytes(%d bytes) = %x want %x", len(c.data), got, c.want)
+		}
+	}
+
+	pushint := []struct {
+		n    int64
+		want []byte
+	}{
+		{0, []byte{byte(OP_0)}},
+		{1, []byte{byte(OP_1)}},
+		{16, []byte{byte(OP_16)}},
+		{17, []byte{byte(OP_DATA_1), 17}},
+		{-1, []byte{0x08, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{math.MaxInt64, []byte{0x08, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}},
+	}
+	for _, c := range pushint {
+		if got := PushdataInt64(c.n); !bytes.Equal(got, c.want) {
+			t.Errorf("PushdataInt64(%d) = %x want %x", c.n, got, c.want)
+		}
+	}
+}
+
+func TestStackOps(t *testing.T) {
+	cases := []opTest{{
+		op:      OP_TOALTSTACK,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{1}}},
+		wantVM:  &virtualMachine{runLimit: 49998, dataStack: [][]byte{}, altStack: [][]byte{{1}}},
+	}, {
+		op:      OP_FROMALTSTACK,
+		startVM: &virtualMachine{runLimit: 50000, altStack: [][]byte{{1}}},
+		wantVM:  &virtualMachine{runLimit: 49998, dataStack: [][]byte{{1}}, altStack: [][]byte{}},
+	}, {
+		op:      OP_FROMALTSTACK,
+		startVM: &virtualMachine{runLimit: 50000},
+		wantErr: ErrAltStackUnderflow,
+	}, {
+		op:      OP_2DROP,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{1}, {1}}},
+		wantVM:  &virtualMachine{runLimit: 50016, dataStack: [][]byte{}},
+	}, {
+		op:      OP_2DUP,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{2}, {1}}},
+		wantVM:  &virtualMachine{runLimit: 49980, dataStack: [][]byte{{2}, {1}, {2}, {1}}},
+	}, {
+		op:      OP_3DUP,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{3}, {2}, {1}}},
+		wantVM:  &virtualMachine{runLimit: 49970, dataStack: [][]byte{{3}, {2}, {1}, {3}, {2}, {1}}},
+	}, {
+		op:      OP_2OVER,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{4}, {3}, {2}, {1}}},
+		wantVM:  &virtualMachine{runLimit: 49980, dataStack: [][]byte{{4}, {3}, {2}, {1}, {4}, {3}}},
+	}, {
+		op:      OP_2ROT,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{6}, {5}, {4}, {3}, {2}, {1}}},
+		wantVM:  &virtualMachine{runLimit: 49998, dataStack: [][]byte{{4}, {3}, {2}, {1}, {6}, {5}}},
+	}, {
+		op:      OP_2SWAP,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{4}, {3}, {2}, {1}}},
+		wantVM:  &virtualMachine{runLimit: 49998, dataStack: [][]byte{{2}, {1}, {4}, {3}}},
+	}, {
+		op:      OP_IFDUP,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{1}}},
+		wantVM:  &virtualMachine{runLimit: 49990, dataStack: [][]byte{{1}, {1}}},
+	}, {
+		op:      OP_IFDUP,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{}}},
+		wantVM:  &virtualMachine{runLimit: 49999, dataStack: [][]byte{{}}},
+	}, {
+		op:      OP_DEPTH,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{1}}},
+		wantVM:  &virtualMachine{runLimit: 49990, dataStack: [][]byte{{1}, {1}}},
+	}, {
+		op:      OP_DROP,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{1}}},
+		wantVM:  &virtualMachine{runLimit: 50008, dataStack: [][]byte{}},
+	}, {
+		op:      OP_DUP,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{1}}},
+		wantVM:  &virtualMachine{runLimit: 49990, dataStack: [][]byte{{1}, {1}}},
+	}, {
+		op:      OP_DUP,
+		startVM: &virtualMachine{runLimit: 50000},
+		wantErr: ErrDataStackUnderflow,
+	}, {
+		op:      OP_NIP,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{2}, {1}}},
+		wantVM:  &virtualMachine{runLimit: 50008, dataStack: [][]byte{{1}}},
+	}, {
+		op:      OP_OVER,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{2}, {1}}},
+		wantVM:  &virtualMachine{runLimit: 49990, dataStack: [][]byte{{2}, {1}, {2}}},
+	}, {
+		op:      OP_PICK,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{3}, {2}, {1}, {2}}},
+		wantVM:  &virtualMachine{runLimit: 49998, dataStack: [][]byte{{3}, {2}, {1}, {3}}},
+	}, {
+		op:      OP_PICK,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{1}, {2}}},
+		wantErr: ErrDataStackUnderflow,
+	}, {
+		op:      OP_PICK,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{1}, Int64Bytes(-1)}},
+		wantErr: ErrBadValue,
+	}, {
+		op:      OP_PICK,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{1}, Int64Bytes(math.MaxInt64)}},
+		wantErr: ErrBadValue,
+	}, {
+		op:      OP_ROLL,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{3}, {2}, {1}, {2}}},
+		wantVM:  &virtualMachine{runLimit: 50007, dataStack: [][]byte{{2}, {1}, {3}}},
+	}, {
+		op:      OP_ROLL,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{1}, Int64Bytes(math.MaxInt64)}},
+		wantErr: ErrBadValue,
+	}, {
+		op:      OP_ROT,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{3}, {2}, {1}}},
+		wantVM:  &virtualMachine{runLimit: 49998, dataStack: [][]byte{{2}, {1}, {3}}},
+	}, {
+		op:      OP_SWAP,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{2}, {1}}},
+		wantVM:  &virtualMachine{runLimit: 49999, dataStack: [][]byte{{1}, {2}}},
+	}, {
+		op:      OP_TUCK,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{2}, {1}}},
+		wantVM:  &virtualMachine{runLimit: 49990, dataStack: [][]byte{{1}, {2}, {1}}},
+	}, {
+		op:      OP_TUCK,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{1}}},
+		wantErr: ErrDataStackUnderflow,
+	}}
+
+	// every stack op underflows on an empty stack
+	for _, op := range []Op{OP_TOALTSTACK, OP_2DROP, OP_2DUP, OP_3DUP, OP_2OVER, OP_2ROT, OP_2SWAP, OP_IFDUP, OP_DROP, OP_NIP, OP_OVER, OP_PICK, OP_ROLL, OP_ROT, OP_SWAP} {
+		cases = append(cases, opTest{op: op, startVM: &virtualMachine{runLimit: 50000}, wantErr: ErrDataStackUnderflow})
+	}
+	testOps(t, cases)
+}
+
+func TestSpliceOps(t *testing.T) {
+	cases := []opTest{{
+		op:      OP_CAT,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{[]byte("hello"), []byte("world")}},
+		wantVM:  &virtualMachine{runLimit: 49986, deferredCost: -18, dataStack: [][]byte{[]byte("helloworld")}},
+	}, {
+		op:      OP_CAT,
+		startVM: &virtualMachine{runLimit: 4, dataStack: [][]byte{[]byte("hello"), []byte("world")}},
+		wantErr: ErrRunLimitExceeded,
+	}, {
+		op:      OP_SUBSTR,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{[]byte("helloworld"), {3}, {5}}},
+		wantVM:  &virtualMachine{runLimit: 49991, deferredCost: -28, dataStack: [][]byte{[]byte("lowor")}},
+	}, {
+		op:      OP_SUBSTR,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{[]byte("hello"), {3}, {5}}},
+		wantErr: ErrBadValue,
+	}, {
+		op:      OP_SUBSTR,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{[]byte("hello"), Int64Bytes(-1), {1}}},
+		wantErr: ErrBadValue,
+	}, {
+		op:      OP_SUBSTR,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{[]byte("hello"), Int64Bytes(math.MaxInt64), {1}}},
+		wantErr: ErrBadValue,
+	}, {
+		op:      OP_LEFT,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{[]byte("helloworld"), {5}}},
+		wantVM:  &virtualMachine{runLimit: 49991, deferredCost: -19, dataStack: [][]byte{[]byte("hello")}},
+	}, {
+		op:      OP_LEFT,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{[]byte("hello"), {6}}},
+		wantErr: ErrBadValue,
+	}, {
+		op:      OP_RIGHT,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{[]byte("helloworld"), {5}}},
+		wantVM:  &virtualMachine{runLimit: 49991, deferredCost: -19, dataStack: [][]byte{[]byte("world")}},
+	}, {
+		op:      OP_RIGHT,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{[]byte("hello"), Int64Bytes(-1)}},
+		wantErr: ErrBadValue,
+	}, {
+		op:      OP_SIZE,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{[]byte("hello")}},
+		wantVM:  &virtualMachine{runLimit: 49999, deferredCost: 9, dataStack: [][]byte{[]byte("hello"), {5}}},
+	}, {
+		op:      OP_CATPUSHDATA,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{0xff}, {0xab, 0xcd}}},
+		wantVM:  &virtualMachine{runLimit: 49993, deferredCost: -10, dataStack: [][]byte{{0xff, 0x02, 0xab, 0xcd}}},
+	}}
+	testOps(t, cases)
+}
+
+func TestBitwiseOps(t *testing.T) {
+	cases := []opTest{{
+		op:      OP_INVERT,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{0xff, 0x00}}},
+		wantVM:  &virtualMachine{runLimit: 49997, dataStack: [][]byte{{0x00, 0xff}}},
+	}, {
+		op:      OP_AND,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{0xff, 0x80}, {0x80}}},
+		wantVM:  &virtualMachine{runLimit: 49998, deferredCost: -10, dataStack: [][]byte{{0x80}}},
+	}, {
+		op:      OP_OR,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{0xff, 0x80}, {0x01}}},
+		wantVM:  &virtualMachine{runLimit: 49997, deferredCost: -9, dataStack: [][]byte{{0xff, 0x80}}},
+	}, {
+		op:      OP_XOR,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{0xff, 0x80}, {0x01}}},
+		wantVM:  &virtualMachine{runLimit: 49997, deferredCost: -9, dataStack: [][]byte{{0xfe, 0x80}}},
+	}, {
+		op:      OP_EQUAL,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{1}, {1}}},
+		wantVM:  &virtualMachine{runLimit: 49998, deferredCost: -9, dataStack: [][]byte{{1}}},
+	}, {
+		op:      OP_EQUAL,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{1}, {1, 0}}},
+		wantVM:  &virtualMachine{runLimit: 49998, deferredCost: -11, dataStack: [][]byte{{}}},
+	}, {
+		op:      OP_EQUALVERIFY,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{1}, {1}}},
+		wantVM:  &virtualMachine{runLimit: 49998, deferredCost: -18, dataStack: [][]byte{}},
+	}, {
+		op:      OP_EQUALVERIFY,
+		startVM: &virtualMachine{runLimit: 50000, dataStack: [][]byte{{1}, {2}}},
+		wantErr: ErrVerifyFailed,
+	}}
+	testOps(t, cases)
+}
+
+func TestDisassemble(t *testing.T) {
+	cases := []struct {
+		prog []byte
+		want string
+	}{
+		{[]byte{0x52, 0x53, 0x93, 0x55, 0x9c}, "0x02 0x03 ADD 0x05 NUMEQUAL"},
+		{[]byte{0x51, 0x64, 0x07, 0x00, 0x00, 0x00, 0x6a}, "0x01 JUMPIF:$alpha FAIL $alpha"},
+		{[]byte{0x02, 0xab, 0xcd, 0x50}, "0xabcd NOPx50"},
+	}
+
+	for _, c := range cases {
+		got, err := Disassemble(c.prog)
+		if err != nil {
+			t.Errorf("Disassemble(%x): %v", c.prog, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("Disassemble(%x) = %q want %q", c.prog, got, c.want)
+		}
+	}
+
+	if _, err := Disassemble([]byte{0x02, 0xab}); err != ErrShortProgram {
+		t.Errorf("got err = %v want %v", err, ErrShortProgram)
+	}
+}
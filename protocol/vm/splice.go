@@ -0,0 +1,156 @@
+package vm
+
+import "github.com/btm-stats/math/checked"
+
+func opCat(vm *virtualMachine) error {
+	if err := vm.applyCost(4); err != nil {
+		return err
+	}
+
+	b, err := vm.pop(true)
+	if err != nil {
+		return err
+	}
+	a, err := vm.pop(true)
+	if err != nil {
+		return err
+	}
+
+	lens := int64(len(a) + len(b))
+	if err = vm.applyCost(lens); err != nil {
+		return err
+	}
+	vm.deferCost(-lens)
+
+	res := make([]byte, 0, lens)
+	res = append(append(res, a...), b...)
+	return vm.push(res, true)
+}
+
+func opSubstr(vm *virtualMachine) error {
+	if err := vm.applyCost(4); err != nil {
+		return err
+	}
+
+	size, err := vm.popInt64(true)
+	if err != nil {
+		return err
+	}
+	if size < 0 {
+		return ErrBadValue
+	}
+	if err = vm.applyCost(size); err != nil {
+		return err
+	}
+	vm.deferCost(-size)
+
+	offset, err := vm.popInt64(true)
+	if err != nil {
+		return err
+	}
+	if offset < 0 {
+		return ErrBadValue
+	}
+	str, err := vm.pop(true)
+	if err != nil {
+		return err
+	}
+
+	end, ok := checked.AddInt64(offset, size)
+	if !ok || end > int64(len(str)) {
+		return ErrBadValue
+	}
+	return vm.push(str[offset:end], true)
+}
+
+func opLeft(vm *virtualMachine) error {
+	if err := vm.applyCost(4); err != nil {
+		return err
+	}
+
+	size, err := vm.popInt64(true)
+	if err != nil {
+		return err
+	}
+	if size < 0 {
+		return ErrBadValue
+	}
+	if err = vm.applyCost(size); err != nil {
+		return err
+	}
+	vm.deferCost(-size)
+
+	str, err := vm.pop(true)
+	if err != nil {
+		return err
+	}
+	if size > int64(len(str)) {
+		return ErrBadValue
+	}
+	return vm.push(str[:size], true)
+}
+
+func opRight(vm *virtualMachine) error {
+	if err := vm.applyCost(4); err != nil {
+		return err
+	}
+
+	size, err := vm.popInt64(true)
+	if err != nil {
+		return err
+	}
+	if size < 0 {
+		return ErrBadValue
+	}
+	if err = vm.applyCost(size); err != nil {
+		return err
+	}
+	vm.deferCost(-size)
+
+	str, err := vm.pop(true)
+	if err != nil {
+		return err
+	}
+	lstr := int64(len(str))
+	if size > lstr {
+		return ErrBadValue
+	}
+	return vm.push(str[lstr-size:], true)
+}
+
+func opSize(vm *virtualMachine) error {
+	if err := vm.applyCost(1); err != nil {
+		return err
+	}
+
+	str, err := vm.top()
+	if err != nil {
+		return err
+	}
+	return vm.pushInt64(int64(len(str)), true)
+}
+
+func opCatpushdata(vm *virtualMachine) error {
+	if err := vm.applyCost(4); err != nil {
+		return err
+	}
+
+	b, err := vm.pop(true)
+	if err != nil {
+		return err
+	}
+	a, err := vm.pop(true)
+	if err != nil {
+		return err
+	}
+
+	lens := int64(len(a) + len(b))
+	if err = vm.applyCost(lens); err != nil {
+		return err
+	}
+	vm.deferCost(-lens)
+
+	res := make([]byte, 0, lens+5)
+	res = append(append(res, a...), PushdataBytes(b)...)
+	return vm.push(res, true)
+}
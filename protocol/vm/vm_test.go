@@ -0,0 +1,172 @@
+package vm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/btm-stats/errors"
+)
+
+// verifyErr unwraps the program and arguments Verify attaches to an error
+func verifyErr(err error) error {
+	if vmErr, ok := err.(Error); ok {
+		return errors.Root(vmErr.Err)
+	}
+	return errors.Root(err)
+}
+
+func TestProgramOK(t *testing.T) {
+	cases := []struct {
+		prog string
+		args [][]byte
+	}{
+		{prog: "TRUE"},
+		{prog: "2 3 ADD 5 NUMEQUAL"},
+		{prog: "123 DEPTH 1 NUMEQUAL VERIFY 123 NUMEQUAL"},
+		{prog: "0x1122 0x3344 CAT 0x11223344 EQUAL"},
+		{prog: "0x012345 2 LEFT 0x0123 EQUAL"},
+		{prog: "10 7 MOD 3 NUMEQUAL"},
+		{prog: "-10 7 MOD 4 NUMEQUAL"},
+		{prog: "1 TOALTSTACK FROMALTSTACK"},
+		{prog: "1 JUMPIF:$ok FAIL $ok 1"},
+		{prog: "0 $loop 1ADD DUP 5 NUMEQUAL NOT JUMPIF:$loop 5 NUMEQUAL"},
+		{prog: "0 0x51 0 CHECKPREDICATE"},
+		{prog: "'abc' SHA3 0x3a985da74fe225b2045c172d6bd390bd855f086e3e9d525b46bfe24511431532 EQUAL"},
+		{prog: "ADD 5 NUMEQUAL", args: [][]byte{{2}, {3}}},
+		{prog: "0x00 NOT"},
+	}
+
+	for i, c := range cases {
+		prog, err := Assemble(c.prog)
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		if _, err := Verify(&Context{VMVersion: 1, Code: prog, Arguments: c.args}, 10000); err != nil {
+			t.Errorf("case %d, %s: %v", i, c.prog, err)
+		}
+	}
+}
+
+func TestVerifyErrors(t *testing.T) {
+	txVersion := uint64(1)
+	cases := []struct {
+		context  *Context
+		gasLimit int64
+		wantErr  error
+	}{{
+		context: &Context{VMVersion: 2, Code: []byte{byte(OP_TRUE)}},
+		wantErr: ErrUnsupportedVM,
+	}, {
+		context: &Context{VMVersion: 1},
+		wantErr: ErrFalseVMResult,
+	}, {
+		context: &Context{VMVersion: 1, Code: []byte{byte(OP_0)}},
+		wantErr: ErrFalseVMResult,
+	}, {
+		context: &Context{VMVersion: 1, Code: []byte{byte(OP_FAIL)}},
+		wantErr: ErrReturn,
+	}, {
+		context: &Context{VMVersion: 1, Code: []byte{byte(OP_0), byte(OP_VERIFY), byte(OP_TRUE)}},
+		wantErr: ErrVerifyFailed,
+	}, {
+		context: &Context{VMVersion: 1, Code: []byte{byte(OP_1), byte(OP_0), byte(OP_DIV)}},
+		wantErr: ErrDivZero,
+	}, {
+		context: &Context{VMVersion: 1, Code: []byte{byte(OP_DATA_1)}},
+		wantErr: ErrShortProgram,
+	}, {
+		context:  &Context{VMVersion: 1, Code: []byte{byte(OP_TRUE)}},
+		gasLimit: 9,
+		wantErr:  ErrRunLimitExceeded,
+	}, {
+		context:  &Context{VMVersion: 1, Code: []byte{byte(OP_TRUE)}, Arguments: [][]byte{make([]byte, 100)}},
+		gasLimit: 100,
+		wantErr:  ErrRunLimitExceeded,
+	}, {
+		// the expansion ops are reserved in version 1 transactions
+		context: &Context{VMVersion: 1, Code: []byte{0x50, byte(OP_TRUE)}, TxVersion: &txVersion},
+		wantErr: ErrDisallowedOpcode,
+	}, {
+		context: &Context{VMVersion: 1, Code: []byte{byte(OP_BLOCKHEIGHT)}},
+		wantErr: ErrContext,
+	}}
+
+	for i, c := range cases {
+		gasLimit := c.gasLimit
+		if gasLimit == 0 {
+			gasLimit = 10000
+		}
+		if _, err := Verify(c.context, gasLimit); verifyErr(err) != c.wantErr {
+			t.Errorf("case %d: got err = %v want %v", i, err, c.wantErr)
+		}
+	}
+
+	// outside a version 1 transaction the expansion ops are nops
+	if _, err := Verify(&Context{VMVersion: 1, Code: []byte{0x50, byte(OP_TRUE)}}, 10000); err != nil {
+		t.Errorf("expansion op outside a transaction: %v", err)
+	}
+}
+
+func TestVerifyGas(t *testing.T) {
+	cases := []struct {
+		prog     string
+		args     [][]byte
+		wantLeft int64
+	}{
+		// 2 and 3 cost 10 each, ADD refunds 7, 5 costs 10 and NUMEQUAL refunds 7
+		{"2 3 ADD 5 NUMEQUAL", nil, 9984},
+		// the arguments are charged as pushes
+		{"DROP 1", [][]byte{{1}}, 9989},
+		{"0 0x51 0 CHECKPREDICATE", nil, 9923},
+	}
+
+	for _, c := range cases {
+		prog, err := Assemble(c.prog)
+		if err != nil {
+			t.Fatal(err)
+		}
+		left, err := Verify(&Context{VMVersion: 1, Code: prog, Arguments: c.args}, 10000)
+		if err != nil {
+			t.Errorf("%s: %v", c.prog, err)
+			continue
+		}
+		if left != c.wantLeft {
+			t.Errorf("%s: got gas left %d want %d", c.prog, left, c.wantLeft)
+		}
+	}
+}
+
+func TestVerifyRecover(t *testing.T) {
+	// a panic in the context callbacks fails the program instead of the node
+	context := &Context{
+		VMVersion: 1,
+		Code:      []byte{byte(OP_TXSIGHASH)},
+		TxSigHash: func() []byte { panic("boom") },
+	}
+	if _, err := Verify(context, 10000); errors.Root(err) != ErrUnexpected {
+		t.Errorf("got err = %v want %v", err, ErrUnexpected)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	buf := &bytes.Buffer{}
+	TraceOut = buf
+	defer func() { TraceOut = nil }()
+
+	if _, err := Verify(&Context{VMVersion: 1, Code: []byte{byte(OP_1), byte(OP_DUP), byte(OP_EQUAL)}}, 10000); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"pc 0", "pc 1 limit 9990 DUP", "pc 2", "EQUAL", "stack 0: 01"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("trace %q doesn't contain %q", buf.String(), want)
+		}
+	}
+}
+
+func TestErrorString(t *testing.T) {
+	_, err := Verify(&Context{VMVersion: 1, Code: []byte{byte(OP_FAIL)}, Arguments: [][]byte{{0xab}}}, 10000)
+	if want := "RETURN executed [prog 6a = FAIL; args ab]"; err == nil || err.Error() != want {
+		t.Errorf("got error %v want %s", err, want)
+	}
+}
@@ -0,0 +1,113 @@
+package vmutil
+
+import (
+	"encoding/binary"
+
+	"github.com/btm-stats/errors"
+	"github.com/btm-stats/protocol/vm"
+)
+
+// ErrUnresolvedJump is returned by Build when a jump target was never set
+var ErrUnresolvedJump = errors.New("unresolved jump target")
+
+// Builder assembles a VM program op by op
+type Builder struct {
+	program     []byte
+	jumpCounter int
+
+	// Maps a jump target number to its absolute address.
+	jumpAddr map[int]uint32
+
+	// Maps a jump target number to the list of places where its
+	// absolute address must be filled in once known.
+	jumpPlaceholders map[int][]int
+}
+
+// NewBuilder creates an empty program builder
+func NewBuilder() *Builder {
+	return &Builder{
+		jumpAddr:         make(map[int]uint32),
+		jumpPlaceholders: make(map[int][]int),
+	}
+}
+
+// AddInt64 adds a pushdata instruction for an integer value.
+func (b *Builder) AddInt64(n int64) *Builder {
+	b.program = append(b.program, vm.PushdataInt64(n)...)
+	return b
+}
+
+// AddData adds a pushdata instruction for a given byte string.
+func (b *Builder) AddData(data []byte) *Builder {
+	b.program = append(b.program, vm.PushdataBytes(data)...)
+	return b
+}
+
+// AddRawBytes simply appends the given bytes to the program. (It does
+// not introduce a pushdata opcode.)
+func (b *Builder) AddRawBytes(data []byte) *Builder {
+	b.program = append(b.program, data...)
+	return b
+}
+
+// AddOp adds the given opcode to the program.
+func (b *Builder) AddOp(op vm.Op) *Builder {
+	b.program = append(b.program, byte(op))
+	return b
+}
+
+// NewJumpTarget allocates a number that can be used as a jump target
+// in AddJump and AddJumpIf. Call SetJumpTarget to associate the
+// number with a program location.
+func (b *Builder) NewJumpTarget() int {
+	b.jumpCounter++
+	return b.jumpCounter
+}
+
+// AddJump adds a JUMP opcode whose target is the given target
+// number. The actual program location of the target does not need to
+// be known yet, as long as SetJumpTarget is called before Build.
+func (b *Builder) AddJump(target int) *Builder {
+	return b.addJump(vm.OP_JUMP, target)
+}
+
+// AddJumpIf adds a JUMPIF opcode whose target is the given target
+// number. The actual program location of the target does not need to
+// be known yet, as long as SetJumpTarget is called before Build.
+func (b *Builder) AddJumpIf(target int) *Builder {
+	return b.addJump(vm.OP_JUMPIF, target)
+}
+
+func (b *Builder) addJump(op vm.Op, target int) *Builder {
+	b.AddOp(op)
+	b.jumpPlaceholders[target] = append(b.jumpPlaceholders[target], len(b.program))
+	b.AddRawBytes([]byte{0, 0, 0, 0})
+	return b
+}
+
+// SetJumpTarget associates the given jump-target number with the
+// current position in the program, so the first instruction executed
+// by a jump to this target is whatever instruction is added next.
+// Setting a target at the end of the program is legal, jumping there
+// terminates the program successfully.
+func (b *Builder) SetJumpTarget(target int) *Builder {
+	b.jumpAddr[target] = uint32(len(b.program))
+	return b
+}
+
+// Build produces the bytecode of the program. It first resolves any
+// jumps in the program by filling in the addresses of their targets,
+// ErrUnresolvedJump is returned if any target used by AddJump or
+// AddJumpIf wasn't set with SetJumpTarget.
+func (b *Builder) Build() ([]byte, error) {
+	for target, placeholders := range b.jumpPlaceholders {
+		addr, ok := b.jumpAddr[target]
+		if !ok {
+			return nil, errors.Wrapf(ErrUnresolvedJump, "target %d", target)
+		}
+		for _, placeholder := range placeholders {
+			binary.LittleEndian.PutUint32(b.program[placeholder:placeholder+4], addr)
+		}
+	}
+	return b.program, nil
+}
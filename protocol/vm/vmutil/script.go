@@ -0,0 +1,263 @@
+package vmutil
+
+import (
+	"github.com/tendermint/ed25519"
+
+	"github.com/btm-stats/consensus"
+	"github.com/btm-stats/errors"
+	"github.com/btm-stats/protocol/vm"
+)
+
+// Errors returned by the program builders and parsers
+var (
+	ErrBadValue       = errors.New("bad value")
+	ErrMultisigFormat = errors.New("bad multisig program format")
+	ErrWitnessVersion = errors.New("unknown witness program version")
+)
+
+// IsUnspendable checks if a control program always fails
+func IsUnspendable(prog []byte) bool {
+	return len(prog) > 0 && prog[0] == byte(vm.OP_FAIL)
+}
+
+// DefaultCoinbaseProgram generates the program of a coinbase output anyone
+// can spend
+func DefaultCoinbaseProgram() ([]byte, error) {
+	return NewBuilder().AddOp(vm.OP_TRUE).Build()
+}
+
+// RetireProgram generates the program of a retirement output
+func RetireProgram(comment []byte) ([]byte, error) {
+	builder := NewBuilder()
+	builder.AddOp(vm.OP_FAIL)
+	if len(comment) != 0 {
+		builder.AddData(comment)
+	}
+	return builder.Build()
+}
+
+// P2WPKHProgram returns the segwit program paying to a public key hash
+func P2WPKHProgram(hash []byte) ([]byte, error) {
+	return NewBuilder().AddInt64(0).AddData(hash).Build()
+}
+
+// P2WSHProgram returns the segwit program paying to a script hash
+func P2WSHProgram(hash []byte) ([]byte, error) {
+	return NewBuilder().AddInt64(0).AddData(hash).Build()
+}
+
+// P2PKHSigProgram generates the program a P2WPKH program runs as, it expects
+// the signature and the public key as arguments
+func P2PKHSigProgram(pubkeyHash []byte) ([]byte, error) {
+	builder := NewBuilder()
+	builder.AddOp(vm.OP_DUP)
+	builder.AddOp(vm.OP_HASH160)
+	builder.AddData(pubkeyHash)
+	builder.AddOp(vm.OP_EQUALVERIFY)
+	builder.AddOp(vm.OP_TXSIGHASH)
+	builder.AddOp(vm.OP_SWAP)
+	builder.AddOp(vm.OP_CHECKSIG)
+	return builder.Build()
+}
+
+// P2SHProgram generates the program a P2WSH program runs as, it expects the
+// arguments of the script followed by the script itself
+func P2SHProgram(scriptHash []byte) ([]byte, error) {
+	builder := NewBuilder()
+	builder.AddOp(vm.OP_DUP)
+	builder.AddOp(vm.OP_SHA3)
+	builder.AddData(scriptHash)
+	builder.AddOp(vm.OP_EQUALVERIFY)
+	builder.AddInt64(-1)
+	builder.AddOp(vm.OP_SWAP)
+	builder.AddInt64(0)
+	builder.AddOp(vm.OP_CHECKPREDICATE)
+	return builder.Build()
+}
+
+// P2SPMultiSigProgram generates the program requiring nrequired signatures
+// of the given public keys
+func P2SPMultiSigProgram(pubkeys [][]byte, nrequired int) ([]byte, error) {
+	builder := NewBuilder()
+	if err := builder.addP2SPMultiSig(pubkeys, nrequired); err != nil {
+		return nil, err
+	}
+	return builder.Build()
+}
+
+// P2SPMultiSigProgramWithHeight is P2SPMultiSigProgram that can only be spent
+// while the chain is below blockHeight
+func P2SPMultiSigProgramWithHeight(pubkeys [][]byte, nrequired int, blockHeight int64) ([]byte, error) {
+	if blockHeight < 0 {
+		return nil, errors.WithDetail(ErrBadValue, "negative blockHeight")
+	}
+
+	builder := NewBuilder()
+	if blockHeight > 0 {
+		builder.AddInt64(blockHeight)
+		builder.AddOp(vm.OP_BLOCKHEIGHT)
+		builder.AddOp(vm.OP_GREATERTHAN)
+		builder.AddOp(vm.OP_VERIFY)
+	}
+	if err := builder.addP2SPMultiSig(pubkeys, nrequired); err != nil {
+		return nil, err
+	}
+	return builder.Build()
+}
+
+func (b *Builder) addP2SPMultiSig(pubkeys [][]byte, nrequired int) error {
+	if err := checkMultiSigParams(int64(nrequired), int64(len(pubkeys))); err != nil {
+		return err
+	}
+
+	b.AddOp(vm.OP_TXSIGHASH)
+	for _, p := range pubkeys {
+		if len(p) != ed25519.PublicKeySize {
+			return errors.WithDetailf(ErrBadValue, "pubkey length %d", len(p))
+		}
+		b.AddData(p)
+	}
+	b.AddInt64(int64(nrequired))    // M
+	b.AddInt64(int64(len(pubkeys))) // N
+	b.AddOp(vm.OP_CHECKMULTISIG)
+	return nil
+}
+
+// ParseP2SPMultiSigProgram returns the public keys and the quorum of a
+// program built by P2SPMultiSigProgram or P2SPMultiSigProgramWithHeight
+func ParseP2SPMultiSigProgram(program []byte) ([][]byte, int, error) {
+	insts, err := vm.ParseProgram(program)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Count all instructions backwards from the end in case there is a
+	// <height> BLOCKHEIGHT GREATERTHAN VERIFY prefix.
+	l := len(insts)
+	if l < 4 || insts[l-1].Op != vm.OP_CHECKMULTISIG {
+		return nil, 0, ErrMultisigFormat
+	}
+
+	npubkeys, err := vm.AsInt64(insts[l-2].Data)
+	if err != nil {
+		return nil, 0, err
+	}
+	nrequired, err := vm.AsInt64(insts[l-3].Data)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err = checkMultiSigParams(nrequired, npubkeys); err != nil {
+		return nil, 0, err
+	}
+
+	first := l - 3 - int(npubkeys)
+	if first < 1 || insts[first-1].Op != vm.OP_TXSIGHASH {
+		return nil, 0, ErrMultisigFormat
+	}
+
+	pubkeys := [][]byte{}
+	for _, inst := range insts[first : l-3] {
+		if len(inst.Data) != ed25519.PublicKeySize {
+			return nil, 0, ErrMultisigFormat
+		}
+		pubkeys = append(pubkeys, inst.Data)
+	}
+	return pubkeys, int(nrequired), nil
+}
+
+func checkMultiSigParams(nrequired, npubkeys int64) error {
+	if nrequired < 0 {
+		return errors.WithDetail(ErrBadValue, "negative quorum")
+	}
+	if npubkeys < 0 {
+		return errors.WithDetail(ErrBadValue, "negative pubkey count")
+	}
+	if nrequired > npubkeys {
+		return errors.WithDetail(ErrBadValue, "quorum too big")
+	}
+	if nrequired == 0 && npubkeys > 0 {
+		return errors.WithDetail(ErrBadValue, "quorum empty with non-empty pubkey list")
+	}
+	return nil
+}
+
+// IsP2WScript checks if the program is one of the standard witness programs
+func IsP2WScript(prog []byte) bool {
+	return IsP2WPKHScript(prog) || IsP2WSHScript(prog) || IsStraightforward(prog)
+}
+
+// IsStraightforward checks if the program is a bare TRUE or FAIL
+func IsStraightforward(prog []byte) bool {
+	insts, err := vm.ParseProgram(prog)
+	if err != nil || len(insts) != 1 {
+		return false
+	}
+	return insts[0].Op == vm.OP_TRUE || insts[0].Op == vm.OP_FAIL
+}
+
+// IsP2WPKHScript checks if the program is a version byte followed by a
+// public key hash
+func IsP2WPKHScript(prog []byte) bool {
+	return isWitnessProgram(prog, vm.OP_DATA_20, consensus.PayToWitnessPubKeyHashDataSize)
+}
+
+// IsP2WSHScript checks if the program is a version byte followed by a
+// script hash
+func IsP2WSHScript(prog []byte) bool {
+	return isWitnessProgram(prog, vm.OP_DATA_32, consensus.PayToWitnessScriptHashDataSize)
+}
+
+func isWitnessProgram(prog []byte, op vm.Op, size int) bool {
+	insts, err := vm.ParseProgram(prog)
+	if err != nil || len(insts) != 2 {
+		return false
+	}
+	if insts[0].Op > vm.OP_16 {
+		return false
+	}
+	return insts[1].Op == op && len(insts[1].Data) == size
+}
+
+// ConvertP2PKHSigProgram expands a P2WPKH program into the program it runs as
+func ConvertP2PKHSigProgram(prog []byte) ([]byte, error) {
+	hash, err := witnessHash(prog)
+	if err != nil {
+		return nil, err
+	}
+	return P2PKHSigProgram(hash)
+}
+
+// ConvertP2SHProgram expands a P2WSH program into the program it runs as
+func ConvertP2SHProgram(prog []byte) ([]byte, error) {
+	hash, err := witnessHash(prog)
+	if err != nil {
+		return nil, err
+	}
+	return P2SHProgram(hash)
+}
+
+// GetHashFromStandardProg returns the hash committed by a witness program
+func GetHashFromStandardProg(prog []byte) ([]byte, error) {
+	insts, err := vm.ParseProgram(prog)
+	if err != nil {
+		return nil, err
+	}
+	if len(insts) != 2 {
+		return nil, ErrBadValue
+	}
+	return insts[1].Data, nil
+}
+
+func witnessHash(prog []byte) ([]byte, error) {
+	insts, err := vm.ParseProgram(prog)
+	if err != nil {
+		return nil, err
+	}
+	if len(insts) != 2 {
+		return nil, ErrBadValue
+	}
+	if insts[0].Op != vm.OP_0 {
+		return nil, ErrWitnessVersion
+	}
+	return insts[1].Data, nil
+}
@@ -0,0 +1,273 @@
+package vmutil
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"golang.org/x/crypto/sha3"
+
+	"github.com/btm-stats/errors"
+	"github.com/btm-stats/protocol/vm"
+)
+
+// the keys are the RFC 8032 test keys 1 to 3, the signatures are made by an
+// independent ed25519 implementation over testMsg
+var (
+	testMsg = mustDecodeHex("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+
+	testPub1 = mustDecodeHex("d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a")
+	testPub2 = mustDecodeHex("3d4017c3e843895a92b70aa74d1b7ebc9c982ccf2ec4968cc0cd55f12af4660c")
+	testPub3 = mustDecodeHex("fc51cd8e6218a1a38da47ed00230f0580816ed13ba3303ac5deb911548908025")
+
+	testSig1 = mustDecodeHex("00c1db988bb12fd7351a6054ae3fac90fab7e4fc56b1651c7181f5f55f896f663933d3a90605d9058e9d0ac45950ee2d3c9c9b14857415587179fe0ccac35f09")
+	testSig2 = mustDecodeHex("ed19931f49cf7559f1474199dfbcce36cef99ed8c2faf414550fa01c8699bc99ca097b6e4764712829214b328f593b8f1db93ef2965b838d9770663b36882105")
+	testSig3 = mustDecodeHex("ba77465c0cc0b86eea1ace49d96f882e22259bb3990590901d7ac4de6367053303a42deab32cb19d262ccc8fe4e58d1974302f42d53eabd3130b95b3f6105202")
+
+	// RIPEMD-160 of testPub1 and SHA3-256 of the TRUE program
+	testPubHash    = mustDecodeHex("85b0d8bb01d99d291f5a9a90f84642e9ff4a1e78")
+	testScriptHash = mustDecodeHex("ba86a2a6dac23e336a34b4337eb740d40d900fae703bf55dcde8430208bb82e8")
+)
+
+func mustDecodeHex(h string) []byte {
+	bits, err := hex.DecodeString(h)
+	if err != nil {
+		panic(err)
+	}
+	return bits
+}
+
+func TestStandardPrograms(t *testing.T) {
+	p2wpkh, _ := P2WPKHProgram(testPubHash)
+	p2wsh, _ := P2WSHProgram(testScriptHash)
+	p2pkh, _ := P2PKHSigProgram(testPubHash)
+	p2sh, _ := P2SHProgram(testScriptHash)
+	multisig, _ := P2SPMultiSigProgram([][]byte{testPub1, testPub2}, 1)
+	multisigHeight, _ := P2SPMultiSigProgramWithHeight([][]byte{testPub1, testPub2}, 1, 10)
+	coinbase, _ := DefaultCoinbaseProgram()
+	retire, _ := RetireProgram(nil)
+	retireComment, _ := RetireProgram([]byte{1, 2})
+
+	cases := []struct {
+		name string
+		got  []byte
+		want string
+	}{
+		{"P2WPKH", p2wpkh, "0014" + hex.EncodeToString(testPubHash)},
+		{"P2WSH", p2wsh, "0020" + hex.EncodeToString(testScriptHash)},
+		{"P2PKH", p2pkh, "76ab14" + hex.EncodeToString(testPubHash) + "88ae7cac"},
+		{"P2SH", p2sh, "76aa20" + hex.EncodeToString(testScriptHash) + "8808ffffffffffffffff7c00c0"},
+		{"multisig", multisig, "ae20" + hex.EncodeToString(testPub1) + "20" + hex.EncodeToString(testPub2) + "5152ad"},
+		{"multisig with height", multisigHeight, "5acda069ae20" + hex.EncodeToString(testPub1) + "20" + hex.EncodeToString(testPub2) + "5152ad"},
+		{"coinbase", coinbase, "51"},
+		{"retire", retire, "6a"},
+		{"retire with comment", retireComment, "6a020102"},
+	}
+
+	for _, c := range cases {
+		if got := hex.EncodeToString(c.got); got != c.want {
+			t.Errorf("%s: got %s want %s", c.name, got, c.want)
+		}
+	}
+
+	if converted, err := ConvertP2PKHSigProgram(p2wpkh); err != nil || !bytes.Equal(converted, p2pkh) {
+		t.Errorf("ConvertP2PKHSigProgram = %x, %v want %x", converted, err, p2pkh)
+	}
+	if converted, err := ConvertP2SHProgram(p2wsh); err != nil || !bytes.Equal(converted, p2sh) {
+		t.Errorf("ConvertP2SHProgram = %x, %v want %x", converted, err, p2sh)
+	}
+	if hash, err := GetHashFromStandardProg(p2wsh); err != nil || !bytes.Equal(hash, testScriptHash) {
+		t.Errorf("GetHashFromStandardProg = %x, %v want %x", hash, err, testScriptHash)
+	}
+
+	// only version 0 witness programs can be converted
+	v1 := append([]byte{byte(vm.OP_1)}, p2wpkh[1:]...)
+	if _, err := ConvertP2PKHSigProgram(v1); err != ErrWitnessVersion {
+		t.Errorf("got err = %v want %v", err, ErrWitnessVersion)
+	}
+	if _, err := ConvertP2SHProgram(p2wsh[:10]); err == nil {
+		t.Error("converted a truncated program")
+	}
+}
+
+func TestProgramKinds(t *testing.T) {
+	p2wpkh, _ := P2WPKHProgram(testPubHash)
+	p2wsh, _ := P2WSHProgram(testScriptHash)
+	coinbase, _ := DefaultCoinbaseProgram()
+	retire, _ := RetireProgram([]byte{1, 2})
+	multisig, _ := P2SPMultiSigProgram([][]byte{testPub1}, 1)
+
+	cases := []struct {
+		prog                     []byte
+		p2wpkh, p2wsh, p2w       bool
+		straightforward, retired bool
+	}{
+		{prog: p2wpkh, p2wpkh: true, p2w: true},
+		{prog: p2wsh, p2wsh: true, p2w: true},
+		{prog: coinbase, p2w: true, straightforward: true},
+		{prog: []byte{byte(vm.OP_FAIL)}, p2w: true, straightforward: true, retired: true},
+		{prog: retire, retired: true},
+		{prog: multisig},
+		{prog: p2wpkh[:21]},
+		{prog: append(p2wpkh, byte(vm.OP_NOP))},
+		{prog: append([]byte{byte(vm.OP_1)}, p2wpkh[1:]...), p2wpkh: true, p2w: true},
+		// any op up to OP_16 passes as the version, as upstream
+		{prog: append([]byte{byte(vm.OP_DATA_1), 0}, p2wpkh[1:]...), p2wpkh: true, p2w: true},
+		{prog: append([]byte{byte(vm.OP_NOP)}, p2wpkh[1:]...)},
+		{prog: []byte{}},
+	}
+
+	for i, c := range cases {
+		if got := IsP2WPKHScript(c.prog); got != c.p2wpkh {
+			t.Errorf("case %d: IsP2WPKHScript = %v want %v", i, got, c.p2wpkh)
+		}
+		if got := IsP2WSHScript(c.prog); got != c.p2wsh {
+			t.Errorf("case %d: IsP2WSHScript = %v want %v", i, got, c.p2wsh)
+		}
+		if got := IsP2WScript(c.prog); got != c.p2w {
+			t.Errorf("case %d: IsP2WScript = %v want %v", i, got, c.p2w)
+		}
+		if got := IsStraightforward(c.prog); got != c.straightforward {
+			t.Errorf("case %d: IsStraightforward = %v want %v", i, got, c.straightforward)
+		}
+		if got := IsUnspendable(c.prog); got != c.retired {
+			t.Errorf("case %d: IsUnspendable = %v want %v", i, got, c.retired)
+		}
+	}
+}
+
+func TestP2SPMultiSig(t *testing.T) {
+	pubkeys := [][]byte{testPub1, testPub2, testPub3}
+	for _, height := range []int64{0, 10} {
+		prog, err := P2SPMultiSigProgramWithHeight(pubkeys, 2, height)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gotKeys, gotQuorum, err := ParseP2SPMultiSigProgram(prog)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gotQuorum != 2 || len(gotKeys) != 3 {
+			t.Fatalf("height %d: got %d of %d keys, want 2 of 3", height, gotQuorum, len(gotKeys))
+		}
+		for i := range pubkeys {
+			if !bytes.Equal(gotKeys[i], pubkeys[i]) {
+				t.Errorf("height %d: key %d is %x want %x", height, i, gotKeys[i], pubkeys[i])
+			}
+		}
+	}
+
+	buildErrs := []struct {
+		pubkeys   [][]byte
+		nrequired int
+		height    int64
+	}{
+		{[][]byte{testPub1}, 0, 0},
+		{[][]byte{testPub1}, 2, 0},
+		{[][]byte{testPub1}, -1, 0},
+		{[][]byte{testPub1[:31]}, 1, 0},
+		{[][]byte{testPub1}, 1, -1},
+	}
+	for i, c := range buildErrs {
+		if _, err := P2SPMultiSigProgramWithHeight(c.pubkeys, c.nrequired, c.height); errors.Root(err) != ErrBadValue {
+			t.Errorf("case %d: got err = %v want %v", i, err, ErrBadValue)
+		}
+	}
+
+	parseErrs := []struct {
+		prog []byte
+		err  error
+	}{
+		{[]byte{byte(vm.OP_TRUE)}, ErrMultisigFormat},
+		{NewBuilder().AddOp(vm.OP_TXSIGHASH).AddData(testPub1).AddInt64(1).AddInt64(1).AddOp(vm.OP_CHECKSIG).program, ErrMultisigFormat},
+		{NewBuilder().AddData(testPub1).AddData(testPub1).AddInt64(1).AddInt64(1).AddOp(vm.OP_CHECKMULTISIG).program, ErrMultisigFormat},
+		{NewBuilder().AddOp(vm.OP_TXSIGHASH).AddData(testPub1[:31]).AddInt64(1).AddInt64(1).AddOp(vm.OP_CHECKMULTISIG).program, ErrMultisigFormat},
+		{NewBuilder().AddOp(vm.OP_TXSIGHASH).AddData(testPub1).AddInt64(1).AddInt64(2).AddOp(vm.OP_CHECKMULTISIG).program, ErrMultisigFormat},
+		{NewBuilder().AddOp(vm.OP_TXSIGHASH).AddData(testPub1).AddInt64(2).AddInt64(1).AddOp(vm.OP_CHECKMULTISIG).program, ErrBadValue},
+		{[]byte{byte(vm.OP_DATA_1)}, vm.ErrShortProgram},
+	}
+	for i, c := range parseErrs {
+		if _, _, err := ParseP2SPMultiSigProgram(c.prog); errors.Root(err) != c.err {
+			t.Errorf("case %d: got err = %v want %v", i, err, c.err)
+		}
+	}
+}
+
+// verify runs a program the way a spend of it is checked
+func verify(prog []byte, args [][]byte, height uint64) error {
+	txVersion := uint64(1)
+	_, err := vm.Verify(&vm.Context{
+		VMVersion:   1,
+		Code:        prog,
+		Arguments:   args,
+		TxVersion:   &txVersion,
+		BlockHeight: &height,
+		TxSigHash:   func() []byte { return testMsg },
+	}, 100000)
+	if vmErr, ok := err.(vm.Error); ok {
+		return errors.Root(vmErr.Err)
+	}
+	return err
+}
+
+func TestSpendStandardPrograms(t *testing.T) {
+	p2wpkh, _ := P2WPKHProgram(testPubHash)
+	p2pkh, err := ConvertP2PKHSigProgram(p2wpkh)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	script, _ := P2SPMultiSigProgram([][]byte{testPub1, testPub2, testPub3}, 2)
+	scriptHash := sha3.Sum256(script)
+	p2wsh, _ := P2WSHProgram(scriptHash[:])
+	p2sh, err := ConvertP2SHProgram(p2wsh)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	heightLocked, _ := P2SPMultiSigProgramWithHeight([][]byte{testPub1}, 1, 10)
+
+	cases := []struct {
+		desc    string
+		prog    []byte
+		args    [][]byte
+		height  uint64
+		wantErr error
+	}{
+		{"p2pkh", p2pkh, [][]byte{testSig1, testPub1}, 1, nil},
+		{"p2pkh wrong signature", p2pkh, [][]byte{testSig2, testPub1}, 1, vm.ErrFalseVMResult},
+		{"p2pkh wrong key", p2pkh, [][]byte{testSig2, testPub2}, 1, vm.ErrVerifyFailed},
+		{"p2sh 2 of 3", p2sh, [][]byte{testSig1, testSig3, script}, 1, nil},
+		{"p2sh 2 of 3", p2sh, [][]byte{testSig2, testSig3, script}, 1, nil},
+		{"p2sh signatures out of order", p2sh, [][]byte{testSig3, testSig1, script}, 1, vm.ErrFalseVMResult},
+		{"p2sh a single signature", p2sh, [][]byte{testSig1, script}, 1, vm.ErrFalseVMResult},
+		{"p2sh wrong script", p2sh, [][]byte{testSig1, testSig3, heightLocked}, 1, vm.ErrVerifyFailed},
+		{"below the height", heightLocked, [][]byte{testSig1}, 9, nil},
+		{"at the height", heightLocked, [][]byte{testSig1}, 10, vm.ErrVerifyFailed},
+	}
+
+	for _, c := range cases {
+		if err := verify(c.prog, c.args, c.height); err != c.wantErr {
+			t.Errorf("%s: got err = %v want %v", c.desc, err, c.wantErr)
+		}
+	}
+}
+
+func TestBuilderJumps(t *testing.T) {
+	b := NewBuilder()
+	target := b.NewJumpTarget()
+	b.AddInt64(1).AddJumpIf(target).AddOp(vm.OP_FAIL).SetJumpTarget(target).AddInt64(1)
+	prog, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "516407000000" + "6a51"; hex.EncodeToString(prog) != want {
+		t.Errorf("got program %x want %s", prog, want)
+	}
+
+	b = NewBuilder()
+	b.AddJump(b.NewJumpTarget())
+	if _, err := b.Build(); errors.Root(err) != ErrUnresolvedJump {
+		t.Errorf("got err = %v want %v", err, ErrUnresolvedJump)
+	}
+}
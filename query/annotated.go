@@ -0,0 +1,213 @@
+// Package query renders the blocks and the transactions as the canonical JSON
+// views shared by the api and the command line
+package query
+
+import (
+	chainjson "github.com/btm-stats/encoding/json"
+	"github.com/btm-stats/protocol/bc"
+	"github.com/btm-stats/protocol/bc/types"
+	"github.com/btm-stats/protocol/vm"
+	"github.com/btm-stats/protocol/vm/vmutil"
+)
+
+// input and output types of the annotated transactions
+const (
+	SpendInputType    = "spend"
+	IssuanceInputType = "issue"
+	CoinbaseInputType = "coinbase"
+
+	ControlOutputType = "control"
+	RetireOutputType  = "retire"
+)
+
+// AnnotatedHeader is the JSON view of a block header
+type AnnotatedHeader struct {
+	Hash                   bc.Hash `json:"hash"`
+	Version                uint64  `json:"version"`
+	Height                 uint64  `json:"height"`
+	PreviousBlockHash      bc.Hash `json:"previous_block_hash"`
+	Timestamp              uint64  `json:"timestamp"`
+	Nonce                  uint64  `json:"nonce"`
+	Bits                   uint64  `json:"bits"`
+	TransactionsMerkleRoot bc.Hash `json:"transaction_merkle_root"`
+	TransactionStatusHash  bc.Hash `json:"transaction_status_hash"`
+}
+
+// AnnotatedBlock is the JSON view of a block
+type AnnotatedBlock struct {
+	AnnotatedHeader
+	Size         uint64         `json:"size"`
+	Transactions []*AnnotatedTx `json:"transactions"`
+}
+
+// AnnotatedTx is the JSON view of a transaction, StatusFail is only known
+// along the status of the block holding it
+type AnnotatedTx struct {
+	ID             bc.Hash            `json:"id"`
+	Version        uint64             `json:"version"`
+	Size           uint64             `json:"size"`
+	TimeRange      uint64             `json:"time_range"`
+	StatusFail     *bool              `json:"status_fail,omitempty"`
+	Inputs         []*AnnotatedInput  `json:"inputs"`
+	Outputs        []*AnnotatedOutput `json:"outputs"`
+	SpentOutputIDs []bc.Hash          `json:"spent_output_ids"`
+}
+
+// AnnotatedInput is the JSON view of a transaction input
+type AnnotatedInput struct {
+	Type             string               `json:"type"`
+	InputID          bc.Hash              `json:"input_id"`
+	AssetID          bc.AssetID           `json:"asset_id"`
+	Amount           uint64               `json:"amount"`
+	SpentOutputID    *bc.Hash             `json:"spent_output_id,omitempty"`
+	ControlProgram   chainjson.HexBytes   `json:"control_program,omitempty"`
+	IssuanceProgram  chainjson.HexBytes   `json:"issuance_program,omitempty"`
+	Program          string               `json:"program,omitempty"`
+	AssetDefinition  chainjson.HexBytes   `json:"asset_definition,omitempty"`
+	Arbitrary        chainjson.HexBytes   `json:"arbitrary,omitempty"`
+	WitnessArguments []chainjson.HexBytes `json:"witness_arguments,omitempty"`
+}
+
+// AnnotatedOutput is the JSON view of a transaction output
+type AnnotatedOutput struct {
+	Type           string             `json:"type"`
+	OutputID       bc.Hash            `json:"id"`
+	Position       int                `json:"position"`
+	AssetID        bc.AssetID         `json:"asset_id"`
+	Amount         uint64             `json:"amount"`
+	ControlProgram chainjson.HexBytes `json:"control_program"`
+	Program        string             `json:"program,omitempty"`
+}
+
+// AnnotateBlock renders the block, a nil status leaves the status of the
+// transactions unknown
+func AnnotateBlock(block *types.Block, status *bc.TransactionStatus) (*AnnotatedBlock, error) {
+	rawBlock, err := block.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	annotated := &AnnotatedBlock{
+		AnnotatedHeader: *AnnotateHeader(&block.BlockHeader),
+		Size:            uint64(len(rawBlock)),
+		Transactions:    []*AnnotatedTx{},
+	}
+
+	for i, tx := range block.Transactions {
+		annotatedTx := AnnotateTx(tx)
+		if status != nil {
+			statusFail, err := status.GetStatus(i)
+			if err != nil {
+				return nil, err
+			}
+			annotatedTx.StatusFail = &statusFail
+		}
+		annotated.Transactions = append(annotated.Transactions, annotatedTx)
+	}
+	return annotated, nil
+}
+
+// AnnotateHeader renders the block header
+func AnnotateHeader(header *types.BlockHeader) *AnnotatedHeader {
+	return &AnnotatedHeader{
+		Hash:                   header.Hash(),
+		Version:                header.Version,
+		Height:                 header.Height,
+		PreviousBlockHash:      header.PreviousBlockHash,
+		Timestamp:              header.Timestamp,
+		Nonce:                  header.Nonce,
+		Bits:                   header.Bits,
+		TransactionsMerkleRoot: header.TransactionsMerkleRoot,
+		TransactionStatusHash:  header.TransactionStatusHash,
+	}
+}
+
+// AnnotateTx renders the transaction with the ids computed from its entries
+func AnnotateTx(tx *types.Tx) *AnnotatedTx {
+	annotated := &AnnotatedTx{
+		ID:             tx.ID,
+		Version:        tx.Version,
+		Size:           tx.SerializedSize,
+		TimeRange:      tx.TimeRange,
+		Inputs:         []*AnnotatedInput{},
+		Outputs:        []*AnnotatedOutput{},
+		SpentOutputIDs: []bc.Hash{},
+	}
+
+	for i := range tx.Inputs {
+		input := annotateInput(tx, i)
+		if input.SpentOutputID != nil {
+			annotated.SpentOutputIDs = append(annotated.SpentOutputIDs, *input.SpentOutputID)
+		}
+		annotated.Inputs = append(annotated.Inputs, input)
+	}
+	for i := range tx.Outputs {
+		annotated.Outputs = append(annotated.Outputs, annotateOutput(tx, i))
+	}
+	return annotated
+}
+
+func annotateInput(tx *types.Tx, i int) *AnnotatedInput {
+	annotated := &AnnotatedInput{InputID: tx.InputIDs[i]}
+	switch inp := tx.Inputs[i].TypedInput.(type) {
+	case *types.SpendInput:
+		annotated.Type = SpendInputType
+		annotated.AssetID = *inp.AssetId
+		annotated.Amount = inp.Amount
+		annotated.ControlProgram = inp.ControlProgram
+		annotated.Program = disassemble(inp.ControlProgram)
+		annotated.WitnessArguments = hexList(inp.Arguments)
+		if spend, ok := tx.Entries[tx.InputIDs[i]].(*bc.Spend); ok {
+			annotated.SpentOutputID = spend.SpentOutputId
+		}
+
+	case *types.IssuanceInput:
+		annotated.Type = IssuanceInputType
+		annotated.AssetID = inp.AssetID()
+		annotated.Amount = inp.Amount
+		annotated.IssuanceProgram = inp.IssuanceProgram
+		annotated.Program = disassemble(inp.IssuanceProgram)
+		annotated.AssetDefinition = inp.AssetDefinition
+		annotated.WitnessArguments = hexList(inp.Arguments)
+
+	case *types.CoinbaseInput:
+		annotated.Type = CoinbaseInputType
+		annotated.Arbitrary = inp.Arbitrary
+	}
+	return annotated
+}
+
+func annotateOutput(tx *types.Tx, i int) *AnnotatedOutput {
+	out := tx.Outputs[i]
+	annotated := &AnnotatedOutput{
+		Type:           ControlOutputType,
+		OutputID:       *tx.ResultIds[i],
+		Position:       i,
+		AssetID:        *out.AssetId,
+		Amount:         out.Amount,
+		ControlProgram: out.ControlProgram,
+		Program:        disassemble(out.ControlProgram),
+	}
+	if vmutil.IsUnspendable(out.ControlProgram) {
+		annotated.Type = RetireOutputType
+	}
+	return annotated
+}
+
+// disassemble renders the program in the vm assembly, the programs failing
+// to parse are only shown in hex
+func disassemble(prog []byte) string {
+	program, err := vm.Disassemble(prog)
+	if err != nil {
+		return ""
+	}
+	return program
+}
+
+func hexList(list [][]byte) []chainjson.HexBytes {
+	var hexes []chainjson.HexBytes
+	for _, item := range list {
+		hexes = append(hexes, item)
+	}
+	return hexes
+}
@@ -0,0 +1,212 @@
+package query
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/btm-stats/consensus"
+	"github.com/btm-stats/protocol/bc"
+	"github.com/btm-stats/protocol/bc/types"
+	"github.com/btm-stats/protocol/vm"
+	"github.com/btm-stats/protocol/vm/vmutil"
+)
+
+// newAnnotateTestBlock returns a block of a coinbase, a spend, an issuance, a
+// retirement and a failed transaction, in that order
+func newAnnotateTestBlock(t *testing.T) (*types.Block, *bc.TransactionStatus) {
+	opTrue := []byte{byte(vm.OP_TRUE)}
+	retire, err := vmutil.RetireProgram([]byte("burn"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuance := types.NewIssuanceInput([]byte{1, 2, 3, 4, 5, 6, 7, 8}, 50, opTrue, [][]byte{{9}}, []byte(`{"name":"TEST"}`))
+	issuedAsset := issuance.TypedInput.(*types.IssuanceInput).AssetID()
+
+	txs := []*types.Tx{
+		types.NewTx(types.TxData{
+			Version: 1,
+			Inputs:  []*types.TxInput{types.NewCoinbaseInput([]byte("arbitrary"))},
+			Outputs: []*types.TxOutput{types.NewTxOutput(*consensus.BTMAssetID, 41250000000, opTrue)},
+		}),
+		types.NewTx(types.TxData{
+			Version: 1,
+			Inputs:  []*types.TxInput{types.NewSpendInput([][]byte{{1, 2}}, bc.Hash{V0: 1}, *consensus.BTMAssetID, 100, 0, opTrue)},
+			Outputs: []*types.TxOutput{types.NewTxOutput(*consensus.BTMAssetID, 40, opTrue), types.NewTxOutput(*consensus.BTMAssetID, 50, opTrue)},
+		}),
+		types.NewTx(types.TxData{
+			Version: 1,
+			Inputs:  []*types.TxInput{issuance, types.NewSpendInput(nil, bc.Hash{V0: 2}, *consensus.BTMAssetID, 100, 0, opTrue)},
+			Outputs: []*types.TxOutput{types.NewTxOutput(issuedAsset, 50, opTrue), types.NewTxOutput(*consensus.BTMAssetID, 90, opTrue)},
+		}),
+		types.NewTx(types.TxData{
+			Version: 1,
+			Inputs:  []*types.TxInput{types.NewSpendInput(nil, bc.Hash{V0: 3}, *consensus.BTMAssetID, 100, 1, opTrue)},
+			Outputs: []*types.TxOutput{types.NewTxOutput(*consensus.BTMAssetID, 90, retire)},
+		}),
+		types.NewTx(types.TxData{
+			Version: 1,
+			Inputs:  []*types.TxInput{types.NewSpendInput(nil, bc.Hash{V0: 4}, *consensus.BTMAssetID, 100, 0, opTrue)},
+			Outputs: []*types.TxOutput{types.NewTxOutput(*consensus.BTMAssetID, 90, opTrue)},
+		}),
+	}
+
+	status := bc.NewTransactionStatus()
+	for i := range txs {
+		status.SetStatus(i, i == len(txs)-1)
+	}
+	block := &types.Block{
+		BlockHeader:  types.BlockHeader{Version: 1, Height: 1, PreviousBlockHash: bc.Hash{V0: 5}, Timestamp: 1528945000, Nonce: 7, Bits: 2161727821137910632},
+		Transactions: txs,
+	}
+	return block, status
+}
+
+func TestAnnotateBlock(t *testing.T) {
+	block, status := newAnnotateTestBlock(t)
+	annotated, err := AnnotateBlock(block, status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if annotated.Hash != block.Hash() || annotated.Height != block.Height || len(annotated.Transactions) != len(block.Transactions) {
+		t.Fatalf("got block %x at height %d with %d transactions, want %x at height %d with %d", annotated.Hash.Bytes(), annotated.Height, len(annotated.Transactions), block.Hash().Bytes(), block.Height, len(block.Transactions))
+	}
+
+	cases := []struct {
+		desc        string
+		wantInputs  []string
+		wantOutputs []string
+		wantFail    bool
+	}{
+		{
+			desc:        "coinbase",
+			wantInputs:  []string{CoinbaseInputType},
+			wantOutputs: []string{ControlOutputType},
+		},
+		{
+			desc:        "spend",
+			wantInputs:  []string{SpendInputType},
+			wantOutputs: []string{ControlOutputType, ControlOutputType},
+		},
+		{
+			desc:        "issuance",
+			wantInputs:  []string{IssuanceInputType, SpendInputType},
+			wantOutputs: []string{ControlOutputType, ControlOutputType},
+		},
+		{
+			desc:        "retirement",
+			wantInputs:  []string{SpendInputType},
+			wantOutputs: []string{RetireOutputType},
+		},
+		{
+			desc:        "failed tx",
+			wantInputs:  []string{SpendInputType},
+			wantOutputs: []string{ControlOutputType},
+			wantFail:    true,
+		},
+	}
+
+	for i, c := range cases {
+		tx, annotatedTx := block.Transactions[i], annotated.Transactions[i]
+		if annotatedTx.ID != tx.ID {
+			t.Errorf("%s: got id %x, want %x", c.desc, annotatedTx.ID.Bytes(), tx.ID.Bytes())
+		}
+		if annotatedTx.StatusFail == nil || *annotatedTx.StatusFail != c.wantFail {
+			t.Errorf("%s: got status fail %v, want %v", c.desc, annotatedTx.StatusFail, c.wantFail)
+		}
+
+		gotInputs := []string{}
+		for _, input := range annotatedTx.Inputs {
+			gotInputs = append(gotInputs, input.Type)
+		}
+		if !reflect.DeepEqual(gotInputs, c.wantInputs) {
+			t.Errorf("%s: got inputs %v, want %v", c.desc, gotInputs, c.wantInputs)
+		}
+		gotOutputs := []string{}
+		for j, output := range annotatedTx.Outputs {
+			gotOutputs = append(gotOutputs, output.Type)
+			if output.OutputID != *tx.ResultIds[j] || output.Amount != tx.Outputs[j].Amount {
+				t.Errorf("%s: output %d got id %x amount %d, want id %x amount %d", c.desc, j, output.OutputID.Bytes(), output.Amount, tx.ResultIds[j].Bytes(), tx.Outputs[j].Amount)
+			}
+		}
+		if !reflect.DeepEqual(gotOutputs, c.wantOutputs) {
+			t.Errorf("%s: got outputs %v, want %v", c.desc, gotOutputs, c.wantOutputs)
+		}
+
+		if len(annotatedTx.SpentOutputIDs) != len(tx.SpentOutputIDs) {
+			t.Errorf("%s: got %d spent outputs, want %d", c.desc, len(annotatedTx.SpentOutputIDs), len(tx.SpentOutputIDs))
+			continue
+		}
+		for j, spent := range annotatedTx.SpentOutputIDs {
+			if spent != tx.SpentOutputIDs[j] {
+				t.Errorf("%s: spent output %d got %x, want %x", c.desc, j, spent.Bytes(), tx.SpentOutputIDs[j].Bytes())
+			}
+		}
+	}
+}
+
+func TestAnnotatedJSONRoundTrip(t *testing.T) {
+	block, status := newAnnotateTestBlock(t)
+
+	cases := []struct {
+		desc       string
+		status     *bc.TransactionStatus
+		wantStatus bool
+	}{
+		{
+			desc:       "block with the status",
+			status:     status,
+			wantStatus: true,
+		},
+		{
+			desc:   "block without the status",
+			status: nil,
+		},
+	}
+
+	for _, c := range cases {
+		annotated, err := AnnotateBlock(block, c.status)
+		if err != nil {
+			t.Fatalf("%s: %v", c.desc, err)
+		}
+		raw, err := json.Marshal(annotated)
+		if err != nil {
+			t.Fatalf("%s: %v", c.desc, err)
+		}
+		if got := strings.Contains(string(raw), `"status_fail"`); got != c.wantStatus {
+			t.Errorf("%s: got status fail rendered %v, want %v", c.desc, got, c.wantStatus)
+		}
+
+		decoded := &AnnotatedBlock{}
+		if err := json.Unmarshal(raw, decoded); err != nil {
+			t.Fatalf("%s: %v", c.desc, err)
+		}
+		if !reflect.DeepEqual(decoded, annotated) {
+			t.Errorf("%s: got %s after the round trip, want %s", c.desc, mustJSON(t, decoded), raw)
+		}
+	}
+
+	// a single transaction renders the same as within its block
+	for i, tx := range block.Transactions {
+		raw, err := json.Marshal(AnnotateTx(tx))
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded := &AnnotatedTx{}
+		if err := json.Unmarshal(raw, decoded); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, AnnotateTx(tx)) {
+			t.Errorf("transaction %d: got %s after the round trip, want %s", i, mustJSON(t, decoded), raw)
+		}
+	}
+}
+
+func mustJSON(t *testing.T, v interface{}) []byte {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
@@ -31,6 +31,7 @@ func EventStringLock() string             { return "Lock" }
 func EventStringRelock() string           { return "Relock" }
 func EventStringTimeoutWait() string      { return "TimeoutWait" }
 func EventStringVote() string             { return "Vote" }
+func EventStringBlockAnomaly() string     { return "BlockAnomaly" }
 
 //----------------------------------------
 
@@ -40,6 +41,7 @@ var (
 	EventDataNameTx             = "tx"
 	EventDataNameRoundState     = "round_state"
 	EventDataNameVote           = "vote"
+	EventDataNameBlockAnomaly   = "block_anomaly"
 )
 
 //----------------------------------------
@@ -82,6 +84,7 @@ const (
 	EventDataTypeFork           = byte(0x02)
 	EventDataTypeTx             = byte(0x03)
 	EventDataTypeNewBlockHeader = byte(0x04)
+	EventDataTypeBlockAnomaly   = byte(0x05)
 
 	EventDataTypeRoundState = byte(0x11)
 	EventDataTypeVote       = byte(0x12)
@@ -92,7 +95,8 @@ var tmEventDataMapper = data.NewMapper(TMEventData{}).
 	RegisterImplementation(EventDataNewBlockHeader{}, EventDataNameNewBlockHeader, EventDataTypeNewBlockHeader).
 	RegisterImplementation(EventDataTx{}, EventDataNameTx, EventDataTypeTx).
 	RegisterImplementation(EventDataRoundState{}, EventDataNameRoundState, EventDataTypeRoundState).
-	RegisterImplementation(EventDataVote{}, EventDataNameVote, EventDataTypeVote)
+	RegisterImplementation(EventDataVote{}, EventDataNameVote, EventDataTypeVote).
+	RegisterImplementation(EventDataBlockAnomaly{}, EventDataNameBlockAnomaly, EventDataTypeBlockAnomaly)
 
 // Most event messages are basic types (a block, a transaction)
 // but some (an input to a call tx or a receive) are more exotic
@@ -130,11 +134,22 @@ type EventDataVote struct {
 	//Vote *Vote
 }
 
+// A block whose timestamp or arrival looks off, fired by the anomaly detector
+type EventDataBlockAnomaly struct {
+	Kind      string `json:"kind"`
+	Height    uint64 `json:"height"`
+	Hash      string `json:"hash"`
+	Timestamp uint64 `json:"timestamp"`
+	Arrival   uint64 `json:"arrival"`
+	Detail    string `json:"detail"`
+}
+
 func (_ EventDataNewBlock) AssertIsTMEventData()       {}
 func (_ EventDataNewBlockHeader) AssertIsTMEventData() {}
 func (_ EventDataTx) AssertIsTMEventData()             {}
 func (_ EventDataRoundState) AssertIsTMEventData()     {}
 func (_ EventDataVote) AssertIsTMEventData()           {}
+func (_ EventDataBlockAnomaly) AssertIsTMEventData()   {}
 
 //----------------------------------------
 // Wrappers for type safety
@@ -192,6 +207,10 @@ func FireEventVote(fireable events.Fireable, vote EventDataVote) {
 	fireEvent(fireable, EventStringVote(), TMEventData{vote})
 }
 
+func FireEventBlockAnomaly(fireable events.Fireable, anomaly EventDataBlockAnomaly) {
+	fireEvent(fireable, EventStringBlockAnomaly(), TMEventData{anomaly})
+}
+
 /*
 func FireEventTx(fireable events.Fireable, tx EventDataTx) {
 	fireEvent(fireable, EventStringTx(tx.Tx), TMEventData{tx})